@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrSandboxOnly = errors.New("operation is available only in sandbox mode")
+	ErrLiveOnly    = errors.New("operation is not available in sandbox mode")
+)
+
+// Broker hides the difference between sandbox and real account services,
+// so the same order and position calls work in both modes.
+type Broker interface {
+	IsSandbox() bool
+	PostOrder(ctx context.Context, req *investapi.PostOrderRequest) (*investapi.PostOrderResponse, error)
+	CancelOrder(ctx context.Context, req *investapi.CancelOrderRequest) (*investapi.CancelOrderResponse, error)
+	GetOrderState(ctx context.Context, req *investapi.GetOrderStateRequest) (*investapi.OrderState, error)
+	GetOrders(ctx context.Context, req *investapi.GetOrdersRequest) (*investapi.GetOrdersResponse, error)
+	GetPositions(ctx context.Context, req *investapi.PositionsRequest) (*investapi.PositionsResponse, error)
+	GetOperations(ctx context.Context, req *investapi.OperationsRequest) (*investapi.OperationsResponse, error)
+	GetPortfolio(ctx context.Context, req *investapi.PortfolioRequest) (*investapi.PortfolioResponse, error)
+	GetAccounts(ctx context.Context, req *investapi.GetAccountsRequest) (*investapi.GetAccountsResponse, error)
+	// stop orders exist only for real accounts, sandbox returns ErrLiveOnly
+	PostStopOrder(ctx context.Context, req *investapi.PostStopOrderRequest) (*investapi.PostStopOrderResponse, error)
+	GetStopOrders(ctx context.Context, req *investapi.GetStopOrdersRequest) (*investapi.GetStopOrdersResponse, error)
+	CancelStopOrder(ctx context.Context, req *investapi.CancelStopOrderRequest) (*investapi.CancelStopOrderResponse, error)
+}
+
+func NewSandboxBroker(client investapi.SandboxServiceClient) Broker {
+	return &sandboxBroker{client: client}
+}
+
+type sandboxBroker struct {
+	client investapi.SandboxServiceClient
+}
+
+func (b sandboxBroker) IsSandbox() bool {
+	return true
+}
+
+func (b sandboxBroker) PostOrder(ctx context.Context, req *investapi.PostOrderRequest) (*investapi.PostOrderResponse, error) {
+	return b.client.PostSandboxOrder(ctx, req)
+}
+
+func (b sandboxBroker) CancelOrder(ctx context.Context, req *investapi.CancelOrderRequest) (*investapi.CancelOrderResponse, error) {
+	return b.client.CancelSandboxOrder(ctx, req)
+}
+
+func (b sandboxBroker) GetOrderState(ctx context.Context, req *investapi.GetOrderStateRequest) (*investapi.OrderState, error) {
+	return b.client.GetSandboxOrderState(ctx, req)
+}
+
+func (b sandboxBroker) GetOrders(ctx context.Context, req *investapi.GetOrdersRequest) (*investapi.GetOrdersResponse, error) {
+	return b.client.GetSandboxOrders(ctx, req)
+}
+
+func (b sandboxBroker) GetPositions(ctx context.Context, req *investapi.PositionsRequest) (*investapi.PositionsResponse, error) {
+	return b.client.GetSandboxPositions(ctx, req)
+}
+
+func (b sandboxBroker) GetOperations(ctx context.Context, req *investapi.OperationsRequest) (*investapi.OperationsResponse, error) {
+	return b.client.GetSandboxOperations(ctx, req)
+}
+
+func (b sandboxBroker) GetPortfolio(ctx context.Context, req *investapi.PortfolioRequest) (*investapi.PortfolioResponse, error) {
+	return b.client.GetSandboxPortfolio(ctx, req)
+}
+
+func (b sandboxBroker) GetAccounts(ctx context.Context, req *investapi.GetAccountsRequest) (*investapi.GetAccountsResponse, error) {
+	return b.client.GetSandboxAccounts(ctx, req)
+}
+
+func (b sandboxBroker) PostStopOrder(ctx context.Context, req *investapi.PostStopOrderRequest) (*investapi.PostStopOrderResponse, error) {
+	return nil, ErrLiveOnly
+}
+
+func (b sandboxBroker) GetStopOrders(ctx context.Context, req *investapi.GetStopOrdersRequest) (*investapi.GetStopOrdersResponse, error) {
+	return nil, ErrLiveOnly
+}
+
+func (b sandboxBroker) CancelStopOrder(ctx context.Context, req *investapi.CancelStopOrderRequest) (*investapi.CancelStopOrderResponse, error) {
+	return nil, ErrLiveOnly
+}
+
+func NewLiveBroker(
+	orders investapi.OrdersServiceClient,
+	operations investapi.OperationsServiceClient,
+	users investapi.UsersServiceClient,
+	stopOrders investapi.StopOrdersServiceClient,
+) Broker {
+	return &liveBroker{
+		orders:     orders,
+		operations: operations,
+		users:      users,
+		stopOrders: stopOrders,
+	}
+}
+
+type liveBroker struct {
+	orders     investapi.OrdersServiceClient
+	operations investapi.OperationsServiceClient
+	users      investapi.UsersServiceClient
+	stopOrders investapi.StopOrdersServiceClient
+}
+
+func (b liveBroker) IsSandbox() bool {
+	return false
+}
+
+func (b liveBroker) PostOrder(ctx context.Context, req *investapi.PostOrderRequest) (*investapi.PostOrderResponse, error) {
+	return b.orders.PostOrder(ctx, req)
+}
+
+func (b liveBroker) CancelOrder(ctx context.Context, req *investapi.CancelOrderRequest) (*investapi.CancelOrderResponse, error) {
+	return b.orders.CancelOrder(ctx, req)
+}
+
+func (b liveBroker) GetOrderState(ctx context.Context, req *investapi.GetOrderStateRequest) (*investapi.OrderState, error) {
+	return b.orders.GetOrderState(ctx, req)
+}
+
+func (b liveBroker) GetOrders(ctx context.Context, req *investapi.GetOrdersRequest) (*investapi.GetOrdersResponse, error) {
+	return b.orders.GetOrders(ctx, req)
+}
+
+func (b liveBroker) GetPositions(ctx context.Context, req *investapi.PositionsRequest) (*investapi.PositionsResponse, error) {
+	return b.operations.GetPositions(ctx, req)
+}
+
+func (b liveBroker) GetOperations(ctx context.Context, req *investapi.OperationsRequest) (*investapi.OperationsResponse, error) {
+	return b.operations.GetOperations(ctx, req)
+}
+
+func (b liveBroker) GetPortfolio(ctx context.Context, req *investapi.PortfolioRequest) (*investapi.PortfolioResponse, error) {
+	return b.operations.GetPortfolio(ctx, req)
+}
+
+func (b liveBroker) GetAccounts(ctx context.Context, req *investapi.GetAccountsRequest) (*investapi.GetAccountsResponse, error) {
+	return b.users.GetAccounts(ctx, req)
+}
+
+func (b liveBroker) PostStopOrder(ctx context.Context, req *investapi.PostStopOrderRequest) (*investapi.PostStopOrderResponse, error) {
+	return b.stopOrders.PostStopOrder(ctx, req)
+}
+
+func (b liveBroker) GetStopOrders(ctx context.Context, req *investapi.GetStopOrdersRequest) (*investapi.GetStopOrdersResponse, error) {
+	return b.stopOrders.GetStopOrders(ctx, req)
+}
+
+func (b liveBroker) CancelStopOrder(ctx context.Context, req *investapi.CancelStopOrderRequest) (*investapi.CancelStopOrderResponse, error) {
+	return b.stopOrders.CancelStopOrder(ctx, req)
+}
@@ -4,46 +4,64 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
+	"net"
+	"os"
 
+	"github.com/nax11/tinkoff_bot_public/clock"
 	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 const (
-	url = "invest-public-api.tinkoff.ru"
+	DefaultEndpoint = "invest-public-api.tinkoff.ru"
 )
 
 type Config struct {
-	Token     string   `required:"true"`
-	AccountID []string `split_words:"true"` // required in non-sandbox mode
+	Token     string   `yaml:"token" required:"true"`
+	Endpoint  string   `yaml:"endpoint"`
+	AccountID []string `yaml:"account_id" split_words:"true"` // required in non-sandbox mode
+	Sandbox   bool     `yaml:"sandbox"`
+	// AppName is sent in x-app-name with every call, DefaultAppName when empty
+	AppName string `yaml:"app_name" split_words:"true"`
+	// AuditLog is a file every call is logged to as JSON, empty logs calls at debug level
+	AuditLog string `yaml:"audit_log" split_words:"true"`
 }
 
+// CreateStreamContext returns a context for streams, x-app-name and the tracking id
+// are handled by the client interceptors.
 func CreateStreamContext(cfg Config) context.Context {
-	//investapi.Share{}
-	ctx := context.TODO()
-
-	//authHeader := fmt.Sprintf("Bearer %s", cfg.Token)
-	// ctx = grpcMetadata.AppendToOutgoingContext(ctx, "authorization", authHeader)
-	// ctx = grpcMetadata.AppendToOutgoingContext(ctx, "x-tracking-id", uuid.New().String())
-	//ctx = grpcMetadata.AppendToOutgoingContext(ctx, "x-app-name", AppName)
-
-	return ctx
+	return context.TODO()
 }
 
 type Client struct {
-	connection               *grpc.ClientConn
-	InstrumentsServiceClient investapi.InstrumentsServiceClient
-	//UsersServiceClient       investapi.UsersServiceClient
-	MarketDataServiceClient investapi.MarketDataServiceClient
-	//OperationsServiceClient  investapi.OperationsServiceClient
-	//OrdersServiceClient      investapi.OrdersServiceClient
-	//StopOrdersServiceClient  investapi.StopOrdersServiceClient
-	sandboxClient investapi.SandboxServiceClient
+	connection                    *grpc.ClientConn
+	auditLog                      io.Closer
+	InstrumentsServiceClient      investapi.InstrumentsServiceClient
+	UsersServiceClient            investapi.UsersServiceClient
+	MarketDataServiceClient       investapi.MarketDataServiceClient
+	MarketDataStreamServiceClient investapi.MarketDataStreamServiceClient
+	OperationsServiceClient       investapi.OperationsServiceClient
+	OrdersServiceClient           investapi.OrdersServiceClient
+	OrdersStreamServiceClient     investapi.OrdersStreamServiceClient     // not available in sandbox
+	OperationsStreamServiceClient investapi.OperationsStreamServiceClient // not available in sandbox
+	StopOrdersServiceClient       investapi.StopOrdersServiceClient
+	sandboxClient                 investapi.SandboxServiceClient
+	// Broker routes order, position and operation calls to the sandbox or to the real account
+	Broker Broker
+	// Intents saves orders posted by PostOrderIntent before they are sent, nil posts them without saving
+	Intents *IntentStore
+	// Clock tells time to order tracking and strategies, it's simulated in backtests
+	Clock clock.Clock
 }
 type tokenAuth struct {
 	// Token from // https://tinkoff.github.io/investAPI/grpc/#tinkoff-invest-api_1
-	Token string
+	Token    string
+	insecure bool
 }
 
 func (t tokenAuth) GetRequestMetadata(ctx context.Context, in ...string) (map[string]string, error) {
@@ -52,33 +70,101 @@ func (t tokenAuth) GetRequestMetadata(ctx context.Context, in ...string) (map[st
 	}, nil
 }
 
-func (tokenAuth) RequireTransportSecurity() bool {
-	return true
+func (t tokenAuth) RequireTransportSecurity() bool {
+	return !t.insecure
+}
+
+// target appends the default TLS port when the endpoint has none.
+func target(endpoint string) (address, host string) {
+	if host, _, err := net.SplitHostPort(endpoint); err == nil {
+		return endpoint, host
+	}
+	return fmt.Sprintf("%s:443", endpoint), endpoint
 }
 
-func NewClient(token string) (client *Client, err error) {
-	return NewWithOpts(token, url, make([]grpc.DialOption, 0)...)
+func NewClient(token string, sandbox bool) (client *Client, err error) {
+	return NewWithOpts(token, DefaultEndpoint, sandbox)
 }
 
-func NewWithOpts(token, endpoint string, opts ...grpc.DialOption) (client *Client, err error) {
-	opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
-		ServerName: endpoint,
-	})))
-	opts = append(opts, grpc.WithPerRPCCredentials(tokenAuth{
-		Token: token,
+func NewFromConfig(cfg Config, opts ...Option) (client *Client, err error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	opts = append(opts, AppName(cfg.AppName))
+	var auditLog *os.File
+	if cfg.AuditLog != "" {
+		auditLog, err = os.OpenFile(cfg.AuditLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail open audit log")
+		}
+		logger := logrus.New()
+		logger.SetOutput(auditLog)
+		logger.SetFormatter(&logrus.JSONFormatter{})
+		opts = append(opts, AuditLog(logger))
+	}
+	client, err = NewWithOpts(cfg.Token, endpoint, cfg.Sandbox, opts...)
+	if err != nil {
+		if auditLog != nil {
+			auditLog.Close()
+		}
+		return nil, err
+	}
+	if auditLog != nil {
+		client.auditLog = auditLog
+	}
+	return client, nil
+}
+
+func NewWithOpts(token, endpoint string, sandbox bool, opts ...Option) (client *Client, err error) {
+	address, host := target(endpoint)
+	o := newOptions(opts)
+	dialOpts := append([]grpc.DialOption{}, o.dial...)
+	if o.insecure {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			ServerName: host,
+		})))
+	}
+	dialOpts = append(dialOpts, newInterceptor(o).dialOptions()...)
+	dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(tokenAuth{
+		Token:    token,
+		insecure: o.insecure,
 	}))
-	conn, err := grpc.Dial(fmt.Sprintf("%s:443", endpoint), opts...)
+	conn, err := grpc.Dial(address, dialOpts...)
 	if err != nil {
 		return
 	}
 	client = new(Client)
 	client.connection = conn
+	client.Clock = o.clock
 	client.InstrumentsServiceClient = investapi.NewInstrumentsServiceClient(conn)
-	//client.UsersServiceClient = investapi.NewUsersServiceClient(conn)
+	client.UsersServiceClient = investapi.NewUsersServiceClient(conn)
 	client.MarketDataServiceClient = investapi.NewMarketDataServiceClient(conn)
-	//client.OperationsServiceClient = investapi.NewOperationsServiceClient(conn)
-	//client.OrdersServiceClient = investapi.NewOrdersServiceClient(conn)
-	//client.StopOrdersServiceClient = investapi.NewStopOrdersServiceClient(conn)
+	client.MarketDataStreamServiceClient = investapi.NewMarketDataStreamServiceClient(conn)
+	client.OperationsServiceClient = investapi.NewOperationsServiceClient(conn)
+	client.OrdersServiceClient = investapi.NewOrdersServiceClient(conn)
+	client.OrdersStreamServiceClient = investapi.NewOrdersStreamServiceClient(conn)
+	client.OperationsStreamServiceClient = investapi.NewOperationsStreamServiceClient(conn)
+	client.StopOrdersServiceClient = investapi.NewStopOrdersServiceClient(conn)
 	client.sandboxClient = investapi.NewSandboxServiceClient(conn)
+	if sandbox {
+		client.Broker = NewSandboxBroker(client.sandboxClient)
+	} else {
+		client.Broker = NewLiveBroker(client.OrdersServiceClient, client.OperationsServiceClient, client.UsersServiceClient, client.StopOrdersServiceClient)
+	}
 	return
 }
+
+func (c Client) Close() error {
+	err := c.connection.Close()
+	if c.auditLog != nil {
+		c.auditLog.Close()
+	}
+	return err
+}
+
+func (c Client) IsSandbox() bool {
+	return c.Broker.IsSandbox()
+}
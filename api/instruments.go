@@ -3,39 +3,48 @@ package api
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/nax11/tinkoff_bot_public/money"
 	investapi "github.com/nax11/tinkoff_bot_public/proto"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-func (c Client) SandboxBuyOrder(ctx context.Context, accountID, figi string, buyPrice float64, qty int64) (orderID string, err error) {
-	req := investapi.PostOrderRequest{
-		Figi:      figi,
-		Quantity:  qty,
-		Price:     BuildQuotationByPrice(buyPrice),
-		Direction: investapi.OrderDirection_ORDER_DIRECTION_BUY,
-		AccountId: accountID,
-		OrderType: investapi.OrderType_ORDER_TYPE_LIMIT,
-		OrderId:   uuid.New().String(),
+func (c Client) BuyOrder(ctx context.Context, accountID, figi string, buyPrice money.Decimal, qty int64) (orderID string, err error) {
+	return c.PostOrder(ctx, NewOrder(accountID, figi).Buy().Lots(qty).Limit(buyPrice))
+}
+
+func (c Client) SellOrder(ctx context.Context, accountID, figi string, sellPrice money.Decimal, qty int64) (orderID string, err error) {
+	return c.PostOrder(ctx, NewOrder(accountID, figi).Sell().Lots(qty).Limit(sellPrice))
+}
+
+// PostOrder validates and sends the order built by order.
+func (c Client) PostOrder(ctx context.Context, order *OrderBuilder) (orderID string, err error) {
+	req, err := order.Build()
+	if err != nil {
+		return "", errors.Wrap(err, "invalid order")
 	}
 	log := logrus.WithFields(logrus.Fields{
-		"account_id": accountID,
-		"figi":       figi,
-		"buy_price":  buyPrice,
-		"request":    fmt.Sprintf("%v", &req),
+		"account_id": req.AccountId,
+		"figi":       req.Figi,
+		"direction":  req.Direction.String(),
+		"order_type": req.OrderType.String(),
+		"sandbox":    c.IsSandbox(),
 	})
+	if req.Price != nil {
+		log = log.WithField("price", money.FromQuotation(req.Price).String())
+	}
+	return c.postOrder(ctx, log, req)
+}
 
-	log.WithField("request", fmt.Sprintf("%v", &req)).Info("PostSandboxOrder")
-	resp, err := c.sandboxClient.PostSandboxOrder(ctx, &req)
+func (c Client) postOrder(ctx context.Context, log *logrus.Entry, req *investapi.PostOrderRequest) (orderID string, err error) {
+	log.WithField("request", fmt.Sprintf("%v", req)).Info("PostOrder")
+	resp, err := c.Broker.PostOrder(ctx, req)
 	if err != nil {
-		return "", errors.Wrap(err, "error on execute buy on PostSandboxOrder")
+		return "", errors.Wrapf(err, "error on execute %v on PostOrder", req.Direction)
 	}
-	log.WithField("response", fmt.Sprintf("%v", resp)).Info("PostSandboxOrder sent")
+	log.WithField("response", fmt.Sprintf("%v", resp)).Info("PostOrder sent")
 
 	if resp.ExecutionReportStatus == investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED ||
 		resp.ExecutionReportStatus == investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED {
@@ -45,36 +54,34 @@ func (c Client) SandboxBuyOrder(ctx context.Context, accountID, figi string, buy
 	return resp.OrderId, nil
 }
 
-func (c Client) SandboxSellOrder(ctx context.Context, accountID, figi string, sellPrice float64, qty int64) (orderID string, err error) {
-	req := investapi.PostOrderRequest{
-		Figi:      figi,
-		Quantity:  qty,
-		Price:     BuildQuotationByPrice(sellPrice),
-		Direction: investapi.OrderDirection_ORDER_DIRECTION_SELL,
+func (c Client) CancelOrder(ctx context.Context, accountID, orderID string) error {
+	req := investapi.CancelOrderRequest{
 		AccountId: accountID,
-		OrderType: investapi.OrderType_ORDER_TYPE_LIMIT,
-		OrderId:   uuid.New().String(),
+		OrderId:   orderID,
 	}
-	log := logrus.WithFields(logrus.Fields{
+	_, err := c.Broker.CancelOrder(ctx, &req)
+	if err != nil {
+		return errors.Wrapf(err, "error on execute CancelOrder for order: %v", orderID)
+	}
+	logrus.WithFields(logrus.Fields{
 		"account_id": accountID,
-		"figi":       figi,
-		"buy_price":  sellPrice,
-		"request":    fmt.Sprintf("%v", &req),
-	})
+		"order_id":   orderID,
+		"sandbox":    c.IsSandbox(),
+	}).Info("CancelOrder")
+	return nil
+}
 
-	log.WithField("request", fmt.Sprintf("%v", &req)).Info("PostSandboxOrder")
-	resp, err := c.sandboxClient.PostSandboxOrder(ctx, &req)
+func (c Client) GetLastPrice(ctx context.Context, figi string) (money.Decimal, error) {
+	resp, err := c.MarketDataServiceClient.GetLastPrices(ctx, &investapi.GetLastPricesRequest{Figi: []string{figi}})
 	if err != nil {
-		return "", errors.Wrap(err, "error on execute buy on PostSandboxOrder")
+		return money.Zero, errors.Wrap(err, "fail get last price")
 	}
-	log.WithField("response", fmt.Sprintf("%v", resp)).Info("PostSandboxOrder sent")
-
-	if resp.ExecutionReportStatus == investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED ||
-		resp.ExecutionReportStatus == investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED {
-		return "", errors.New("post order with unsuccessful status")
+	for _, price := range resp.GetLastPrices() {
+		if price.Figi == figi {
+			return money.FromQuotation(price.GetPrice()), nil
+		}
 	}
-
-	return resp.OrderId, nil
+	return money.Zero, errors.Errorf("no last price for %v", figi)
 }
 
 func (c Client) GetShare(ctx context.Context, figi string) (share *investapi.Share, err error) {
@@ -95,11 +102,17 @@ func (c Client) GetShare(ctx context.Context, figi string) (share *investapi.Sha
 	return share, nil
 }
 
+// Held is the whole quantity of the position, Balance is only the part not blocked by sell orders.
+func Held(position *investapi.PositionsSecurities) int64 {
+	return position.GetBalance() + position.GetBlocked()
+}
+
+// GetOpenPosition returns the position of figi when some of it is held, including the blocked part.
 func (c Client) GetOpenPosition(ctx context.Context, accountID, figi string) (openPosition *investapi.PositionsSecurities, err error) {
 	req := investapi.PositionsRequest{
 		AccountId: accountID,
 	}
-	resp, err := c.sandboxClient.GetSandboxPositions(ctx, &req)
+	resp, err := c.Broker.GetPositions(ctx, &req)
 	if err != nil {
 		return nil, errors.Wrap(err, "fail get open positions")
 	}
@@ -115,7 +128,7 @@ func (c Client) GetOpenPosition(ctx context.Context, accountID, figi string) (op
 			return nil, errors.New("exchange blocked by stock")
 		}
 
-		if position.GetBalance() > 0 {
+		if Held(position) > 0 {
 			return position, nil
 		}
 	}
@@ -126,7 +139,7 @@ func (c Client) GetActiveOrder(ctx context.Context, accountID, figi string) (ord
 	req := investapi.GetOrdersRequest{
 		AccountId: accountID,
 	}
-	resp, err := c.sandboxClient.GetSandboxOrders(ctx, &req)
+	resp, err := c.Broker.GetOrders(ctx, &req)
 	if err != nil {
 		return nil, errors.Wrap(err, "fail get orders")
 	}
@@ -148,9 +161,9 @@ func (c Client) CheckOrderStatus(ctx context.Context, accountID, orderID string)
 		AccountId: accountID,
 		OrderId:   orderID,
 	}
-	stateResp, err := c.sandboxClient.GetSandboxOrderState(ctx, &stateReq)
+	stateResp, err := c.Broker.GetOrderState(ctx, &stateReq)
 	if err != nil {
-		return false, errors.Wrapf(err, "error on execute GetSandboxOrderState for order: %v", orderID)
+		return false, errors.Wrapf(err, "error on execute GetOrderState for order: %v", orderID)
 	}
 	if stateResp.ExecutionReportStatus == investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED ||
 		stateResp.ExecutionReportStatus == investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED {
@@ -167,7 +180,11 @@ func (c Client) CheckOrderStatus(ctx context.Context, accountID, orderID string)
 	return false, nil
 }
 
-func (c Client) SandboxOpenAccount(ctx context.Context, amount int) (accountID string, err error) {
+// SandboxOpenAccount opens a sandbox account and pays in deposit when it's not zero.
+func (c Client) SandboxOpenAccount(ctx context.Context, deposit money.Money) (accountID string, err error) {
+	if !c.IsSandbox() {
+		return "", ErrSandboxOnly
+	}
 	req := investapi.OpenSandboxAccountRequest{}
 	resp, err := c.sandboxClient.OpenSandboxAccount(ctx, &req)
 	if err != nil {
@@ -177,12 +194,29 @@ func (c Client) SandboxOpenAccount(ctx context.Context, amount int) (accountID s
 		return "", errors.New("empty response received during open account")
 	}
 
+	if !deposit.IsZero() {
+		if _, err = c.SandboxPayInAccount(ctx, resp.GetAccountId(), deposit); err != nil {
+			return resp.GetAccountId(), err
+		}
+	}
 	return resp.GetAccountId(), nil
 }
 
-func (c Client) SandboxGetAccounts(ctx context.Context) (accounts []*investapi.Account, err error) {
+func (c Client) SandboxCloseAccount(ctx context.Context, accountID string) error {
+	if !c.IsSandbox() {
+		return ErrSandboxOnly
+	}
+	req := investapi.CloseSandboxAccountRequest{AccountId: accountID}
+	_, err := c.sandboxClient.CloseSandboxAccount(ctx, &req)
+	if err != nil {
+		return errors.Wrapf(err, "fail close account %v", accountID)
+	}
+	return nil
+}
+
+func (c Client) GetAccounts(ctx context.Context) (accounts []*investapi.Account, err error) {
 	req := investapi.GetAccountsRequest{}
-	resp, err := c.sandboxClient.GetSandboxAccounts(ctx, &req)
+	resp, err := c.Broker.GetAccounts(ctx, &req)
 	if err != nil {
 		return nil, errors.Wrap(err, "fail get accounts")
 	}
@@ -192,66 +226,60 @@ func (c Client) SandboxGetAccounts(ctx context.Context) (accounts []*investapi.A
 	return resp.GetAccounts(), nil
 }
 
-func (c Client) SandboxPayInAccount(ctx context.Context, accountID string, amount int64) (*investapi.MoneyValue, error) {
+func (c Client) SandboxPayInAccount(ctx context.Context, accountID string, amount money.Money) (money.Money, error) {
+	if !c.IsSandbox() {
+		return money.Money{}, ErrSandboxOnly
+	}
 	req := investapi.SandboxPayInRequest{
 		AccountId: accountID,
-		Amount: &investapi.MoneyValue{
-			Currency: "RUB",
-			Units:    amount,
-			Nano:     0,
-		},
+		Amount:    amount.MoneyValue(),
 	}
 	resp, err := c.sandboxClient.SandboxPayIn(ctx, &req)
 	if err != nil {
-		return nil, errors.Wrap(err, "fail pay in account")
+		return money.Money{}, errors.Wrap(err, "fail pay in account")
 	}
 	if resp == nil {
-		return nil, errors.New("empty response received during ay in account")
+		return money.Money{}, errors.New("empty response received during pay in account")
 	}
-	return resp.GetBalance(), nil
+	return money.FromMoneyValue(resp.GetBalance()), nil
 }
 
-func (c Client) SandboxGetOperations(ctx context.Context, accountID, figi string, state investapi.OperationState) ([]*investapi.Operation, error) {
-	req := investapi.OperationsRequest{
+// GetBalances returns money of the account by currency, blocked money is included.
+func (c Client) GetBalances(ctx context.Context, accountID string) (money.Totals, error) {
+	req := investapi.PositionsRequest{
 		AccountId: accountID,
-		From:      timestamppb.New(time.Now().Add(time.Hour * (-24))),
-		To:        timestamppb.New(time.Now()),
-		State:     state,
-		Figi:      figi,
 	}
-	resp, err := c.sandboxClient.GetSandboxOperations(ctx, &req)
+	resp, err := c.Broker.GetPositions(ctx, &req)
 	if err != nil {
-		return nil, errors.Wrap(err, "fail get operations")
-	}
-	if resp == nil {
-		return nil, nil
+		return nil, errors.Wrap(err, "fail get positions")
 	}
-
-	for _, operation := range resp.GetOperations() {
-		logrus.
-			WithField("operation", operation).
-			WithField("account_id", accountID).
-			Info("Account operation")
+	balances := make(money.Totals)
+	for _, value := range resp.GetMoney() {
+		balances.Add(money.FromMoneyValue(value))
 	}
-	return resp.GetOperations(), nil
-}
-func BuildQuotationByPrice(price float64) *investapi.Quotation {
-	priceUnits := int64(price)
-	priceNano := int32((price - float64(priceUnits)) * 100)
-	return &investapi.Quotation{
-		Units: priceUnits,
-		Nano:  priceNano,
+	for _, value := range resp.GetBlocked() {
+		balances.Add(money.FromMoneyValue(value))
 	}
+	return balances, nil
 }
 
-func GetPrice(q *investapi.Quotation) (float64, error) {
-	return strconv.ParseFloat(fmt.Sprintf("%v.%v", q.GetUnits(), q.GetNano()), 64)
+// GetOperations returns operations of the last day, see GetOperationsRange for other periods.
+func (c Client) GetOperations(ctx context.Context, accountID, figi string, state investapi.OperationState) ([]*investapi.Operation, error) {
+	return c.GetOperationsRange(ctx, accountID, OperationsFilter{
+		From:  c.Clock.Now().Add(-24 * time.Hour),
+		Figi:  figi,
+		State: state,
+	})
 }
 
-func CalcLotCount(maxDealSum, price float64, lot int32, operationLots int64) int64 {
-	if maxDealSum > price*float64(operationLots*int64(lot)) {
+func CalcLotCount(maxDealSum, price money.Decimal, lot int32, operationLots int64) int64 {
+	lotPrice := price.MulInt(int64(lot))
+	if maxDealSum.GreaterThan(lotPrice.MulInt(operationLots)) {
 		return operationLots
 	}
+	if lotPrice.Sign() <= 0 {
+		return 0
+	}
 
-	return int64(maxDealSum / (float64(lot) * price))
+	return maxDealSum.DivRound(lotPrice, money.RoundDown).Truncate()
 }
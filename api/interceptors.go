@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// DefaultAppName is sent in x-app-name, it lets the API tell requests of the bot apart
+	DefaultAppName = "nax11.tinkoff_bot_public"
+
+	appNameHeader    = "x-app-name"
+	trackingIDHeader = "x-tracking-id"
+	// auditMaxMessage limits the size of a logged message, instrument lists are megabytes long
+	auditMaxMessage = 4096
+)
+
+// Error is a failed API call with the tracking id support can find it by.
+type Error struct {
+	Method string
+	Code   codes.Code
+	// APICode is the numeric code the message starts with, e.g. "30079"
+	APICode    string
+	Message    string
+	TrackingID string
+	err        error
+}
+
+func newError(method, trackingID string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	apiCode := ""
+	if idx := strings.Index(st.Message(), ":"); idx > 0 {
+		apiCode = st.Message()[:idx]
+	}
+	return &Error{
+		Method:     method,
+		Code:       st.Code(),
+		APICode:    apiCode,
+		Message:    st.Message(),
+		TrackingID: trackingID,
+		err:        err,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.TrackingID == "" {
+		return fmt.Sprintf("%v: %v", e.Method, e.err)
+	}
+	return fmt.Sprintf("%v: %v (tracking id %v)", e.Method, e.err, e.TrackingID)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// GRPCStatus keeps status.Code and status.FromError working with the wrapped error.
+func (e *Error) GRPCStatus() *status.Status {
+	return status.Convert(e.err)
+}
+
+// TrackingID returns the tracking id of a failed API call, empty when err isn't an API error.
+func TrackingID(err error) string {
+	apiErr := &Error{}
+	if errors.As(err, &apiErr) {
+		return apiErr.TrackingID
+	}
+	return ""
+}
+
+// interceptor adds metadata to calls, keeps them within the tariff, retries transient failures,
+// turns failures into *Error and writes the audit log.
+type interceptor struct {
+	appName string
+	audit   *logrus.Logger
+	level   logrus.Level
+	retry   RetryPolicy
+	limiter *rateLimiter
+}
+
+func newInterceptor(o options) *interceptor {
+	i := &interceptor{
+		appName: o.appName,
+		audit:   logrus.StandardLogger(),
+		level:   logrus.DebugLevel,
+		retry:   o.retry,
+		limiter: newRateLimiter(),
+	}
+	if o.audit != nil {
+		i.audit, i.level = o.audit, logrus.InfoLevel
+	}
+	return i
+}
+
+func (i *interceptor) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(i.unary),
+		grpc.WithChainStreamInterceptor(i.stream),
+	}
+}
+
+func (i *interceptor) outgoing(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, appNameHeader, i.appName)
+}
+
+// unary waits for the rate limit and retries transient failures of idempotent calls.
+func (i *interceptor) unary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx = i.outgoing(ctx)
+	retry := i.retry.allows(method)
+	for attempt := 1; ; attempt++ {
+		if err := i.limiter.wait(ctx, method, cc); err != nil {
+			return err
+		}
+		trailer, err := i.invoke(ctx, method, req, reply, cc, invoker, attempt, opts)
+		if err == nil || !retry || attempt >= i.retry.MaxAttempts || !i.retry.retryable(status.Code(errors.Cause(err))) {
+			return err
+		}
+		delay := i.retry.backoff(attempt, trailer)
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"method":  method,
+			"attempt": attempt,
+			"delay":   delay,
+		}).Warn("api call failed, retrying")
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (i *interceptor) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, attempt int, opts []grpc.CallOption) (metadata.MD, error) {
+	var header, trailer metadata.MD
+	opts = append(opts, grpc.Header(&header), grpc.Trailer(&trailer))
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	trackingID := trackingIDOf(header, trailer)
+	if !i.enabled() {
+		return trailer, newError(method, trackingID, err)
+	}
+
+	fields := logrus.Fields{
+		"method":      method,
+		"tracking_id": trackingID,
+		"attempt":     attempt,
+		"duration":    time.Since(start),
+		"metadata":    redact(ctx),
+		"request":     message(req),
+		"code":        status.Code(err).String(),
+	}
+	if err == nil {
+		fields["response"] = message(reply)
+	} else {
+		fields["error"] = status.Convert(err).Message()
+	}
+	i.log(fields, "api call")
+	return trailer, newError(method, trackingID, err)
+}
+
+func (i *interceptor) stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx = i.outgoing(ctx)
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if !i.enabled() {
+		if err != nil {
+			return nil, newError(method, "", err)
+		}
+		return &auditStream{ClientStream: stream, interceptor: i, method: method}, nil
+	}
+	fields := logrus.Fields{
+		"method":   method,
+		"metadata": redact(ctx),
+		"code":     status.Code(err).String(),
+	}
+	if err != nil {
+		fields["error"] = status.Convert(err).Message()
+		i.log(fields, "api stream open")
+		return nil, newError(method, "", err)
+	}
+	i.log(fields, "api stream open")
+	return &auditStream{ClientStream: stream, interceptor: i, method: method}, nil
+}
+
+func (i *interceptor) enabled() bool {
+	return i.audit.IsLevelEnabled(i.level)
+}
+
+func (i *interceptor) log(fields logrus.Fields, msg string) {
+	i.audit.WithFields(fields).Log(i.level, msg)
+}
+
+// auditStream logs messages of a stream, the tracking id comes with the stream header.
+type auditStream struct {
+	grpc.ClientStream
+	interceptor *interceptor
+	method      string
+	trackingID  string
+}
+
+// tracking reads the tracking id from the header, the trailer is read only after the stream failed.
+func (s *auditStream) tracking(failed bool) string {
+	if s.trackingID != "" {
+		return s.trackingID
+	}
+	header, err := s.ClientStream.Header()
+	if err == nil {
+		s.trackingID = trackingIDOf(header)
+	}
+	if s.trackingID == "" && failed {
+		s.trackingID = trackingIDOf(s.ClientStream.Trailer())
+	}
+	return s.trackingID
+}
+
+func (s *auditStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if s.interceptor.enabled() {
+		s.interceptor.log(logrus.Fields{
+			"method":  s.method,
+			"request": message(m),
+			"code":    status.Code(err).String(),
+		}, "api stream send")
+	}
+	if err != nil {
+		return newError(s.method, s.tracking(true), err)
+	}
+	return nil
+}
+
+func (s *auditStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		return err
+	}
+	if s.interceptor.enabled() {
+		fields := logrus.Fields{
+			"method":      s.method,
+			"tracking_id": s.tracking(err != nil),
+			"code":        status.Code(err).String(),
+		}
+		if err == nil {
+			fields["response"] = message(m)
+		} else {
+			fields["error"] = status.Convert(err).Message()
+		}
+		s.interceptor.log(fields, "api stream receive")
+	}
+	if err != nil {
+		return newError(s.method, s.tracking(true), err)
+	}
+	return nil
+}
+
+func trackingIDOf(mds ...metadata.MD) string {
+	for _, md := range mds {
+		if values := md.Get(trackingIDHeader); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// redact returns outgoing metadata of the call without secrets.
+func redact(ctx context.Context) map[string]string {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	result := make(map[string]string, len(md))
+	for key, values := range md {
+		value := strings.Join(values, ",")
+		if key == "authorization" {
+			value = "Bearer ***"
+		}
+		result[key] = value
+	}
+	return result
+}
+
+func message(m interface{}) string {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Sprintf("%v", m)
+	}
+	data, err := protojson.MarshalOptions{}.Marshal(msg)
+	if err != nil {
+		return fmt.Sprintf("%v", m)
+	}
+	if len(data) > auditMaxMessage {
+		return string(data[:auditMaxMessage]) + "...(truncated)"
+	}
+	return string(data)
+}
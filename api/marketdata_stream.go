@@ -0,0 +1,446 @@
+package api
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	streamBufferSize = 100
+	streamMinBackoff = time.Second
+	streamMaxBackoff = time.Minute
+)
+
+type candleSubscription struct {
+	figi     string
+	interval investapi.SubscriptionInterval
+}
+
+type orderBookSubscription struct {
+	figi  string
+	depth int32
+}
+
+// MarketDataStream keeps one bidirectional MarketDataStream open, restores its subscriptions
+// after reconnect and delivers events to typed channels.
+// Subscriptions can be changed before and while Run is working.
+type MarketDataStream struct {
+	client investapi.MarketDataStreamServiceClient
+
+	mu         sync.Mutex
+	stream     investapi.MarketDataStreamService_MarketDataStreamClient
+	candles    map[candleSubscription]struct{}
+	orderBooks map[orderBookSubscription]struct{}
+	trades     map[string]struct{}
+	lastPrices map[string]struct{}
+	infos      map[string]struct{}
+
+	candleCh        chan *investapi.Candle
+	orderBookCh     chan *investapi.OrderBook
+	tradeCh         chan *investapi.Trade
+	lastPriceCh     chan *investapi.LastPrice
+	tradingStatusCh chan *investapi.TradingStatus
+}
+
+func NewMarketDataStream(client investapi.MarketDataStreamServiceClient) *MarketDataStream {
+	return &MarketDataStream{
+		client:          client,
+		candles:         make(map[candleSubscription]struct{}),
+		orderBooks:      make(map[orderBookSubscription]struct{}),
+		trades:          make(map[string]struct{}),
+		lastPrices:      make(map[string]struct{}),
+		infos:           make(map[string]struct{}),
+		candleCh:        make(chan *investapi.Candle, streamBufferSize),
+		orderBookCh:     make(chan *investapi.OrderBook, streamBufferSize),
+		tradeCh:         make(chan *investapi.Trade, streamBufferSize),
+		lastPriceCh:     make(chan *investapi.LastPrice, streamBufferSize),
+		tradingStatusCh: make(chan *investapi.TradingStatus, streamBufferSize),
+	}
+}
+
+func (c Client) NewMarketDataStream() *MarketDataStream {
+	return NewMarketDataStream(c.MarketDataStreamServiceClient)
+}
+
+// Candles and the other event channels are closed when Run returns.
+func (s *MarketDataStream) Candles() <-chan *investapi.Candle {
+	return s.candleCh
+}
+
+func (s *MarketDataStream) OrderBooks() <-chan *investapi.OrderBook {
+	return s.orderBookCh
+}
+
+func (s *MarketDataStream) Trades() <-chan *investapi.Trade {
+	return s.tradeCh
+}
+
+func (s *MarketDataStream) LastPrices() <-chan *investapi.LastPrice {
+	return s.lastPriceCh
+}
+
+func (s *MarketDataStream) TradingStatuses() <-chan *investapi.TradingStatus {
+	return s.tradingStatusCh
+}
+
+func (s *MarketDataStream) SubscribeCandles(interval investapi.SubscriptionInterval, figis ...string) error {
+	return s.changeCandles(investapi.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE, interval, figis)
+}
+
+func (s *MarketDataStream) UnsubscribeCandles(interval investapi.SubscriptionInterval, figis ...string) error {
+	return s.changeCandles(investapi.SubscriptionAction_SUBSCRIPTION_ACTION_UNSUBSCRIBE, interval, figis)
+}
+
+func (s *MarketDataStream) changeCandles(action investapi.SubscriptionAction, interval investapi.SubscriptionInterval, figis []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instruments := make([]*investapi.CandleInstrument, 0, len(figis))
+	for _, figi := range figis {
+		key := candleSubscription{figi: figi, interval: interval}
+		if action == investapi.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE {
+			s.candles[key] = struct{}{}
+		} else {
+			delete(s.candles, key)
+		}
+		instruments = append(instruments, &investapi.CandleInstrument{Figi: figi, Interval: interval})
+	}
+	return s.send(candlesRequest(action, instruments))
+}
+
+func (s *MarketDataStream) SubscribeOrderBook(depth int32, figis ...string) error {
+	return s.changeOrderBooks(investapi.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE, depth, figis)
+}
+
+func (s *MarketDataStream) UnsubscribeOrderBook(depth int32, figis ...string) error {
+	return s.changeOrderBooks(investapi.SubscriptionAction_SUBSCRIPTION_ACTION_UNSUBSCRIBE, depth, figis)
+}
+
+func (s *MarketDataStream) changeOrderBooks(action investapi.SubscriptionAction, depth int32, figis []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instruments := make([]*investapi.OrderBookInstrument, 0, len(figis))
+	for _, figi := range figis {
+		key := orderBookSubscription{figi: figi, depth: depth}
+		if action == investapi.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE {
+			s.orderBooks[key] = struct{}{}
+		} else {
+			delete(s.orderBooks, key)
+		}
+		instruments = append(instruments, &investapi.OrderBookInstrument{Figi: figi, Depth: depth})
+	}
+	return s.send(orderBooksRequest(action, instruments))
+}
+
+func (s *MarketDataStream) SubscribeTrades(figis ...string) error {
+	return s.changeFigis(s.trades, investapi.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE, figis, tradesRequest)
+}
+
+func (s *MarketDataStream) UnsubscribeTrades(figis ...string) error {
+	return s.changeFigis(s.trades, investapi.SubscriptionAction_SUBSCRIPTION_ACTION_UNSUBSCRIBE, figis, tradesRequest)
+}
+
+func (s *MarketDataStream) SubscribeLastPrices(figis ...string) error {
+	return s.changeFigis(s.lastPrices, investapi.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE, figis, lastPricesRequest)
+}
+
+func (s *MarketDataStream) UnsubscribeLastPrices(figis ...string) error {
+	return s.changeFigis(s.lastPrices, investapi.SubscriptionAction_SUBSCRIPTION_ACTION_UNSUBSCRIBE, figis, lastPricesRequest)
+}
+
+func (s *MarketDataStream) SubscribeTradingStatus(figis ...string) error {
+	return s.changeFigis(s.infos, investapi.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE, figis, infoRequest)
+}
+
+func (s *MarketDataStream) UnsubscribeTradingStatus(figis ...string) error {
+	return s.changeFigis(s.infos, investapi.SubscriptionAction_SUBSCRIPTION_ACTION_UNSUBSCRIBE, figis, infoRequest)
+}
+
+func (s *MarketDataStream) changeFigis(
+	subscriptions map[string]struct{},
+	action investapi.SubscriptionAction,
+	figis []string,
+	build func(investapi.SubscriptionAction, []string) *investapi.MarketDataRequest,
+) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, figi := range figis {
+		if action == investapi.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE {
+			subscriptions[figi] = struct{}{}
+		} else {
+			delete(subscriptions, figi)
+		}
+	}
+	return s.send(build(action, figis))
+}
+
+// send should be called with s.mu held, without open stream the request is sent on (re)connect.
+func (s *MarketDataStream) send(req *investapi.MarketDataRequest) error {
+	if s.stream == nil {
+		return nil
+	}
+	if err := s.stream.Send(req); err != nil {
+		return errors.Wrap(err, "fail send market data request")
+	}
+	return nil
+}
+
+// Run connects and reads the stream until ctx is done, reconnecting with exponential backoff.
+// It should be called once, slow readers of the channels hold the stream back.
+func (s *MarketDataStream) Run(ctx context.Context) error {
+	defer s.closeChannels()
+
+	backoff := streamMinBackoff
+	for {
+		received, err := s.runOnce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if received {
+			backoff = streamMinBackoff
+		}
+		logrus.WithError(err).WithField("backoff", backoff).Warn("market data stream dropped, reconnecting")
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+func (s *MarketDataStream) runOnce(ctx context.Context) (received bool, err error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := s.client.MarketDataStream(streamCtx)
+	if err != nil {
+		return false, errors.Wrap(err, "fail open market data stream")
+	}
+	if err = s.attach(stream); err != nil {
+		return false, err
+	}
+	defer s.detach()
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return received, errors.New("market data stream closed by server")
+		}
+		if err != nil {
+			return received, errors.Wrap(err, "fail receive market data")
+		}
+		received = true
+		if !s.dispatch(ctx, resp) {
+			return received, ctx.Err()
+		}
+	}
+}
+
+// attach makes stream current and resubscribes everything subscribed so far.
+func (s *MarketDataStream) attach(stream investapi.MarketDataStreamService_MarketDataStreamClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stream = stream
+
+	subscribe := investapi.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE
+	requests := []*investapi.MarketDataRequest{}
+	if len(s.candles) > 0 {
+		instruments := []*investapi.CandleInstrument{}
+		for key := range s.candles {
+			instruments = append(instruments, &investapi.CandleInstrument{Figi: key.figi, Interval: key.interval})
+		}
+		requests = append(requests, candlesRequest(subscribe, instruments))
+	}
+	if len(s.orderBooks) > 0 {
+		instruments := []*investapi.OrderBookInstrument{}
+		for key := range s.orderBooks {
+			instruments = append(instruments, &investapi.OrderBookInstrument{Figi: key.figi, Depth: key.depth})
+		}
+		requests = append(requests, orderBooksRequest(subscribe, instruments))
+	}
+	if len(s.trades) > 0 {
+		requests = append(requests, tradesRequest(subscribe, keys(s.trades)))
+	}
+	if len(s.lastPrices) > 0 {
+		requests = append(requests, lastPricesRequest(subscribe, keys(s.lastPrices)))
+	}
+	if len(s.infos) > 0 {
+		requests = append(requests, infoRequest(subscribe, keys(s.infos)))
+	}
+
+	for _, req := range requests {
+		if err := s.send(req); err != nil {
+			s.stream = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MarketDataStream) detach() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stream = nil
+}
+
+// dispatch returns false if ctx is done before the event was delivered.
+func (s *MarketDataStream) dispatch(ctx context.Context, resp *investapi.MarketDataResponse) bool {
+	switch payload := resp.GetPayload().(type) {
+	case *investapi.MarketDataResponse_Candle:
+		select {
+		case s.candleCh <- payload.Candle:
+		case <-ctx.Done():
+			return false
+		}
+	case *investapi.MarketDataResponse_Orderbook:
+		select {
+		case s.orderBookCh <- payload.Orderbook:
+		case <-ctx.Done():
+			return false
+		}
+	case *investapi.MarketDataResponse_Trade:
+		select {
+		case s.tradeCh <- payload.Trade:
+		case <-ctx.Done():
+			return false
+		}
+	case *investapi.MarketDataResponse_LastPrice:
+		select {
+		case s.lastPriceCh <- payload.LastPrice:
+		case <-ctx.Done():
+			return false
+		}
+	case *investapi.MarketDataResponse_TradingStatus:
+		select {
+		case s.tradingStatusCh <- payload.TradingStatus:
+		case <-ctx.Done():
+			return false
+		}
+	case *investapi.MarketDataResponse_SubscribeCandlesResponse:
+		for _, sub := range payload.SubscribeCandlesResponse.GetCandlesSubscriptions() {
+			logSubscription("candles", payload.SubscribeCandlesResponse.GetTrackingId(), sub.GetFigi(), sub.GetSubscriptionStatus())
+		}
+	case *investapi.MarketDataResponse_SubscribeOrderBookResponse:
+		for _, sub := range payload.SubscribeOrderBookResponse.GetOrderBookSubscriptions() {
+			logSubscription("order_book", payload.SubscribeOrderBookResponse.GetTrackingId(), sub.GetFigi(), sub.GetSubscriptionStatus())
+		}
+	case *investapi.MarketDataResponse_SubscribeTradesResponse:
+		for _, sub := range payload.SubscribeTradesResponse.GetTradeSubscriptions() {
+			logSubscription("trades", payload.SubscribeTradesResponse.GetTrackingId(), sub.GetFigi(), sub.GetSubscriptionStatus())
+		}
+	case *investapi.MarketDataResponse_SubscribeLastPriceResponse:
+		for _, sub := range payload.SubscribeLastPriceResponse.GetLastPriceSubscriptions() {
+			logSubscription("last_price", payload.SubscribeLastPriceResponse.GetTrackingId(), sub.GetFigi(), sub.GetSubscriptionStatus())
+		}
+	case *investapi.MarketDataResponse_SubscribeInfoResponse:
+		for _, sub := range payload.SubscribeInfoResponse.GetInfoSubscriptions() {
+			logSubscription("info", payload.SubscribeInfoResponse.GetTrackingId(), sub.GetFigi(), sub.GetSubscriptionStatus())
+		}
+	}
+	return true
+}
+
+func logSubscription(kind, trackingID, figi string, status investapi.SubscriptionStatus) {
+	if status == investapi.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+		return
+	}
+	logrus.WithFields(logrus.Fields{
+		"subscription": kind,
+		"tracking_id":  trackingID,
+		"figi":         figi,
+		"status":       status,
+	}).Warn("market data subscription failed")
+}
+
+func (s *MarketDataStream) closeChannels() {
+	close(s.candleCh)
+	close(s.orderBookCh)
+	close(s.tradeCh)
+	close(s.lastPriceCh)
+	close(s.tradingStatusCh)
+}
+
+func keys(set map[string]struct{}) []string {
+	result := make([]string, 0, len(set))
+	for key := range set {
+		result = append(result, key)
+	}
+	return result
+}
+
+func candlesRequest(action investapi.SubscriptionAction, instruments []*investapi.CandleInstrument) *investapi.MarketDataRequest {
+	return &investapi.MarketDataRequest{
+		Payload: &investapi.MarketDataRequest_SubscribeCandlesRequest{
+			SubscribeCandlesRequest: &investapi.SubscribeCandlesRequest{
+				SubscriptionAction: action,
+				Instruments:        instruments,
+			},
+		},
+	}
+}
+
+func orderBooksRequest(action investapi.SubscriptionAction, instruments []*investapi.OrderBookInstrument) *investapi.MarketDataRequest {
+	return &investapi.MarketDataRequest{
+		Payload: &investapi.MarketDataRequest_SubscribeOrderBookRequest{
+			SubscribeOrderBookRequest: &investapi.SubscribeOrderBookRequest{
+				SubscriptionAction: action,
+				Instruments:        instruments,
+			},
+		},
+	}
+}
+
+func tradesRequest(action investapi.SubscriptionAction, figis []string) *investapi.MarketDataRequest {
+	instruments := make([]*investapi.TradeInstrument, 0, len(figis))
+	for _, figi := range figis {
+		instruments = append(instruments, &investapi.TradeInstrument{Figi: figi})
+	}
+	return &investapi.MarketDataRequest{
+		Payload: &investapi.MarketDataRequest_SubscribeTradesRequest{
+			SubscribeTradesRequest: &investapi.SubscribeTradesRequest{
+				SubscriptionAction: action,
+				Instruments:        instruments,
+			},
+		},
+	}
+}
+
+func lastPricesRequest(action investapi.SubscriptionAction, figis []string) *investapi.MarketDataRequest {
+	instruments := make([]*investapi.LastPriceInstrument, 0, len(figis))
+	for _, figi := range figis {
+		instruments = append(instruments, &investapi.LastPriceInstrument{Figi: figi})
+	}
+	return &investapi.MarketDataRequest{
+		Payload: &investapi.MarketDataRequest_SubscribeLastPriceRequest{
+			SubscribeLastPriceRequest: &investapi.SubscribeLastPriceRequest{
+				SubscriptionAction: action,
+				Instruments:        instruments,
+			},
+		},
+	}
+}
+
+func infoRequest(action investapi.SubscriptionAction, figis []string) *investapi.MarketDataRequest {
+	instruments := make([]*investapi.InfoInstrument, 0, len(figis))
+	for _, figi := range figis {
+		instruments = append(instruments, &investapi.InfoInstrument{Figi: figi})
+	}
+	return &investapi.MarketDataRequest{
+		Payload: &investapi.MarketDataRequest_SubscribeInfoRequest{
+			SubscribeInfoRequest: &investapi.SubscribeInfoRequest{
+				SubscriptionAction: action,
+				Instruments:        instruments,
+			},
+		},
+	}
+}
@@ -0,0 +1,160 @@
+package api_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/fakeapi"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// streamed runs MarketDataStream.Run against the fake server until the test ends.
+type streamed struct {
+	server *fakeapi.Server
+	stream *api.MarketDataStream
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// newStreamed subscribes the stream with subscribe before Run starts.
+func newStreamed(t *testing.T, subscribe func(*api.MarketDataStream)) *streamed {
+	logrus.SetLevel(logrus.ErrorLevel)
+	s := &streamed{server: fakeapi.New(), done: make(chan error, 1)}
+	t.Cleanup(s.server.Stop)
+	s.server.SetTariff(&investapi.GetUserTariffResponse{})
+	client, err := s.server.Dial(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	s.stream = client.NewMarketDataStream()
+	subscribe(s.stream)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	t.Cleanup(cancel)
+	go func() { s.done <- s.stream.Run(ctx) }()
+	return s
+}
+
+// eventually publishes until received reports the event, the server drops events of figis
+// the stream has not subscribed yet and the subscription is sent after connect.
+func eventually(t *testing.T, what string, publish func(), received func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		publish()
+		if received() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%v isn't received", what)
+}
+
+func testCandle(volume int64) *investapi.Candle {
+	return &investapi.Candle{
+		Figi:     testFigi,
+		Interval: investapi.SubscriptionInterval_SUBSCRIPTION_INTERVAL_ONE_MINUTE,
+		Close:    money.FromInt(100).Quotation(),
+		Time:     timestamppb.New(time.Date(2024, 3, 12, 8, 0, 0, 0, time.UTC)),
+		Volume:   volume,
+	}
+}
+
+// candleOf returns a receiver of the candle with volume, other candles are skipped.
+func candleOf(stream *api.MarketDataStream, volume int64) func() bool {
+	return func() bool {
+		for {
+			select {
+			case candle := <-stream.Candles():
+				if candle.Volume == volume {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}
+}
+
+func TestMarketDataStreamDelivers(t *testing.T) {
+	s := newStreamed(t, func(stream *api.MarketDataStream) {
+		stream.SubscribeCandles(investapi.SubscriptionInterval_SUBSCRIPTION_INTERVAL_ONE_MINUTE, testFigi)
+		stream.SubscribeLastPrices(testFigi)
+		stream.SubscribeTradingStatus(testFigi)
+	})
+
+	eventually(t, "candle", func() { s.server.PublishCandle(testCandle(1)) }, candleOf(s.stream, 1))
+	eventually(t, "last price", func() { s.server.PublishLastPrice(testFigi, money.FromInt(101).Quotation()) }, func() bool {
+		select {
+		case price := <-s.stream.LastPrices():
+			return money.FromQuotation(price.Price).Equal(money.FromInt(101))
+		default:
+			return false
+		}
+	})
+	eventually(t, "trading status", func() {
+		s.server.SetTradingStatus(testFigi, investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_BREAK_IN_TRADING)
+	}, func() bool {
+		select {
+		case status := <-s.stream.TradingStatuses():
+			return status.TradingStatus == investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_BREAK_IN_TRADING
+		default:
+			return false
+		}
+	})
+
+	// subscriptions made while Run is working are sent on the open stream
+	if err := s.stream.SubscribeTrades(testFigi); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "trade", func() { s.server.PublishTrade(&investapi.Trade{Figi: testFigi, Quantity: 3}) }, func() bool {
+		select {
+		case trade := <-s.stream.Trades():
+			return trade.Quantity == 3
+		default:
+			return false
+		}
+	})
+}
+
+func TestMarketDataStreamResubscribesAfterDrop(t *testing.T) {
+	s := newStreamed(t, func(stream *api.MarketDataStream) {
+		stream.SubscribeCandles(investapi.SubscriptionInterval_SUBSCRIPTION_INTERVAL_ONE_MINUTE, testFigi)
+	})
+	eventually(t, "candle", func() { s.server.PublishCandle(testCandle(1)) }, candleOf(s.stream, 1))
+
+	// the dropped stream may still deliver what it has queued, it's gone well before the client
+	// reconnects after a second, the new stream has no subscriptions until the client restores them
+	s.server.DropStreams()
+	time.Sleep(200 * time.Millisecond)
+	eventually(t, "candle after reconnect", func() { s.server.PublishCandle(testCandle(2)) }, candleOf(s.stream, 2))
+}
+
+func TestMarketDataStreamClosesChannels(t *testing.T) {
+	s := newStreamed(t, func(stream *api.MarketDataStream) {
+		stream.SubscribeCandles(investapi.SubscriptionInterval_SUBSCRIPTION_INTERVAL_ONE_MINUTE, testFigi)
+	})
+	eventually(t, "candle", func() { s.server.PublishCandle(testCandle(1)) }, candleOf(s.stream, 1))
+
+	s.cancel()
+	select {
+	case err := <-s.done:
+		if err != context.Canceled {
+			t.Fatalf("run returned %v, want canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run doesn't return after cancel")
+	}
+	for range s.stream.Candles() {
+	}
+	if _, ok := <-s.stream.TradingStatuses(); ok {
+		t.Fatal("channel is open after run returned")
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// operationsPageSpan limits the period of one GetOperations request, longer ranges are requested page by page
+const operationsPageSpan = 30 * 24 * time.Hour
+
+// OperationsFilter selects operations, zero values match everything.
+type OperationsFilter struct {
+	From time.Time
+	// To zero value is now
+	To    time.Time
+	Figi  string
+	State investapi.OperationState
+	Types []investapi.OperationType
+}
+
+// Match checks operation against the filter, the API filters by figi and state only.
+func (f OperationsFilter) Match(operation *investapi.Operation) bool {
+	date := operation.GetDate().AsTime()
+	if !f.From.IsZero() && date.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && date.After(f.To) {
+		return false
+	}
+	if f.Figi != "" && operation.GetFigi() != f.Figi {
+		return false
+	}
+	if f.State != investapi.OperationState_OPERATION_STATE_UNSPECIFIED && operation.GetState() != f.State {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, operationType := range f.Types {
+		if operation.GetOperationType() == operationType {
+			return true
+		}
+	}
+	return false
+}
+
+// GetOperationsRange returns operations of the range sorted by date, the range may be of any length.
+func (c Client) GetOperationsRange(ctx context.Context, accountID string, filter OperationsFilter) ([]*investapi.Operation, error) {
+	if filter.From.IsZero() {
+		return nil, errors.New("operations range has no start")
+	}
+	if filter.To.IsZero() {
+		filter.To = c.Clock.Now()
+	}
+	if !filter.From.Before(filter.To) {
+		return nil, errors.Errorf("operations range %v - %v is empty", filter.From, filter.To)
+	}
+
+	seen := make(map[string]bool)
+	operations := []*investapi.Operation{}
+	for from := filter.From; from.Before(filter.To); from = from.Add(operationsPageSpan) {
+		to := from.Add(operationsPageSpan)
+		if to.After(filter.To) {
+			to = filter.To
+		}
+		req := investapi.OperationsRequest{
+			AccountId: accountID,
+			From:      timestamppb.New(from),
+			To:        timestamppb.New(to),
+			State:     filter.State,
+			Figi:      filter.Figi,
+		}
+		resp, err := c.Broker.GetOperations(ctx, &req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail get operations from %v to %v", from, to)
+		}
+		for _, operation := range resp.GetOperations() {
+			// operations on the edge of pages are returned twice
+			if seen[operation.GetId()] || !filter.Match(operation) {
+				continue
+			}
+			seen[operation.GetId()] = true
+			operations = append(operations, operation)
+		}
+	}
+	sort.SliceStable(operations, func(i, j int) bool {
+		return operations[i].GetDate().AsTime().Before(operations[j].GetDate().AsTime())
+	})
+
+	logrus.WithFields(logrus.Fields{
+		"account_id": accountID,
+		"from":       filter.From,
+		"to":         filter.To,
+		"count":      len(operations),
+	}).Debug("GetOperations")
+	return operations, nil
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"github.com/nax11/tinkoff_bot_public/clock"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// Option sets up a client created by NewWithOpts or NewFromConfig.
+type Option func(*options)
+
+type options struct {
+	insecure bool
+	clock    clock.Clock
+	appName  string
+	audit    *logrus.Logger
+	retry    RetryPolicy
+	dial     []grpc.DialOption
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		clock:   clock.Real,
+		appName: DefaultAppName,
+		retry:   DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Insecure makes the client dial without TLS, e.g. a local fake server over bufconn or plain TCP.
+func Insecure() Option {
+	return func(o *options) {
+		o.insecure = true
+	}
+}
+
+// UseClock sets Client.Clock, clock.Real is used without the option.
+func UseClock(c clock.Clock) Option {
+	return func(o *options) {
+		if c != nil {
+			o.clock = c
+		}
+	}
+}
+
+// AppName sets x-app-name sent with every call, DefaultAppName is used without the option.
+func AppName(name string) Option {
+	return func(o *options) {
+		if name != "" {
+			o.appName = name
+		}
+	}
+}
+
+// AuditLog writes every call of the client to logger, without the option calls are logged
+// by the standard logger at debug level.
+func AuditLog(logger *logrus.Logger) Option {
+	return func(o *options) {
+		o.audit = logger
+	}
+}
+
+// Retry sets the retry policy of unary calls, DefaultRetryPolicy is used without the option.
+func Retry(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = policy
+	}
+}
+
+// DialOptions passes options to grpc.Dial, e.g. a dialer of an in-process server.
+func DialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dial = append(o.dial, opts...)
+	}
+}
@@ -0,0 +1,418 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nax11/tinkoff_bot_public/atomicfile"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// intentMatchSkew is how much earlier than the intent an order found by GetOrders may be dated,
+// clocks of the bot and of the broker differ.
+const intentMatchSkew = time.Minute
+
+type IntentStatus string
+
+const (
+	// IntentPending is saved before the order is sent, the outcome of the call is unknown
+	IntentPending IntentStatus = "pending"
+	// IntentPlaced has the order id assigned by the broker
+	IntentPlaced IntentStatus = "placed"
+	// IntentFinished is filled, cancelled, rejected or never reached the broker
+	IntentFinished IntentStatus = "finished"
+)
+
+// OrderIntent is an order saved before it's sent. Scope names a sequence of orders of which
+// only one is active at a time, e.g. strategy/account/figi/buy. ClientOrderID is derived from
+// the scope and the sequence number, so a repeated send is deduplicated by the broker.
+type OrderIntent struct {
+	Scope         string       `json:"scope"`
+	Seq           int64        `json:"seq"`
+	ClientOrderID string       `json:"client_order_id"`
+	AccountID     string       `json:"account_id"`
+	Figi          string       `json:"figi"`
+	OrderID       string       `json:"order_id,omitempty"`
+	Status        IntentStatus `json:"status"`
+	// Request is the PostOrderRequest in protojson, active orders are matched against it while OrderID is empty
+	Request   json.RawMessage `json:"request"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+func (i OrderIntent) request() (*investapi.PostOrderRequest, error) {
+	req := &investapi.PostOrderRequest{}
+	if err := protojson.Unmarshal(i.Request, req); err != nil {
+		return nil, errors.Wrapf(err, "fail parse request of order intent %v", i.ClientOrderID)
+	}
+	return req, nil
+}
+
+// IntentStore keeps the last intent of every scope in a JSON file.
+type IntentStore struct {
+	mu   sync.Mutex
+	path string
+	file intentsFile
+}
+
+type intentsFile struct {
+	// Namespace is generated with the file, ids of a new file don't repeat ids of a deleted one
+	Namespace uuid.UUID               `json:"namespace"`
+	Intents   map[string]*OrderIntent `json:"intents"`
+}
+
+// OpenIntentStore reads intents saved by a previous run, empty path keeps them in memory only.
+func OpenIntentStore(path string) (*IntentStore, error) {
+	s := &IntentStore{
+		path: path,
+		file: intentsFile{Namespace: uuid.New(), Intents: make(map[string]*OrderIntent)},
+	}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "fail read order intents")
+	}
+	file := intentsFile{}
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "fail parse order intents %v", path)
+	}
+	if file.Namespace != uuid.Nil {
+		s.file.Namespace = file.Namespace
+	}
+	for scope, intent := range file.Intents {
+		s.file.Intents[scope] = intent
+	}
+	return s, nil
+}
+
+// Last returns the last intent of the scope.
+func (s *IntentStore) Last(scope string) (OrderIntent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	intent, ok := s.file.Intents[scope]
+	if !ok {
+		return OrderIntent{}, false
+	}
+	return *intent, true
+}
+
+// Unfinished returns intents of the account whose orders may still be active, oldest first.
+func (s *IntentStore) Unfinished(accountID string) []OrderIntent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	intents := []OrderIntent{}
+	for _, intent := range s.file.Intents {
+		if intent.AccountID == accountID && intent.Status != IntentFinished {
+			intents = append(intents, *intent)
+		}
+	}
+	sort.Slice(intents, func(a, b int) bool { return intents[a].CreatedAt.Before(intents[b].CreatedAt) })
+	return intents
+}
+
+// create saves the next intent of the scope created at now and sets its client order id to req.
+func (s *IntentStore) create(scope string, req *investapi.PostOrderRequest, now time.Time) (OrderIntent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq := int64(1)
+	if last, ok := s.file.Intents[scope]; ok {
+		if last.Status != IntentFinished {
+			return OrderIntent{}, errors.Errorf("order intent %v of %v is not finished", last.ClientOrderID, scope)
+		}
+		seq = last.Seq + 1
+	}
+	req.OrderId = uuid.NewSHA1(s.file.Namespace, []byte(fmt.Sprintf("%v#%v", scope, seq))).String()
+	data, err := protojson.Marshal(req)
+	if err != nil {
+		return OrderIntent{}, errors.Wrap(err, "fail marshal order request")
+	}
+	intent := &OrderIntent{
+		Scope:         scope,
+		Seq:           seq,
+		ClientOrderID: req.OrderId,
+		AccountID:     req.AccountId,
+		Figi:          req.Figi,
+		Status:        IntentPending,
+		Request:       data,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	previous := s.file.Intents[scope]
+	s.file.Intents[scope] = intent
+	if err = s.save(); err != nil {
+		// the order mustn't be sent without its intent on disk
+		if previous != nil {
+			s.file.Intents[scope] = previous
+		} else {
+			delete(s.file.Intents, scope)
+		}
+		return OrderIntent{}, err
+	}
+	return *intent, nil
+}
+
+// update records the broker order id and the status of the intent with the client order id at now.
+func (s *IntentStore) update(scope, clientOrderID, orderID string, intentStatus IntentStatus, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	intent, ok := s.file.Intents[scope]
+	if !ok || intent.ClientOrderID != clientOrderID {
+		return nil
+	}
+	if orderID != "" {
+		intent.OrderID = orderID
+	}
+	intent.Status = intentStatus
+	intent.UpdatedAt = now
+	return s.save()
+}
+
+// owned tells whether orderID is the order of some intent.
+func (s *IntentStore) owned(orderID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, intent := range s.file.Intents {
+		if intent.OrderID == orderID {
+			return true
+		}
+	}
+	return false
+}
+
+// save rewrites the file. s.mu must be held.
+func (s *IntentStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.file)
+	if err != nil {
+		return errors.Wrap(err, "fail marshal order intents")
+	}
+	return atomicfile.WriteFile(s.path, data)
+}
+
+// IntentActiveError is returned by PostOrderIntent when the order of the previous intent of the scope
+// is still active, the new order isn't posted. Matched tells that the intent had no order id and
+// the order was matched among active orders by its fields, it may be an order posted by hand.
+type IntentActiveError struct {
+	Scope   string
+	OrderID string
+	Matched bool
+}
+
+func (e *IntentActiveError) Error() string {
+	if e.Matched {
+		return fmt.Sprintf("order %v matching the pending intent of %v is active", e.OrderID, e.Scope)
+	}
+	return fmt.Sprintf("order %v of the last intent of %v is active", e.OrderID, e.Scope)
+}
+
+// PostOrderIntent posts the order as the next intent of scope, the intent is saved before the order is sent.
+// An unfinished intent of the scope is reconciled first: when its order is active, IntentActiveError
+// is returned and the new order isn't posted; when its order isn't found, the intent is finished
+// and the new order is posted. A saved request is never sent again, its price and lots may be
+// stale by now. Without the intent store the order is just posted.
+func (c Client) PostOrderIntent(ctx context.Context, scope string, order *OrderBuilder) (orderID string, err error) {
+	if c.Intents == nil {
+		return c.PostOrder(ctx, order)
+	}
+	if last, ok := c.Intents.Last(scope); ok && last.Status != IntentFinished {
+		orderID, matched, err := c.reconcileIntent(ctx, last)
+		if err != nil {
+			return "", err
+		}
+		if orderID != "" {
+			return "", &IntentActiveError{Scope: scope, OrderID: orderID, Matched: matched}
+		}
+	}
+
+	req, err := order.Build()
+	if err != nil {
+		return "", errors.Wrap(err, "invalid order")
+	}
+	intent, err := c.Intents.create(scope, req, c.Clock.Now())
+	if err != nil {
+		return "", err
+	}
+	orderID, active, err := c.sendIntent(ctx, intent, req)
+	if err != nil {
+		return "", err
+	}
+	if !active && orderID == "" {
+		return "", errors.New("post order with unsuccessful status")
+	}
+	return orderID, nil
+}
+
+// ReconcileIntents resolves unfinished intents of the account left by a previous run, nothing is posted.
+// Orders of intents are looked up by GetOrderState or matched among active orders,
+// an intent whose order isn't found is finished.
+func (c Client) ReconcileIntents(ctx context.Context, accountID string) error {
+	if c.Intents == nil {
+		return nil
+	}
+	for _, intent := range c.Intents.Unfinished(accountID) {
+		if _, _, err := c.reconcileIntent(ctx, intent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settleIntent marks the intent of the order finished once the order is.
+func (c Client) settleIntent(scope, orderID string) {
+	if c.Intents == nil || scope == "" {
+		return
+	}
+	last, ok := c.Intents.Last(scope)
+	if !ok || last.OrderID != orderID {
+		return
+	}
+	if err := c.Intents.update(scope, last.ClientOrderID, "", IntentFinished, c.Clock.Now()); err != nil {
+		logrus.WithError(err).WithField("scope", scope).Warn("fail save order intent")
+	}
+}
+
+// reconcileIntent finds the active order of the intent, orderID is empty when there's none and the intent
+// is finished. matched is true when the intent had no order id and the order was matched by its fields.
+func (c Client) reconcileIntent(ctx context.Context, intent OrderIntent) (orderID string, matched bool, err error) {
+	log := intentLog(intent)
+	if intent.OrderID != "" {
+		state, err := c.Broker.GetOrderState(ctx, &investapi.GetOrderStateRequest{
+			AccountId: intent.AccountID,
+			OrderId:   intent.OrderID,
+		})
+		if status.Code(errors.Cause(err)) == codes.NotFound {
+			log.Warn("order of intent is not found")
+			return "", false, c.Intents.update(intent.Scope, intent.ClientOrderID, "", IntentFinished, c.Clock.Now())
+		}
+		if err != nil {
+			return "", false, errors.Wrapf(err, "fail get state of order %v", intent.OrderID)
+		}
+		if OrderStatusOf(state.ExecutionReportStatus).IsFinal() {
+			log.WithField("status", OrderStatusOf(state.ExecutionReportStatus)).Info("order intent is finished")
+			return "", false, c.Intents.update(intent.Scope, intent.ClientOrderID, "", IntentFinished, c.Clock.Now())
+		}
+		log.Info("order intent is active")
+		return intent.OrderID, false, c.Intents.update(intent.Scope, intent.ClientOrderID, "", IntentPlaced, c.Clock.Now())
+	}
+
+	req, err := intent.request()
+	if err != nil {
+		return "", false, err
+	}
+	resp, err := c.Broker.GetOrders(ctx, &investapi.GetOrdersRequest{AccountId: intent.AccountID})
+	if err != nil {
+		return "", false, errors.Wrap(err, "fail get orders")
+	}
+	matches := []string{}
+	for _, order := range resp.GetOrders() {
+		if !c.Intents.owned(order.OrderId) && matchesIntent(order, intent, req) {
+			matches = append(matches, order.OrderId)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		// the call either failed or its order is already final, sending it again could post a stale order
+		log.Warn("order of pending intent is not active, intent is finished")
+		return "", false, c.Intents.update(intent.Scope, intent.ClientOrderID, "", IntentFinished, c.Clock.Now())
+	case 1:
+		log.WithField("order_id", matches[0]).Warn("order matching pending intent is found, it's taken as the order of the intent")
+		return matches[0], true, c.Intents.update(intent.Scope, intent.ClientOrderID, matches[0], IntentPlaced, c.Clock.Now())
+	}
+	return "", false, errors.Errorf("orders %v all match pending order intent %v of %v", matches, intent.ClientOrderID, intent.Scope)
+}
+
+// sendIntent posts the request of the saved intent. An error that leaves the outcome unknown keeps
+// the intent pending, any other failure finishes it.
+func (c Client) sendIntent(ctx context.Context, intent OrderIntent, req *investapi.PostOrderRequest) (orderID string, active bool, err error) {
+	log := intentLog(intent).WithFields(logrus.Fields{
+		"direction":  req.Direction.String(),
+		"order_type": req.OrderType.String(),
+		"sandbox":    c.IsSandbox(),
+	})
+	if req.Price != nil {
+		log = log.WithField("price", money.FromQuotation(req.Price).String())
+	}
+	log.WithField("request", fmt.Sprintf("%v", req)).Info("PostOrder")
+	resp, err := c.Broker.PostOrder(ctx, req)
+	if err != nil {
+		if isOutcomeUnknown(err) {
+			log.WithError(err).Warn("order outcome is unknown, intent is kept pending")
+		} else if saveErr := c.Intents.update(intent.Scope, intent.ClientOrderID, "", IntentFinished, c.Clock.Now()); saveErr != nil {
+			log.WithError(saveErr).Warn("fail save order intent")
+		}
+		return "", false, errors.Wrapf(err, "error on execute %v on PostOrder", req.Direction)
+	}
+	log.WithField("response", fmt.Sprintf("%v", resp)).Info("PostOrder sent")
+
+	orderStatus := OrderStatusOf(resp.ExecutionReportStatus)
+	intentStatus := IntentPlaced
+	if orderStatus.IsFinal() {
+		intentStatus = IntentFinished
+	}
+	if err = c.Intents.update(intent.Scope, intent.ClientOrderID, resp.OrderId, intentStatus, c.Clock.Now()); err != nil {
+		return "", false, err
+	}
+	switch orderStatus {
+	case OrderCancelled, OrderRejected:
+		return "", false, nil
+	case OrderFilled:
+		return resp.OrderId, false, nil
+	}
+	return resp.OrderId, true, nil
+}
+
+// matchesIntent tells whether the active order may be the one the intent posted. OrderState of this
+// API version doesn't return the client order id, so the order is compared by figi, direction, lots,
+// price and date, orders of other intents are excluded by the caller.
+func matchesIntent(order *investapi.OrderState, intent OrderIntent, req *investapi.PostOrderRequest) bool {
+	if order.Figi != req.Figi || order.Direction != req.Direction || order.LotsRequested != req.Quantity {
+		return false
+	}
+	if order.OrderDate != nil && order.OrderDate.AsTime().Before(intent.CreatedAt.Add(-intentMatchSkew)) {
+		return false
+	}
+	if req.OrderType == investapi.OrderType_ORDER_TYPE_LIMIT {
+		return money.FromMoneyValue(order.InitialSecurityPrice).Amount.Equal(money.FromQuotation(req.Price))
+	}
+	return true
+}
+
+// isOutcomeUnknown tells whether the failed call may have been executed.
+func isOutcomeUnknown(err error) bool {
+	if errors.Cause(err) == context.Canceled || errors.Cause(err) == context.DeadlineExceeded {
+		return true
+	}
+	switch status.Code(errors.Cause(err)) {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Canceled, codes.Unavailable, codes.Internal:
+		return true
+	}
+	return false
+}
+
+func intentLog(intent OrderIntent) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"scope":           intent.Scope,
+		"client_order_id": intent.ClientOrderID,
+		"account_id":      intent.AccountID,
+		"figi":            intent.Figi,
+	})
+}
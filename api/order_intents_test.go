@@ -0,0 +1,158 @@
+package api_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/clock"
+	"github.com/nax11/tinkoff_bot_public/fakeapi"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+)
+
+const postOrderMethod = "tinkoff.public.invest.api.contract.v1.OrdersService/PostOrder"
+
+// newIntents returns a client posting orders through an intent store on a simulated clock.
+func newIntents(t *testing.T) (*fakeapi.Server, *api.Client, *clock.Simulated) {
+	logrus.SetLevel(logrus.WarnLevel)
+	clk := clock.NewSimulated(time.Date(2024, 3, 12, 8, 0, 0, 0, time.UTC))
+	server := fakeapi.New()
+	t.Cleanup(server.Stop)
+	server.Now = clk.Now
+	server.SetTariff(&investapi.GetUserTariffResponse{})
+	server.OnPostOrder(func(req *investapi.PostOrderRequest) fakeapi.Outcome { return fakeapi.Rest })
+	server.AddShare(&investapi.Share{
+		Figi:              testFigi,
+		Ticker:            "TEST",
+		ClassCode:         "TQBR",
+		Lot:               1,
+		Currency:          "rub",
+		MinPriceIncrement: money.New(0, 10000000).Quotation(),
+	})
+	server.SetLastPrice(testFigi, money.FromInt(100).Quotation())
+	server.AddAccount(testAccount)
+	client, err := server.Dial(false, api.UseClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	client.Intents, err = api.OpenIntentStore(filepath.Join(t.TempDir(), "intents.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, client, clk
+}
+
+func buy(lots int64, price string) *api.OrderBuilder {
+	return api.NewOrder(testAccount, testFigi).Buy().Lots(lots).Limit(money.MustParse(price))
+}
+
+// postLost posts the intent of scope with a call whose outcome is unknown, the intent is left pending.
+func postLost(t *testing.T, server *fakeapi.Server, client *api.Client, scope string, order *api.OrderBuilder) {
+	t.Helper()
+	server.FailCalls(postOrderMethod, 1, codes.Unavailable)
+	if _, err := client.PostOrderIntent(context.Background(), scope, order); err == nil {
+		t.Fatal("failed call posted the order")
+	}
+	if last, _ := client.Intents.Last(scope); last.Status != api.IntentPending {
+		t.Fatalf("intent is %v after a lost call, want pending", last.Status)
+	}
+}
+
+func TestPendingIntentIsNotSentAgain(t *testing.T) {
+	server, client, _ := newIntents(t)
+	postLost(t, server, client, "buy", buy(3, "99"))
+
+	orderID, err := client.PostOrderIntent(context.Background(), "buy", buy(2, "98"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders := server.Orders(testAccount)
+	if len(orders) != 1 || orders[0].OrderId != orderID {
+		t.Fatalf("orders %v, want only the new order %v", orders, orderID)
+	}
+	price := money.FromMoneyValue(orders[0].InitialSecurityPrice).Amount
+	if orders[0].LotsRequested != 2 || !price.Equal(money.MustParse("98")) {
+		t.Fatalf("posted %v lots at %v, want the new order of 2 lots at 98", orders[0].LotsRequested, price)
+	}
+	if last, _ := client.Intents.Last("buy"); last.Seq != 2 || last.OrderID != orderID {
+		t.Fatalf("last intent %+v, want the second one with order %v", last, orderID)
+	}
+}
+
+func TestPendingIntentMatchesActiveOrder(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// active orders posted by hand after the lost call
+		active  int
+		matched bool
+		fails   bool
+	}{
+		{name: "one", active: 1, matched: true},
+		{name: "ambiguous", active: 2, fails: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client, _ := newIntents(t)
+			postLost(t, server, client, "buy", buy(3, "99"))
+			for i := 0; i < tt.active; i++ {
+				if _, err := client.PostOrder(context.Background(), buy(3, "99")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err := client.PostOrderIntent(context.Background(), "buy", buy(2, "98"))
+			activeErr := &api.IntentActiveError{}
+			if errors.As(err, &activeErr) != tt.matched || tt.matched && !activeErr.Matched {
+				t.Fatalf("error %v, want matched %v", err, tt.matched)
+			}
+			if tt.fails && err == nil {
+				t.Fatal("ambiguous match isn't an error")
+			}
+			if len(server.Orders(testAccount)) != tt.active {
+				t.Fatalf("%v orders, want the new order not posted", len(server.Orders(testAccount)))
+			}
+		})
+	}
+}
+
+func TestOrderOfAnotherIntentIsNotMatched(t *testing.T) {
+	server, client, _ := newIntents(t)
+	other, err := client.PostOrderIntent(context.Background(), "other", buy(3, "99"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	postLost(t, server, client, "buy", buy(3, "99"))
+
+	orderID, err := client.PostOrderIntent(context.Background(), "buy", buy(3, "99"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orderID == other || len(server.Orders(testAccount)) != 2 {
+		t.Fatalf("order %v of intent is taken from the other scope %v", orderID, other)
+	}
+}
+
+func TestIntentIsUpdatedOnClientClock(t *testing.T) {
+	server, client, clk := newIntents(t)
+	orderID, err := client.PostOrderIntent(context.Background(), "buy", buy(3, "99"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = server.FillOrder(orderID, 0); err != nil {
+		t.Fatal(err)
+	}
+	clk.Set(clk.Now().Add(time.Hour))
+	if err = client.ReconcileIntents(context.Background(), testAccount); err != nil {
+		t.Fatal(err)
+	}
+	last, _ := client.Intents.Last("buy")
+	if last.Status != api.IntentFinished || !last.UpdatedAt.Equal(clk.Now()) {
+		t.Fatalf("intent is %v at %v, want finished at %v", last.Status, last.UpdatedAt, clk.Now())
+	}
+}
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// OrderManager cancels and re-quotes limit orders. It waits for orders through the tracker,
+// the tracker has to be running.
+type OrderManager struct {
+	client  Client
+	tracker *OrderTracker
+}
+
+func (c Client) NewOrderManager(tracker *OrderTracker) *OrderManager {
+	return &OrderManager{client: c, tracker: tracker}
+}
+
+// Quote returns the price an order should have now.
+type Quote func(ctx context.Context) (money.Decimal, error)
+
+type ManageParams struct {
+	// MaxAge replaces the order when it's older, zero keeps it until the price changes
+	MaxAge time.Duration
+	// CheckInterval is how often Quote is asked for a new price, zero never asks
+	CheckInterval time.Duration
+	Quote         Quote
+	// Exit is checked together with Quote, true cancels the order and Manage returns ErrOrderExited
+	Exit func(ctx context.Context) (bool, error)
+	// Paused holds the order as it is while it returns true, it's neither re-quoted nor exited
+	Paused func() bool
+	// Scope posts replacements as intents of the scope, see Client.PostOrderIntent
+	Scope string
+	// Replaced is called with the id of the order that replaced the managed one and the final state
+	// of the replaced order, lots it executed are not in the event Manage returns
+	Replaced func(orderID string, old *investapi.OrderState)
+}
+
+// pausePollInterval is how often a paused order without CheckInterval is checked for the end of the pause.
+const pausePollInterval = time.Minute
+
+// ErrOrderExited is returned by Manage when the order is cancelled because Exit asked for it.
+var ErrOrderExited = errors.New("order is cancelled on exit")
+
+// Cancel cancels the order and returns its final state.
+// An order that is already filled or cancelled is not an error, its state is returned as is.
+func (m *OrderManager) Cancel(ctx context.Context, accountID, orderID string) (*investapi.OrderState, error) {
+	cancelErr := m.client.CancelOrder(ctx, accountID, orderID)
+	state, err := m.client.Broker.GetOrderState(ctx, &investapi.GetOrderStateRequest{
+		AccountId: accountID,
+		OrderId:   orderID,
+	})
+	if err != nil {
+		if cancelErr != nil {
+			return nil, cancelErr
+		}
+		return nil, errors.Wrapf(err, "fail get state of cancelled order %v", orderID)
+	}
+	if cancelErr != nil && !OrderStatusOf(state.ExecutionReportStatus).IsFinal() {
+		return nil, cancelErr
+	}
+	return state, nil
+}
+
+// CancelAll cancels active orders of the account, figi limits them to one instrument when set,
+// orders with ids in keep are left active. It's used on startup to drop orders left by a previous run.
+func (m *OrderManager) CancelAll(ctx context.Context, accountID, figi string, keep ...string) (cancelled int, err error) {
+	resp, err := m.client.Broker.GetOrders(ctx, &investapi.GetOrdersRequest{AccountId: accountID})
+	if err != nil {
+		return 0, errors.Wrap(err, "fail get orders")
+	}
+	for _, order := range resp.GetOrders() {
+		if figi != "" && order.Figi != figi || contains(keep, order.OrderId) {
+			continue
+		}
+		if _, err = m.Cancel(ctx, accountID, order.OrderId); err != nil {
+			return cancelled, err
+		}
+		cancelled++
+	}
+	return cancelled, nil
+}
+
+// Reprice cancels the order and posts its unfilled lots at price.
+// newOrderID is empty when nothing is left to post, the state of the old order tells why.
+func (m *OrderManager) Reprice(ctx context.Context, accountID, orderID string, price money.Decimal) (newOrderID string, old *investapi.OrderState, err error) {
+	return m.reprice(ctx, "", accountID, orderID, price)
+}
+
+// reprice is Reprice posting the replacement as the next intent of scope when it's set.
+func (m *OrderManager) reprice(ctx context.Context, scope, accountID, orderID string, price money.Decimal) (newOrderID string, old *investapi.OrderState, err error) {
+	old, err = m.Cancel(ctx, accountID, orderID)
+	if err != nil {
+		return "", nil, err
+	}
+	rest := old.LotsRequested - old.LotsExecuted
+	if old.ExecutionReportStatus != investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED || rest <= 0 {
+		return "", old, nil
+	}
+
+	order := NewOrder(accountID, old.Figi).Buy().Lots(rest).Limit(price)
+	if old.Direction == investapi.OrderDirection_ORDER_DIRECTION_SELL {
+		order.Sell()
+	}
+	newOrderID, err = m.client.PostOrderIntent(ctx, scope, order)
+	if err != nil {
+		return "", old, err
+	}
+	return newOrderID, old, nil
+}
+
+// Manage waits until the order reaches a final status. While it waits the order is re-quoted
+// when Quote gives another price and replaced when it's older than MaxAge.
+// The returned event belongs to the last posted order, fills of the replaced ones are passed to Replaced.
+func (m *OrderManager) Manage(ctx context.Context, accountID, orderID string, params ManageParams) (OrderEvent, error) {
+	state, err := m.client.Broker.GetOrderState(ctx, &investapi.GetOrderStateRequest{
+		AccountId: accountID,
+		OrderId:   orderID,
+	})
+	if err != nil {
+		return OrderEvent{}, errors.Wrapf(err, "fail get state of order %v", orderID)
+	}
+	price := money.FromMoneyValue(state.InitialSecurityPrice).Amount
+	placed := m.client.Clock.Now()
+	if state.OrderDate != nil {
+		placed = state.OrderDate.AsTime()
+	}
+
+	for {
+		event, err := m.tracker.Track(accountID, orderID, m.nextCheck(params, placed)).Wait(ctx)
+		if err == nil && event.Status.IsFinal() {
+			m.client.settleIntent(params.Scope, orderID)
+		}
+		if err != ErrOrderTimeout {
+			return event, err
+		}
+		if params.Paused != nil && params.Paused() {
+			logrus.WithField("order_id", orderID).Info("order is held while paused")
+			continue
+		}
+
+		if params.Exit != nil {
+			exit, err := params.Exit(ctx)
+			if err != nil {
+				logrus.WithError(err).WithField("order_id", orderID).Warn("fail check exit condition")
+			} else if exit {
+				state, err := m.Cancel(ctx, accountID, orderID)
+				if err != nil {
+					return OrderEvent{}, err
+				}
+				event := eventOf(accountID, state)
+				m.client.settleIntent(params.Scope, orderID)
+				if event.Status == OrderFilled {
+					return event, nil
+				}
+				return event, ErrOrderExited
+			}
+		}
+
+		newPrice := price
+		if params.Quote != nil {
+			quoted, err := params.Quote(ctx)
+			if err != nil {
+				logrus.WithError(err).WithField("order_id", orderID).Warn("fail quote order, price is kept")
+			} else {
+				newPrice = quoted
+			}
+		}
+		expired := params.MaxAge > 0 && m.client.Clock.Since(placed) >= params.MaxAge
+		if newPrice.Equal(price) && !expired {
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"account_id": accountID,
+			"order_id":   orderID,
+			"price":      price.String(),
+			"new_price":  newPrice.String(),
+			"expired":    expired,
+		}).Info("reprice order")
+		newOrderID, old, err := m.reprice(ctx, params.Scope, accountID, orderID, newPrice)
+		if err != nil {
+			return OrderEvent{}, err
+		}
+		if newOrderID == "" {
+			m.client.settleIntent(params.Scope, orderID)
+			return eventOf(accountID, old), nil
+		}
+		orderID, price, placed = newOrderID, newPrice, m.client.Clock.Now()
+		if params.Replaced != nil {
+			params.Replaced(orderID, old)
+		}
+	}
+}
+
+// nextCheck is the tracking timeout until the next quote or the order expiration, zero waits forever.
+// A paused order doesn't expire, it's checked every CheckInterval or pausePollInterval until resumed.
+func (m *OrderManager) nextCheck(params ManageParams, placed time.Time) time.Duration {
+	wait := params.CheckInterval
+	if params.Paused != nil && params.Paused() {
+		if wait == 0 && params.MaxAge > 0 {
+			wait = pausePollInterval
+		}
+		return wait
+	}
+	if params.MaxAge > 0 {
+		untilExpired := m.client.Clock.Until(placed.Add(params.MaxAge))
+		if untilExpired <= 0 {
+			untilExpired = time.Millisecond
+		}
+		if wait == 0 || untilExpired < wait {
+			wait = untilExpired
+		}
+	}
+	return wait
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func eventOf(accountID string, state *investapi.OrderState) OrderEvent {
+	return OrderEvent{
+		AccountID:     accountID,
+		OrderID:       state.OrderId,
+		Status:        OrderStatusOf(state.ExecutionReportStatus),
+		LotsRequested: state.LotsRequested,
+		LotsExecuted:  state.LotsExecuted,
+		State:         state,
+	}
+}
@@ -0,0 +1,309 @@
+package api_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/clock"
+	"github.com/nax11/tinkoff_bot_public/fakeapi"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testAccount = "test-account"
+	testFigi    = "BBG000TEST01"
+	// pollInterval is how often the tracker polls orders on the simulated clock
+	pollInterval = 5 * time.Second
+)
+
+// managed runs OrderManager.Manage against the fake server in lockstep with a simulated clock.
+type managed struct {
+	t       *testing.T
+	clock   *clock.Simulated
+	server  *fakeapi.Server
+	client  *api.Client
+	manager *api.OrderManager
+	done    chan result
+	// replaced are the orders Manage replaced, they are read after the clock is settled
+	replaced []*investapi.OrderState
+}
+
+type result struct {
+	event api.OrderEvent
+	err   error
+}
+
+func newManaged(t *testing.T) *managed {
+	logrus.SetLevel(logrus.WarnLevel)
+	m := &managed{
+		t:      t,
+		clock:  clock.NewSimulated(time.Date(2024, 3, 12, 8, 0, 0, 0, time.UTC)),
+		server: fakeapi.New(),
+		done:   make(chan result, 1),
+	}
+	t.Cleanup(m.server.Stop)
+	m.server.Now = m.clock.Now
+	m.server.SetTariff(&investapi.GetUserTariffResponse{})
+	m.server.AddShare(&investapi.Share{
+		Figi:              testFigi,
+		Ticker:            "TEST",
+		ClassCode:         "TQBR",
+		Lot:               1,
+		Currency:          "rub",
+		MinPriceIncrement: money.New(0, 10000000).Quotation(),
+	})
+	m.server.SetLastPrice(testFigi, money.FromInt(100).Quotation())
+	m.server.AddAccount(testAccount)
+	if err := m.server.PayIn(testAccount, money.NewMoney(money.FromInt(100000), "rub").MoneyValue()); err != nil {
+		t.Fatal(err)
+	}
+	client, err := m.server.Dial(false, api.UseClock(m.clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	m.client = client
+
+	tracker := client.NewOrderTracker()
+	m.manager = client.NewOrderManager(tracker)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	clock.Go(m.clock, func() { tracker.Run(ctx) })
+	return m
+}
+
+// post places a limit buy of lots at price.
+func (m *managed) post(lots int64, price string) string {
+	m.t.Helper()
+	orderID, err := m.client.PostOrder(context.Background(), api.NewOrder(testAccount, testFigi).Buy().Lots(lots).Limit(money.MustParse(price)))
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return orderID
+}
+
+// manage starts managing the order, replaced orders are collected.
+func (m *managed) manage(orderID string, params api.ManageParams) {
+	params.Replaced = func(orderID string, old *investapi.OrderState) {
+		m.replaced = append(m.replaced, old)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.t.Cleanup(cancel)
+	clock.Go(m.clock, func() {
+		event, err := m.manager.Manage(ctx, testAccount, orderID, params)
+		m.done <- result{event, err}
+	})
+	m.settle()
+}
+
+func (m *managed) settle() {
+	m.t.Helper()
+	select {
+	case <-m.clock.Idle():
+	case <-time.After(5 * time.Second):
+		m.t.Fatal("goroutines aren't parked on the clock")
+	}
+}
+
+// advance moves the clock by d in poll intervals.
+func (m *managed) advance(d time.Duration) {
+	m.t.Helper()
+	for end := m.clock.Now().Add(d); m.clock.Now().Before(end); {
+		m.clock.Set(m.clock.Now().Add(pollInterval))
+		m.settle()
+	}
+}
+
+// result returns what Manage returned, the test fails when it's still running.
+func (m *managed) result() result {
+	m.t.Helper()
+	select {
+	case r := <-m.done:
+		return r
+	default:
+		m.t.Fatal("order is still managed")
+		return result{}
+	}
+}
+
+func (m *managed) running() bool {
+	select {
+	case r := <-m.done:
+		m.done <- r
+		return false
+	default:
+		return true
+	}
+}
+
+// active returns the active orders of the account.
+func (m *managed) active() []*investapi.OrderState {
+	active := []*investapi.OrderState{}
+	for _, order := range m.server.Orders(testAccount) {
+		if !api.OrderStatusOf(order.ExecutionReportStatus).IsFinal() {
+			active = append(active, order)
+		}
+	}
+	return active
+}
+
+func (m *managed) fill(orderID string, lots int64) {
+	m.t.Helper()
+	if err := m.server.FillOrder(orderID, lots); err != nil {
+		m.t.Fatal(err)
+	}
+	m.advance(pollInterval)
+}
+
+func TestManageFill(t *testing.T) {
+	m := newManaged(t)
+	orderID := m.post(3, "99")
+	m.manage(orderID, api.ManageParams{})
+	if !m.running() {
+		t.Fatal("active order isn't managed")
+	}
+
+	m.fill(orderID, 0)
+	r := m.result()
+	if r.err != nil || r.event.OrderID != orderID || r.event.Status != api.OrderFilled || r.event.LotsExecuted != 3 {
+		t.Fatalf("event %+v, error %v, want filled 3 lots of %v", r.event, r.err, orderID)
+	}
+}
+
+func TestManagePartialFill(t *testing.T) {
+	m := newManaged(t)
+	orderID := m.post(3, "99")
+	m.manage(orderID, api.ManageParams{})
+
+	m.fill(orderID, 1)
+	if !m.running() {
+		t.Fatal("partially filled order isn't managed")
+	}
+	m.fill(orderID, 0)
+	r := m.result()
+	if r.err != nil || r.event.Status != api.OrderFilled || r.event.LotsExecuted != 3 {
+		t.Fatalf("event %+v, error %v, want filled 3 lots", r.event, r.err)
+	}
+}
+
+func TestManageReject(t *testing.T) {
+	m := newManaged(t)
+	orderID := m.post(3, "99")
+	m.manage(orderID, api.ManageParams{})
+
+	if err := m.server.RejectOrder(orderID); err != nil {
+		t.Fatal(err)
+	}
+	m.advance(pollInterval)
+	r := m.result()
+	if r.err != nil || r.event.Status != api.OrderRejected {
+		t.Fatalf("event %+v, error %v, want rejected", r.event, r.err)
+	}
+}
+
+func TestManageRepricesOnQuote(t *testing.T) {
+	m := newManaged(t)
+	orderID := m.post(3, "99")
+	quote := money.MustParse("99")
+	m.manage(orderID, api.ManageParams{
+		CheckInterval: time.Minute,
+		Quote:         func(ctx context.Context) (money.Decimal, error) { return quote, nil },
+	})
+	m.fill(orderID, 1)
+
+	m.advance(time.Minute)
+	if active := m.active(); len(active) != 1 || active[0].OrderId != orderID {
+		t.Fatal("order is replaced while the quote is the same")
+	}
+
+	quote = money.MustParse("99.5")
+	m.advance(time.Minute)
+	active := m.active()
+	if len(active) != 1 || active[0].OrderId == orderID {
+		t.Fatalf("active orders %v, want a replacement of %v", active, orderID)
+	}
+	price := money.FromMoneyValue(active[0].InitialSecurityPrice).Amount
+	if active[0].LotsRequested != 2 || !price.Equal(quote) {
+		t.Fatalf("replacement is %v lots at %v, want 2 lots at %v", active[0].LotsRequested, price, quote)
+	}
+	if len(m.replaced) != 1 || m.replaced[0].OrderId != orderID || m.replaced[0].LotsExecuted != 1 {
+		t.Fatalf("replaced %v, want %v with 1 executed lot", m.replaced, orderID)
+	}
+
+	m.fill(active[0].OrderId, 0)
+	r := m.result()
+	if r.err != nil || r.event.OrderID != active[0].OrderId || r.event.LotsExecuted != 2 {
+		t.Fatalf("event %+v, error %v, want 2 lots of the replacement", r.event, r.err)
+	}
+}
+
+func TestManageReplacesExpired(t *testing.T) {
+	m := newManaged(t)
+	orderID := m.post(3, "99")
+	m.manage(orderID, api.ManageParams{MaxAge: 10 * time.Minute})
+
+	m.advance(10*time.Minute - pollInterval)
+	if active := m.active(); len(active) != 1 || active[0].OrderId != orderID {
+		t.Fatal("order is replaced before MaxAge")
+	}
+	m.advance(pollInterval)
+	active := m.active()
+	if len(active) != 1 || active[0].OrderId == orderID || active[0].LotsRequested != 3 {
+		t.Fatalf("active orders %v, want a replacement of 3 lots", active)
+	}
+}
+
+func TestManageHoldsWhilePaused(t *testing.T) {
+	m := newManaged(t)
+	orderID := m.post(3, "99")
+	paused := true
+	m.manage(orderID, api.ManageParams{
+		MaxAge: 10 * time.Minute,
+		Paused: func() bool { return paused },
+		Exit:   func(ctx context.Context) (bool, error) { return true, nil },
+	})
+
+	m.advance(30 * time.Minute)
+	if active := m.active(); len(active) != 1 || active[0].OrderId != orderID || !m.running() {
+		t.Fatal("order isn't held while paused")
+	}
+
+	paused = false
+	m.advance(10 * time.Minute)
+	r := m.result()
+	if r.err != api.ErrOrderExited || r.event.Status != api.OrderCancelled {
+		t.Fatalf("event %+v, error %v, want the order cancelled on exit", r.event, r.err)
+	}
+	if active := m.active(); len(active) != 0 {
+		t.Fatalf("active orders %v after exit", active)
+	}
+}
+
+func TestManagePausedPastMaxAgeDoesNotSpin(t *testing.T) {
+	m := newManaged(t)
+	orderID := m.post(3, "99")
+	m.manage(orderID, api.ManageParams{
+		MaxAge: 10 * time.Minute,
+		Paused: func() bool { return true },
+	})
+	m.advance(15 * time.Minute)
+
+	// a spinning check re-tracks the order on every deadline check of the tracker, every 100ms
+	const method = "tinkoff.public.invest.api.contract.v1.OrdersService/GetOrderState"
+	before := m.server.Calls(method)
+	for i := 0; i < 100; i++ {
+		m.clock.Set(m.clock.Now().Add(100 * time.Millisecond))
+		m.settle()
+	}
+	// 10 seconds are two polls of the tracker
+	if calls := m.server.Calls(method) - before; calls > 2 {
+		t.Fatalf("%v order state calls in 10 seconds of pause, want at most 2", calls)
+	}
+	if !m.running() {
+		t.Fatal("order isn't held while paused")
+	}
+}
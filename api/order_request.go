@@ -0,0 +1,128 @@
+package api
+
+import (
+	"github.com/google/uuid"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
+)
+
+// OrderBuilder collects a PostOrderRequest and validates it against the instrument.
+// The proto knows only limit and market orders, there is no best-price type.
+//
+//	req, err := api.NewOrder(accountID, figi).Buy().Instrument(share.Lot, increment).Pieces(20).Limit(price).Build()
+type OrderBuilder struct {
+	req       *investapi.PostOrderRequest
+	lot       int32
+	increment money.Decimal
+	price     money.Decimal
+	pieces    int64
+	err       error
+}
+
+func NewOrder(accountID, figi string) *OrderBuilder {
+	return &OrderBuilder{req: &investapi.PostOrderRequest{
+		AccountId: accountID,
+		Figi:      figi,
+	}}
+}
+
+func (b *OrderBuilder) Buy() *OrderBuilder {
+	b.req.Direction = investapi.OrderDirection_ORDER_DIRECTION_BUY
+	return b
+}
+
+func (b *OrderBuilder) Sell() *OrderBuilder {
+	b.req.Direction = investapi.OrderDirection_ORDER_DIRECTION_SELL
+	return b
+}
+
+// Instrument sets the lot size and the price step, zero values skip the checks.
+func (b *OrderBuilder) Instrument(lot int32, increment money.Decimal) *OrderBuilder {
+	b.lot = lot
+	b.increment = increment
+	return b
+}
+
+func (b *OrderBuilder) Lots(lots int64) *OrderBuilder {
+	b.req.Quantity = lots
+	b.pieces = 0
+	return b
+}
+
+// Pieces sets the quantity in securities, it has to be a whole number of lots.
+func (b *OrderBuilder) Pieces(pieces int64) *OrderBuilder {
+	b.pieces = pieces
+	b.req.Quantity = 0
+	return b
+}
+
+func (b *OrderBuilder) Limit(price money.Decimal) *OrderBuilder {
+	b.req.OrderType = investapi.OrderType_ORDER_TYPE_LIMIT
+	b.price = price
+	return b
+}
+
+func (b *OrderBuilder) Market() *OrderBuilder {
+	b.req.OrderType = investapi.OrderType_ORDER_TYPE_MARKET
+	b.price = money.Zero
+	return b
+}
+
+// Type sets any OrderType of the proto, limit orders still need a price from Limit.
+func (b *OrderBuilder) Type(orderType investapi.OrderType) *OrderBuilder {
+	b.req.OrderType = orderType
+	return b
+}
+
+// OrderID sets the client order id, by default a new uuid is used.
+func (b *OrderBuilder) OrderID(orderID string) *OrderBuilder {
+	b.req.OrderId = orderID
+	return b
+}
+
+func (b *OrderBuilder) Build() (*investapi.PostOrderRequest, error) {
+	if b.req.AccountId == "" {
+		return nil, errors.New("order account is empty")
+	}
+	if b.req.Figi == "" {
+		return nil, errors.New("order figi is empty")
+	}
+	if b.req.Direction == investapi.OrderDirection_ORDER_DIRECTION_UNSPECIFIED {
+		return nil, errors.New("order direction is not set")
+	}
+
+	if b.pieces != 0 {
+		if b.lot <= 0 {
+			return nil, errors.New("lot size is required to order in pieces")
+		}
+		if b.pieces%int64(b.lot) != 0 {
+			return nil, errors.Errorf("quantity %v is not a multiple of lot %v", b.pieces, b.lot)
+		}
+		b.req.Quantity = b.pieces / int64(b.lot)
+	}
+	if b.req.Quantity <= 0 {
+		return nil, errors.Errorf("quantity should be positive, got %v lots", b.req.Quantity)
+	}
+
+	switch b.req.OrderType {
+	case investapi.OrderType_ORDER_TYPE_LIMIT:
+		if b.price.Sign() <= 0 {
+			return nil, errors.Errorf("limit price should be positive, got %v", b.price)
+		}
+		if b.increment.Sign() > 0 && !b.price.RoundTo(b.increment, money.RoundDown).Equal(b.price) {
+			return nil, errors.Errorf("limit price %v is not a multiple of price step %v", b.price, b.increment)
+		}
+		b.req.Price = b.price.Quotation()
+	case investapi.OrderType_ORDER_TYPE_MARKET:
+		b.req.Price = nil
+	default:
+		return nil, errors.Errorf("order type %v is not supported", b.req.OrderType)
+	}
+
+	if b.req.OrderId == "" {
+		b.req.OrderId = uuid.New().String()
+	}
+	return proto.Clone(b.req).(*investapi.PostOrderRequest), nil
+}
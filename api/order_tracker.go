@@ -0,0 +1,461 @@
+package api
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/clock"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	sandboxPollInterval = 5 * time.Second
+	// the trades stream reports fills only, cancellations and rejections are caught by polling
+	livePollInterval   = 30 * time.Second
+	orderEventsBufSize = 100
+	// deadlineCheckInterval is the precision of per-order timeouts
+	deadlineCheckInterval = 100 * time.Millisecond
+)
+
+var ErrOrderTimeout = errors.New("order is not finished in time")
+
+type OrderStatus int
+
+const (
+	OrderNew OrderStatus = iota
+	OrderPartiallyFilled
+	OrderFilled
+	OrderCancelled
+	OrderRejected
+)
+
+func (s OrderStatus) String() string {
+	switch s {
+	case OrderNew:
+		return "new"
+	case OrderPartiallyFilled:
+		return "partially_filled"
+	case OrderFilled:
+		return "filled"
+	case OrderCancelled:
+		return "cancelled"
+	case OrderRejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+func (s OrderStatus) IsFinal() bool {
+	return s == OrderFilled || s == OrderCancelled || s == OrderRejected
+}
+
+func OrderStatusOf(status investapi.OrderExecutionReportStatus) OrderStatus {
+	switch status {
+	case investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL:
+		return OrderPartiallyFilled
+	case investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL:
+		return OrderFilled
+	case investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED:
+		return OrderCancelled
+	case investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED:
+		return OrderRejected
+	}
+	return OrderNew
+}
+
+type OrderEvent struct {
+	AccountID     string
+	OrderID       string
+	Status        OrderStatus
+	LotsRequested int64
+	LotsExecuted  int64
+	State         *investapi.OrderState
+}
+
+// OrderFuture is resolved when the order reaches a final status, times out or can't be tracked.
+type OrderFuture struct {
+	clock clock.Clock
+	mu    sync.Mutex
+	// parked counts goroutines blocked in Wait, they are woken up by resolve
+	parked int
+	done   chan struct{}
+	event  OrderEvent
+	err    error
+}
+
+// Done doesn't park the goroutine on a scheduler, Wait does.
+func (f *OrderFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result should be called after Done is closed.
+func (f *OrderFuture) Result() (OrderEvent, error) {
+	return f.event, f.err
+}
+
+// Wait blocks until the future is resolved, the goroutine is parked on the clock of the tracker meanwhile.
+func (f *OrderFuture) Wait(ctx context.Context) (OrderEvent, error) {
+	f.mu.Lock()
+	select {
+	case <-f.done:
+		f.mu.Unlock()
+		return f.event, f.err
+	default:
+	}
+	f.parked++
+	clock.Park(f.clock)
+	f.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.event, f.err
+	case <-ctx.Done():
+		f.mu.Lock()
+		select {
+		case <-f.done:
+			// resolve has woken the goroutine up already
+		default:
+			f.parked--
+			clock.Wake(f.clock)
+		}
+		f.mu.Unlock()
+		return OrderEvent{}, ctx.Err()
+	}
+}
+
+// resolve sets the result and wakes up goroutines blocked in Wait.
+func (f *OrderFuture) resolve(event OrderEvent, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.event = event
+	f.err = err
+	for ; f.parked > 0; f.parked-- {
+		clock.Wake(f.clock)
+	}
+	close(f.done)
+}
+
+type trackedOrder struct {
+	accountID    string
+	orderID      string
+	status       OrderStatus
+	lotsExecuted int64
+	deadline     time.Time
+	future       *OrderFuture
+}
+
+// OrderTracker follows orders until they are filled, cancelled or rejected.
+// Fills come from OrdersStreamService.TradesStream, without the stream (sandbox) orders are polled.
+type OrderTracker struct {
+	broker       Broker
+	streamClient investapi.OrdersStreamServiceClient
+	PollInterval time.Duration
+	clock        clock.Clock
+
+	mu       sync.Mutex
+	orders   map[string]*trackedOrder
+	accounts map[string]struct{}
+	events   chan OrderEvent
+	check    chan string
+	restart  chan struct{}
+}
+
+// NewOrderTracker streamClient is optional, the sandbox has no trades stream.
+func NewOrderTracker(broker Broker, streamClient investapi.OrdersStreamServiceClient) *OrderTracker {
+	pollInterval := sandboxPollInterval
+	if streamClient != nil {
+		pollInterval = livePollInterval
+	}
+	return &OrderTracker{
+		broker:       broker,
+		streamClient: streamClient,
+		PollInterval: pollInterval,
+		clock:        clock.Real,
+		orders:       make(map[string]*trackedOrder),
+		accounts:     make(map[string]struct{}),
+		events:       make(chan OrderEvent, orderEventsBufSize),
+		check:        make(chan string, orderEventsBufSize),
+		restart:      make(chan struct{}, 1),
+	}
+}
+
+func (c Client) NewOrderTracker() *OrderTracker {
+	streamClient := c.OrdersStreamServiceClient
+	// a scheduler can't tell when stream messages come, orders are polled on its ticks instead
+	if _, scheduled := c.Clock.(clock.Scheduler); scheduled || c.IsSandbox() {
+		streamClient = nil
+	}
+	tracker := NewOrderTracker(c.Broker, streamClient)
+	if c.Clock != nil {
+		tracker.clock = c.Clock
+	}
+	return tracker
+}
+
+// Events publishes every status change of tracked orders. Events are dropped when nobody reads them.
+func (t *OrderTracker) Events() <-chan OrderEvent {
+	return t.events
+}
+
+// Track starts following the order, zero timeout waits without limit.
+// Tracking the same order again returns the same future.
+func (t *OrderTracker) Track(accountID, orderID string, timeout time.Duration) *OrderFuture {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if order, ok := t.orders[orderID]; ok {
+		return order.future
+	}
+	order := &trackedOrder{
+		accountID: accountID,
+		orderID:   orderID,
+		future:    &OrderFuture{clock: t.clock, done: make(chan struct{})},
+	}
+	if timeout > 0 {
+		order.deadline = t.clock.Now().Add(timeout)
+	}
+	t.orders[orderID] = order
+
+	if _, ok := t.accounts[accountID]; !ok {
+		t.accounts[accountID] = struct{}{}
+		select {
+		case t.restart <- struct{}{}:
+		default:
+		}
+	}
+	// the check wakes Run up
+	clock.Wake(t.clock)
+	select {
+	case t.check <- orderID:
+	default:
+		clock.Park(t.clock)
+	}
+	return order.future
+}
+
+// Run processes tracked orders until ctx is done.
+func (t *OrderTracker) Run(ctx context.Context) error {
+	if t.streamClient != nil {
+		go t.runStream(ctx)
+	}
+
+	ticker := t.clock.NewTicker(t.PollInterval)
+	defer ticker.Stop()
+	deadlines := t.clock.NewTicker(deadlineCheckInterval)
+	defer deadlines.Stop()
+	for {
+		clock.Park(t.clock)
+		select {
+		case <-ctx.Done():
+			clock.Wake(t.clock)
+			return ctx.Err()
+		case orderID := <-t.check:
+			t.refresh(ctx, orderID)
+		case <-ticker.C():
+			for _, orderID := range t.trackedIDs() {
+				t.refresh(ctx, orderID)
+			}
+		case <-deadlines.C():
+			t.expire()
+		}
+	}
+}
+
+// expire resolves timed out orders between polls, without asking the API.
+func (t *OrderTracker) expire() {
+	t.mu.Lock()
+	orders := make([]*trackedOrder, 0, len(t.orders))
+	for _, order := range t.orders {
+		orders = append(orders, order)
+	}
+	t.mu.Unlock()
+	for _, order := range orders {
+		t.checkDeadline(order)
+	}
+}
+
+func (t *OrderTracker) trackedIDs() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]string, 0, len(t.orders))
+	for id := range t.orders {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (t *OrderTracker) refresh(ctx context.Context, orderID string) {
+	t.mu.Lock()
+	order, ok := t.orders[orderID]
+	t.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	state, err := t.broker.GetOrderState(ctx, &investapi.GetOrderStateRequest{
+		AccountId: order.accountID,
+		OrderId:   order.orderID,
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		if isNotFound(err) {
+			t.resolve(order, OrderEvent{AccountID: order.accountID, OrderID: order.orderID},
+				errors.Wrapf(err, "order %v can't be tracked", orderID))
+			return
+		}
+		logrus.WithError(err).WithField("order_id", orderID).Warn("fail refresh order state")
+		t.checkDeadline(order)
+		return
+	}
+
+	event := OrderEvent{
+		AccountID:     order.accountID,
+		OrderID:       order.orderID,
+		Status:        OrderStatusOf(state.ExecutionReportStatus),
+		LotsRequested: state.LotsRequested,
+		LotsExecuted:  state.LotsExecuted,
+		State:         state,
+	}
+
+	t.mu.Lock()
+	changed := event.Status != order.status || event.LotsExecuted != order.lotsExecuted
+	order.status = event.Status
+	order.lotsExecuted = event.LotsExecuted
+	t.mu.Unlock()
+
+	if changed {
+		t.publish(event)
+	}
+	if event.Status.IsFinal() {
+		t.resolve(order, event, nil)
+		return
+	}
+	t.checkDeadline(order)
+}
+
+func (t *OrderTracker) checkDeadline(order *trackedOrder) {
+	if order.deadline.IsZero() || t.clock.Now().Before(order.deadline) {
+		return
+	}
+	t.mu.Lock()
+	event := OrderEvent{
+		AccountID:    order.accountID,
+		OrderID:      order.orderID,
+		Status:       order.status,
+		LotsExecuted: order.lotsExecuted,
+	}
+	t.mu.Unlock()
+	t.resolve(order, event, ErrOrderTimeout)
+}
+
+func (t *OrderTracker) publish(event OrderEvent) {
+	logrus.WithFields(logrus.Fields{
+		"account_id":    event.AccountID,
+		"order_id":      event.OrderID,
+		"status":        event.Status.String(),
+		"lots_executed": event.LotsExecuted,
+	}).Info("order status changed")
+
+	select {
+	case t.events <- event:
+	default:
+	}
+}
+
+func (t *OrderTracker) resolve(order *trackedOrder, event OrderEvent, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.orders[order.orderID]; !ok {
+		return
+	}
+	delete(t.orders, order.orderID)
+	order.future.resolve(event, err)
+}
+
+// runStream listens to fills of all tracked accounts, the stream is reopened when an account is added.
+func (t *OrderTracker) runStream(ctx context.Context) {
+	backoff := streamMinBackoff
+	for {
+		err := t.streamOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			backoff = streamMinBackoff
+			continue
+		}
+		logrus.WithError(err).WithField("backoff", backoff).Warn("trades stream dropped, reconnecting")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+// streamOnce returns nil when the stream should be reopened for a new account list.
+func (t *OrderTracker) streamOnce(ctx context.Context) error {
+	t.mu.Lock()
+	accounts := keys(t.accounts)
+	t.mu.Unlock()
+	if len(accounts) == 0 {
+		select {
+		case <-t.restart:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := t.streamClient.TradesStream(streamCtx, &investapi.TradesStreamRequest{Accounts: accounts})
+	if err != nil {
+		return errors.Wrap(err, "fail open trades stream")
+	}
+
+	go func() {
+		select {
+		case <-t.restart:
+			cancel()
+		case <-streamCtx.Done():
+		}
+	}()
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() == nil && streamCtx.Err() != nil {
+				return nil
+			}
+			if err == io.EOF {
+				return errors.New("trades stream closed by server")
+			}
+			return errors.Wrap(err, "fail receive trades")
+		}
+		if trades := resp.GetOrderTrades(); trades != nil {
+			select {
+			case t.check <- trades.GetOrderId():
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func isNotFound(err error) bool {
+	return status.Code(errors.Cause(err)) == codes.NotFound
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+const (
+	getUserTariffMethod = "tinkoff.public.invest.api.contract.v1.UsersService/GetUserTariff"
+	// tariffRetryInterval is the pause before loading the tariff again after a failure
+	tariffRetryInterval = time.Minute
+)
+
+// tokenBucket allows limit calls per minute, the whole limit may be spent at once.
+type tokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	perSec   float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(limitPerMinute int32) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(limitPerMinute),
+		perSec:   float64(limitPerMinute) / 60,
+		tokens:   float64(limitPerMinute),
+		last:     time.Now(),
+	}
+}
+
+// take reserves a token and returns how long to wait until it's available.
+func (b *tokenBucket) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.perSec
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.perSec * float64(time.Second))
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	delay := b.take()
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// the call isn't made, its token is given back
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// rateLimiter keeps unary calls within limits of the user tariff. Methods sharing a limit
+// share a bucket, methods missing in the tariff aren't limited.
+type rateLimiter struct {
+	mu       sync.Mutex
+	loaded   bool
+	failedAt time.Time
+	buckets  map[string]*tokenBucket
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+func (l *rateLimiter) wait(ctx context.Context, method string, cc *grpc.ClientConn) error {
+	method = strings.TrimPrefix(method, "/")
+	if method == getUserTariffMethod {
+		return nil
+	}
+	l.load(ctx, cc)
+	l.mu.Lock()
+	bucket := l.buckets[method]
+	l.mu.Unlock()
+	if bucket == nil {
+		return nil
+	}
+	return bucket.wait(ctx)
+}
+
+// load requests the tariff once, after a failure calls aren't limited until the next attempt.
+func (l *rateLimiter) load(ctx context.Context, cc *grpc.ClientConn) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.loaded || time.Since(l.failedAt) < tariffRetryInterval {
+		return
+	}
+	tariff, err := investapi.NewUsersServiceClient(cc).GetUserTariff(ctx, &investapi.GetUserTariffRequest{})
+	if err != nil {
+		l.failedAt = time.Now()
+		logrus.WithError(err).Warn("fail get user tariff, calls aren't rate limited")
+		return
+	}
+	for _, limit := range tariff.GetUnaryLimits() {
+		if limit.GetLimitPerMinute() <= 0 {
+			continue
+		}
+		bucket := newTokenBucket(limit.GetLimitPerMinute())
+		for _, method := range limit.GetMethods() {
+			l.buckets[strings.TrimPrefix(method, "/")] = bucket
+		}
+	}
+	l.loaded = true
+	logrus.WithField("limits", len(tariff.GetUnaryLimits())).Debug("user tariff loaded")
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// DefaultReportPollInterval is the pause between checks of a report being generated
+	DefaultReportPollInterval = 5 * time.Second
+	// reportNotReadyCode is returned while the report task is not completed
+	reportNotReadyCode = "30058"
+)
+
+// reportPage is one page of a generated report, count is the number of its rows.
+type reportPage struct {
+	count      int
+	pagesCount int32
+}
+
+// GetBrokerReport generates the broker report of the period, waits until it's ready and reads all of its pages.
+func (c Client) GetBrokerReport(ctx context.Context, accountID string, from, to time.Time, pollInterval time.Duration) ([]*investapi.BrokerReport, error) {
+	if c.IsSandbox() {
+		return nil, ErrLiveOnly
+	}
+	resp, err := c.OperationsServiceClient.GetBrokerReport(ctx, &investapi.BrokerReportRequest{
+		Payload: &investapi.BrokerReportRequest_GenerateBrokerReportRequest{
+			GenerateBrokerReportRequest: &investapi.GenerateBrokerReportRequest{
+				AccountId: accountID,
+				From:      timestamppb.New(from),
+				To:        timestamppb.New(to),
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "fail generate broker report")
+	}
+	taskID := resp.GetGenerateBrokerReportResponse().GetTaskId()
+	if taskID == "" {
+		return nil, errors.New("broker report is generated without task id")
+	}
+
+	rows := []*investapi.BrokerReport{}
+	err = readReport(ctx, "broker", taskID, pollInterval, func(page int32) (reportPage, error) {
+		resp, err := c.OperationsServiceClient.GetBrokerReport(ctx, &investapi.BrokerReportRequest{
+			Payload: &investapi.BrokerReportRequest_GetBrokerReportRequest{
+				GetBrokerReportRequest: &investapi.GetBrokerReportRequest{TaskId: taskID, Page: page},
+			},
+		})
+		if err != nil {
+			return reportPage{}, err
+		}
+		report := resp.GetGetBrokerReportResponse()
+		rows = append(rows, report.GetBrokerReport()...)
+		return reportPage{count: len(report.GetBrokerReport()), pagesCount: report.GetPagesCount()}, nil
+	})
+	return rows, err
+}
+
+// GetDividendsForeignIssuerReport generates the report of dividends paid by foreign issuers in the period,
+// waits until it's ready and reads all of its pages.
+func (c Client) GetDividendsForeignIssuerReport(ctx context.Context, accountID string, from, to time.Time, pollInterval time.Duration) ([]*investapi.DividendsForeignIssuerReport, error) {
+	if c.IsSandbox() {
+		return nil, ErrLiveOnly
+	}
+	resp, err := c.OperationsServiceClient.GetDividendsForeignIssuer(ctx, &investapi.GetDividendsForeignIssuerRequest{
+		Payload: &investapi.GetDividendsForeignIssuerRequest_GenerateDivForeignIssuerReport{
+			GenerateDivForeignIssuerReport: &investapi.GenerateDividendsForeignIssuerReportRequest{
+				AccountId: accountID,
+				From:      timestamppb.New(from),
+				To:        timestamppb.New(to),
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "fail generate dividends report")
+	}
+	taskID := resp.GetGenerateDivForeignIssuerReportResponse().GetTaskId()
+	if taskID == "" {
+		return nil, errors.New("dividends report is generated without task id")
+	}
+
+	rows := []*investapi.DividendsForeignIssuerReport{}
+	err = readReport(ctx, "dividends", taskID, pollInterval, func(page int32) (reportPage, error) {
+		resp, err := c.OperationsServiceClient.GetDividendsForeignIssuer(ctx, &investapi.GetDividendsForeignIssuerRequest{
+			Payload: &investapi.GetDividendsForeignIssuerRequest_GetDivForeignIssuerReport{
+				GetDivForeignIssuerReport: &investapi.GetDividendsForeignIssuerReportRequest{TaskId: taskID, Page: page},
+			},
+		})
+		if err != nil {
+			return reportPage{}, err
+		}
+		report := resp.GetDivForeignIssuerReport()
+		rows = append(rows, report.GetDividendsForeignIssuerReport()...)
+		return reportPage{count: len(report.GetDividendsForeignIssuerReport()), pagesCount: report.GetPagesCount()}, nil
+	})
+	return rows, err
+}
+
+// readReport polls the first page until the report is ready and then reads the rest.
+// Pages are numbered from 0, reading stops on an empty page or after the last one.
+func readReport(ctx context.Context, name, taskID string, pollInterval time.Duration, read func(page int32) (reportPage, error)) error {
+	if pollInterval <= 0 {
+		pollInterval = DefaultReportPollInterval
+	}
+	log := logrus.WithFields(logrus.Fields{
+		"report":  name,
+		"task_id": taskID,
+	})
+	for page := int32(0); ; page++ {
+		result, err := read(page)
+		for page == 0 && isReportNotReady(err) {
+			log.Debug("report is not ready")
+			select {
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			result, err = read(page)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "fail get %v report page %v", name, page)
+		}
+		if result.count == 0 || page+1 >= result.pagesCount {
+			log.WithField("pages", page+1).Info("report received")
+			return nil
+		}
+	}
+}
+
+func isReportNotReady(err error) bool {
+	apiErr := &Error{}
+	return errors.As(err, &apiErr) && apiErr.APICode == reportNotReadyCode
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// rateLimitResetHeader tells in seconds when the exhausted limit is restored
+const rateLimitResetHeader = "x-ratelimit-reset"
+
+// nonIdempotent calls may be executed even when they fail with a retryable code,
+// repeating them could place an order or pay in twice.
+var nonIdempotent = map[string]bool{
+	"tinkoff.public.invest.api.contract.v1.OrdersService/PostOrder":           true,
+	"tinkoff.public.invest.api.contract.v1.StopOrdersService/PostStopOrder":   true,
+	"tinkoff.public.invest.api.contract.v1.SandboxService/PostSandboxOrder":   true,
+	"tinkoff.public.invest.api.contract.v1.SandboxService/OpenSandboxAccount": true,
+	"tinkoff.public.invest.api.contract.v1.SandboxService/SandboxPayIn":       true,
+}
+
+// RetryPolicy repeats unary calls failed with a transient code after an exponential backoff with full jitter.
+type RetryPolicy struct {
+	// MaxAttempts includes the first call, 1 disables retries
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	Codes       []codes.Code
+}
+
+// DefaultRetryPolicy retries calls rejected before they were processed or by an unavailable server.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	MinBackoff:  200 * time.Millisecond,
+	MaxBackoff:  10 * time.Second,
+	Codes:       []codes.Code{codes.Unavailable, codes.ResourceExhausted},
+}
+
+func (p RetryPolicy) allows(method string) bool {
+	return p.MaxAttempts > 1 && !nonIdempotent[strings.TrimPrefix(method, "/")]
+}
+
+func (p RetryPolicy) retryable(code codes.Code) bool {
+	for _, c := range p.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the pause before the attempt following attempt, the rate limit reset is waited out.
+func (p RetryPolicy) backoff(attempt int, trailer metadata.MD) time.Duration {
+	ceiling := p.MinBackoff << uint(attempt-1)
+	if ceiling > p.MaxBackoff || ceiling <= 0 {
+		ceiling = p.MaxBackoff
+	}
+	delay := time.Duration(rand.Int63n(int64(ceiling) + 1))
+	if values := trailer.Get(rateLimitResetHeader); len(values) > 0 {
+		if seconds, err := strconv.Atoi(values[0]); err == nil && time.Duration(seconds)*time.Second > delay {
+			delay = time.Duration(seconds) * time.Second
+		}
+	}
+	return delay
+}
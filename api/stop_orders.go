@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/clock"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type StopKind int
+
+const (
+	// StopLoss sends a market order when price reaches the stop price
+	StopLoss StopKind = iota
+	// TakeProfit sends a market order when price reaches the stop price in favor of the position
+	TakeProfit
+	// StopLimit sends a limit order at LimitPrice when price reaches the stop price
+	StopLimit
+)
+
+func (k StopKind) String() string {
+	switch k {
+	case StopLoss:
+		return "stop-loss"
+	case TakeProfit:
+		return "take-profit"
+	case StopLimit:
+		return "stop-limit"
+	}
+	return "unknown"
+}
+
+func (k StopKind) orderType() investapi.StopOrderType {
+	switch k {
+	case TakeProfit:
+		return investapi.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT
+	case StopLimit:
+		return investapi.StopOrderType_STOP_ORDER_TYPE_STOP_LIMIT
+	}
+	return investapi.StopOrderType_STOP_ORDER_TYPE_STOP_LOSS
+}
+
+type StopOrderParams struct {
+	AccountID  string
+	Figi       string
+	Kind       StopKind
+	Direction  investapi.StopOrderDirection
+	Qty        int64 // lots
+	StopPrice  money.Decimal
+	LimitPrice money.Decimal // StopLimit only
+	ExpireAt   time.Time     // zero means good till cancel
+}
+
+func (c Client) PostStopOrder(ctx context.Context, params StopOrderParams) (stopOrderID string, err error) {
+	req := investapi.PostStopOrderRequest{
+		Figi:           params.Figi,
+		Quantity:       params.Qty,
+		StopPrice:      params.StopPrice.Quotation(),
+		Direction:      params.Direction,
+		AccountId:      params.AccountID,
+		ExpirationType: investapi.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_GOOD_TILL_CANCEL,
+		StopOrderType:  params.Kind.orderType(),
+	}
+	if params.Kind == StopLimit {
+		req.Price = params.LimitPrice.Quotation()
+	}
+	if !params.ExpireAt.IsZero() {
+		req.ExpirationType = investapi.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_GOOD_TILL_DATE
+		req.ExpireDate = timestamppb.New(params.ExpireAt)
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"account_id": params.AccountID,
+		"figi":       params.Figi,
+		"kind":       params.Kind,
+		"stop_price": params.StopPrice.String(),
+	})
+	log.WithField("request", fmt.Sprintf("%v", &req)).Info("PostStopOrder")
+	resp, err := c.Broker.PostStopOrder(ctx, &req)
+	if err != nil {
+		return "", errors.Wrapf(err, "error on post %v", params.Kind)
+	}
+	log.WithField("stop_order_id", resp.StopOrderId).Info("PostStopOrder sent")
+	return resp.StopOrderId, nil
+}
+
+// GetStopOrders returns active stop orders of the account, figi filters them when set.
+func (c Client) GetStopOrders(ctx context.Context, accountID, figi string) ([]*investapi.StopOrder, error) {
+	resp, err := c.Broker.GetStopOrders(ctx, &investapi.GetStopOrdersRequest{AccountId: accountID})
+	if err != nil {
+		return nil, errors.Wrap(err, "fail get stop orders")
+	}
+	stopOrders := []*investapi.StopOrder{}
+	for _, stopOrder := range resp.GetStopOrders() {
+		if figi == "" || stopOrder.Figi == figi {
+			stopOrders = append(stopOrders, stopOrder)
+		}
+	}
+	return stopOrders, nil
+}
+
+func (c Client) CancelStopOrder(ctx context.Context, accountID, stopOrderID string) error {
+	_, err := c.Broker.CancelStopOrder(ctx, &investapi.CancelStopOrderRequest{
+		AccountId:   accountID,
+		StopOrderId: stopOrderID,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "fail cancel stop order %v", stopOrderID)
+	}
+	return nil
+}
+
+// Bracket is a pair of sell stop orders protecting a long position,
+// when one of them is triggered the other is cancelled.
+type Bracket struct {
+	AccountID    string
+	Figi         string
+	StopID       string
+	TakeProfitID string // empty when the position has no take-profit
+}
+
+// PostBracket places a protective stop at stopPrice and a take-profit at takeProfitPrice for qty lots.
+// A non-zero stopLimitPrice makes the stop a stop-limit order, a zero takeProfitPrice skips the take-profit.
+func (c Client) PostBracket(ctx context.Context, accountID, figi string, qty int64, stopPrice, stopLimitPrice, takeProfitPrice money.Decimal) (*Bracket, error) {
+	stop := StopOrderParams{
+		AccountID: accountID,
+		Figi:      figi,
+		Kind:      StopLoss,
+		Direction: investapi.StopOrderDirection_STOP_ORDER_DIRECTION_SELL,
+		Qty:       qty,
+		StopPrice: stopPrice,
+	}
+	if !stopLimitPrice.IsZero() {
+		stop.Kind = StopLimit
+		stop.LimitPrice = stopLimitPrice
+	}
+	stopID, err := c.PostStopOrder(ctx, stop)
+	if err != nil {
+		return nil, err
+	}
+	bracket := &Bracket{AccountID: accountID, Figi: figi, StopID: stopID}
+	if takeProfitPrice.IsZero() {
+		return bracket, nil
+	}
+
+	bracket.TakeProfitID, err = c.PostStopOrder(ctx, StopOrderParams{
+		AccountID: accountID,
+		Figi:      figi,
+		Kind:      TakeProfit,
+		Direction: investapi.StopOrderDirection_STOP_ORDER_DIRECTION_SELL,
+		Qty:       qty,
+		StopPrice: takeProfitPrice,
+	})
+	if err != nil {
+		// keep the position protected, the caller decides what to do with the stop
+		return bracket, err
+	}
+	return bracket, nil
+}
+
+// WaitBracket polls stop orders until one of the bracket orders is triggered, cancels the other
+// and returns StopLoss for the protective stop of either kind or TakeProfit.
+func (c Client) WaitBracket(ctx context.Context, bracket *Bracket, pollInterval time.Duration) (StopKind, error) {
+	ticker := c.Clock.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		stopOrders, err := c.GetStopOrders(ctx, bracket.AccountID, bracket.Figi)
+		if err != nil {
+			return 0, err
+		}
+		stopActive, takeProfitActive := false, false
+		for _, stopOrder := range stopOrders {
+			switch stopOrder.StopOrderId {
+			case bracket.StopID:
+				stopActive = true
+			case bracket.TakeProfitID:
+				takeProfitActive = true
+			}
+		}
+
+		switch {
+		case !stopActive && !takeProfitActive && bracket.TakeProfitID != "":
+			return 0, errors.New("both bracket orders are gone")
+		case !stopActive:
+			if bracket.TakeProfitID != "" {
+				err = c.cancelTriggeredPair(ctx, bracket.AccountID, bracket.TakeProfitID)
+			}
+			return StopLoss, err
+		case bracket.TakeProfitID != "" && !takeProfitActive:
+			return TakeProfit, c.cancelTriggeredPair(ctx, bracket.AccountID, bracket.StopID)
+		}
+
+		clock.Park(c.Clock)
+		select {
+		case <-ctx.Done():
+			clock.Wake(c.Clock)
+			return 0, ctx.Err()
+		case <-ticker.C():
+		}
+	}
+}
+
+// cancelTriggeredPair ignores NotFound, the pair may be triggered at the same time.
+func (c Client) cancelTriggeredPair(ctx context.Context, accountID, stopOrderID string) error {
+	err := c.CancelStopOrder(ctx, accountID, stopOrderID)
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Package atomicfile replaces files so that a crash leaves either the old or the new content.
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// WriteFile writes data to a temporary file next to path, syncs it to disk and renames it over path.
+// Missing directories are created. Without the sync a crash right after the rename may leave
+// an empty file on filesystems that reorder metadata and data writes.
+func WriteFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return errors.Wrapf(err, "fail create dir %v", dir)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "fail create temporary file for %v", path)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "fail write %v", path)
+	}
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		return errors.Wrapf(err, "fail write %v", path)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrapf(err, "fail replace %v", path)
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir makes the rename durable, it's best effort since not every system can sync a directory.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
@@ -0,0 +1,419 @@
+// Package backtest runs strategies over historic candles. A strategy talks to an in-process fake API
+// through the same client it uses live, while the time of the client is moved candle by candle.
+package backtest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/calendar"
+	"github.com/nax11/tinkoff_bot_public/candles"
+	"github.com/nax11/tinkoff_bot_public/clock"
+	"github.com/nax11/tinkoff_bot_public/fakeapi"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/nax11/tinkoff_bot_public/registry"
+	"github.com/nax11/tinkoff_bot_public/strategy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const accountID = "backtest"
+
+type Config struct {
+	From, To time.Time
+	Figis    []string
+	// Interval of the replayed candles, 5 minutes by default
+	Interval investapi.CandleInterval
+	// Warmup is the history before From that is available to strategies but not traded,
+	// AnalyzePeriod of Params by default
+	Warmup  time.Duration
+	Deposit money.Money
+	// Model executes orders by candles, DefaultFillModel by default
+	Model  FillModel
+	Tariff Tariff
+	// Params is the template of strategy parameters, Figi, Instrument and AccountID are set for every instrument
+	Params strategy.TradeParams
+}
+
+// Fill is an execution of an order.
+type Fill struct {
+	Time       time.Time
+	OrderID    string
+	Figi       string
+	Direction  investapi.OrderDirection
+	Lots       int64
+	Quantity   int64
+	Price      money.Decimal
+	Commission money.Decimal
+}
+
+// EquityPoint is the value of the account at the close of a step.
+type EquityPoint struct {
+	Time   time.Time
+	Equity money.Decimal
+}
+
+type Result struct {
+	Fills  []Fill
+	Equity []EquityPoint
+	Stats  Stats
+}
+
+// step is the candles of all instruments opened at the same time.
+type step struct {
+	time    time.Time
+	candles map[string]*investapi.HistoricCandle
+}
+
+type backtest struct {
+	cfg      Config
+	interval time.Duration
+	server   *fakeapi.Server
+	clock    *clock.Simulated
+	client   *api.Client
+	shares   map[string]*investapi.Share
+	closes   map[string]money.Decimal
+	// day and turnover choose the commission rate of the tariff
+	day      string
+	turnover money.Decimal
+	result   *Result
+}
+
+// Run replays candles of cfg.Figis from source, every instrument is traded by its own strategy made by newStrategy.
+func Run(ctx context.Context, source Source, newStrategy func(client *api.Client) strategy.Strategy, cfg Config) (*Result, error) {
+	if cfg.Interval == investapi.CandleInterval_CANDLE_INTERVAL_UNSPECIFIED {
+		cfg.Interval = investapi.CandleInterval_CANDLE_INTERVAL_5_MIN
+	}
+	if cfg.Warmup == 0 {
+		cfg.Warmup = cfg.Params.AnalyzePeriod
+	}
+	if cfg.Model == nil {
+		cfg.Model = DefaultFillModel()
+	}
+	if len(cfg.Figis) == 0 {
+		return nil, errors.New("no instruments to backtest")
+	}
+	if !cfg.From.Before(cfg.To) {
+		return nil, errors.New("backtest period is empty")
+	}
+	interval, err := candles.Duration(cfg.Interval)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &backtest{
+		cfg:      cfg,
+		interval: interval,
+		server:   fakeapi.New(),
+		shares:   make(map[string]*investapi.Share),
+		closes:   make(map[string]money.Decimal),
+		result:   &Result{Fills: []Fill{}, Equity: []EquityPoint{}},
+	}
+	defer b.server.Stop()
+	steps, err := b.load(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("no candles in backtest period")
+	}
+
+	b.clock = clock.NewSimulated(steps[0].time)
+	b.server.Now = b.clock.Now
+	// the tariff without limits, the simulated time doesn't move while the limiter waits
+	b.server.SetTariff(&investapi.GetUserTariffResponse{})
+	// orders, market ones too, wait for the fill model
+	b.server.OnPostOrder(func(req *investapi.PostOrderRequest) fakeapi.Outcome {
+		return fakeapi.Rest
+	})
+	b.server.AddAccount(accountID)
+	if err = b.server.PayIn(accountID, cfg.Deposit.MoneyValue()); err != nil {
+		return nil, errors.Wrap(err, "fail pay in backtest account")
+	}
+	// stop orders exist on real accounts only
+	b.client, err = b.server.Dial(false, api.UseClock(b.clock))
+	if err != nil {
+		return nil, errors.Wrap(err, "fail dial backtest server")
+	}
+
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	errs := make(chan error, len(cfg.Figis))
+	wg := sync.WaitGroup{}
+	for _, figi := range cfg.Figis {
+		params := cfg.Params
+		params.Figi = figi
+		params.Instrument = registry.FromShare(b.shares[figi])
+		params.AccountID = accountID
+		strategyOperation := newStrategy(b.client)
+		wg.Add(1)
+		// the strategy runs in lockstep with the clock, see settle
+		clock.Go(b.clock, func() {
+			defer wg.Done()
+			if err := strategyOperation.Run(runCtx, params); err != nil && runCtx.Err() == nil {
+				errs <- errors.Wrapf(err, "strategy of %v failed", params.Figi)
+			}
+		})
+	}
+
+	err = b.replay(runCtx, steps, errs)
+	stop()
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	b.result.Stats = computeStats(b.result, cfg.Deposit.Amount)
+	return b.result, nil
+}
+
+// load registers instruments on the server with the warmup candles and returns the rest grouped by time.
+func (b *backtest) load(ctx context.Context, source Source) ([]*step, error) {
+	byTime := make(map[time.Time]*step)
+	for _, figi := range b.cfg.Figis {
+		share, err := source.Share(ctx, figi)
+		if err != nil {
+			return nil, err
+		}
+		b.shares[figi] = share
+		b.server.AddShare(share)
+
+		candles, err := source.Candles(ctx, figi, b.cfg.Interval, b.cfg.From.Add(-b.cfg.Warmup), b.cfg.To)
+		if err != nil {
+			return nil, err
+		}
+		warmup := []*investapi.HistoricCandle{}
+		for _, candle := range candles {
+			candleTime := candle.GetTime().AsTime()
+			if candleTime.Before(b.cfg.From) {
+				warmup = append(warmup, candle)
+				continue
+			}
+			s, ok := byTime[candleTime]
+			if !ok {
+				s = &step{time: candleTime, candles: make(map[string]*investapi.HistoricCandle)}
+				byTime[candleTime] = s
+			}
+			s.candles[figi] = candle
+		}
+		if len(warmup) > 0 {
+			b.server.AddCandles(figi, b.cfg.Interval, warmup...)
+			b.closes[figi] = money.FromQuotation(warmup[len(warmup)-1].GetClose())
+		}
+	}
+	steps := make([]*step, 0, len(byTime))
+	for _, s := range byTime {
+		steps = append(steps, s)
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i].time.Before(steps[j].time) })
+	return steps, nil
+}
+
+// replay moves the clock to the open of every step, executes orders reached by its candles,
+// publishes the candles and moves the clock to the close.
+func (b *backtest) replay(ctx context.Context, steps []*step, errs <-chan error) error {
+	if err := b.settle(ctx, errs); err != nil {
+		return err
+	}
+	for _, s := range steps {
+		if s.time.After(b.clock.Now()) {
+			b.clock.Set(s.time)
+			if err := b.settle(ctx, errs); err != nil {
+				return err
+			}
+		}
+		figis := make([]string, 0, len(s.candles))
+		for figi := range s.candles {
+			figis = append(figis, figi)
+		}
+		sort.Strings(figis)
+		for _, figi := range figis {
+			if err := b.execute(s.time, figi, s.candles[figi]); err != nil {
+				return err
+			}
+		}
+		for _, figi := range figis {
+			candle := s.candles[figi]
+			b.server.AddCandles(figi, b.cfg.Interval, candle)
+			b.closes[figi] = money.FromQuotation(candle.GetClose())
+		}
+		b.clock.Set(s.time.Add(b.interval))
+		if err := b.settle(ctx, errs); err != nil {
+			return err
+		}
+		if err := b.recordEquity(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execute passes orders posted before the candle opened to the fill model and executes the fills it returns.
+func (b *backtest) execute(candleTime time.Time, figi string, candle *investapi.HistoricCandle) error {
+	orders, err := b.activeOrders(candleTime, figi)
+	if err != nil {
+		return err
+	}
+	if len(orders) == 0 {
+		return nil
+	}
+	byID := make(map[string]Order, len(orders))
+	for _, order := range orders {
+		byID[order.ID] = order
+	}
+	share := b.shares[figi]
+	for _, execution := range b.cfg.Model.Execute(share, candle, orders) {
+		order, ok := byID[execution.OrderID]
+		if !ok {
+			return errors.Errorf("fill model executed unknown order %v", execution.OrderID)
+		}
+		orderID := order.ID
+		if order.Stop {
+			orderID, err = b.server.TriggerStopOrder(accountID, order.ID)
+			if err != nil {
+				return errors.Wrapf(err, "fail trigger stop order %v", order.ID)
+			}
+		}
+		if execution.Lots <= 0 {
+			continue
+		}
+		if err = b.fill(candleTime, share, order.Direction, orderID, execution); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// activeOrders returns orders and stop orders of figi posted before the candle opened.
+func (b *backtest) activeOrders(candleTime time.Time, figi string) ([]Order, error) {
+	orders := []Order{}
+	for _, order := range b.server.Orders(accountID) {
+		if order.Figi != figi || api.OrderStatusOf(order.ExecutionReportStatus).IsFinal() ||
+			order.OrderDate.AsTime().After(candleTime) {
+			continue
+		}
+		orders = append(orders, Order{
+			ID:        order.OrderId,
+			Direction: order.Direction,
+			Market:    order.OrderType == investapi.OrderType_ORDER_TYPE_MARKET,
+			Price:     money.FromMoneyValue(order.InitialSecurityPrice).Amount,
+			Lots:      order.LotsRequested - order.LotsExecuted,
+			CreatedAt: order.OrderDate.AsTime(),
+		})
+	}
+	stopOrders, err := b.server.StopOrders(accountID)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail get backtest stop orders")
+	}
+	for _, stopOrder := range stopOrders {
+		if stopOrder.Figi != figi || stopOrder.CreateDate.AsTime().After(candleTime) {
+			continue
+		}
+		direction := investapi.OrderDirection_ORDER_DIRECTION_BUY
+		if stopOrder.Direction == investapi.StopOrderDirection_STOP_ORDER_DIRECTION_SELL {
+			direction = investapi.OrderDirection_ORDER_DIRECTION_SELL
+		}
+		// a take-profit is triggered by the price moving in favor of the position, the stops against it
+		rising := stopOrder.OrderType == investapi.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT
+		if direction == investapi.OrderDirection_ORDER_DIRECTION_BUY {
+			rising = !rising
+		}
+		orders = append(orders, Order{
+			ID:        stopOrder.StopOrderId,
+			Stop:      true,
+			Direction: direction,
+			Market:    stopOrder.OrderType != investapi.StopOrderType_STOP_ORDER_TYPE_STOP_LIMIT,
+			Price:     money.FromMoneyValue(stopOrder.Price).Amount,
+			StopPrice: money.FromMoneyValue(stopOrder.StopPrice).Amount,
+			Rising:    rising,
+			Lots:      stopOrder.LotsRequested,
+			CreatedAt: stopOrder.CreateDate.AsTime(),
+		})
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		if !orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+			return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+		}
+		return orders[i].ID < orders[j].ID
+	})
+	return orders, nil
+}
+
+// fill executes the order with the commission of the tariff and records the fill.
+func (b *backtest) fill(candleTime time.Time, share *investapi.Share, direction investapi.OrderDirection, orderID string, execution Execution) error {
+	day := candleTime.In(calendar.Moscow).Format("2006-01-02")
+	if day != b.day {
+		b.day, b.turnover = day, money.Zero
+	}
+	qty := execution.Lots * int64(share.Lot)
+	amount := execution.Price.MulInt(qty)
+	commission := b.cfg.Tariff.Commission(b.turnover, amount)
+	b.turnover = b.turnover.Add(amount)
+	if err := b.server.FillOrderAt(orderID, execution.Lots, execution.Price, commission); err != nil {
+		return errors.Wrapf(err, "fail fill order %v", orderID)
+	}
+	b.result.Fills = append(b.result.Fills, Fill{
+		Time:       candleTime,
+		OrderID:    orderID,
+		Figi:       share.Figi,
+		Direction:  direction,
+		Lots:       execution.Lots,
+		Quantity:   qty,
+		Price:      execution.Price,
+		Commission: commission,
+	})
+	logrus.WithFields(logrus.Fields{
+		"time":      candleTime,
+		"figi":      share.Figi,
+		"direction": direction.String(),
+		"lots":      execution.Lots,
+		"price":     execution.Price.String(),
+	}).Debug("backtest fill")
+	return nil
+}
+
+// settle waits until every goroutine of the strategies is parked on the clock, they have handled
+// the previous move of the time and the candles published before it.
+func (b *backtest) settle(ctx context.Context, errs <-chan error) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-errs:
+		return err
+	case <-b.clock.Idle():
+	}
+	// a failed strategy sends its error before it stops counting
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// recordEquity values the account by the closes of the candles.
+func (b *backtest) recordEquity(ctx context.Context) error {
+	positions, err := b.client.Broker.GetPositions(ctx, &investapi.PositionsRequest{AccountId: accountID})
+	if err != nil {
+		return errors.Wrap(err, "fail get backtest positions")
+	}
+	equity := money.Zero
+	for _, value := range positions.GetMoney() {
+		if value.Currency == b.cfg.Deposit.Currency {
+			equity = equity.Add(money.FromMoneyValue(value).Amount)
+		}
+	}
+	for _, value := range positions.GetBlocked() {
+		if value.Currency == b.cfg.Deposit.Currency {
+			equity = equity.Add(money.FromMoneyValue(value).Amount)
+		}
+	}
+	for _, security := range positions.GetSecurities() {
+		equity = equity.Add(b.closes[security.Figi].MulInt(api.Held(security)))
+	}
+	b.result.Equity = append(b.result.Equity, EquityPoint{Time: b.clock.Now(), Equity: equity})
+	return nil
+}
@@ -0,0 +1,128 @@
+package backtest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/calendar"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/nax11/tinkoff_bot_public/strategy"
+	priceband "github.com/nax11/tinkoff_bot_public/strategy/price-band"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const testFigi = "BBG000TEST01"
+
+// sliceSource replays prepared candles.
+type sliceSource struct {
+	share   *investapi.Share
+	candles []*investapi.HistoricCandle
+}
+
+func (s sliceSource) Share(ctx context.Context, figi string) (*investapi.Share, error) {
+	return s.share, nil
+}
+
+func (s sliceSource) Candles(ctx context.Context, figi string, interval investapi.CandleInterval, from, to time.Time) ([]*investapi.HistoricCandle, error) {
+	result := []*investapi.HistoricCandle{}
+	for _, candle := range s.candles {
+		t := candle.GetTime().AsTime()
+		if !t.Before(from) && t.Before(to) {
+			result = append(result, candle)
+		}
+	}
+	return result, nil
+}
+
+// swingSource is a day of 5 minute candles swinging between 100 and 104.
+func swingSource(day time.Time) sliceSource {
+	prices := []int64{100, 101, 102, 103, 104, 103, 102, 101}
+	candles := []*investapi.HistoricCandle{}
+	for i, t := 0, day.Add(7*time.Hour); t.Before(day.Add(18 * time.Hour)); i, t = i+1, t.Add(5*time.Minute) {
+		price := money.FromInt(prices[i%len(prices)])
+		candles = append(candles, &investapi.HistoricCandle{
+			Time:       timestamppb.New(t),
+			Open:       price.Quotation(),
+			High:       price.Add(money.FromInt(1)).Quotation(),
+			Low:        price.Sub(money.FromInt(1)).Quotation(),
+			Close:      price.Quotation(),
+			Volume:     1000,
+			IsComplete: true,
+		})
+	}
+	return sliceSource{
+		share: &investapi.Share{
+			Figi:                  testFigi,
+			Ticker:                "TEST",
+			ClassCode:             "TQBR",
+			Lot:                   1,
+			Currency:              "rub",
+			Exchange:              "MOEX",
+			MinPriceIncrement:     money.New(0, 10000000).Quotation(),
+			TradingStatus:         investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING,
+			BuyAvailableFlag:      true,
+			SellAvailableFlag:     true,
+			ApiTradeAvailableFlag: true,
+		},
+		candles: candles,
+	}
+}
+
+func swingConfig(day time.Time) Config {
+	return Config{
+		From:    day.Add(10 * time.Hour),
+		To:      day.Add(18 * time.Hour),
+		Figis:   []string{testFigi},
+		Deposit: money.NewMoney(money.FromInt(10000), "rub"),
+		Tariff:  Tariffs["investor"],
+		Params: strategy.TradeParams{
+			OperationLots: 10,
+			MaxDealSum:    money.FromInt(2000),
+			DealLimit:     money.FromInt(5000),
+			AnalyzePeriod: time.Hour,
+			DealPeriod:    time.Hour,
+			MaxOrderAge:   30 * time.Minute,
+		},
+	}
+}
+
+// withoutOrderIDs drops the ids, the fake server generates new ones every run.
+func withoutOrderIDs(fills []Fill) []Fill {
+	result := make([]Fill, len(fills))
+	for i, fill := range fills {
+		fill.OrderID = ""
+		result[i] = fill
+	}
+	return result
+}
+
+func TestRunIsDeterministic(t *testing.T) {
+	logrus.SetLevel(logrus.WarnLevel)
+	day := time.Date(2024, 3, 12, 0, 0, 0, 0, calendar.Moscow)
+	source := swingSource(day)
+
+	var first *Result
+	for run := 0; run < 5; run++ {
+		result, err := Run(context.Background(), source, priceband.NewStrategy, swingConfig(day))
+		if err != nil {
+			t.Fatalf("run %v: %v", run, err)
+		}
+		if run == 0 {
+			if len(result.Fills) == 0 {
+				t.Fatal("strategy made no fills")
+			}
+			first = result
+			continue
+		}
+		if !reflect.DeepEqual(withoutOrderIDs(result.Fills), withoutOrderIDs(first.Fills)) {
+			t.Fatalf("run %v fills differ:\n%+v\nfirst:\n%+v", run, result.Fills, first.Fills)
+		}
+		if !reflect.DeepEqual(result.Equity, first.Equity) {
+			t.Fatalf("run %v equity differs", run)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package backtest
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+// Order is an order or a stop order of an instrument that was active before the candle opened.
+type Order struct {
+	ID string
+	// Stop is a stop order, it's triggered before it's executed
+	Stop      bool
+	Direction investapi.OrderDirection
+	// Market is executed at any price, so are the triggered stop orders except stop-limit ones
+	Market bool
+	// Price is the limit price, of a triggered stop-limit order too
+	Price     money.Decimal
+	StopPrice money.Decimal
+	// Rising stop orders are triggered when the price rises to StopPrice, the others when it falls to it
+	Rising    bool
+	Lots      int64
+	CreatedAt time.Time
+}
+
+// Execution fills Lots of the order at Price, a stop order is triggered first.
+// A stop order with zero Lots is only triggered, its exchange order is left active.
+type Execution struct {
+	OrderID string
+	Lots    int64
+	Price   money.Decimal
+}
+
+// FillModel decides which orders a candle executes, orders are sorted by the time they were posted.
+type FillModel interface {
+	Execute(share *investapi.Share, candle *investapi.HistoricCandle, orders []Order) []Execution
+}
+
+// Conservative walks the candle from the open through its extremes to the close and executes orders
+// in the order the walk reaches their prices. The extreme closer to the open is visited first, the low
+// on a tie, and at most one stop order of the instrument is triggered by a candle, so a stop-loss and
+// a take-profit of one position aren't both executed when the candle can't tell which came first.
+type Conservative struct {
+	// ThroughTicks is how far the price has to go past the limit price to fill the order,
+	// touching the price isn't enough when the place in the queue is unknown
+	ThroughTicks int64
+	// SlippageTicks worsens prices of market orders and triggered stop orders
+	SlippageTicks int64
+	// Participation is the part of the candle volume the orders may take, zero is no limit
+	Participation money.Decimal
+}
+
+// DefaultFillModel fills a limit order when the price goes a tick past it, slips market orders by a tick
+// and takes at most a tenth of the candle volume.
+func DefaultFillModel() Conservative {
+	return Conservative{
+		ThroughTicks:  1,
+		SlippageTicks: 1,
+		Participation: money.MustParse("0.1"),
+	}
+}
+
+// Touch fills a limit order as soon as the candle touches its price, without slippage and volume limits.
+// It's the optimistic bound of the results.
+func Touch() Conservative {
+	return Conservative{}
+}
+
+// reached is an order with the position on the candle path where its price is reached.
+type reached struct {
+	order Order
+	at    money.Decimal
+}
+
+func (m Conservative) Execute(share *investapi.Share, candle *investapi.HistoricCandle, orders []Order) []Execution {
+	tick := money.FromQuotation(share.MinPriceIncrement)
+	path := candlePath(candle)
+	available := int64(-1)
+	if m.Participation.Sign() > 0 {
+		available = money.FromInt(candle.GetVolume()).Mul(m.Participation).Truncate()
+	}
+
+	events := []reached{}
+	for _, order := range orders {
+		var at money.Decimal
+		var ok bool
+		switch {
+		case order.Stop:
+			at, ok = path.reach(order.StopPrice, !order.Rising, money.Zero)
+		case order.Market:
+			at, ok = money.Zero, true
+		default:
+			at, ok = path.reach(m.through(order, tick), order.Direction == investapi.OrderDirection_ORDER_DIRECTION_BUY, money.Zero)
+		}
+		if ok {
+			events = append(events, reached{order: order, at: at})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.LessThan(events[j].at) })
+
+	executions := []Execution{}
+	stopTriggered := false
+	for _, event := range events {
+		order := event.order
+		if order.Stop {
+			if stopTriggered {
+				continue
+			}
+			stopTriggered = true
+		}
+		lots := order.Lots
+		if available >= 0 && lots > available {
+			lots = available
+		}
+
+		var price money.Decimal
+		switch {
+		case order.Stop && !order.Market:
+			// the limit order of the stop is filled by the rest of the walk
+			at, ok := path.reach(m.through(order, tick), order.Direction == investapi.OrderDirection_ORDER_DIRECTION_BUY, event.at)
+			if !ok {
+				lots = 0
+			}
+			price = order.Price
+			if ok && at.IsZero() {
+				price = better(order.Direction, order.Price, path[0])
+			}
+		case order.Stop:
+			price = order.StopPrice
+			if event.at.IsZero() {
+				// the candle opened past the stop price
+				price = path[0]
+			}
+			price = m.slip(order.Direction, price, tick, candle)
+		case order.Market:
+			price = m.slip(order.Direction, path[0], tick, candle)
+		default:
+			price = order.Price
+			if event.at.IsZero() {
+				price = better(order.Direction, order.Price, path[0])
+			}
+		}
+
+		if lots <= 0 && !order.Stop {
+			continue
+		}
+		if available >= 0 {
+			available -= lots
+		}
+		executions = append(executions, Execution{OrderID: order.ID, Lots: lots, Price: price})
+	}
+	return executions
+}
+
+// through is the price that has to be reached to fill the limit order.
+func (m Conservative) through(order Order, tick money.Decimal) money.Decimal {
+	offset := tick.MulInt(m.ThroughTicks)
+	if order.Direction == investapi.OrderDirection_ORDER_DIRECTION_BUY {
+		return order.Price.Sub(offset)
+	}
+	return order.Price.Add(offset)
+}
+
+// slip worsens the price by SlippageTicks within the range of the candle.
+func (m Conservative) slip(direction investapi.OrderDirection, price, tick money.Decimal, candle *investapi.HistoricCandle) money.Decimal {
+	offset := tick.MulInt(m.SlippageTicks)
+	if direction == investapi.OrderDirection_ORDER_DIRECTION_BUY {
+		return money.Min(price.Add(offset), money.FromQuotation(candle.GetHigh()))
+	}
+	return money.Max(price.Sub(offset), money.FromQuotation(candle.GetLow()))
+}
+
+// better returns the price that is better for the direction, e.g. the open of a candle that gapped past a limit.
+func better(direction investapi.OrderDirection, price, other money.Decimal) money.Decimal {
+	if direction == investapi.OrderDirection_ORDER_DIRECTION_BUY {
+		return money.Min(price, other)
+	}
+	return money.Max(price, other)
+}
+
+// path is the prices a candle is assumed to go through, a position on it is the index of a segment
+// plus the passed part of the segment.
+type path []money.Decimal
+
+func candlePath(candle *investapi.HistoricCandle) path {
+	open := money.FromQuotation(candle.GetOpen())
+	high := money.FromQuotation(candle.GetHigh())
+	low := money.FromQuotation(candle.GetLow())
+	closePrice := money.FromQuotation(candle.GetClose())
+	if high.Sub(open).LessThan(open.Sub(low)) {
+		return path{open, high, low, closePrice}
+	}
+	return path{open, low, high, closePrice}
+}
+
+// reach returns the first position from start where the price gets to level, falling to it when down.
+// Prices are compared exactly, a position inside a segment is rounded up so it's never before the price.
+func (p path) reach(level money.Decimal, down bool, start money.Decimal) (money.Decimal, bool) {
+	passed := func(price money.Decimal) bool {
+		if down {
+			return !price.GreaterThan(level)
+		}
+		return !price.LessThan(level)
+	}
+	first := start.Truncate()
+	for i := first; i < int64(len(p)-1); i++ {
+		from, to := p[i], p[i+1]
+		at := money.FromInt(i)
+		if i == first {
+			at = start
+			from = from.Add(to.Sub(from).Mul(start.Sub(money.FromInt(i))))
+		}
+		if passed(from) {
+			return at, true
+		}
+		if passed(to) {
+			part := p[i].Sub(level).DivRound(p[i].Sub(to), money.RoundUp)
+			return money.Max(at, money.FromInt(i).Add(part)), true
+		}
+	}
+	return money.Zero, false
+}
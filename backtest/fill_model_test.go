@@ -0,0 +1,196 @@
+package backtest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+const (
+	buy  = investapi.OrderDirection_ORDER_DIRECTION_BUY
+	sell = investapi.OrderDirection_ORDER_DIRECTION_SELL
+)
+
+func testCandle(open, high, low, closePrice string, volume int64) *investapi.HistoricCandle {
+	return &investapi.HistoricCandle{
+		Open:   money.MustParse(open).Quotation(),
+		High:   money.MustParse(high).Quotation(),
+		Low:    money.MustParse(low).Quotation(),
+		Close:  money.MustParse(closePrice).Quotation(),
+		Volume: volume,
+	}
+}
+
+func limit(id string, direction investapi.OrderDirection, price string, lots int64) Order {
+	return Order{ID: id, Direction: direction, Price: money.MustParse(price), Lots: lots}
+}
+
+func TestConservativeExecute(t *testing.T) {
+	share := &investapi.Share{MinPriceIncrement: money.New(0, 10000000).Quotation()}
+	tests := []struct {
+		name       string
+		model      Conservative
+		candle     *investapi.HistoricCandle
+		orders     []Order
+		executions []Execution
+	}{
+		{
+			name:       "touch doesn't fill through ticks",
+			model:      DefaultFillModel(),
+			candle:     testCandle("101", "102", "100", "101", 1000),
+			orders:     []Order{limit("a", buy, "100", 1)},
+			executions: []Execution{},
+		},
+		{
+			name:       "touch fills without through ticks",
+			model:      Touch(),
+			candle:     testCandle("101", "102", "100", "101", 1000),
+			orders:     []Order{limit("a", buy, "100", 1)},
+			executions: []Execution{{OrderID: "a", Lots: 1, Price: money.MustParse("100")}},
+		},
+		{
+			name:       "a tick through fills at the limit",
+			model:      DefaultFillModel(),
+			candle:     testCandle("101", "102", "99.99", "101", 1000),
+			orders:     []Order{limit("a", buy, "100", 1)},
+			executions: []Execution{{OrderID: "a", Lots: 1, Price: money.MustParse("100")}},
+		},
+		{
+			name:       "sell a tick through",
+			model:      DefaultFillModel(),
+			candle:     testCandle("100", "100.51", "99", "100", 1000),
+			orders:     []Order{limit("a", sell, "100.5", 2)},
+			executions: []Execution{{OrderID: "a", Lots: 2, Price: money.MustParse("100.5")}},
+		},
+		{
+			name:       "gap past the limit fills at the open",
+			model:      DefaultFillModel(),
+			candle:     testCandle("99.5", "100", "99", "99.8", 1000),
+			orders:     []Order{limit("a", buy, "100", 1)},
+			executions: []Execution{{OrderID: "a", Lots: 1, Price: money.MustParse("99.5")}},
+		},
+		{
+			name:       "market buy slips",
+			model:      DefaultFillModel(),
+			candle:     testCandle("100", "101", "99", "100", 1000),
+			orders:     []Order{{ID: "a", Direction: buy, Market: true, Lots: 1}},
+			executions: []Execution{{OrderID: "a", Lots: 1, Price: money.MustParse("100.01")}},
+		},
+		{
+			name:       "market sell slips",
+			model:      DefaultFillModel(),
+			candle:     testCandle("100", "101", "99", "100", 1000),
+			orders:     []Order{{ID: "a", Direction: sell, Market: true, Lots: 1}},
+			executions: []Execution{{OrderID: "a", Lots: 1, Price: money.MustParse("99.99")}},
+		},
+		{
+			name:       "slippage stays within the candle",
+			model:      Conservative{SlippageTicks: 5},
+			candle:     testCandle("100", "100.02", "99", "100", 1000),
+			orders:     []Order{{ID: "a", Direction: buy, Market: true, Lots: 1}},
+			executions: []Execution{{OrderID: "a", Lots: 1, Price: money.MustParse("100.02")}},
+		},
+		{
+			name:   "volume limits the fill to a part",
+			model:  DefaultFillModel(),
+			candle: testCandle("101", "102", "98", "101", 50),
+			orders: []Order{limit("a", buy, "100", 10), limit("b", buy, "99", 10)},
+			executions: []Execution{
+				{OrderID: "a", Lots: 5, Price: money.MustParse("100")},
+			},
+		},
+		{
+			name:   "the walk orders fills, not the time of posting",
+			model:  Conservative{ThroughTicks: 1, Participation: money.MustParse("0.1")},
+			candle: testCandle("101", "102", "98", "101", 150),
+			orders: []Order{limit("late", buy, "99", 10), limit("early", buy, "100", 10)},
+			executions: []Execution{
+				{OrderID: "early", Lots: 10, Price: money.MustParse("100")},
+				{OrderID: "late", Lots: 5, Price: money.MustParse("99")},
+			},
+		},
+		{
+			name:   "one stop per candle, the low is visited first",
+			model:  DefaultFillModel(),
+			candle: testCandle("100", "104", "97", "100", 1000),
+			orders: []Order{
+				{ID: "take", Stop: true, Market: true, Direction: sell, StopPrice: money.MustParse("103"), Rising: true, Lots: 1},
+				{ID: "loss", Stop: true, Market: true, Direction: sell, StopPrice: money.MustParse("98"), Lots: 1},
+			},
+			executions: []Execution{{OrderID: "loss", Lots: 1, Price: money.MustParse("97.99")}},
+		},
+		{
+			name:   "one stop per candle, the high is visited first",
+			model:  DefaultFillModel(),
+			candle: testCandle("100", "103", "96", "100", 1000),
+			orders: []Order{
+				{ID: "loss", Stop: true, Market: true, Direction: sell, StopPrice: money.MustParse("98"), Lots: 1},
+				{ID: "take", Stop: true, Market: true, Direction: sell, StopPrice: money.MustParse("103"), Rising: true, Lots: 1},
+			},
+			executions: []Execution{{OrderID: "take", Lots: 1, Price: money.MustParse("102.99")}},
+		},
+		{
+			name:   "stop opened past its price fills at the open",
+			model:  DefaultFillModel(),
+			candle: testCandle("97", "98", "96", "97", 1000),
+			orders: []Order{
+				{ID: "loss", Stop: true, Market: true, Direction: sell, StopPrice: money.MustParse("98"), Lots: 1},
+			},
+			executions: []Execution{{OrderID: "loss", Lots: 1, Price: money.MustParse("96.99")}},
+		},
+		{
+			name:   "stop-limit is filled by the rest of the walk",
+			model:  DefaultFillModel(),
+			candle: testCandle("100", "101", "97", "99", 1000),
+			orders: []Order{
+				{ID: "loss", Stop: true, Direction: sell, StopPrice: money.MustParse("98"), Price: money.MustParse("97.5"), Lots: 1},
+			},
+			executions: []Execution{{OrderID: "loss", Lots: 1, Price: money.MustParse("97.5")}},
+		},
+		{
+			name:   "stop-limit is only triggered when the walk doesn't come back",
+			model:  DefaultFillModel(),
+			candle: testCandle("100", "100", "97", "97", 1000),
+			orders: []Order{
+				{ID: "loss", Stop: true, Direction: sell, StopPrice: money.MustParse("98"), Price: money.MustParse("99"), Lots: 1},
+			},
+			executions: []Execution{{OrderID: "loss", Lots: 0, Price: money.MustParse("99")}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executions := test.model.Execute(share, test.candle, test.orders)
+			if !reflect.DeepEqual(executions, test.executions) {
+				t.Errorf("executions %+v, want %+v", executions, test.executions)
+			}
+		})
+	}
+}
+
+func TestPathReach(t *testing.T) {
+	p := candlePath(testCandle("100", "104", "99", "102", 0))
+	tests := []struct {
+		level string
+		down  bool
+		start string
+		at    string
+		ok    bool
+	}{
+		{level: "100", down: true, start: "0", at: "0", ok: true},
+		{level: "99.5", down: true, start: "0", at: "0.5", ok: true},
+		{level: "102", down: false, start: "0", at: "1.6", ok: true},
+		{level: "100.3", down: false, start: "1.5", at: "1.5", ok: true},
+		{level: "103", down: false, start: "1.2", at: "1.8", ok: true},
+		{level: "103", down: true, start: "2.1", at: "2.5", ok: true},
+		{level: "98", down: true, start: "0", ok: false},
+		{level: "105", down: false, start: "0", ok: false},
+	}
+	for _, test := range tests {
+		at, ok := p.reach(money.MustParse(test.level), test.down, money.MustParse(test.start))
+		if ok != test.ok || ok && !at.Equal(money.MustParse(test.at)) {
+			t.Errorf("reach %v down %v from %v = %v %v, want %v %v", test.level, test.down, test.start, at, ok, test.at, test.ok)
+		}
+	}
+}
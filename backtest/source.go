@@ -0,0 +1,40 @@
+package backtest
+
+import (
+	"context"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/candles"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/nax11/tinkoff_bot_public/registry"
+)
+
+// Source gives instruments and historic candles to replay.
+type Source interface {
+	Share(ctx context.Context, figi string) (*investapi.Share, error)
+	// Candles returns complete candles of figi with time in [from, to), sorted by time
+	Candles(ctx context.Context, figi string, interval investapi.CandleInterval, from, to time.Time) ([]*investapi.HistoricCandle, error)
+}
+
+// StoreSource takes instruments from the registry and candles from the local store, periods missing
+// in the store are downloaded first. A period downloaded before is replayed without the API.
+func StoreSource(instruments registry.Provider, downloader *candles.Downloader) Source {
+	return storeSource{instruments: instruments, downloader: downloader}
+}
+
+type storeSource struct {
+	instruments registry.Provider
+	downloader  *candles.Downloader
+}
+
+func (s storeSource) Share(ctx context.Context, figi string) (*investapi.Share, error) {
+	instrument, err := s.instruments.ByFigi(ctx, figi)
+	if err != nil {
+		return nil, err
+	}
+	return instrument.Share(), nil
+}
+
+func (s storeSource) Candles(ctx context.Context, figi string, interval investapi.CandleInterval, from, to time.Time) ([]*investapi.HistoricCandle, error) {
+	return s.downloader.Candles(ctx, figi, interval, from, to)
+}
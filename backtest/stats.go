@@ -0,0 +1,84 @@
+package backtest
+
+import (
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+// Stats summarizes a backtest, ratios are fractions, e.g. 0.05 is 5%.
+type Stats struct {
+	StartEquity money.Decimal
+	EndEquity   money.Decimal
+	Return      money.Decimal
+	// MaxDrawdown is the largest fall of equity from its peak, relative to the peak
+	MaxDrawdown money.Decimal
+	Fills       int
+	Commission  money.Decimal
+	// RoundTrips are positions opened and closed back to zero, Wins are the ones with profit after commission
+	RoundTrips int
+	Wins       int
+	WinRate    money.Decimal
+	// Profit is the result of the round trips after commission
+	Profit money.Decimal
+}
+
+// roundTrip is an open position of an instrument with the cash it has taken so far.
+type roundTrip struct {
+	quantity int64
+	cash     money.Decimal
+}
+
+func computeStats(result *Result, deposit money.Decimal) Stats {
+	stats := Stats{
+		StartEquity: deposit,
+		EndEquity:   deposit,
+		Fills:       len(result.Fills),
+	}
+	if len(result.Equity) > 0 {
+		stats.EndEquity = result.Equity[len(result.Equity)-1].Equity
+	}
+	if deposit.Sign() > 0 {
+		stats.Return = stats.EndEquity.Sub(deposit).Div(deposit)
+	}
+
+	peak := deposit
+	for _, point := range result.Equity {
+		peak = money.Max(peak, point.Equity)
+		if peak.Sign() <= 0 {
+			continue
+		}
+		drawdown := peak.Sub(point.Equity).Div(peak)
+		stats.MaxDrawdown = money.Max(stats.MaxDrawdown, drawdown)
+	}
+
+	trips := make(map[string]*roundTrip)
+	for _, fill := range result.Fills {
+		stats.Commission = stats.Commission.Add(fill.Commission)
+		trip, ok := trips[fill.Figi]
+		if !ok {
+			trip = &roundTrip{}
+			trips[fill.Figi] = trip
+		}
+		value := fill.Price.MulInt(fill.Quantity)
+		if fill.Direction == investapi.OrderDirection_ORDER_DIRECTION_BUY {
+			trip.quantity += fill.Quantity
+			value = value.Neg()
+		} else {
+			trip.quantity -= fill.Quantity
+		}
+		trip.cash = trip.cash.Add(value).Sub(fill.Commission)
+		if trip.quantity != 0 {
+			continue
+		}
+		stats.RoundTrips++
+		stats.Profit = stats.Profit.Add(trip.cash)
+		if trip.cash.Sign() > 0 {
+			stats.Wins++
+		}
+		trip.cash = money.Zero
+	}
+	if stats.RoundTrips > 0 {
+		stats.WinRate = money.FromInt(int64(stats.Wins)).DivInt(int64(stats.RoundTrips))
+	}
+	return stats
+}
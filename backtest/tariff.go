@@ -0,0 +1,41 @@
+package backtest
+
+import (
+	"github.com/nax11/tinkoff_bot_public/money"
+)
+
+// Tariff is the commission of the broker as a fraction of the amount of a fill. Tiers lower the rate
+// once the turnover of the day before the fill reaches them.
+type Tariff struct {
+	Rate  money.Decimal
+	Tiers []TariffTier
+}
+
+type TariffTier struct {
+	Turnover money.Decimal
+	Rate     money.Decimal
+}
+
+// Tariffs are the rates of the broker for shares by tariff name, check them with the current tariffs of the broker.
+var Tariffs = map[string]Tariff{
+	"investor": {Rate: money.MustParse("0.003")},
+	"trader": {
+		Rate:  money.MustParse("0.0005"),
+		Tiers: []TariffTier{{Turnover: money.FromInt(200000), Rate: money.MustParse("0.00025")}},
+	},
+	"premium": {Rate: money.MustParse("0.0004")},
+}
+
+// commissionIncrement is the kopeck the broker rounds a commission up to.
+var commissionIncrement = money.New(0, 10000000)
+
+// Commission returns the commission of a fill of amount after turnover of the day, rounded up to a kopeck.
+func (t Tariff) Commission(turnover, amount money.Decimal) money.Decimal {
+	rate := t.Rate
+	for _, tier := range t.Tiers {
+		if !turnover.LessThan(tier.Turnover) {
+			rate = tier.Rate
+		}
+	}
+	return amount.Mul(rate).RoundTo(commissionIncrement, money.RoundUp)
+}
@@ -0,0 +1,31 @@
+package backtest
+
+import (
+	"testing"
+
+	"github.com/nax11/tinkoff_bot_public/money"
+)
+
+func TestTariffCommission(t *testing.T) {
+	tests := []struct {
+		tariff     string
+		turnover   string
+		amount     string
+		commission string
+	}{
+		{tariff: "trader", turnover: "0", amount: "1000", commission: "0.5"},
+		{tariff: "trader", turnover: "199999.99", amount: "1000", commission: "0.5"},
+		{tariff: "trader", turnover: "200000", amount: "1000", commission: "0.25"},
+		{tariff: "trader", turnover: "0", amount: "10.01", commission: "0.01"},
+		{tariff: "trader", turnover: "0", amount: "0.01", commission: "0.01"},
+		{tariff: "investor", turnover: "0", amount: "333.33", commission: "1"},
+		{tariff: "investor", turnover: "0", amount: "0", commission: "0"},
+		{tariff: "premium", turnover: "1000000", amount: "2500", commission: "1"},
+	}
+	for _, test := range tests {
+		commission := Tariffs[test.tariff].Commission(money.MustParse(test.turnover), money.MustParse(test.amount))
+		if !commission.Equal(money.MustParse(test.commission)) {
+			t.Errorf("%v commission of %v after %v = %v, want %v", test.tariff, test.amount, test.turnover, commission, test.commission)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package calendar
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// scheduleWindow is how many days are requested from TradingSchedules at once
+	scheduleWindow = 7
+	// searchDays limits lookups of the next or previous trading day, long holidays fit in it
+	searchDays = 30
+)
+
+var ErrNoSession = errors.New("no trading session found")
+
+type Provider interface {
+	// Day returns the schedule of the exchange for the Moscow day containing t
+	Day(ctx context.Context, exchange string, t time.Time) (*Day, error)
+	// Session returns the session of the exchange at t, nil when the exchange is closed
+	Session(ctx context.Context, exchange string, t time.Time) (*Session, error)
+	// NextOpen returns the start of the first continuous session at or after t
+	NextOpen(ctx context.Context, exchange string, t time.Time) (time.Time, error)
+	// UntilClose returns the time left in the continuous session at t, zero when it's closed
+	UntilClose(ctx context.Context, exchange string, t time.Time) (time.Duration, error)
+	// PreviousTradingDay returns the last trading day before the day containing t
+	PreviousTradingDay(ctx context.Context, exchange string, t time.Time) (*Day, error)
+	// IsTradable checks both the schedule and the current trading status of the instrument
+	IsTradable(ctx context.Context, exchange, figi string) (bool, error)
+}
+
+type impl struct {
+	client *api.Client
+	now    func() time.Time
+
+	mu   sync.Mutex
+	days map[string]map[time.Time]*Day
+}
+
+func Instance(client *api.Client) Provider {
+	return &impl{
+		client: client,
+		now:    client.Clock.Now,
+		days:   make(map[string]map[time.Time]*Day),
+	}
+}
+
+func (i *impl) Day(ctx context.Context, exchange string, t time.Time) (*Day, error) {
+	date := dayOf(t)
+	if day := i.cached(exchange, date); day != nil {
+		return day, nil
+	}
+	if err := i.load(ctx, exchange, date, date.AddDate(0, 0, scheduleWindow)); err != nil {
+		return nil, err
+	}
+	return i.cached(exchange, date), nil
+}
+
+func (i *impl) Session(ctx context.Context, exchange string, t time.Time) (*Session, error) {
+	day, err := i.Day(ctx, exchange, t)
+	if err != nil {
+		return nil, err
+	}
+	return day.Session(t), nil
+}
+
+func (i *impl) NextOpen(ctx context.Context, exchange string, t time.Time) (time.Time, error) {
+	for n := 0; n < searchDays; n++ {
+		day, err := i.Day(ctx, exchange, dayOf(t).AddDate(0, 0, n))
+		if err != nil {
+			return time.Time{}, err
+		}
+		for _, session := range day.Sessions {
+			if !session.Kind.Continuous() || !session.End.After(t) {
+				continue
+			}
+			if session.Start.Before(t) {
+				return t, nil
+			}
+			return session.Start, nil
+		}
+	}
+	return time.Time{}, errors.Wrapf(ErrNoSession, "exchange %v in %v days after %v", exchange, searchDays, t)
+}
+
+func (i *impl) UntilClose(ctx context.Context, exchange string, t time.Time) (time.Duration, error) {
+	session, err := i.Session(ctx, exchange, t)
+	if err != nil {
+		return 0, err
+	}
+	if session == nil || !session.Kind.Continuous() {
+		return 0, nil
+	}
+	return session.End.Sub(t), nil
+}
+
+func (i *impl) PreviousTradingDay(ctx context.Context, exchange string, t time.Time) (*Day, error) {
+	date := dayOf(t)
+	for n := 1; n <= searchDays; n++ {
+		prev := date.AddDate(0, 0, -n)
+		day := i.cached(exchange, prev)
+		if day == nil {
+			if err := i.load(ctx, exchange, prev.AddDate(0, 0, 1-scheduleWindow), prev.AddDate(0, 0, 1)); err != nil {
+				return nil, err
+			}
+			day = i.cached(exchange, prev)
+		}
+		if day.IsTradingDay && len(day.Sessions) > 0 {
+			return day, nil
+		}
+	}
+	return nil, errors.Wrapf(ErrNoSession, "exchange %v in %v days before %v", exchange, searchDays, t)
+}
+
+func (i *impl) IsTradable(ctx context.Context, exchange, figi string) (bool, error) {
+	session, err := i.Session(ctx, exchange, i.now())
+	if err != nil {
+		return false, err
+	}
+	if session == nil || !session.Kind.Continuous() {
+		return false, nil
+	}
+
+	resp, err := i.client.MarketDataServiceClient.GetTradingStatus(ctx, &investapi.GetTradingStatusRequest{Figi: figi})
+	if err != nil {
+		return false, errors.Wrapf(err, "fail get trading status %v", figi)
+	}
+	switch resp.GetTradingStatus() {
+	case investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING,
+		investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_DEALER_NORMAL_TRADING:
+		return resp.GetApiTradeAvailableFlag() && resp.GetLimitOrderAvailableFlag(), nil
+	}
+	return false, nil
+}
+
+func (i *impl) cached(exchange string, date time.Time) *Day {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.days[exchange][date]
+}
+
+// load requests days [from, to) and caches them, days missing in the response are holidays.
+func (i *impl) load(ctx context.Context, exchange string, from, to time.Time) error {
+	if exchange == "" {
+		return errors.New("exchange of the instrument is unknown")
+	}
+	resp, err := i.client.InstrumentsServiceClient.TradingSchedules(ctx, &investapi.TradingSchedulesRequest{
+		Exchange: exchange,
+		From:     timestamppb.New(from),
+		To:       timestamppb.New(to),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "fail get trading schedule of %v", exchange)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	days, ok := i.days[exchange]
+	if !ok {
+		days = make(map[time.Time]*Day)
+		i.days[exchange] = days
+	}
+	for date := from; date.Before(to); date = date.AddDate(0, 0, 1) {
+		if _, ok := days[date]; !ok {
+			days[date] = &Day{Date: date}
+		}
+	}
+	for _, schedule := range resp.GetExchanges() {
+		if schedule.GetExchange() != exchange {
+			continue
+		}
+		for _, td := range schedule.GetDays() {
+			day := fromTradingDay(td)
+			days[day.Date] = day
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package calendar_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/calendar"
+	"github.com/nax11/tinkoff_bot_public/clock"
+	"github.com/nax11/tinkoff_bot_public/fakeapi"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	exchange = "MOEX"
+	testFigi = "BBG000TEST01"
+)
+
+// msk returns the time of March 2024 in Moscow, the 8th is a holiday and the 9th and 10th are a weekend.
+func msk(day, hour, min int) time.Time {
+	return time.Date(2024, 3, day, hour, min, 0, 0, calendar.Moscow)
+}
+
+func newCalendar(t *testing.T, now time.Time) (*fakeapi.Server, *clock.Simulated, calendar.Provider) {
+	logrus.SetLevel(logrus.WarnLevel)
+	clk := clock.NewSimulated(now)
+	server := fakeapi.New()
+	t.Cleanup(server.Stop)
+	server.Now = clk.Now
+	server.SetTariff(&investapi.GetUserTariffResponse{})
+	server.SetTradingDay(exchange, &investapi.TradingDay{Date: timestamppb.New(msk(8, 0, 0))})
+	server.AddShare(&investapi.Share{
+		Figi:                  testFigi,
+		Ticker:                "TEST",
+		ClassCode:             "TQBR",
+		Lot:                   1,
+		Currency:              "rub",
+		Exchange:              exchange,
+		MinPriceIncrement:     money.New(0, 10000000).Quotation(),
+		TradingStatus:         investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING,
+		ApiTradeAvailableFlag: true,
+	})
+	client, err := server.Dial(false, api.UseClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server, clk, calendar.Instance(client)
+}
+
+func TestSessionBoundaries(t *testing.T) {
+	_, _, cal := newCalendar(t, msk(12, 12, 0))
+	for _, tt := range []struct {
+		name string
+		at   time.Time
+		// kind is empty when the exchange is closed
+		kind  string
+		until time.Duration
+	}{
+		{name: "night", at: msk(12, 3, 0)},
+		{name: "opening auction", at: msk(12, 9, 50), kind: "opening auction"},
+		{name: "main session start", at: msk(12, 10, 0), kind: "main", until: 8*time.Hour + 40*time.Minute},
+		{name: "main session in UTC", at: time.Date(2024, 3, 12, 15, 39, 0, 0, time.UTC), kind: "main", until: time.Minute},
+		{name: "main session end", at: msk(12, 18, 40), kind: "closing auction"},
+		{name: "between sessions", at: msk(12, 18, 55)},
+		{name: "evening", at: msk(12, 19, 5), kind: "evening", until: 4*time.Hour + 45*time.Minute},
+		{name: "evening end", at: msk(12, 23, 50)},
+		{name: "weekend", at: msk(9, 12, 0)},
+		{name: "holiday", at: msk(8, 12, 0)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			session, err := cal.Session(context.Background(), exchange, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kind := ""
+			if session != nil {
+				kind = session.Kind.String()
+			}
+			if kind != tt.kind {
+				t.Fatalf("session %q, want %q", kind, tt.kind)
+			}
+			until, err := cal.UntilClose(context.Background(), exchange, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if until != tt.until {
+				t.Fatalf("%v until close, want %v", until, tt.until)
+			}
+		})
+	}
+}
+
+func TestNextOpen(t *testing.T) {
+	_, _, cal := newCalendar(t, msk(12, 12, 0))
+	for _, tt := range []struct {
+		name     string
+		at, want time.Time
+	}{
+		{name: "before the open", at: msk(12, 9, 0), want: msk(12, 10, 0)},
+		{name: "during the session", at: msk(12, 12, 0), want: msk(12, 12, 0)},
+		{name: "closing auction", at: msk(12, 18, 45), want: msk(12, 19, 5)},
+		{name: "after the evening", at: msk(12, 23, 55), want: msk(13, 10, 0)},
+		// the day of Moscow starts at 21:00 UTC
+		{name: "next day in UTC", at: time.Date(2024, 3, 12, 21, 30, 0, 0, time.UTC), want: msk(13, 10, 0)},
+		{name: "holiday and weekend", at: msk(7, 23, 55), want: msk(11, 10, 0)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			next, err := cal.NextOpen(context.Background(), exchange, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !next.Equal(tt.want) {
+				t.Fatalf("next open %v, want %v", next, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreviousTradingDay(t *testing.T) {
+	_, _, cal := newCalendar(t, msk(12, 12, 0))
+	for _, tt := range []struct {
+		name     string
+		at, want time.Time
+	}{
+		{name: "weekday", at: msk(12, 1, 0), want: msk(11, 0, 0)},
+		{name: "after holiday and weekend", at: msk(11, 12, 0), want: msk(7, 0, 0)},
+		// 21:30 UTC of the 11th is already the 12th in Moscow
+		{name: "day of Moscow", at: time.Date(2024, 3, 11, 21, 30, 0, 0, time.UTC), want: msk(11, 0, 0)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			day, err := cal.PreviousTradingDay(context.Background(), exchange, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !day.Date.Equal(tt.want) {
+				t.Fatalf("previous trading day %v, want %v", day.Date, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsTradable(t *testing.T) {
+	// the simulated clock only moves forward, the cases are in time order
+	server, clk, cal := newCalendar(t, msk(8, 12, 0))
+	for _, tt := range []struct {
+		name   string
+		now    time.Time
+		status investapi.SecurityTradingStatus
+		want   bool
+	}{
+		{name: "holiday", now: msk(8, 12, 0), status: investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING},
+		{name: "auction", now: msk(12, 9, 55), status: investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING},
+		{name: "main session", now: msk(12, 12, 0), status: investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING, want: true},
+		{name: "halt", now: msk(12, 12, 0), status: investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_BREAK_IN_TRADING},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clk.Set(tt.now)
+			server.SetTradingStatus(testFigi, tt.status)
+			tradable, err := cal.IsTradable(context.Background(), exchange, testFigi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tradable != tt.want {
+				t.Fatalf("tradable %v, want %v", tradable, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package calendar
+
+import (
+	"sort"
+	"time"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Moscow is the time zone of the exchange schedule, falls back to a fixed UTC+3
+// when the system has no tz database.
+var Moscow = loadMoscow()
+
+func loadMoscow() *time.Location {
+	loc, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		return time.FixedZone("MSK", 3*60*60)
+	}
+	return loc
+}
+
+type SessionKind int
+
+const (
+	SessionPremarket SessionKind = iota
+	SessionOpeningAuction
+	SessionMain
+	SessionClosingAuction
+	SessionEveningAuction
+	SessionEvening
+)
+
+func (k SessionKind) String() string {
+	switch k {
+	case SessionPremarket:
+		return "premarket"
+	case SessionOpeningAuction:
+		return "opening auction"
+	case SessionMain:
+		return "main"
+	case SessionClosingAuction:
+		return "closing auction"
+	case SessionEveningAuction:
+		return "evening auction"
+	case SessionEvening:
+		return "evening"
+	}
+	return "unknown"
+}
+
+// Continuous tells whether orders are matched immediately, during auctions they are only collected.
+func (k SessionKind) Continuous() bool {
+	return k == SessionPremarket || k == SessionMain || k == SessionEvening
+}
+
+type Session struct {
+	Kind  SessionKind
+	Start time.Time
+	End   time.Time
+}
+
+func (s Session) Contains(t time.Time) bool {
+	return !t.Before(s.Start) && t.Before(s.End)
+}
+
+// Day is a calendar day of an exchange, Date is midnight in Moscow.
+type Day struct {
+	Date         time.Time
+	IsTradingDay bool
+	// Sessions are ordered by start
+	Sessions []Session
+}
+
+// Session returns the session containing t or nil.
+func (d *Day) Session(t time.Time) *Session {
+	for i := range d.Sessions {
+		if d.Sessions[i].Contains(t) {
+			return &d.Sessions[i]
+		}
+	}
+	return nil
+}
+
+// Open is the start of the first continuous session.
+func (d *Day) Open() (time.Time, bool) {
+	for _, session := range d.Sessions {
+		if session.Kind.Continuous() {
+			return session.Start, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Close is the end of the last session of the day.
+func (d *Day) Close() (time.Time, bool) {
+	if len(d.Sessions) == 0 {
+		return time.Time{}, false
+	}
+	return d.Sessions[len(d.Sessions)-1].End, true
+}
+
+func dayOf(t time.Time) time.Time {
+	t = t.In(Moscow)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, Moscow)
+}
+
+func fromTradingDay(td *investapi.TradingDay) *Day {
+	day := &Day{
+		Date:         dayOf(td.GetDate().AsTime()),
+		IsTradingDay: td.GetIsTradingDay(),
+	}
+	if !day.IsTradingDay {
+		return day
+	}
+	day.add(SessionPremarket, td.GetPremarketStartTime(), td.GetPremarketEndTime())
+	day.add(SessionOpeningAuction, td.GetOpeningAuctionStartTime(), td.GetStartTime())
+	day.add(SessionMain, td.GetStartTime(), td.GetEndTime())
+	day.add(SessionClosingAuction, td.GetEndTime(), td.GetClosingAuctionEndTime())
+	day.add(SessionEveningAuction, td.GetEveningOpeningAuctionStartTime(), td.GetEveningStartTime())
+	day.add(SessionEvening, td.GetEveningStartTime(), td.GetEveningEndTime())
+	return day
+}
+
+// add skips sessions the exchange doesn't have, their timestamps are empty.
+func (d *Day) add(kind SessionKind, start, end *timestamppb.Timestamp) {
+	if start.GetSeconds() == 0 || end.GetSeconds() == 0 {
+		return
+	}
+	session := Session{Kind: kind, Start: start.AsTime().In(Moscow), End: end.AsTime().In(Moscow)}
+	if !session.End.After(session.Start) {
+		return
+	}
+	d.Sessions = append(d.Sessions, session)
+	sort.SliceStable(d.Sessions, func(i, j int) bool { return d.Sessions[i].Start.Before(d.Sessions[j].Start) })
+}
@@ -0,0 +1,129 @@
+// Package candles downloads historic candles in chunks the API accepts and keeps them in a local store,
+// so backtests and analysis of downloaded periods don't need the API.
+package candles
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Duration is the length of a candle of interval.
+func Duration(interval investapi.CandleInterval) (time.Duration, error) {
+	switch interval {
+	case investapi.CandleInterval_CANDLE_INTERVAL_1_MIN:
+		return time.Minute, nil
+	case investapi.CandleInterval_CANDLE_INTERVAL_5_MIN:
+		return 5 * time.Minute, nil
+	case investapi.CandleInterval_CANDLE_INTERVAL_15_MIN:
+		return 15 * time.Minute, nil
+	case investapi.CandleInterval_CANDLE_INTERVAL_HOUR:
+		return time.Hour, nil
+	case investapi.CandleInterval_CANDLE_INTERVAL_DAY:
+		return 24 * time.Hour, nil
+	}
+	return 0, errors.Errorf("unsupported candle interval %v", interval)
+}
+
+// RequestPeriod is the longest period GetCandles accepts for interval.
+func RequestPeriod(interval investapi.CandleInterval) (time.Duration, error) {
+	switch interval {
+	case investapi.CandleInterval_CANDLE_INTERVAL_1_MIN,
+		investapi.CandleInterval_CANDLE_INTERVAL_5_MIN,
+		investapi.CandleInterval_CANDLE_INTERVAL_15_MIN:
+		return 24 * time.Hour, nil
+	case investapi.CandleInterval_CANDLE_INTERVAL_HOUR:
+		return 7 * 24 * time.Hour, nil
+	case investapi.CandleInterval_CANDLE_INTERVAL_DAY:
+		return 365 * 24 * time.Hour, nil
+	}
+	return 0, errors.Errorf("unsupported candle interval %v", interval)
+}
+
+// intervalName is the directory of the interval in the store, e.g. "5_min".
+func intervalName(interval investapi.CandleInterval) string {
+	return strings.ToLower(strings.TrimPrefix(interval.String(), "CANDLE_INTERVAL_"))
+}
+
+// Fetch requests candles of [from, to) from the API by periods it accepts, the client keeps the calls
+// within the rate limit. Candles are sorted by time and unique, the incomplete one is included.
+func Fetch(ctx context.Context, client *api.Client, figi string, interval investapi.CandleInterval, from, to time.Time) ([]*investapi.HistoricCandle, error) {
+	period, err := RequestPeriod(interval)
+	if err != nil {
+		return nil, err
+	}
+	byTime := make(map[time.Time]*investapi.HistoricCandle)
+	for start := from; start.Before(to); start = start.Add(period) {
+		end := start.Add(period)
+		if end.After(to) {
+			end = to
+		}
+		resp, err := client.MarketDataServiceClient.GetCandles(ctx, &investapi.GetCandlesRequest{
+			Figi:     figi,
+			From:     timestamppb.New(start),
+			To:       timestamppb.New(end),
+			Interval: interval,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail get candles of %v from %v to %v", figi, start, end)
+		}
+		for _, candle := range resp.GetCandles() {
+			byTime[candle.GetTime().AsTime()] = candle
+		}
+	}
+	return sorted(byTime), nil
+}
+
+func sorted(byTime map[time.Time]*investapi.HistoricCandle) []*investapi.HistoricCandle {
+	candles := make([]*investapi.HistoricCandle, 0, len(byTime))
+	for _, candle := range byTime {
+		candles = append(candles, candle)
+	}
+	sort.Slice(candles, func(i, j int) bool {
+		return candles[i].GetTime().AsTime().Before(candles[j].GetTime().AsTime())
+	})
+	return candles
+}
+
+// Range is a period [From, To).
+type Range struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Gap is a pause between candles of a day longer than the interval, e.g. trading was suspended
+// or the instrument isn't traded every minute.
+type Gap struct {
+	Range
+	// Missing is the number of candles that would fit in the gap
+	Missing int64
+}
+
+// FindGaps returns pauses between the sorted candles of the same day, the nights and weekends aren't gaps.
+func FindGaps(candles []*investapi.HistoricCandle, interval investapi.CandleInterval, location *time.Location) ([]Gap, error) {
+	length, err := Duration(interval)
+	if err != nil {
+		return nil, err
+	}
+	gaps := []Gap{}
+	if interval == investapi.CandleInterval_CANDLE_INTERVAL_DAY {
+		return gaps, nil
+	}
+	for i := 1; i < len(candles); i++ {
+		prev, next := candles[i-1].GetTime().AsTime(), candles[i].GetTime().AsTime()
+		if next.Sub(prev) <= length || prev.In(location).YearDay() != next.In(location).YearDay() {
+			continue
+		}
+		gaps = append(gaps, Gap{
+			Range:   Range{From: prev.Add(length), To: next},
+			Missing: int64(next.Sub(prev)/length) - 1,
+		})
+	}
+	return gaps, nil
+}
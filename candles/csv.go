@@ -0,0 +1,135 @@
+package candles
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// csvTimeLayouts are the accepted time formats, e.g. the ones of pandas to_csv. A time without
+// an offset is in the location given to ReadCSV.
+var csvTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// WriteCSV writes candles with the header of the candle schema, time is RFC 3339 in location.
+func WriteCSV(w io.Writer, candles []*investapi.HistoricCandle, location *time.Location) error {
+	records := make([][]string, 0, len(candles)+1)
+	records = append(records, columns)
+	for _, candle := range candles {
+		records = append(records, []string{
+			candle.GetTime().AsTime().In(location).Format(time.RFC3339Nano),
+			money.FromQuotation(candle.GetOpen()).String(),
+			money.FromQuotation(candle.GetHigh()).String(),
+			money.FromQuotation(candle.GetLow()).String(),
+			money.FromQuotation(candle.GetClose()).String(),
+			strconv.FormatInt(candle.GetVolume(), 10),
+			strconv.FormatBool(candle.GetIsComplete()),
+		})
+	}
+	if err := csv.NewWriter(w).WriteAll(records); err != nil {
+		return errors.Wrap(err, "fail write csv")
+	}
+	return nil
+}
+
+// ReadCSV reads candles of a CSV file with a header, the columns of the candle schema are found by name
+// and other columns are ignored.
+func ReadCSV(r io.Reader, location *time.Location) ([]*investapi.HistoricCandle, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("csv file is empty")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "fail read csv")
+	}
+	index := make([]int, len(columns))
+	for i := range index {
+		index[i] = -1
+	}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if column := columnIndex(name); column >= 0 {
+			index[column] = i
+		}
+	}
+	for i, name := range columns[:6] {
+		if index[i] < 0 {
+			return nil, errors.Errorf("no column %v in csv header", name)
+		}
+	}
+
+	candles := []*investapi.HistoricCandle{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return candles, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "fail read csv")
+		}
+		candle, err := csvCandle(record, index, location)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %v", line)
+		}
+		candles = append(candles, candle)
+	}
+}
+
+func csvCandle(record []string, index []int, location *time.Location) (*investapi.HistoricCandle, error) {
+	field := func(column int) string {
+		return strings.TrimSpace(record[index[column]])
+	}
+	candleTime, err := parseCSVTime(field(0), location)
+	if err != nil {
+		return nil, err
+	}
+	prices := make([]*investapi.Quotation, 4)
+	for i := range prices {
+		price, err := money.Parse(field(i + 1))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %v", columns[i+1])
+		}
+		prices[i] = price.Quotation()
+	}
+	volume, err := strconv.ParseInt(field(5), 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid volume")
+	}
+	isComplete := true
+	if index[6] >= 0 && field(6) != "" {
+		if isComplete, err = strconv.ParseBool(field(6)); err != nil {
+			return nil, errors.Wrap(err, "invalid is_complete")
+		}
+	}
+	return &investapi.HistoricCandle{
+		Time:       timestamppb.New(candleTime),
+		Open:       prices[0],
+		High:       prices[1],
+		Low:        prices[2],
+		Close:      prices[3],
+		Volume:     volume,
+		IsComplete: isComplete,
+	}, nil
+}
+
+func parseCSVTime(value string, location *time.Location) (time.Time, error) {
+	for _, layout := range csvTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("invalid time %q", value)
+}
@@ -0,0 +1,72 @@
+package candles
+
+import (
+	"context"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/sirupsen/logrus"
+)
+
+// Downloader fills the store with candles from the API.
+type Downloader struct {
+	client *api.Client
+	store  *Store
+}
+
+func NewDownloader(client *api.Client, store *Store) *Downloader {
+	return &Downloader{client: client, store: store}
+}
+
+// Download requests the parts of [from, to) missing in the store and returns the number of saved candles.
+// Every request period is saved before the next one is requested, so an interrupted download continues
+// where it stopped. The period of an incomplete candle isn't marked downloaded, it's requested again later.
+func (d *Downloader) Download(ctx context.Context, figi string, interval investapi.CandleInterval, from, to time.Time) (int, error) {
+	period, err := RequestPeriod(interval)
+	if err != nil {
+		return 0, err
+	}
+	if now := d.client.Clock.Now(); to.After(now) {
+		to = now
+	}
+	missing, err := d.store.Missing(figi, interval, from, to)
+	if err != nil {
+		return 0, err
+	}
+	saved := 0
+	for _, r := range missing {
+		for start := r.From; start.Before(r.To); start = start.Add(period) {
+			end := start.Add(period)
+			if end.After(r.To) {
+				end = r.To
+			}
+			fetched, err := Fetch(ctx, d.client, figi, interval, start, end)
+			if err != nil {
+				return saved, err
+			}
+			complete, covered := completeOf(fetched, Range{From: start, To: end})
+			if err = d.store.Save(figi, interval, covered, complete); err != nil {
+				return saved, err
+			}
+			saved += len(complete)
+		}
+	}
+	if len(missing) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"figi":     figi,
+			"interval": interval.String(),
+			"periods":  len(missing),
+			"candles":  saved,
+		}).Info("candles downloaded")
+	}
+	return saved, nil
+}
+
+// Candles returns candles of [from, to) from the store, the missing parts are downloaded first.
+func (d *Downloader) Candles(ctx context.Context, figi string, interval investapi.CandleInterval, from, to time.Time) ([]*investapi.HistoricCandle, error) {
+	if _, err := d.Download(ctx, figi, interval, from, to); err != nil {
+		return nil, err
+	}
+	return d.store.Candles(figi, interval, from, to)
+}
@@ -0,0 +1,119 @@
+package candles
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/atomicfile"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+)
+
+// columns is the candle schema of CSV and parquet files, a row is a candle:
+//
+//	time         start of the candle; CSV has RFC 3339, a time without an offset is local to the
+//	             location given on read; parquet has an INT64 timestamp in UTC
+//	open         price of a unit of the instrument, not of a lot; decimal in CSV, DOUBLE in parquet
+//	high         the highest price
+//	low          the lowest price
+//	close        the last price
+//	volume       lots traded, integer
+//	is_complete  optional, false for a candle of the current interval; true when the column is absent
+//
+// Names are matched case-insensitive, other columns are ignored. Prices of parquet files are rounded
+// to nine fractional digits.
+var columns = []string{"time", "open", "high", "low", "close", "volume", "is_complete"}
+
+func columnIndex(name string) int {
+	for i, column := range columns {
+		if column == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ReadFile reads candles of a .csv or .parquet file and validates them for interval. The candles
+// are ready for the analyzer and for Store.Import, which makes them available to backtests.
+func ReadFile(path string, interval investapi.CandleInterval, location *time.Location) ([]*investapi.HistoricCandle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail open candles file")
+	}
+	defer file.Close()
+	var candles []*investapi.HistoricCandle
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		candles, err = ReadCSV(file, location)
+	case ".parquet":
+		candles, err = ReadParquet(file, location)
+	default:
+		return nil, errors.Errorf("unknown format of %v, expected .csv or .parquet", path)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail read %v", path)
+	}
+	if err = Validate(candles, interval); err != nil {
+		return nil, errors.Wrapf(err, "invalid %v", path)
+	}
+	return candles, nil
+}
+
+// WriteFile writes candles to a .csv or .parquet file by its extension, CSV times are in location.
+func WriteFile(path string, candles []*investapi.HistoricCandle, location *time.Location) error {
+	buf := bytes.Buffer{}
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		err = WriteCSV(&buf, candles, location)
+	case ".parquet":
+		err = WriteParquet(&buf, candles)
+	default:
+		return errors.Errorf("unknown format of %v, expected .csv or .parquet", path)
+	}
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, buf.Bytes())
+}
+
+// Validate checks that candles are sorted by time without duplicates, start on a boundary of interval,
+// have positive prices within low and high and non-negative volume.
+func Validate(candles []*investapi.HistoricCandle, interval investapi.CandleInterval) error {
+	length, err := Duration(interval)
+	if err != nil {
+		return err
+	}
+	var prev time.Time
+	for i, candle := range candles {
+		row := i + 1
+		if candle.GetTime() == nil {
+			return errors.Errorf("row %v: no time", row)
+		}
+		candleTime := candle.GetTime().AsTime()
+		if i > 0 && !candleTime.After(prev) {
+			return errors.Errorf("row %v: time %v isn't after %v of the previous row", row, candleTime, prev)
+		}
+		prev = candleTime
+		// day candles start at the session open, not at midnight
+		if interval != investapi.CandleInterval_CANDLE_INTERVAL_DAY && !candleTime.Truncate(length).Equal(candleTime) {
+			return errors.Errorf("row %v: time %v isn't a start of %v candle", row, candleTime, length)
+		}
+		open, high := money.FromQuotation(candle.GetOpen()), money.FromQuotation(candle.GetHigh())
+		low, closePrice := money.FromQuotation(candle.GetLow()), money.FromQuotation(candle.GetClose())
+		if low.Sign() <= 0 {
+			return errors.Errorf("row %v: low %v isn't positive", row, low)
+		}
+		if high.LessThan(money.Max(open, closePrice)) || low.GreaterThan(money.Min(open, closePrice)) {
+			return errors.Errorf("row %v: open %v and close %v aren't within low %v and high %v", row, open, closePrice, low, high)
+		}
+		if candle.GetVolume() < 0 {
+			return errors.Errorf("row %v: volume %v is negative", row, candle.GetVolume())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package candles
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var parquetMagic = []byte("PAR1")
+
+// WriteParquet writes candles as an uncompressed parquet file with one row group. Time is
+// INT64 TIMESTAMP(MICROS, UTC), prices are DOUBLE, volume is INT64 and is_complete is BOOLEAN.
+func WriteParquet(w io.Writer, candles []*investapi.HistoricCandle) error {
+	n := len(candles)
+	values := make([][]byte, len(columns))
+	for i := range values[:6] {
+		values[i] = make([]byte, 8*n)
+	}
+	values[6] = make([]byte, (n+7)/8)
+	for i, candle := range candles {
+		binary.LittleEndian.PutUint64(values[0][8*i:], uint64(candle.GetTime().AsTime().UnixMicro()))
+		for j, price := range []*investapi.Quotation{candle.GetOpen(), candle.GetHigh(), candle.GetLow(), candle.GetClose()} {
+			binary.LittleEndian.PutUint64(values[j+1][8*i:], math.Float64bits(money.FromQuotation(price).Float64()))
+		}
+		binary.LittleEndian.PutUint64(values[5][8*i:], uint64(candle.GetVolume()))
+		if candle.GetIsComplete() {
+			values[6][i/8] |= 1 << (i % 8)
+		}
+	}
+	physical := []int32{parquetInt64, parquetDouble, parquetDouble, parquetDouble, parquetDouble, parquetInt64, parquetBoolean}
+
+	file := bytes.Buffer{}
+	file.Write(parquetMagic)
+	meta := &thriftWriter{}
+	meta.begin()
+	meta.i32(1, 1)
+	meta.list(2, thriftStructType, len(columns)+1)
+	meta.begin()
+	meta.string(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.end()
+	for i, name := range columns {
+		meta.begin()
+		meta.i32(1, physical[i])
+		meta.i32(3, 0) // required
+		meta.string(4, name)
+		if name == "time" {
+			meta.i32(6, 10) // TIMESTAMP_MICROS
+			meta.structField(10)
+			meta.structField(8)
+			meta.bool(1, true)
+			meta.structField(2)
+			meta.structField(2) // MICROS
+			meta.end()
+			meta.end()
+			meta.end()
+			meta.end()
+		}
+		meta.end()
+	}
+	meta.i64(3, int64(n))
+	meta.list(4, thriftStructType, 1)
+	meta.begin()
+	meta.list(1, thriftStructType, len(columns))
+	total := 0
+	for i, name := range columns {
+		page := &thriftWriter{}
+		page.begin()
+		page.i32(1, pageData)
+		page.i32(2, int32(len(values[i])))
+		page.i32(3, int32(len(values[i])))
+		page.structField(5)
+		page.i32(1, int32(n))
+		page.i32(2, encodingPlain)
+		page.i32(3, encodingRLE)
+		page.i32(4, encodingRLE)
+		page.end()
+		page.end()
+		offset := int64(file.Len())
+		size := int64(page.buf.Len() + len(values[i]))
+		file.Write(page.buf.Bytes())
+		file.Write(values[i])
+		total += int(size)
+
+		meta.begin()
+		meta.i64(2, offset)
+		meta.structField(3)
+		meta.i32(1, physical[i])
+		meta.list(2, thriftI32, 2)
+		meta.varint(encodingPlain)
+		meta.varint(encodingRLE)
+		meta.list(3, thriftBinary, 1)
+		meta.uvarint(uint64(len(name)))
+		meta.buf.WriteString(name)
+		meta.i32(4, codecUncompressed)
+		meta.i64(5, int64(n))
+		meta.i64(6, size)
+		meta.i64(7, size)
+		meta.i64(9, offset)
+		meta.end()
+		meta.end()
+	}
+	meta.i64(2, int64(total))
+	meta.i64(3, int64(n))
+	meta.end()
+	meta.string(6, "tinkoff_bot")
+	meta.end()
+
+	file.Write(meta.buf.Bytes())
+	footer := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footer, uint32(meta.buf.Len()))
+	file.Write(footer)
+	file.Write(parquetMagic)
+	_, err := w.Write(file.Bytes())
+	return errors.Wrap(err, "fail write parquet")
+}
+
+// ReadParquet reads candles of a parquet file with flat columns of the candle schema, other columns are
+// ignored. Pages may be PLAIN or dictionary encoded, uncompressed or compressed with snappy or gzip,
+// as pandas and pyarrow write them by default. Time is an INT64 timestamp, a timestamp not adjusted
+// to UTC is in location. Prices are DOUBLE, FLOAT or integer.
+func ReadParquet(r io.Reader, location *time.Location) ([]*investapi.HistoricCandle, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail read parquet")
+	}
+	if len(data) < 12 || !bytes.Equal(data[:4], parquetMagic) || !bytes.Equal(data[len(data)-4:], parquetMagic) {
+		return nil, errors.New("not a parquet file")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if size > len(data)-12 {
+		return nil, errors.New("corrupt parquet footer")
+	}
+	meta, err := (&thriftReader{data: data[len(data)-8-size : len(data)-8]}).readStruct()
+	if err != nil {
+		return nil, errors.Wrap(err, "fail read parquet metadata")
+	}
+	leaves, err := parquetLeaves(meta.list(2))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range columns[:6] {
+		if _, ok := leaves[name]; !ok {
+			return nil, errors.Errorf("no column %v in parquet file", name)
+		}
+	}
+	toTime, err := parquetTimestamp(leaves["time"], location)
+	if err != nil {
+		return nil, err
+	}
+
+	candles := []*investapi.HistoricCandle{}
+	for _, item := range meta.list(4) {
+		rowGroup, _ := item.(thriftStruct)
+		rows := int(rowGroup.int(3))
+		values := make(map[string][]interface{}, len(columns))
+		for _, item := range rowGroup.list(1) {
+			chunk, _ := item.(thriftStruct)
+			columnMeta := chunk.child(3)
+			path := columnMeta.list(3)
+			if len(path) != 1 {
+				continue
+			}
+			name, _ := path[0].([]byte)
+			leaf, ok := leaves[strings.ToLower(string(name))]
+			if !ok {
+				continue
+			}
+			if values[leaf.name], err = readColumn(data, leaf, columnMeta); err != nil {
+				return nil, err
+			}
+			if len(values[leaf.name]) < rows {
+				return nil, errors.Errorf("column %v has %v values of %v rows", leaf.name, len(values[leaf.name]), rows)
+			}
+		}
+		for i := 0; i < rows; i++ {
+			row := len(candles) + 1
+			if values["time"][i] == nil {
+				return nil, errors.Errorf("row %v: time is null", row)
+			}
+			candle := &investapi.HistoricCandle{
+				Time:       timestamppb.New(toTime(values["time"][i].(int64))),
+				IsComplete: true,
+			}
+			for _, price := range []struct {
+				name  string
+				value **investapi.Quotation
+			}{{"open", &candle.Open}, {"high", &candle.High}, {"low", &candle.Low}, {"close", &candle.Close}} {
+				switch v := values[price.name][i].(type) {
+				case float64:
+					d, err := money.FromFloat(v)
+					if err != nil {
+						return nil, errors.Wrapf(err, "row %v: %v", row, price.name)
+					}
+					*price.value = d.Quotation()
+				case int64:
+					*price.value = money.FromInt(v).Quotation()
+				default:
+					return nil, errors.Errorf("row %v: %v is null or not a number", row, price.name)
+				}
+			}
+			switch v := values["volume"][i].(type) {
+			case int64:
+				candle.Volume = v
+			case float64:
+				if v != math.Trunc(v) {
+					return nil, errors.Errorf("row %v: volume %v isn't a whole number", row, v)
+				}
+				candle.Volume = int64(v)
+			default:
+				return nil, errors.Errorf("row %v: volume is null or not a number", row)
+			}
+			if isComplete, ok := values["is_complete"]; ok && isComplete[i] != nil {
+				if candle.IsComplete, ok = isComplete[i].(bool); !ok {
+					return nil, errors.Errorf("row %v: is_complete isn't BOOLEAN", row)
+				}
+			}
+			candles = append(candles, candle)
+		}
+	}
+	return candles, nil
+}
+
+// parquetLeaves returns the top level columns of the schema that are columns of the candle schema.
+func parquetLeaves(schema []interface{}) (map[string]parquetLeaf, error) {
+	if len(schema) == 0 {
+		return nil, errors.New("parquet schema is empty")
+	}
+	leaves := make(map[string]parquetLeaf)
+	pos := 1
+	var walk func(count int, top bool) error
+	walk = func(count int, top bool) error {
+		for i := 0; i < count; i++ {
+			if pos >= len(schema) {
+				return errors.New("corrupt parquet schema")
+			}
+			element, _ := schema[pos].(thriftStruct)
+			pos++
+			if children := element.int(5); children > 0 {
+				if err := walk(int(children), false); err != nil {
+					return err
+				}
+				continue
+			}
+			name := strings.ToLower(element.string(4))
+			if !top || element.int(3) == 2 || columnIndex(name) < 0 {
+				continue
+			}
+			leaves[name] = parquetLeaf{
+				name:     name,
+				physical: element.int(1),
+				optional: element.int(3) == 1,
+				element:  element,
+			}
+		}
+		return nil
+	}
+	root, _ := schema[0].(thriftStruct)
+	return leaves, walk(int(root.int(5)), true)
+}
+
+// parquetTimestamp returns the conversion of values of the time column by its unit.
+func parquetTimestamp(leaf parquetLeaf, location *time.Location) (func(int64) time.Time, error) {
+	if leaf.physical != parquetInt64 {
+		return nil, errors.New("column time must be an INT64 timestamp")
+	}
+	var toTime func(int64) time.Time
+	utc := true
+	if timestamp := leaf.element.child(10).child(8); timestamp != nil {
+		utc, _ = timestamp.bool(1)
+		switch unit := timestamp.child(2); {
+		case unit.has(1):
+			toTime = time.UnixMilli
+		case unit.has(2):
+			toTime = time.UnixMicro
+		case unit.has(3):
+			toTime = func(v int64) time.Time { return time.Unix(0, v) }
+		}
+	} else if leaf.element.has(6) {
+		switch leaf.element.int(6) {
+		case 9: // TIMESTAMP_MILLIS
+			toTime = time.UnixMilli
+		case 10: // TIMESTAMP_MICROS
+			toTime = time.UnixMicro
+		}
+	}
+	if toTime == nil {
+		return nil, errors.New("column time must be an INT64 timestamp")
+	}
+	if utc {
+		return toTime, nil
+	}
+	return func(v int64) time.Time {
+		wall := toTime(v).UTC()
+		return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), location)
+	}, nil
+}
@@ -0,0 +1,378 @@
+package candles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Physical types, encodings, codecs and page types of the parquet format.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetFloat     = 4
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	encodingPlain           = 0
+	encodingPlainDictionary = 2
+	encodingRLE             = 3
+	encodingRLEDictionary   = 8
+
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecGzip         = 2
+
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+// parquetLeaf is a flat column of the file schema.
+type parquetLeaf struct {
+	name     string
+	physical int64
+	optional bool
+	element  thriftStruct
+}
+
+// readColumn decodes values of a column chunk, a null value is nil. The values are int64, float64, bool
+// or []byte by the physical type of the column.
+func readColumn(data []byte, leaf parquetLeaf, meta thriftStruct) ([]interface{}, error) {
+	codec := meta.int(4)
+	total := meta.int(5)
+	start := meta.int(9)
+	if meta.has(11) && meta.int(11) > 0 && meta.int(11) < start {
+		start = meta.int(11)
+	}
+	if start < 0 || start > int64(len(data)) {
+		return nil, errors.Errorf("column %v is out of the file", leaf.name)
+	}
+	r := &thriftReader{data: data, pos: int(start)}
+	values := make([]interface{}, 0, total)
+	var dictionary []interface{}
+	for int64(len(values)) < total {
+		header, err := r.readStruct()
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail read page of column %v", leaf.name)
+		}
+		body, err := r.bytes(uint64(header.int(3)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail read page of column %v", leaf.name)
+		}
+		switch header.int(1) {
+		case pageDictionary:
+			dict := header.child(7)
+			if body, err = decompress(codec, body, header.int(2)); err != nil {
+				return nil, errors.Wrapf(err, "column %v", leaf.name)
+			}
+			if dictionary, _, err = plainValues(body, leaf.physical, int(dict.int(1))); err != nil {
+				return nil, errors.Wrapf(err, "fail read dictionary of column %v", leaf.name)
+			}
+		case pageData:
+			page := header.child(5)
+			if body, err = decompress(codec, body, header.int(2)); err != nil {
+				return nil, errors.Wrapf(err, "column %v", leaf.name)
+			}
+			n := int(page.int(1))
+			defined := allDefined(n)
+			if leaf.optional {
+				// some writers put empty repetition levels of a flat column first, levels of rows aren't empty
+				if len(body) >= 4 && n > 0 && binary.LittleEndian.Uint32(body) == 0 {
+					body = body[4:]
+				}
+				if len(body) < 4 {
+					return nil, errors.Errorf("short page of column %v", leaf.name)
+				}
+				size := int(binary.LittleEndian.Uint32(body))
+				if size > len(body)-4 {
+					return nil, errors.Errorf("short page of column %v", leaf.name)
+				}
+				if defined, err = hybridValues(body[4:4+size], 1, n); err != nil {
+					return nil, errors.Wrapf(err, "fail read levels of column %v", leaf.name)
+				}
+				body = body[4+size:]
+			}
+			if values, err = appendValues(values, body, page.int(2), defined, dictionary, leaf); err != nil {
+				return nil, err
+			}
+		case pageDataV2:
+			page := header.child(8)
+			n := int(page.int(1))
+			repetition, definition := int(page.int(6)), int(page.int(5))
+			if repetition+definition > len(body) {
+				return nil, errors.Errorf("short page of column %v", leaf.name)
+			}
+			defined := allDefined(n)
+			if leaf.optional {
+				if defined, err = hybridValues(body[repetition:repetition+definition], 1, n); err != nil {
+					return nil, errors.Wrapf(err, "fail read levels of column %v", leaf.name)
+				}
+			}
+			body = body[repetition+definition:]
+			if compressed, ok := page.bool(7); compressed || !ok {
+				size := header.int(2) - int64(repetition+definition)
+				if body, err = decompress(codec, body, size); err != nil {
+					return nil, errors.Wrapf(err, "column %v", leaf.name)
+				}
+			}
+			if values, err = appendValues(values, body, page.int(4), defined, dictionary, leaf); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return values, nil
+}
+
+func allDefined(n int) []uint64 {
+	defined := make([]uint64, n)
+	for i := range defined {
+		defined[i] = 1
+	}
+	return defined
+}
+
+// appendValues decodes values of a data page, defined has the definition level of every row of the page.
+func appendValues(values []interface{}, body []byte, encoding int64, defined []uint64, dictionary []interface{}, leaf parquetLeaf) ([]interface{}, error) {
+	count := 0
+	for _, level := range defined {
+		count += int(level)
+	}
+	var decoded []interface{}
+	switch encoding {
+	case encodingPlain:
+		var err error
+		if decoded, _, err = plainValues(body, leaf.physical, count); err != nil {
+			return nil, errors.Wrapf(err, "fail read values of column %v", leaf.name)
+		}
+	case encodingPlainDictionary, encodingRLEDictionary:
+		if len(body) == 0 {
+			return nil, errors.Errorf("short page of column %v", leaf.name)
+		}
+		indexes, err := hybridValues(body[1:], int(body[0]), count)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fail read values of column %v", leaf.name)
+		}
+		decoded = make([]interface{}, 0, count)
+		for _, index := range indexes {
+			if index >= uint64(len(dictionary)) {
+				return nil, errors.Errorf("dictionary index %v is out of range in column %v", index, leaf.name)
+			}
+			decoded = append(decoded, dictionary[index])
+		}
+	default:
+		return nil, errors.Errorf("unsupported encoding %v of column %v", encoding, leaf.name)
+	}
+	for _, level := range defined {
+		if level == 0 {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, decoded[0])
+		decoded = decoded[1:]
+	}
+	return values, nil
+}
+
+// plainValues decodes n values of the physical type and returns the rest of data.
+func plainValues(data []byte, physical int64, n int) ([]interface{}, []byte, error) {
+	size := 0
+	switch physical {
+	case parquetBoolean:
+		size = (n + 7) / 8
+	case parquetInt32, parquetFloat:
+		size = 4 * n
+	case parquetInt64, parquetDouble:
+		size = 8 * n
+	case parquetByteArray:
+	default:
+		return nil, nil, errors.Errorf("unsupported parquet type %v", physical)
+	}
+	if size > len(data) || n < 0 {
+		return nil, nil, errors.New("unexpected end of page")
+	}
+	values := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		switch physical {
+		case parquetBoolean:
+			values = append(values, data[i/8]>>(i%8)&1 == 1)
+		case parquetInt32:
+			values = append(values, int64(int32(binary.LittleEndian.Uint32(data[4*i:]))))
+		case parquetFloat:
+			values = append(values, float64(math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))))
+		case parquetInt64:
+			values = append(values, int64(binary.LittleEndian.Uint64(data[8*i:])))
+		case parquetDouble:
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:])))
+		case parquetByteArray:
+			if size+4 > len(data) {
+				return nil, nil, errors.New("unexpected end of page")
+			}
+			length := int(binary.LittleEndian.Uint32(data[size:]))
+			if length > len(data)-size-4 {
+				return nil, nil, errors.New("unexpected end of page")
+			}
+			values = append(values, data[size+4:size+4+length])
+			size += 4 + length
+		}
+	}
+	return values, data[size:], nil
+}
+
+// hybridValues decodes n values of the RLE/bit-packing hybrid encoding used by levels and dictionary indexes.
+func hybridValues(data []byte, bitWidth, n int) ([]uint64, error) {
+	if bitWidth > 64 {
+		return nil, errors.Errorf("invalid bit width %v", bitWidth)
+	}
+	values := make([]uint64, 0, n)
+	for len(values) < n {
+		header, size := binary.Uvarint(data)
+		if size <= 0 {
+			return nil, errors.New("unexpected end of page")
+		}
+		data = data[size:]
+		if header&1 == 0 {
+			width := (bitWidth + 7) / 8
+			if width > len(data) {
+				return nil, errors.New("unexpected end of page")
+			}
+			value := uint64(0)
+			for i := 0; i < width; i++ {
+				value |= uint64(data[i]) << (8 * i)
+			}
+			data = data[width:]
+			for count := header >> 1; count > 0 && len(values) < n; count-- {
+				values = append(values, value)
+			}
+			continue
+		}
+		count := int(header>>1) * 8
+		// writers may cut the padding of the last group
+		if count > n-len(values) {
+			count = n - len(values)
+		}
+		if count*bitWidth > len(data)*8 {
+			return nil, errors.New("unexpected end of page")
+		}
+		for i := 0; i < count; i++ {
+			value := uint64(0)
+			for bit := 0; bit < bitWidth; bit++ {
+				position := i*bitWidth + bit
+				value |= uint64(data[position/8]>>(position%8)&1) << bit
+			}
+			values = append(values, value)
+		}
+		if size := int(header>>1) * bitWidth; size < len(data) {
+			data = data[size:]
+		} else {
+			data = nil
+		}
+	}
+	return values, nil
+}
+
+func decompress(codec int64, data []byte, size int64) ([]byte, error) {
+	switch codec {
+	case codecUncompressed:
+		return data, nil
+	case codecSnappy:
+		return snappyDecode(data)
+	case codecGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "fail read gzip page")
+		}
+		out := bytes.NewBuffer(make([]byte, 0, size))
+		if _, err = io.Copy(out, r); err != nil {
+			return nil, errors.Wrap(err, "fail read gzip page")
+		}
+		return out.Bytes(), nil
+	}
+	return nil, errors.Errorf("unsupported compression codec %v, write the file with snappy, gzip or no compression", codec)
+}
+
+// snappyDecode decompresses a snappy block.
+func snappyDecode(data []byte) ([]byte, error) {
+	errCorrupt := errors.New("corrupt snappy page")
+	var err error
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length > uint64(len(data))*255 {
+		return nil, errCorrupt
+	}
+	data = data[n:]
+	out := make([]byte, 0, length)
+	for len(data) > 0 {
+		tag := data[0]
+		data = data[1:]
+		switch tag & 3 {
+		case 0:
+			size := int(tag >> 2)
+			if size >= 60 {
+				extra := size - 59
+				if extra > len(data) {
+					return nil, errCorrupt
+				}
+				size = 0
+				for i := 0; i < extra; i++ {
+					size |= int(data[i]) << (8 * i)
+				}
+				data = data[extra:]
+			}
+			size++
+			if size > len(data) || size <= 0 {
+				return nil, errCorrupt
+			}
+			out = append(out, data[:size]...)
+			data = data[size:]
+			continue
+		case 1:
+			if len(data) < 1 {
+				return nil, errCorrupt
+			}
+			size := 4 + int(tag>>2&7)
+			offset := int(tag>>5)<<8 | int(data[0])
+			data = data[1:]
+			if out, err = snappyCopy(out, offset, size); err != nil {
+				return nil, err
+			}
+		case 2:
+			if len(data) < 2 {
+				return nil, errCorrupt
+			}
+			offset := int(binary.LittleEndian.Uint16(data))
+			data = data[2:]
+			if out, err = snappyCopy(out, offset, int(tag>>2)+1); err != nil {
+				return nil, err
+			}
+		case 3:
+			if len(data) < 4 {
+				return nil, errCorrupt
+			}
+			offset := int(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+			if out, err = snappyCopy(out, offset, int(tag>>2)+1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if uint64(len(out)) != length {
+		return nil, errCorrupt
+	}
+	return out, nil
+}
+
+func snappyCopy(out []byte, offset, size int) ([]byte, error) {
+	if offset <= 0 || offset > len(out) {
+		return nil, errors.New("corrupt snappy page")
+	}
+	// the copy may overlap the bytes it writes
+	for i := 0; i < size; i++ {
+		out = append(out, out[len(out)-offset])
+	}
+	return out, nil
+}
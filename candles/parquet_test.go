@@ -0,0 +1,141 @@
+package candles
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/calendar"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func testCandle(t time.Time, open, high, low, closePrice string, volume int64, complete bool) *investapi.HistoricCandle {
+	return &investapi.HistoricCandle{
+		Time:       timestamppb.New(t),
+		Open:       money.MustParse(open).Quotation(),
+		High:       money.MustParse(high).Quotation(),
+		Low:        money.MustParse(low).Quotation(),
+		Close:      money.MustParse(closePrice).Quotation(),
+		Volume:     volume,
+		IsComplete: complete,
+	}
+}
+
+func equalCandles(t *testing.T, got, want []*investapi.HistoricCandle) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%v candles, want %v", len(got), len(want))
+	}
+	for i := range want {
+		if !proto.Equal(got[i], want[i]) {
+			t.Fatalf("candle %v is %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	start := time.Date(2024, 3, 12, 7, 0, 0, 123000, time.UTC)
+	prices := []string{"250.5", "0.005", "100", "7.123456", "99999.99"}
+	candles := []*investapi.HistoricCandle{}
+	// more than a byte of is_complete bits and not a multiple of eight
+	for i := 0; i < 19; i++ {
+		price := prices[i%len(prices)]
+		candles = append(candles, testCandle(start.Add(time.Duration(i)*time.Minute), price, price, price, price, int64(i)*1000, i%3 != 1))
+	}
+
+	for _, test := range []struct {
+		name    string
+		candles []*investapi.HistoricCandle
+	}{
+		{name: "candles", candles: candles},
+		{name: "one", candles: candles[:1]},
+		{name: "empty", candles: []*investapi.HistoricCandle{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			if err := WriteParquet(&buf, test.candles); err != nil {
+				t.Fatal(err)
+			}
+			read, err := ReadParquet(&buf, calendar.Moscow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			equalCandles(t, read, test.candles)
+		})
+	}
+}
+
+// fixtureCandles are the candles gen_parquet.go writes, complete says which ones are complete.
+func fixtureCandles(complete func(i int) bool) []*investapi.HistoricCandle {
+	start := time.Date(2024, 3, 12, 7, 0, 0, 0, time.UTC)
+	candles := []*investapi.HistoricCandle{}
+	for i := 0; i < 43; i++ {
+		p := money.FromInt(100).Add(money.New(0, 10000000).MulInt(int64(i % 10)))
+		candles = append(candles, &investapi.HistoricCandle{
+			Time:       timestamppb.New(start.Add(time.Duration(i) * time.Minute)),
+			Open:       p.Quotation(),
+			High:       p.Add(money.MustParse("0.05")).Quotation(),
+			Low:        p.Sub(money.MustParse("0.05")).Quotation(),
+			Close:      p.Add(money.MustParse("0.01")).Quotation(),
+			Volume:     int64(i * 10),
+			IsComplete: complete(i),
+		})
+	}
+	return candles
+}
+
+func TestReadParquetFixtures(t *testing.T) {
+	for _, test := range []struct {
+		file    string
+		candles []*investapi.HistoricCandle
+	}{
+		{
+			// TIMESTAMP(NANOS, UTC), dictionary pages, several snappy v1 pages, nulls in is_complete
+			file:    "utc_nanos_snappy_v1.parquet",
+			candles: fixtureCandles(func(i int) bool { return i%7 == 0 || i != 41 }),
+		},
+		{
+			// TIMESTAMP(MILLIS) of Moscow wall clock, gzip v2 pages, bit-packed required is_complete
+			file:    "local_millis_gzip_v2.parquet",
+			candles: fixtureCandles(func(i int) bool { return i%3 != 0 }),
+		},
+	} {
+		t.Run(test.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			read, err := ReadParquet(bytes.NewReader(data), calendar.Moscow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			equalCandles(t, read, test.candles)
+		})
+	}
+}
+
+func TestReadParquetErrors(t *testing.T) {
+	buf := bytes.Buffer{}
+	if err := WriteParquet(&buf, fixtureCandles(func(i int) bool { return true })); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "not parquet", data: []byte("time,open,high,low,close,volume\n")},
+		{name: "truncated", data: data[:len(data)/2]},
+		{name: "footer too long", data: append(append(append([]byte{}, data[:len(data)-8]...), 0xff, 0xff, 0xff, 0x0f), parquetMagic...)},
+	} {
+		if _, err := ReadParquet(bytes.NewReader(test.data), calendar.Moscow); err == nil {
+			t.Errorf("%v: no error", test.name)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package candles
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/atomicfile"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Store keeps complete candles in dir/FIGI/INTERVAL/YYYY-MM.jsonl, a candle per line, and the periods
+// that were downloaded in coverage.json next to them. A period is requested from the API once,
+// the candles of a period without trades are just absent.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+type coverageFile struct {
+	Ranges []Range `json:"ranges"`
+}
+
+func OpenStore(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, errors.New("candles dir is empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "fail create candles dir")
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) path(figi string, interval investapi.CandleInterval, name string) string {
+	return filepath.Join(s.dir, figi, intervalName(interval), name)
+}
+
+// Candles returns stored candles of [from, to) sorted by time.
+func (s *Store) Candles(figi string, interval investapi.CandleInterval, from, to time.Time) ([]*investapi.HistoricCandle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	candles := []*investapi.HistoricCandle{}
+	for _, month := range months(from, to) {
+		monthCandles, err := s.readMonth(figi, interval, month)
+		if err != nil {
+			return nil, err
+		}
+		for _, candle := range monthCandles {
+			candleTime := candle.GetTime().AsTime()
+			if !candleTime.Before(from) && candleTime.Before(to) {
+				candles = append(candles, candle)
+			}
+		}
+	}
+	return candles, nil
+}
+
+// Save merges candles of the downloaded period into the store, a stored candle of the same time is replaced.
+// The period is marked downloaded after its candles are written, a crash in between only repeats the download.
+func (s *Store) Save(figi string, interval investapi.CandleInterval, period Range, candles []*investapi.HistoricCandle) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byMonth := make(map[time.Time][]*investapi.HistoricCandle)
+	for _, candle := range candles {
+		month := monthOf(candle.GetTime().AsTime())
+		byMonth[month] = append(byMonth[month], candle)
+	}
+	for month, monthCandles := range byMonth {
+		stored, err := s.readMonth(figi, interval, month)
+		if err != nil {
+			return err
+		}
+		byTime := make(map[time.Time]*investapi.HistoricCandle, len(stored)+len(monthCandles))
+		for _, candle := range append(stored, monthCandles...) {
+			byTime[candle.GetTime().AsTime()] = candle
+		}
+		if err = s.writeMonth(figi, interval, month, sorted(byTime)); err != nil {
+			return err
+		}
+	}
+
+	coverage, err := s.readCoverage(figi, interval)
+	if err != nil {
+		return err
+	}
+	coverage.Ranges = merge(append(coverage.Ranges, period))
+	data, err := json.Marshal(coverage)
+	if err != nil {
+		return errors.Wrap(err, "fail marshal candles coverage")
+	}
+	return atomicfile.WriteFile(s.path(figi, interval, "coverage.json"), data)
+}
+
+// Import saves candles read from a file, the period from the first candle to the end of the last one is
+// marked downloaded, so backtests of the period don't request the API. Returns the number of saved candles.
+func (s *Store) Import(figi string, interval investapi.CandleInterval, candles []*investapi.HistoricCandle) (int, error) {
+	length, err := Duration(interval)
+	if err != nil {
+		return 0, err
+	}
+	if len(candles) == 0 {
+		return 0, nil
+	}
+	period := Range{
+		From: candles[0].GetTime().AsTime(),
+		To:   candles[len(candles)-1].GetTime().AsTime().Add(length),
+	}
+	complete, covered := completeOf(candles, period)
+	return len(complete), s.Save(figi, interval, covered, complete)
+}
+
+// completeOf returns the complete candles and the part of period before the first incomplete one.
+func completeOf(candles []*investapi.HistoricCandle, period Range) ([]*investapi.HistoricCandle, Range) {
+	complete := make([]*investapi.HistoricCandle, 0, len(candles))
+	for _, candle := range candles {
+		if !candle.IsComplete {
+			if candleTime := candle.GetTime().AsTime(); candleTime.Before(period.To) {
+				period.To = candleTime
+			}
+			continue
+		}
+		complete = append(complete, candle)
+	}
+	return complete, period
+}
+
+// Missing returns the parts of [from, to) that weren't downloaded.
+func (s *Store) Missing(figi string, interval investapi.CandleInterval, from, to time.Time) ([]Range, error) {
+	s.mu.Lock()
+	coverage, err := s.readCoverage(figi, interval)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	missing := []Range{}
+	cursor := from
+	for _, covered := range coverage.Ranges {
+		if !covered.To.After(cursor) {
+			continue
+		}
+		if !covered.From.Before(to) {
+			break
+		}
+		if covered.From.After(cursor) {
+			missing = append(missing, Range{From: cursor, To: covered.From})
+		}
+		cursor = covered.To
+	}
+	if cursor.Before(to) {
+		missing = append(missing, Range{From: cursor, To: to})
+	}
+	return missing, nil
+}
+
+func (s *Store) readCoverage(figi string, interval investapi.CandleInterval) (*coverageFile, error) {
+	coverage := &coverageFile{}
+	data, err := os.ReadFile(s.path(figi, interval, "coverage.json"))
+	if os.IsNotExist(err) {
+		return coverage, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "fail read candles coverage")
+	}
+	if err = json.Unmarshal(data, coverage); err != nil {
+		return nil, errors.Wrap(err, "fail parse candles coverage")
+	}
+	return coverage, nil
+}
+
+func (s *Store) readMonth(figi string, interval investapi.CandleInterval, month time.Time) ([]*investapi.HistoricCandle, error) {
+	path := s.path(figi, interval, month.Format("2006-01")+".jsonl")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "fail read candles")
+	}
+	candles := []*investapi.HistoricCandle{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		candle := &investapi.HistoricCandle{}
+		if err = protojson.Unmarshal(scanner.Bytes(), candle); err != nil {
+			return nil, errors.Wrapf(err, "fail parse candle at %v:%v", path, line)
+		}
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
+
+func (s *Store) writeMonth(figi string, interval investapi.CandleInterval, month time.Time, candles []*investapi.HistoricCandle) error {
+	buf := bytes.Buffer{}
+	for _, candle := range candles {
+		data, err := protojson.Marshal(candle)
+		if err != nil {
+			return errors.Wrap(err, "fail marshal candle")
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return atomicfile.WriteFile(s.path(figi, interval, month.Format("2006-01")+".jsonl"), buf.Bytes())
+}
+
+// merge sorts ranges and joins the overlapping and adjacent ones.
+func merge(ranges []Range) []Range {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].From.Before(ranges[j].From) })
+	merged := []Range{}
+	for _, r := range ranges {
+		if !r.From.Before(r.To) {
+			continue
+		}
+		last := len(merged) - 1
+		if last >= 0 && !r.From.After(merged[last].To) {
+			if r.To.After(merged[last].To) {
+				merged[last].To = r.To
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func monthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// months returns the first days of months overlapping [from, to).
+func months(from, to time.Time) []time.Time {
+	result := []time.Time{}
+	for month := monthOf(from); month.Before(to); month = month.AddDate(0, 1, 0) {
+		result = append(result, month)
+	}
+	return result
+}
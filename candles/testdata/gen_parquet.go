@@ -0,0 +1,91 @@
+//go:build ignore
+
+// gen_parquet writes the parquet fixtures with github.com/parquet-go/parquet-go, a writer independent
+// of ours. Run it from a module that requires parquet-go: go run gen_parquet.go DIR
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const rows = 43
+
+var start = time.Date(2024, 3, 12, 7, 0, 0, 0, time.UTC)
+
+func price(i int) float64 { return 100 + float64(i%10)*0.01 }
+
+// utcRow has a TIMESTAMP(NANOS, UTC) logical type and an optional is_complete with nulls.
+type utcRow struct {
+	Ticker     string    `parquet:"ticker,dict"`
+	Time       time.Time `parquet:"time,timestamp(nanosecond)"`
+	Open       float64   `parquet:"open,dict"`
+	High       float64   `parquet:"high"`
+	Low        float64   `parquet:"low"`
+	Close      float64   `parquet:"close,dict"`
+	Volume     int64     `parquet:"volume"`
+	IsComplete *bool     `parquet:"is_complete,optional"`
+}
+
+// localRow is written with a schema of TIMESTAMP(MILLIS) not adjusted to UTC and a required is_complete.
+type localRow struct {
+	Time       int64   `parquet:"time"`
+	Open       float64 `parquet:"open"`
+	High       float64 `parquet:"high"`
+	Low        float64 `parquet:"low"`
+	Close      float64 `parquet:"close"`
+	Volume     int64   `parquet:"volume"`
+	IsComplete bool    `parquet:"is_complete"`
+}
+
+func main() {
+	dir := os.Args[1]
+	moscow := time.FixedZone("MSK", 3*60*60)
+
+	utc := []utcRow{}
+	local := []localRow{}
+	for i := 0; i < rows; i++ {
+		t := start.Add(time.Duration(i) * time.Minute)
+		p := price(i)
+		var complete *bool
+		if i%7 != 0 {
+			v := i != rows-2
+			complete = &v
+		}
+		utc = append(utc, utcRow{"SBER", t, p, p + 0.05, p - 0.05, p + 0.01, int64(i * 10), complete})
+		wall := t.In(moscow)
+		wallMillis := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), 0, 0, time.UTC).UnixMilli()
+		local = append(local, localRow{wallMillis, p, p + 0.05, p - 0.05, p + 0.01, int64(i * 10), i%3 != 0})
+	}
+
+	err := parquet.WriteFile(filepath.Join(dir, "utc_nanos_snappy_v1.parquet"), utc,
+		parquet.Compression(&parquet.Snappy), parquet.DataPageVersion(1), parquet.PageBufferSize(256))
+	if err != nil {
+		panic(err)
+	}
+
+	schema := parquet.NewSchema("candles", parquet.Group{
+		"time":        parquet.TimestampAdjusted(parquet.Millisecond, false),
+		"open":        parquet.Leaf(parquet.DoubleType),
+		"high":        parquet.Leaf(parquet.DoubleType),
+		"low":         parquet.Leaf(parquet.DoubleType),
+		"close":       parquet.Leaf(parquet.DoubleType),
+		"volume":      parquet.Int(64),
+		"is_complete": parquet.Leaf(parquet.BooleanType),
+	})
+	f, err := os.Create(filepath.Join(dir, "local_millis_gzip_v2.parquet"))
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	w := parquet.NewGenericWriter[localRow](f, schema, parquet.Compression(&parquet.Gzip), parquet.DataPageVersion(2))
+	if _, err = w.Write(local); err != nil {
+		panic(err)
+	}
+	if err = w.Close(); err != nil {
+		panic(err)
+	}
+}
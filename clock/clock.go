@@ -0,0 +1,268 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells time to code that runs both live and in backtests, where time is simulated.
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Until(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the wall clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) Until(t time.Time) time.Duration        { return time.Until(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ timer *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.timer.C }
+func (t realTimer) Stop() bool          { return t.timer.Stop() }
+
+type realTicker struct{ ticker *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.ticker.C }
+func (t realTicker) Stop()               { t.ticker.Stop() }
+
+// Scheduler is a clock that knows whether the goroutines using it run or wait for it. A goroutine
+// started by Go is counted running until it parks, that is blocks until a timer or a ticker of the clock
+// fires or another goroutine wakes it up. A fire counts the goroutine running again by itself, everything
+// else has to call Wake before it wakes a parked goroutine up.
+type Scheduler interface {
+	Clock
+	// Hold counts a goroutine running or a wake-up sent to a parked one
+	Hold()
+	// Release counts a goroutine parked
+	Release()
+}
+
+// Go runs f in a new goroutine, a Scheduler counts it running until f returns.
+func Go(c Clock, f func()) {
+	s, ok := c.(Scheduler)
+	if !ok {
+		go f()
+		return
+	}
+	s.Hold()
+	go func() {
+		defer s.Release()
+		f()
+	}()
+}
+
+// Park is called by a goroutine right before it blocks waiting for the clock or a wake-up.
+func Park(c Clock) {
+	if s, ok := c.(Scheduler); ok {
+		s.Release()
+	}
+}
+
+// Wake is called before a parked goroutine is woken up by anything but the clock, e.g. by closing
+// a channel it waits on. A goroutine that stops waiting on its own, e.g. on a cancelled context, calls it too.
+func Wake(c Clock) {
+	if s, ok := c.(Scheduler); ok {
+		s.Hold()
+	}
+}
+
+// Simulated is a clock moved by Set. Timers and tickers fire when the time passes their deadline,
+// a ticker fires once per Set however many periods have passed, like a real ticker with a slow reader.
+//
+// Simulated is a Scheduler: goroutines started by Go are busy until they park on the clock, Idle
+// tells when all of them are parked, so the time is moved only after they handled the previous move.
+type Simulated struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+	// busy counts running goroutines of Go and wake-ups sent to parked ones
+	busy int
+	// idle is closed while busy is zero
+	idle chan struct{}
+}
+
+type waiter struct {
+	clock    *Simulated
+	deadline time.Time
+	period   time.Duration
+	c        chan time.Time
+}
+
+func NewSimulated(now time.Time) *Simulated {
+	idle := make(chan struct{})
+	close(idle)
+	return &Simulated{now: now, idle: idle}
+}
+
+func (s *Simulated) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now
+}
+
+func (s *Simulated) Since(t time.Time) time.Duration { return s.Now().Sub(t) }
+func (s *Simulated) Until(t time.Time) time.Duration { return t.Sub(s.Now()) }
+
+func (s *Simulated) After(d time.Duration) <-chan time.Time {
+	return s.NewTimer(d).C()
+}
+
+func (s *Simulated) NewTimer(d time.Duration) Timer {
+	return simulatedTimer{s.add(d, 0)}
+}
+
+func (s *Simulated) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return simulatedTicker{s.add(d, d)}
+}
+
+// Set moves the time forward to t and fires waiters whose deadline has come, earlier t is ignored.
+func (s *Simulated) Set(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.Before(s.now) {
+		return
+	}
+	s.now = t
+	left := s.waiters[:0]
+	for _, w := range s.waiters {
+		if w.deadline.After(t) {
+			left = append(left, w)
+			continue
+		}
+		// a fire the goroutine hasn't received yet is the wake-up it gets, the new one is dropped
+		select {
+		case w.c <- t:
+			s.holdLocked()
+		default:
+		}
+		if w.period > 0 {
+			for !w.deadline.After(t) {
+				w.deadline = w.deadline.Add(w.period)
+			}
+			left = append(left, w)
+		}
+	}
+	s.waiters = left
+	s.sort()
+}
+
+// Next returns the earliest deadline of the waiters.
+func (s *Simulated) Next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.waiters) == 0 {
+		return time.Time{}, false
+	}
+	return s.waiters[0].deadline, true
+}
+
+// Hold counts a goroutine running, see Scheduler.
+func (s *Simulated) Hold() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holdLocked()
+}
+
+// Release counts a goroutine parked, see Scheduler.
+func (s *Simulated) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked()
+}
+
+// Idle is closed when every goroutine started by Go is parked and no wake-up is sent to them.
+// Nothing happens then until the clock is moved, except what the caller does itself.
+func (s *Simulated) Idle() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.idle
+}
+
+// holdLocked is Hold with s.mu held.
+func (s *Simulated) holdLocked() {
+	if s.busy == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.busy++
+}
+
+// releaseLocked is Release with s.mu held.
+func (s *Simulated) releaseLocked() {
+	s.busy--
+	if s.busy == 0 {
+		close(s.idle)
+	}
+}
+
+func (s *Simulated) add(d, period time.Duration) *waiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := &waiter{clock: s, deadline: s.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	if d <= 0 && period == 0 {
+		w.c <- s.now
+		s.holdLocked()
+		return w
+	}
+	s.waiters = append(s.waiters, w)
+	s.sort()
+	return w
+}
+
+// remove stops the waiter and drops its fire that wasn't received, nobody is going to wake up by it.
+func (s *Simulated) remove(w *waiter) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-w.c:
+		s.releaseLocked()
+	default:
+	}
+	for i, other := range s.waiters {
+		if other == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// sort keeps waiters by deadline. s.mu must be held.
+func (s *Simulated) sort() {
+	sort.SliceStable(s.waiters, func(i, j int) bool { return s.waiters[i].deadline.Before(s.waiters[j].deadline) })
+}
+
+type simulatedTimer struct{ w *waiter }
+
+func (t simulatedTimer) C() <-chan time.Time { return t.w.c }
+func (t simulatedTimer) Stop() bool          { return t.w.clock.remove(t.w) }
+
+type simulatedTicker struct{ w *waiter }
+
+func (t simulatedTicker) C() <-chan time.Time { return t.w.c }
+func (t simulatedTicker) Stop()               { t.w.clock.remove(t.w) }
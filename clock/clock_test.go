@@ -0,0 +1,186 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2024, 3, 12, 7, 0, 0, 0, time.UTC)
+
+func fired(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func idle(s *Simulated) bool {
+	select {
+	case <-s.Idle():
+		return true
+	default:
+		return false
+	}
+}
+
+// waitIdle waits until goroutines of Go park, they run on their own between the moves of the clock.
+func waitIdle(t *testing.T, s *Simulated) {
+	t.Helper()
+	select {
+	case <-s.Idle():
+	case <-time.After(5 * time.Second):
+		t.Fatal("goroutines aren't parked")
+	}
+}
+
+func TestTimer(t *testing.T) {
+	s := NewSimulated(t0)
+	timer := s.NewTimer(10 * time.Second)
+
+	s.Set(t0.Add(9 * time.Second))
+	if _, ok := fired(timer.C()); ok {
+		t.Fatal("timer fired before its deadline")
+	}
+	s.Set(t0)
+	if !s.Now().Equal(t0.Add(9 * time.Second)) {
+		t.Fatalf("time moved back to %v", s.Now())
+	}
+	s.Set(t0.Add(15 * time.Second))
+	if at, ok := fired(timer.C()); !ok || !at.Equal(t0.Add(15*time.Second)) {
+		t.Fatalf("timer fired %v at %v, want at the time it was set to", ok, at)
+	}
+	if timer.Stop() {
+		t.Fatal("fired timer is stopped")
+	}
+	if _, ok := s.Next(); ok {
+		t.Fatal("fired timer is still waiting")
+	}
+}
+
+func TestTimerOfZeroFiresAtOnce(t *testing.T) {
+	s := NewSimulated(t0)
+	if at, ok := fired(s.After(0)); !ok || !at.Equal(t0) {
+		t.Fatalf("timer of zero fired %v at %v", ok, at)
+	}
+}
+
+func TestTickerFiresOncePerSet(t *testing.T) {
+	s := NewSimulated(t0)
+	ticker := s.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	s.Set(t0.Add(5*time.Second + 500*time.Millisecond))
+	if _, ok := fired(ticker.C()); !ok {
+		t.Fatal("ticker didn't fire")
+	}
+	if _, ok := fired(ticker.C()); ok {
+		t.Fatal("ticker fired for every period passed")
+	}
+	if next, _ := s.Next(); !next.Equal(t0.Add(6 * time.Second)) {
+		t.Fatalf("next tick at %v, want the next period", next)
+	}
+}
+
+func TestNextIsEarliestDeadline(t *testing.T) {
+	s := NewSimulated(t0)
+	if _, ok := s.Next(); ok {
+		t.Fatal("deadline without waiters")
+	}
+	late := s.NewTimer(time.Minute)
+	s.NewTimer(time.Second)
+	s.NewTicker(10 * time.Second)
+	if next, _ := s.Next(); !next.Equal(t0.Add(time.Second)) {
+		t.Fatalf("next deadline %v, want %v", next, t0.Add(time.Second))
+	}
+	s.Set(t0.Add(30 * time.Second))
+	late.Stop()
+	if next, _ := s.Next(); !next.Equal(t0.Add(40 * time.Second)) {
+		t.Fatalf("next deadline %v, want the ticker at %v", next, t0.Add(40*time.Second))
+	}
+}
+
+func TestIdleWhileParked(t *testing.T) {
+	s := NewSimulated(t0)
+	if !idle(s) {
+		t.Fatal("clock without goroutines isn't idle")
+	}
+	done := make(chan struct{})
+	Go(s, func() {
+		timer := s.NewTimer(time.Second)
+		Park(s)
+		<-timer.C()
+		close(done)
+	})
+	waitIdle(t, s)
+	select {
+	case <-done:
+		t.Fatal("goroutine didn't wait for the timer")
+	default:
+	}
+
+	s.Set(t0.Add(time.Second))
+	waitIdle(t, s)
+	select {
+	case <-done:
+	default:
+		t.Fatal("goroutine isn't done when the clock is idle")
+	}
+}
+
+func TestWakeHoldsUntilParked(t *testing.T) {
+	s := NewSimulated(t0)
+	wake := make(chan struct{})
+	parked := make(chan struct{})
+	Go(s, func() {
+		Park(s)
+		close(parked)
+		<-wake
+		// the goroutine runs now, it parks again on the clock
+		timer := s.NewTimer(time.Second)
+		Park(s)
+		<-timer.C()
+	})
+	<-parked
+	waitIdle(t, s)
+
+	Wake(s)
+	if idle(s) {
+		t.Fatal("clock is idle with a wake-up sent")
+	}
+	close(wake)
+	waitIdle(t, s)
+	if next, ok := s.Next(); !ok || !next.Equal(t0.Add(time.Second)) {
+		t.Fatalf("next deadline %v, want the timer of the woken goroutine", next)
+	}
+	s.Set(t0.Add(time.Second))
+	waitIdle(t, s)
+}
+
+func TestStopDropsUnreceivedFire(t *testing.T) {
+	s := NewSimulated(t0)
+	timer := s.NewTimer(time.Second)
+	s.Set(t0.Add(time.Second))
+	if idle(s) {
+		t.Fatal("clock is idle with a fire nobody received")
+	}
+	timer.Stop()
+	if !idle(s) {
+		t.Fatal("stopped timer keeps the clock busy")
+	}
+}
+
+func TestRealClockRunsGo(t *testing.T) {
+	done := make(chan struct{})
+	Go(Real, func() {
+		Park(Real)
+		Wake(Real)
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("goroutine of the real clock doesn't run")
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/backtest"
+	"github.com/nax11/tinkoff_bot_public/calendar"
+	"github.com/nax11/tinkoff_bot_public/candles"
+	"github.com/nax11/tinkoff_bot_public/config"
+	"github.com/nax11/tinkoff_bot_public/money"
+	"github.com/nax11/tinkoff_bot_public/profile"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/nax11/tinkoff_bot_public/registry"
+	"github.com/nax11/tinkoff_bot_public/report"
+	"github.com/pkg/errors"
+)
+
+const (
+	accountsUsage   = "usage: accounts list | open [AMOUNT] | close ID | fund ID AMOUNT"
+	portfolioUsage  = "usage: portfolio [ACCOUNT_ID]"
+	operationsUsage = "usage: operations [ACCOUNT_ID]"
+	reportUsage     = "usage: report broker|dividends FROM TO [ACCOUNT_ID], dates are YYYY-MM-DD"
+	backtestUsage   = "usage: backtest FROM TO, dates are YYYY-MM-DD"
+	candlesUsage    = "usage: candles FROM TO [INSTRUMENT...] | export FILE INSTRUMENT FROM TO [INTERVAL] | import FILE INSTRUMENT [INTERVAL], " +
+		"dates are YYYY-MM-DD, FILE is .csv or .parquet, instruments of strategies by default"
+)
+
+// runCommand executes a command given after flags instead of running strategies.
+func runCommand(ctx context.Context, cfg *config.Config, client *api.Client, clientProfile profile.Provider) error {
+	switch cfg.Args[0] {
+	case "accounts":
+		return runAccounts(ctx, cfg, clientProfile, cfg.Args[1:])
+	case "portfolio":
+		return runPortfolio(ctx, cfg, clientProfile, cfg.Args[1:])
+	case "operations":
+		return runOperations(ctx, cfg, client, clientProfile, cfg.Args[1:])
+	case "report":
+		return runReport(ctx, cfg, client, clientProfile, cfg.Args[1:])
+	case "backtest":
+		return runBacktest(ctx, cfg, client, cfg.Args[1:])
+	case "candles":
+		return runCandles(ctx, cfg, client, cfg.Args[1:])
+	}
+	return errors.Errorf("unknown command %q, available: accounts, portfolio, operations, report, backtest, candles", cfg.Args[0])
+}
+
+func runAccounts(ctx context.Context, cfg *config.Config, clientProfile profile.Provider, args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	amount := func(idx int) (money.Money, error) {
+		if len(args) <= idx {
+			return cfg.Accounts.Deposit(), nil
+		}
+		value, err := money.Parse(args[idx])
+		if err != nil {
+			return money.Money{}, errors.Wrap(err, "invalid amount")
+		}
+		return money.NewMoney(value, cfg.Accounts.Currency), nil
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		accounts, err := clientProfile.ListAccounts(ctx)
+		if err != nil {
+			return err
+		}
+		printAccounts(accounts)
+		return nil
+	case args[0] == "open" && len(args) <= 2:
+		deposit, err := amount(1)
+		if err != nil {
+			return err
+		}
+		accountID, err := clientProfile.OpenAccount(ctx, deposit)
+		if err != nil {
+			return err
+		}
+		fmt.Println(accountID)
+		return nil
+	case args[0] == "close" && len(args) == 2:
+		return clientProfile.CloseAccount(ctx, args[1])
+	case args[0] == "fund" && len(args) == 3:
+		target, err := amount(2)
+		if err != nil {
+			return err
+		}
+		balance, err := clientProfile.FundAccount(ctx, args[1], target)
+		if err != nil {
+			return err
+		}
+		fmt.Println(balance)
+		return nil
+	}
+	return errors.New(accountsUsage)
+}
+
+func printAccounts(accounts []profile.Account) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATUS\tBALANCE")
+	for _, account := range accounts {
+		status := strings.ToLower(strings.TrimPrefix(account.Status.String(), "ACCOUNT_STATUS_"))
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", account.ID, account.Name, status, account.Balance.String())
+	}
+	w.Flush()
+}
+
+// runPortfolio prints the portfolio of the account, it may be omitted when there is one.
+func runPortfolio(ctx context.Context, cfg *config.Config, clientProfile profile.Provider, args []string) error {
+	if len(args) > 1 {
+		return errors.New(portfolioUsage)
+	}
+	accountID, err := commandAccount(ctx, cfg, clientProfile, args)
+	if err != nil {
+		return err
+	}
+	portfolio, err := clientProfile.Portfolios().Get(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	printPortfolio(portfolio)
+	return nil
+}
+
+// runOperations syncs the operations journal of the account and prints its deals.
+func runOperations(ctx context.Context, cfg *config.Config, client *api.Client, clientProfile profile.Provider, args []string) error {
+	if len(args) > 1 {
+		return errors.New(operationsUsage)
+	}
+	accountID, err := commandAccount(ctx, cfg, clientProfile, args)
+	if err != nil {
+		return err
+	}
+	history := profile.NewHistory(client, cfg.Journal.Dir)
+	if _, err = history.Sync(ctx, accountID); err != nil {
+		return err
+	}
+	deals, err := history.Deals(accountID, api.OperationsFilter{})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tFIGI\tDIRECTION\tQUANTITY\tPRICE\tAMOUNT\tCOMMISSION\tCURRENCY\tTRADES\tORDER")
+	for _, deal := range deals {
+		direction := strings.ToLower(strings.TrimPrefix(deal.Direction.String(), "ORDER_DIRECTION_"))
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", deal.Time.Local().Format("2006-01-02 15:04:05"),
+			deal.Figi, direction, deal.Quantity, deal.AveragePrice, deal.Amount, deal.Commission, deal.Currency,
+			len(deal.Trades), deal.OrderID)
+	}
+	return w.Flush()
+}
+
+// runReport generates a broker report of the period and prints it as CSV, TO is included.
+func runReport(ctx context.Context, cfg *config.Config, client *api.Client, clientProfile profile.Provider, args []string) error {
+	if len(args) < 3 || len(args) > 4 {
+		return errors.New(reportUsage)
+	}
+	from, to, err := parsePeriod(args[1], args[2])
+	if err != nil {
+		return err
+	}
+	accountID, err := commandAccount(ctx, cfg, clientProfile, args[3:])
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "broker":
+		rows, err := client.GetBrokerReport(ctx, accountID, from, to, api.DefaultReportPollInterval)
+		if err != nil {
+			return err
+		}
+		return report.WriteBrokerCSV(os.Stdout, rows)
+	case "dividends":
+		rows, err := client.GetDividendsForeignIssuerReport(ctx, accountID, from, to, api.DefaultReportPollInterval)
+		if err != nil {
+			return err
+		}
+		return report.WriteDividendsCSV(os.Stdout, rows)
+	}
+	return errors.New(reportUsage)
+}
+
+// runBacktest replays every configured strategy on candles of its instrument from FROM until TO included.
+func runBacktest(ctx context.Context, cfg *config.Config, client *api.Client, args []string) error {
+	if len(args) != 2 {
+		return errors.New(backtestUsage)
+	}
+	from, to, err := parsePeriod(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	if err = cfg.Validate(strategyNames()); err != nil {
+		return err
+	}
+	store, err := candles.OpenStore(cfg.Candles.Dir)
+	if err != nil {
+		return err
+	}
+	instruments := registry.Instance(client, cfg.Instruments.CachePath, cfg.Instruments.CacheTTL)
+	source := backtest.StoreSource(instruments, candles.NewDownloader(client, store))
+	for _, strategyCfg := range cfg.Strategies {
+		instrument, err := instruments.Resolve(ctx, strategyCfg.Instrument)
+		if err != nil {
+			return err
+		}
+		backtestCfg, err := newBacktestConfig(cfg, strategyCfg, instrument)
+		if err != nil {
+			return err
+		}
+		backtestCfg.From, backtestCfg.To = from, to
+		result, err := backtest.Run(ctx, source, AvailableStartegy[strategyCfg.Name], backtestCfg)
+		if err != nil {
+			return errors.Wrapf(err, "fail backtest %v on %v", strategyCfg.Name, strategyCfg.Instrument)
+		}
+		fmt.Printf("%v on %v\n", strategyCfg.Name, strategyCfg.Instrument)
+		printBacktest(result)
+	}
+	return nil
+}
+
+// newBacktestConfig sets up the backtest of the strategy on the instrument without the period.
+func newBacktestConfig(cfg *config.Config, strategyCfg config.StrategyConfig, instrument *registry.Instrument) (backtest.Config, error) {
+	if cfg.Backtest.Deposit.Sign() <= 0 {
+		return backtest.Config{}, errors.New("backtest.deposit should be positive")
+	}
+	tariff, ok := backtest.Tariffs[cfg.Backtest.Tariff]
+	if !ok {
+		return backtest.Config{}, errors.Errorf("unknown backtest.tariff %q, expected investor, trader or premium", cfg.Backtest.Tariff)
+	}
+	if cfg.Backtest.ThroughTicks < 0 || cfg.Backtest.SlippageTicks < 0 {
+		return backtest.Config{}, errors.New("backtest.through_ticks and backtest.slippage_ticks should not be negative")
+	}
+	if cfg.Backtest.Participation.Sign() < 0 || cfg.Backtest.Participation.GreaterThan(money.FromInt(1)) {
+		return backtest.Config{}, errors.New("backtest.participation should be from 0 to 1")
+	}
+	return backtest.Config{
+		Figis:    []string{instrument.Figi},
+		Interval: investapi.CandleInterval(strategyCfg.Interval),
+		Deposit:  money.NewMoney(cfg.Backtest.Deposit, cfg.Accounts.Currency),
+		Model: backtest.Conservative{
+			ThroughTicks:  cfg.Backtest.ThroughTicks,
+			SlippageTicks: cfg.Backtest.SlippageTicks,
+			Participation: cfg.Backtest.Participation,
+		},
+		Tariff: tariff,
+		Params: strategyCfg.TradeParams(instrument),
+	}, nil
+}
+
+// simulateDay backtests the strategy on the previous trading day of the instrument with SimulateLotQty
+// lots per deal, it's what simulate_day_trade runs instead of trading. The candles of the day are returned
+// for the chart of the fills.
+func simulateDay(ctx context.Context, cfg *config.Config, client *api.Client, strategyCfg config.StrategyConfig, instrument *registry.Instrument) (*backtest.Result, []*investapi.HistoricCandle, error) {
+	backtestCfg, err := newBacktestConfig(cfg, strategyCfg, instrument)
+	if err != nil {
+		return nil, nil, err
+	}
+	day, err := calendar.Instance(client).PreviousTradingDay(ctx, instrument.Exchange, client.Clock.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	backtestCfg.From, backtestCfg.To = day.Date, day.Date.AddDate(0, 0, 1)
+	backtestCfg.Params.OperationLots = strategyCfg.SimulateLotQty
+
+	store, err := candles.OpenStore(cfg.Candles.Dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	instruments := registry.Instance(client, cfg.Instruments.CachePath, cfg.Instruments.CacheTTL)
+	source := backtest.StoreSource(instruments, candles.NewDownloader(client, store))
+	result, err := backtest.Run(ctx, source, AvailableStartegy[strategyCfg.Name], backtestCfg)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "fail simulate %v on %v", strategyCfg.Name, strategyCfg.Instrument)
+	}
+	dayCandles, err := source.Candles(ctx, instrument.Figi, backtestCfg.Interval, backtestCfg.From, backtestCfg.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, dayCandles, nil
+}
+
+func printBacktest(result *backtest.Result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tFIGI\tDIRECTION\tQUANTITY\tPRICE\tCOMMISSION\tORDER")
+	for _, fill := range result.Fills {
+		direction := strings.ToLower(strings.TrimPrefix(fill.Direction.String(), "ORDER_DIRECTION_"))
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", fill.Time.Local().Format("2006-01-02 15:04:05"),
+			fill.Figi, direction, fill.Quantity, fill.Price, fill.Commission, fill.OrderID)
+	}
+	w.Flush()
+	stats := result.Stats
+	fmt.Printf("equity: %v -> %v\nreturn: %v\nmax drawdown: %v\nfills: %v\ncommission: %v\nround trips: %v\nwins: %v\nwin rate: %v\nprofit: %v\n",
+		stats.StartEquity, stats.EndEquity, stats.Return, stats.MaxDrawdown, stats.Fills, stats.Commission,
+		stats.RoundTrips, stats.Wins, stats.WinRate, stats.Profit)
+}
+
+// runCandles downloads candles of the period to the local store and reports gaps in them. Instruments are
+// taken from args or from the configured strategies. Export and import move candles between the store and files.
+func runCandles(ctx context.Context, cfg *config.Config, client *api.Client, args []string) error {
+	if len(args) < 2 {
+		return errors.New(candlesUsage)
+	}
+	switch args[0] {
+	case "export":
+		return exportCandles(ctx, cfg, client, args[1:])
+	case "import":
+		return importCandles(ctx, cfg, client, args[1:])
+	}
+	from, to, err := parsePeriod(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	type target struct {
+		instrument string
+		interval   investapi.CandleInterval
+	}
+	targets := []target{}
+	for _, instrument := range args[2:] {
+		targets = append(targets, target{instrument: instrument, interval: investapi.CandleInterval(config.DefaultStrategy().Interval)})
+	}
+	if len(targets) == 0 {
+		for _, strategyCfg := range cfg.Strategies {
+			targets = append(targets, target{instrument: strategyCfg.Instrument, interval: investapi.CandleInterval(strategyCfg.Interval)})
+		}
+	}
+	if len(targets) == 0 {
+		return errors.New(candlesUsage)
+	}
+
+	store, err := candles.OpenStore(cfg.Candles.Dir)
+	if err != nil {
+		return err
+	}
+	downloader := candles.NewDownloader(client, store)
+	instruments := registry.Instance(client, cfg.Instruments.CachePath, cfg.Instruments.CacheTTL)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INSTRUMENT\tFIGI\tINTERVAL\tDOWNLOADED\tSTORED\tGAPS\tMISSING")
+	for _, t := range targets {
+		instrument, err := instruments.Resolve(ctx, t.instrument)
+		if err != nil {
+			return err
+		}
+		downloaded, err := downloader.Download(ctx, instrument.Figi, t.interval, from, to)
+		if err != nil {
+			return err
+		}
+		stored, err := store.Candles(instrument.Figi, t.interval, from, to)
+		if err != nil {
+			return err
+		}
+		gaps, err := candles.FindGaps(stored, t.interval, calendar.Moscow)
+		if err != nil {
+			return err
+		}
+		missing := int64(0)
+		for _, gap := range gaps {
+			missing += gap.Missing
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", t.instrument, instrument.Figi, config.Interval(t.interval),
+			downloaded, len(stored), len(gaps), missing)
+	}
+	return w.Flush()
+}
+
+// exportCandles writes candles of the period to a file, the missing ones are downloaded to the store first.
+func exportCandles(ctx context.Context, cfg *config.Config, client *api.Client, args []string) error {
+	if len(args) < 4 || len(args) > 5 {
+		return errors.New(candlesUsage)
+	}
+	from, to, err := parsePeriod(args[2], args[3])
+	if err != nil {
+		return err
+	}
+	interval, err := parseInterval(args[4:])
+	if err != nil {
+		return err
+	}
+	store, err := candles.OpenStore(cfg.Candles.Dir)
+	if err != nil {
+		return err
+	}
+	instrument, err := registry.Instance(client, cfg.Instruments.CachePath, cfg.Instruments.CacheTTL).Resolve(ctx, args[1])
+	if err != nil {
+		return err
+	}
+	exported, err := candles.NewDownloader(client, store).Candles(ctx, instrument.Figi, interval, from, to)
+	if err != nil {
+		return err
+	}
+	if err = candles.WriteFile(args[0], exported, calendar.Moscow); err != nil {
+		return err
+	}
+	fmt.Printf("%v candles of %v written to %v\n", len(exported), instrument.Figi, args[0])
+	return nil
+}
+
+// importCandles validates candles of a file and saves them to the store, backtests of the period use them
+// instead of the API. Times without an offset are in Moscow time.
+func importCandles(ctx context.Context, cfg *config.Config, client *api.Client, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errors.New(candlesUsage)
+	}
+	interval, err := parseInterval(args[2:])
+	if err != nil {
+		return err
+	}
+	imported, err := candles.ReadFile(args[0], interval, calendar.Moscow)
+	if err != nil {
+		return err
+	}
+	store, err := candles.OpenStore(cfg.Candles.Dir)
+	if err != nil {
+		return err
+	}
+	instrument, err := registry.Instance(client, cfg.Instruments.CachePath, cfg.Instruments.CacheTTL).Resolve(ctx, args[1])
+	if err != nil {
+		return err
+	}
+	saved, err := store.Import(instrument.Figi, interval, imported)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v candles of %v imported from %v\n", saved, instrument.Figi, args[0])
+	return nil
+}
+
+// parseInterval parses an optional interval like 5m, the default is the one of the default strategy.
+func parseInterval(args []string) (investapi.CandleInterval, error) {
+	interval := config.DefaultStrategy().Interval
+	if len(args) > 0 {
+		if err := interval.UnmarshalText([]byte(args[0])); err != nil {
+			return 0, err
+		}
+	}
+	return investapi.CandleInterval(interval), nil
+}
+
+// parsePeriod parses dates FROM and TO as YYYY-MM-DD in Moscow time, the returned to is the end of day TO.
+func parsePeriod(fromArg, toArg string) (from, to time.Time, err error) {
+	from, err = time.ParseInLocation("2006-01-02", fromArg, calendar.Moscow)
+	if err != nil {
+		return from, to, errors.Wrap(err, "invalid FROM")
+	}
+	to, err = time.ParseInLocation("2006-01-02", toArg, calendar.Moscow)
+	if err != nil {
+		return from, to, errors.Wrap(err, "invalid TO")
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
+
+// commandAccount takes the account from args or from the config, it may be omitted when there is one.
+// Commands only read accounts, so none is opened when there is no open account.
+func commandAccount(ctx context.Context, cfg *config.Config, clientProfile profile.Provider, args []string) (string, error) {
+	accountID := ""
+	if len(args) == 1 {
+		accountID = args[0]
+	} else if len(cfg.API.AccountID) == 1 {
+		accountID = cfg.API.AccountID[0]
+	}
+	return clientProfile.FindAccount(ctx, accountID)
+}
+
+func printPortfolio(portfolio *profile.Portfolio) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FIGI\tTYPE\tQUANTITY\tAVERAGE\tCURRENT\tVALUE\tUNREALISED\tREALISED\tCURRENCY")
+	for _, p := range portfolio.Positions {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", p.Figi, p.InstrumentType, p.Quantity,
+			p.AveragePrice, p.CurrentPrice, p.Value, p.Unrealised, p.Realised, p.Currency)
+	}
+	w.Flush()
+	fmt.Printf("value: %v\nunrealised: %v\nrealised: %v\n",
+		portfolio.Value.String(), portfolio.Unrealised.String(), portfolio.Realised.String())
+}
@@ -0,0 +1,263 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/nax11/tinkoff_bot_public/registry"
+	"github.com/nax11/tinkoff_bot_public/strategy"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to environment variables, e.g. BOT_TOKEN or BOT_ACCOUNT_ID.
+const EnvPrefix = "BOT"
+
+// Config is merged from defaults, a YAML file, environment variables and flags, later sources win.
+type Config struct {
+	API         api.Config        `yaml:"api"`
+	Accounts    AccountsConfig    `yaml:"accounts"`
+	Instruments InstrumentsConfig `yaml:"instruments"`
+	Journal     JournalConfig     `yaml:"journal"`
+	Orders      OrdersConfig      `yaml:"orders"`
+	State       StateConfig       `yaml:"state"`
+	Reconcile   ReconcileConfig   `yaml:"reconcile"`
+	Candles     CandlesConfig     `yaml:"candles"`
+	Backtest    BacktestConfig    `yaml:"backtest"`
+	Strategies  []StrategyConfig  `yaml:"strategies"`
+	// Args are positional arguments left after flags, e.g. a command
+	Args []string `yaml:"-"`
+}
+
+// AccountsConfig sets how sandbox accounts are funded, every used account is paid in up to SandboxDeposit.
+type AccountsConfig struct {
+	SandboxDeposit money.Decimal `yaml:"sandbox_deposit"`
+	Currency       string        `yaml:"currency"`
+}
+
+func (a AccountsConfig) Deposit() money.Money {
+	return money.NewMoney(a.SandboxDeposit, a.Currency)
+}
+
+// InstrumentsConfig controls the on-disk instruments cache, empty CachePath disables it.
+type InstrumentsConfig struct {
+	CachePath string        `yaml:"cache_path"`
+	CacheTTL  time.Duration `yaml:"cache_ttl"`
+}
+
+// JournalConfig sets where operations of accounts are saved, a file per account.
+type JournalConfig struct {
+	Dir string `yaml:"dir"`
+}
+
+// OrdersConfig sets where order intents are saved before the orders are sent, empty IntentsPath
+// keeps them in memory, so orders left by a crash aren't reconciled on restart.
+type OrdersConfig struct {
+	IntentsPath string `yaml:"intents_path"`
+}
+
+// StateConfig sets the journal strategies save their deals to, empty Path keeps them in memory.
+type StateConfig struct {
+	Path string `yaml:"path"`
+}
+
+// ReconcileConfig sets how often holdings of strategies are compared with the broker, zero Interval disables it.
+// PauseOnDrift stops strategies from trading while their positions or orders differ from the broker.
+type ReconcileConfig struct {
+	Interval     time.Duration `yaml:"interval"`
+	PauseOnDrift bool          `yaml:"pause_on_drift"`
+}
+
+// CandlesConfig sets the directory historic candles are downloaded to.
+type CandlesConfig struct {
+	Dir string `yaml:"dir"`
+}
+
+// BacktestConfig sets the account the backtest command replays strategies on and how orders are filled:
+// Tariff is a name of the broker tariff, a limit order is filled when the price goes ThroughTicks past it,
+// market orders slip by SlippageTicks and orders take at most Participation of the candle volume.
+type BacktestConfig struct {
+	Deposit       money.Decimal `yaml:"deposit"`
+	Tariff        string        `yaml:"tariff"`
+	ThroughTicks  int64         `yaml:"through_ticks"`
+	SlippageTicks int64         `yaml:"slippage_ticks"`
+	Participation money.Decimal `yaml:"participation"`
+}
+
+type StrategyConfig struct {
+	// Name is a key of the available strategies map
+	Name      string `yaml:"name"`
+	AccountID string `yaml:"account_id"`
+	// Instrument is a ticker or a FIGI
+	Instrument       string        `yaml:"instrument"`
+	OperationLots    int64         `yaml:"operation_lots"`
+	MaxDealSum       money.Decimal `yaml:"max_deal_sum"`
+	DealLimit        money.Decimal `yaml:"deal_limit"`
+	StopLoss         money.Decimal `yaml:"stop_loss"`
+	StopLimitOffset  money.Decimal `yaml:"stop_limit_offset"`
+	TakeProfit       money.Decimal `yaml:"take_profit"`
+	MaxLoss          money.Decimal `yaml:"max_loss"`
+	Interval         Interval      `yaml:"interval"`
+	AnalyzePeriod    time.Duration `yaml:"analyze_period"`
+	DealPeriod       time.Duration `yaml:"deal_period"`
+	MaxOrderAge      time.Duration `yaml:"max_order_age"`
+	SimulateDayTrade bool          `yaml:"simulate_day_trade"`
+	SimulateLotQty   int64         `yaml:"simulate_lot_qty"`
+}
+
+func Default() *Config {
+	return &Config{
+		API: api.Config{
+			Endpoint: api.DefaultEndpoint,
+			Sandbox:  true,
+		},
+		Accounts: AccountsConfig{
+			SandboxDeposit: money.FromInt(3000),
+			Currency:       money.RUB,
+		},
+		Instruments: InstrumentsConfig{
+			CachePath: filepath.Join(".cache", "instruments.json"),
+			CacheTTL:  24 * time.Hour,
+		},
+		Journal: JournalConfig{
+			Dir: filepath.Join(".cache", "journal"),
+		},
+		Orders: OrdersConfig{
+			IntentsPath: filepath.Join(".cache", "order_intents.json"),
+		},
+		State: StateConfig{
+			Path: filepath.Join(".cache", "strategy_state.jsonl"),
+		},
+		Reconcile: ReconcileConfig{
+			Interval: time.Minute,
+		},
+		Candles: CandlesConfig{
+			Dir: filepath.Join(".cache", "candles"),
+		},
+		Backtest: BacktestConfig{
+			Deposit:       money.FromInt(100000),
+			Tariff:        "trader",
+			ThroughTicks:  1,
+			SlippageTicks: 1,
+			Participation: money.MustParse("0.1"),
+		},
+	}
+}
+
+// DefaultStrategy is the base of every strategy entry, values from the file override it.
+func DefaultStrategy() StrategyConfig {
+	return StrategyConfig{
+		Name:          "band",
+		OperationLots: 1,
+		Interval:      Interval(investapi.CandleInterval_CANDLE_INTERVAL_5_MIN),
+		AnalyzePeriod: 20 * time.Minute,
+		DealPeriod:    30 * time.Minute,
+	}
+}
+
+// Load builds the configuration from args (without the program name) and the environment.
+// The file is taken from -config flag or BOT_CONFIG variable.
+func Load(args []string) (*Config, error) {
+	flags, err := parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	path := flags.configPath
+	if path == "" {
+		path = os.Getenv(EnvPrefix + "_CONFIG")
+	}
+	if path != "" {
+		if err = cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err = loadEnv(EnvPrefix, &cfg.API); err != nil {
+		return nil, err
+	}
+	flags.apply(cfg)
+	cfg.Args = flags.args
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".yaml" && ext != ".yml" {
+		return errors.Errorf("unsupported config format %q, use yaml", ext)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "fail read config file")
+	}
+
+	file := struct {
+		API         api.Config        `yaml:"api"`
+		Accounts    AccountsConfig    `yaml:"accounts"`
+		Instruments InstrumentsConfig `yaml:"instruments"`
+		Journal     JournalConfig     `yaml:"journal"`
+		Orders      OrdersConfig      `yaml:"orders"`
+		State       StateConfig       `yaml:"state"`
+		Reconcile   ReconcileConfig   `yaml:"reconcile"`
+		Candles     CandlesConfig     `yaml:"candles"`
+		Backtest    BacktestConfig    `yaml:"backtest"`
+		Strategies  []yaml.Node       `yaml:"strategies"`
+	}{
+		API:         c.API,
+		Accounts:    c.Accounts,
+		Instruments: c.Instruments,
+		Journal:     c.Journal,
+		Orders:      c.Orders,
+		State:       c.State,
+		Reconcile:   c.Reconcile,
+		Candles:     c.Candles,
+		Backtest:    c.Backtest,
+	}
+	if err = yaml.Unmarshal(data, &file); err != nil {
+		return errors.Wrapf(err, "fail parse config file %v", path)
+	}
+	c.API = file.API
+	c.Accounts = file.Accounts
+	c.Instruments = file.Instruments
+	c.Journal = file.Journal
+	c.Orders = file.Orders
+	c.State = file.State
+	c.Reconcile = file.Reconcile
+	c.Candles = file.Candles
+	c.Backtest = file.Backtest
+	c.Strategies = nil
+	for _, node := range file.Strategies {
+		strategyCfg := DefaultStrategy()
+		if err = node.Decode(&strategyCfg); err != nil {
+			return errors.Wrapf(err, "fail parse strategy at line %v", node.Line)
+		}
+		c.Strategies = append(c.Strategies, strategyCfg)
+	}
+	return nil
+}
+
+// TradeParams converts the entry for strategy.Run, instrument is the resolved Instrument.
+func (s StrategyConfig) TradeParams(instrument *registry.Instrument) strategy.TradeParams {
+	return strategy.TradeParams{
+		AccountID:       s.AccountID,
+		Figi:            instrument.Figi,
+		Instrument:      instrument,
+		OperationLots:   s.OperationLots,
+		MaxDealSum:      s.MaxDealSum,
+		DealLimit:       s.DealLimit,
+		StopLoss:        s.StopLoss,
+		StopLimitOffset: s.StopLimitOffset,
+		TakeProfit:      s.TakeProfit,
+		MaxLoss:         s.MaxLoss,
+		Interval:        investapi.CandleInterval(s.Interval),
+		AnalyzePeriod:   s.AnalyzePeriod,
+		DealPeriod:      s.DealPeriod,
+		MaxOrderAge:     s.MaxOrderAge,
+	}
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+const testFile = `
+api:
+  token: file-token
+  endpoint: file.example:443
+  sandbox: false
+  account_id: [file-account]
+strategies:
+  - instrument: SBER
+    max_deal_sum: 2000.5
+    deal_period: 1h
+  - name: other
+    instrument: GAZP
+    interval: 1m
+`
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestPrecedence checks that defaults, the file, the environment and flags override each other in this order.
+func TestPrecedence(t *testing.T) {
+	path := writeConfig(t, "config.yaml", testFile)
+	for _, tt := range []struct {
+		name string
+		env  map[string]string
+		args []string
+		want api.Config
+	}{
+		{
+			name: "defaults",
+			want: api.Config{Endpoint: api.DefaultEndpoint, Sandbox: true},
+		},
+		{
+			name: "file",
+			args: []string{"-config", path},
+			want: api.Config{Token: "file-token", Endpoint: "file.example:443", AccountID: []string{"file-account"}},
+		},
+		{
+			name: "file from environment",
+			env:  map[string]string{"BOT_CONFIG": path},
+			want: api.Config{Token: "file-token", Endpoint: "file.example:443", AccountID: []string{"file-account"}},
+		},
+		{
+			name: "environment over file",
+			env:  map[string]string{"BOT_TOKEN": "env-token", "BOT_ACCOUNT_ID": "a, b", "BOT_SANDBOX": "true"},
+			args: []string{"-config", path},
+			want: api.Config{Token: "env-token", Endpoint: "file.example:443", AccountID: []string{"a", "b"}, Sandbox: true},
+		},
+		{
+			name: "flags over environment",
+			env:  map[string]string{"BOT_TOKEN": "env-token", "BOT_ENDPOINT": "env.example:443", "BOT_SANDBOX": "true"},
+			args: []string{"-config", path, "-token", "flag-token", "-sandbox=false", "-account-id", "c"},
+			want: api.Config{Token: "flag-token", Endpoint: "env.example:443", AccountID: []string{"c"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg.API, tt.want) {
+				t.Fatalf("api config %+v, want %+v", cfg.API, tt.want)
+			}
+		})
+	}
+}
+
+func TestStrategiesOfFile(t *testing.T) {
+	cfg, err := Load([]string{"-config", writeConfig(t, "config.yml", testFile), "run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Strategies) != 2 || !reflect.DeepEqual(cfg.Args, []string{"run"}) {
+		t.Fatalf("strategies %+v, args %v, want 2 strategies and the run command", cfg.Strategies, cfg.Args)
+	}
+
+	// values missing in the file are defaults
+	want := DefaultStrategy()
+	want.Instrument = "SBER"
+	want.MaxDealSum = money.MustParse("2000.5")
+	want.DealPeriod = time.Hour
+	if !reflect.DeepEqual(cfg.Strategies[0], want) {
+		t.Fatalf("strategy %+v, want %+v", cfg.Strategies[0], want)
+	}
+	if cfg.Strategies[1].Name != "other" || investapi.CandleInterval(cfg.Strategies[1].Interval) != investapi.CandleInterval_CANDLE_INTERVAL_1_MIN {
+		t.Fatalf("strategy %+v, want other of 1 minute candles", cfg.Strategies[1])
+	}
+	// sections missing in the file are defaults
+	if !reflect.DeepEqual(cfg.Backtest, Default().Backtest) {
+		t.Fatalf("backtest config %+v, want defaults", cfg.Backtest)
+	}
+}
+
+func TestStrategyFlags(t *testing.T) {
+	path := writeConfig(t, "config.yaml", testFile)
+	for _, tt := range []struct {
+		name            string
+		args            []string
+		wantNames       []string
+		wantInstruments []string
+	}{
+		{name: "file", args: []string{"-config", path}, wantNames: []string{"band", "other"}, wantInstruments: []string{"SBER", "GAZP"}},
+		{name: "selected", args: []string{"-config", path, "-strategy", "other"}, wantNames: []string{"other"}, wantInstruments: []string{"GAZP"}},
+		{name: "instrument", args: []string{"-config", path, "-instrument", "VTBR"}, wantNames: []string{"band", "other"}, wantInstruments: []string{"VTBR", "VTBR"}},
+		{name: "without file", args: []string{"-strategy", "band", "-instrument", "VTBR"}, wantNames: []string{"band"}, wantInstruments: []string{"VTBR"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names, instruments := []string{}, []string{}
+			for _, s := range cfg.Strategies {
+				names = append(names, s.Name)
+				instruments = append(instruments, s.Instrument)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) || !reflect.DeepEqual(instruments, tt.wantInstruments) {
+				t.Fatalf("strategies %v of %v, want %v of %v", names, instruments, tt.wantNames, tt.wantInstruments)
+			}
+		})
+	}
+}
+
+func TestInvalidSources(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		env  map[string]string
+		args []string
+	}{
+		{name: "json file", args: []string{"-config", writeConfig(t, "config.json", "{}")}},
+		{name: "broken yaml", args: []string{"-config", writeConfig(t, "config.yaml", "api: [")}},
+		{name: "missing file", args: []string{"-config", filepath.Join(t.TempDir(), "none.yaml")}},
+		{name: "bool variable", env: map[string]string{"BOT_SANDBOX": "maybe"}},
+		{name: "unknown flag", args: []string{"-unknown"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if _, err := Load(tt.args); err == nil {
+				t.Fatal("invalid configuration is loaded")
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var wordBoundary = regexp.MustCompile("([a-z0-9])([A-Z])")
+
+// envName follows the envconfig convention: AccountID with split_words becomes PREFIX_ACCOUNT_ID.
+func envName(prefix string, field reflect.StructField) string {
+	name := field.Name
+	if field.Tag.Get("split_words") == "true" {
+		name = wordBoundary.ReplaceAllString(name, "${1}_${2}")
+	}
+	return prefix + "_" + strings.ToUpper(name)
+}
+
+// loadEnv overrides fields of the struct pointed by target with set environment variables.
+func loadEnv(prefix string, target interface{}) error {
+	value := reflect.ValueOf(target).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := envName(prefix, field)
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(value.Field(i), raw); err != nil {
+			return errors.Wrapf(err, "invalid value of %v", name)
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(field reflect.Value, raw string) error {
+	if unmarshaler, ok := field.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText([]byte(raw))
+	}
+	if field.Type() == durationType {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return errors.Errorf("unsupported slice of %v", field.Type().Elem())
+		}
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return errors.Errorf("unsupported type %v", field.Type())
+	}
+	return nil
+}
+
+// missingRequired lists fields tagged required:"true" that are left zero.
+func missingRequired(prefix string, target interface{}) []string {
+	value := reflect.ValueOf(target).Elem()
+	missing := []string{}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.Tag.Get("required") == "true" && value.Field(i).IsZero() {
+			missing = append(missing, envName(prefix, field))
+		}
+	}
+	return missing
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"flag"
+	"strings"
+)
+
+type flagValues struct {
+	set        map[string]bool
+	configPath string
+	token      string
+	endpoint   string
+	sandbox    bool
+	accountID  string
+	strategy   string
+	instrument string
+	args       []string
+}
+
+func parseFlags(args []string) (*flagValues, error) {
+	values := &flagValues{set: make(map[string]bool)}
+	flags := flag.NewFlagSet("tinkoff_bot", flag.ContinueOnError)
+	flags.StringVar(&values.configPath, "config", "", "path to yaml config")
+	flags.StringVar(&values.token, "token", "", "Invest API token")
+	flags.StringVar(&values.endpoint, "endpoint", "", "Invest API endpoint, host or host:port")
+	flags.BoolVar(&values.sandbox, "sandbox", true, "trade in sandbox")
+	flags.StringVar(&values.accountID, "account-id", "", "comma separated account ids")
+	flags.StringVar(&values.strategy, "strategy", "", "run only strategies with this name")
+	flags.StringVar(&values.instrument, "instrument", "", "override instrument of every strategy")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	values.args = flags.Args()
+	flags.Visit(func(f *flag.Flag) {
+		values.set[f.Name] = true
+	})
+	return values, nil
+}
+
+// apply overrides cfg with explicitly passed flags only.
+func (f *flagValues) apply(cfg *Config) {
+	if f.set["token"] {
+		cfg.API.Token = f.token
+	}
+	if f.set["endpoint"] {
+		cfg.API.Endpoint = f.endpoint
+	}
+	if f.set["sandbox"] {
+		cfg.API.Sandbox = f.sandbox
+	}
+	if f.set["account-id"] {
+		cfg.API.AccountID = strings.Split(f.accountID, ",")
+	}
+	if f.set["strategy"] {
+		selected := []StrategyConfig{}
+		for _, strategyCfg := range cfg.Strategies {
+			if strategyCfg.Name == f.strategy {
+				selected = append(selected, strategyCfg)
+			}
+		}
+		if len(selected) == 0 {
+			strategyCfg := DefaultStrategy()
+			strategyCfg.Name = f.strategy
+			selected = append(selected, strategyCfg)
+		}
+		cfg.Strategies = selected
+	}
+	if f.set["instrument"] {
+		if len(cfg.Strategies) == 0 {
+			cfg.Strategies = append(cfg.Strategies, DefaultStrategy())
+		}
+		for i := range cfg.Strategies {
+			cfg.Strategies[i].Instrument = f.instrument
+		}
+	}
+}
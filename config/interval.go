@@ -0,0 +1,40 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+// Interval is a candle interval written as 1m, 5m, 15m, 1h or 1d.
+type Interval investapi.CandleInterval
+
+var intervalNames = map[string]investapi.CandleInterval{
+	"1m":  investapi.CandleInterval_CANDLE_INTERVAL_1_MIN,
+	"5m":  investapi.CandleInterval_CANDLE_INTERVAL_5_MIN,
+	"15m": investapi.CandleInterval_CANDLE_INTERVAL_15_MIN,
+	"1h":  investapi.CandleInterval_CANDLE_INTERVAL_HOUR,
+	"1d":  investapi.CandleInterval_CANDLE_INTERVAL_DAY,
+}
+
+func (i Interval) String() string {
+	for name, interval := range intervalNames {
+		if interval == investapi.CandleInterval(i) {
+			return name
+		}
+	}
+	return ""
+}
+
+func (i Interval) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+func (i *Interval) UnmarshalText(text []byte) error {
+	interval, ok := intervalNames[string(text)]
+	if !ok {
+		return errors.Errorf("unknown candle interval %q, expected one of 1m, 5m, 15m, 1h, 1d", text)
+	}
+	*i = Interval(interval)
+	return nil
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+// ValidateAPI checks only what is needed to connect, e.g. for account commands.
+func (c *Config) ValidateAPI() error {
+	problems := c.apiProblems()
+	if len(problems) > 0 {
+		return errors.Errorf("invalid config: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (c *Config) apiProblems() []string {
+	problems := []string{}
+	for _, name := range missingRequired(EnvPrefix, &c.API) {
+		problems = append(problems, name+" is required")
+	}
+	if c.API.Endpoint == "" {
+		problems = append(problems, "endpoint is empty")
+	}
+	if c.Accounts.SandboxDeposit.Sign() < 0 {
+		problems = append(problems, "accounts.sandbox_deposit should not be negative")
+	}
+	if c.Accounts.Currency == "" {
+		problems = append(problems, "accounts.currency is required")
+	}
+	return problems
+}
+
+// Validate checks the whole configuration, knownStrategies are names the binary can run.
+func (c *Config) Validate(knownStrategies []string) error {
+	problems := c.apiProblems()
+	if !c.API.Sandbox && len(c.API.AccountID) == 0 {
+		problems = append(problems, "account_id is required in non-sandbox mode")
+	}
+	if len(c.Strategies) == 0 {
+		problems = append(problems, "no strategies configured")
+	}
+
+	known := map[string]bool{}
+	for _, name := range knownStrategies {
+		known[name] = true
+	}
+	accounts := map[string]bool{}
+	for _, accountID := range c.API.AccountID {
+		accounts[accountID] = true
+	}
+
+	for i, s := range c.Strategies {
+		prefix := fmt.Sprintf("strategies[%d] %v: ", i, s.Name)
+		if !known[s.Name] {
+			problems = append(problems, prefix+"unknown strategy, available: "+strings.Join(knownStrategies, ", "))
+		}
+		if s.Instrument == "" {
+			problems = append(problems, prefix+"instrument is required")
+		}
+		if s.AccountID != "" && len(accounts) > 0 && !accounts[s.AccountID] {
+			problems = append(problems, prefix+"account_id is not listed in api.account_id")
+		}
+		if !c.API.Sandbox && s.AccountID == "" && len(c.API.AccountID) != 1 {
+			problems = append(problems, prefix+"account_id is required when several accounts are configured")
+		}
+		if s.OperationLots <= 0 {
+			problems = append(problems, prefix+"operation_lots should be positive")
+		}
+		if s.MaxDealSum.Sign() <= 0 {
+			problems = append(problems, prefix+"max_deal_sum should be positive")
+		}
+		if s.MaxDealSum.GreaterThan(s.DealLimit) {
+			problems = append(problems, prefix+"deal_limit should not be less than max_deal_sum")
+		}
+		if investapi.CandleInterval(s.Interval) == investapi.CandleInterval_CANDLE_INTERVAL_UNSPECIFIED {
+			problems = append(problems, prefix+"interval is required")
+		}
+		if s.AnalyzePeriod <= 0 {
+			problems = append(problems, prefix+"analyze_period should be positive")
+		}
+		if s.MaxOrderAge < 0 {
+			problems = append(problems, prefix+"max_order_age should not be negative")
+		}
+		if s.StopLoss.Sign() < 0 || s.StopLimitOffset.Sign() < 0 || s.TakeProfit.Sign() < 0 || s.MaxLoss.Sign() < 0 {
+			problems = append(problems, prefix+"stop_loss, stop_limit_offset, take_profit and max_loss should not be negative")
+		}
+		if !s.StopLimitOffset.IsZero() && s.StopLoss.IsZero() {
+			problems = append(problems, prefix+"stop_limit_offset requires stop_loss")
+		}
+		if c.API.Sandbox && !s.SimulateDayTrade && !s.StopLoss.IsZero() {
+			problems = append(problems, prefix+"stop_loss is not available in sandbox")
+		}
+		if s.SimulateDayTrade && s.SimulateLotQty <= 0 {
+			problems = append(problems, prefix+"simulate_lot_qty should be positive in simulation")
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.Errorf("invalid config: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,843 @@
+package fakeapi
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type Outcome int
+
+const (
+	// Rest leaves the order active until it's filled, cancelled or rejected
+	Rest Outcome = iota
+	Fill
+	Reject
+)
+
+type OrderScript func(req *investapi.PostOrderRequest) Outcome
+
+type candleKey struct {
+	figi     string
+	interval investapi.CandleInterval
+}
+
+type account struct {
+	info       *investapi.Account
+	sandbox    bool
+	money      money.Totals     // currency -> amount
+	positions  map[string]int64 // figi -> quantity in pieces
+	orders     map[string]*investapi.OrderState
+	requests   map[string]string // client order id -> order id
+	operations []*investapi.Operation
+	// orderOperations keeps the operation of every order, fills are added to it as trades
+	orderOperations map[string]*investapi.Operation
+	orderFees       map[string]*investapi.Operation
+	stopOrders      map[string]*investapi.StopOrder
+}
+
+type exchange struct {
+	now     func() time.Time
+	onTrade func(*investapi.OrderTrades)
+
+	mu             sync.Mutex
+	shares         map[string]*investapi.Share
+	candles        map[candleKey][]*investapi.HistoricCandle
+	lastPrices     map[string]*investapi.LastPrice
+	tradingStatus  map[string]investapi.SecurityTradingStatus
+	schedules      map[string]map[time.Time]*investapi.TradingDay
+	accounts       map[string]*account
+	accountByOrder map[string]string
+	script         OrderScript
+	// commission is the broker fee rate of a trade amount
+	commission money.Decimal
+}
+
+func newExchange(now func() time.Time, onTrade func(*investapi.OrderTrades)) *exchange {
+	return &exchange{
+		now:            now,
+		onTrade:        onTrade,
+		shares:         make(map[string]*investapi.Share),
+		candles:        make(map[candleKey][]*investapi.HistoricCandle),
+		lastPrices:     make(map[string]*investapi.LastPrice),
+		tradingStatus:  make(map[string]investapi.SecurityTradingStatus),
+		schedules:      make(map[string]map[time.Time]*investapi.TradingDay),
+		accounts:       make(map[string]*account),
+		accountByOrder: make(map[string]string),
+	}
+}
+
+func moneyValue(currency string, amount money.Decimal) *investapi.MoneyValue {
+	return money.NewMoney(amount, currency).MoneyValue()
+}
+
+func (e *exchange) addShare(share *investapi.Share) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.shares[share.Figi] = share
+}
+
+func (e *exchange) findShare(idType investapi.InstrumentIdType, classCode, id string) *investapi.Share {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, share := range e.shares {
+		switch idType {
+		case investapi.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI:
+			if share.Figi == id {
+				return share
+			}
+		case investapi.InstrumentIdType_INSTRUMENT_ID_TYPE_TICKER:
+			if share.Ticker == id && share.ClassCode == classCode {
+				return share
+			}
+		case investapi.InstrumentIdType_INSTRUMENT_ID_TYPE_UID:
+			if share.Uid == id {
+				return share
+			}
+		}
+	}
+	return nil
+}
+
+func (e *exchange) allShares() []*investapi.Share {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	shares := make([]*investapi.Share, 0, len(e.shares))
+	for _, share := range e.shares {
+		shares = append(shares, share)
+	}
+	sort.Slice(shares, func(i, j int) bool { return shares[i].Figi < shares[j].Figi })
+	return shares
+}
+
+func (e *exchange) addCandles(figi string, interval investapi.CandleInterval, candles []*investapi.HistoricCandle) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := candleKey{figi: figi, interval: interval}
+	e.candles[key] = append(e.candles[key], candles...)
+	sort.SliceStable(e.candles[key], func(i, j int) bool {
+		return e.candles[key][i].GetTime().AsTime().Before(e.candles[key][j].GetTime().AsTime())
+	})
+	for _, candle := range candles {
+		last, ok := e.lastPrices[figi]
+		if ok && last.GetTime().AsTime().After(candle.GetTime().AsTime()) {
+			continue
+		}
+		e.lastPrices[figi] = &investapi.LastPrice{
+			Figi:  figi,
+			Price: candle.GetClose(),
+			Time:  candle.GetTime(),
+		}
+	}
+}
+
+func (e *exchange) getCandles(figi string, interval investapi.CandleInterval, from, to time.Time) []*investapi.HistoricCandle {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	result := []*investapi.HistoricCandle{}
+	for _, candle := range e.candles[candleKey{figi: figi, interval: interval}] {
+		candleTime := candle.GetTime().AsTime()
+		if candleTime.Before(from) || !candleTime.Before(to) {
+			continue
+		}
+		result = append(result, candle)
+	}
+	return result
+}
+
+func (e *exchange) setLastPrice(figi string, price *investapi.Quotation) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastPrices[figi] = &investapi.LastPrice{
+		Figi:  figi,
+		Price: price,
+		Time:  timestamppb.New(e.now()),
+	}
+}
+
+func (e *exchange) lastPrice(figi string) *investapi.LastPrice {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lastPrices[figi]
+}
+
+func (e *exchange) setTradingStatus(figi string, tradingStatus investapi.SecurityTradingStatus) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tradingStatus[figi] = tradingStatus
+}
+
+func (e *exchange) getTradingStatus(figi string) investapi.SecurityTradingStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if tradingStatus, ok := e.tradingStatus[figi]; ok {
+		return tradingStatus
+	}
+	return investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING
+}
+
+func (e *exchange) setScript(script OrderScript) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.script = script
+}
+
+func (e *exchange) openAccount(accountID string, sandbox bool) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if accountID == "" {
+		accountID = uuid.New().String()
+	}
+	e.accounts[accountID] = &account{
+		info: &investapi.Account{
+			Id:          accountID,
+			Type:        investapi.AccountType_ACCOUNT_TYPE_TINKOFF,
+			Name:        accountID,
+			Status:      investapi.AccountStatus_ACCOUNT_STATUS_OPEN,
+			OpenedDate:  timestamppb.New(e.now()),
+			AccessLevel: investapi.AccessLevel_ACCOUNT_ACCESS_LEVEL_FULL_ACCESS,
+		},
+		sandbox:    sandbox,
+		money:      make(money.Totals),
+		positions:  make(map[string]int64),
+		orders:     make(map[string]*investapi.OrderState),
+		requests:   make(map[string]string),
+		stopOrders: make(map[string]*investapi.StopOrder),
+
+		orderOperations: make(map[string]*investapi.Operation),
+		orderFees:       make(map[string]*investapi.Operation),
+	}
+	return accountID
+}
+
+func (e *exchange) closeAccount(accountID string, sandbox bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, err := e.account(accountID, sandbox)
+	if err != nil {
+		return err
+	}
+	acc.info.Status = investapi.AccountStatus_ACCOUNT_STATUS_CLOSED
+	acc.info.ClosedDate = timestamppb.New(e.now())
+	return nil
+}
+
+func (e *exchange) listAccounts(sandbox bool) []*investapi.Account {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	accounts := []*investapi.Account{}
+	for _, acc := range e.accounts {
+		if acc.sandbox != sandbox {
+			continue
+		}
+		accounts = append(accounts, proto.Clone(acc.info).(*investapi.Account))
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Id < accounts[j].Id })
+	return accounts
+}
+
+// account should be called with e.mu held.
+func (e *exchange) account(accountID string, sandbox bool) (*account, error) {
+	acc, ok := e.accounts[accountID]
+	if !ok || acc.sandbox != sandbox {
+		return nil, status.Error(codes.NotFound, "50004: account not found")
+	}
+	return acc, nil
+}
+
+func (e *exchange) openedAccount(accountID string, sandbox bool) (*account, error) {
+	acc, err := e.account(accountID, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	if acc.info.Status != investapi.AccountStatus_ACCOUNT_STATUS_OPEN {
+		return nil, status.Error(codes.InvalidArgument, "30025: account is closed")
+	}
+	return acc, nil
+}
+
+func (e *exchange) payIn(accountID string, amount *investapi.MoneyValue) (*investapi.MoneyValue, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, ok := e.accounts[accountID]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "50004: account not found")
+	}
+	if amount.GetCurrency() == "" {
+		return nil, status.Error(codes.InvalidArgument, "30013: currency is required")
+	}
+	payment := money.FromMoneyValue(amount)
+	acc.money.Add(payment)
+	return acc.money.Get(payment.Currency).MoneyValue(), nil
+}
+
+func (e *exchange) postOrder(req *investapi.PostOrderRequest, sandbox bool) (*investapi.PostOrderResponse, error) {
+	e.mu.Lock()
+	acc, err := e.openedAccount(req.AccountId, sandbox)
+	if err != nil {
+		e.mu.Unlock()
+		return nil, err
+	}
+	if orderID, ok := acc.requests[req.OrderId]; ok && req.OrderId != "" {
+		// fills change the order, the response is taken before unlock
+		resp := postOrderResponse(acc.orders[orderID])
+		e.mu.Unlock()
+		return resp, nil
+	}
+	share, ok := e.shares[req.Figi]
+	if !ok {
+		e.mu.Unlock()
+		return nil, status.Error(codes.NotFound, "50002: instrument not found")
+	}
+	if req.Quantity <= 0 {
+		e.mu.Unlock()
+		return nil, status.Error(codes.InvalidArgument, "30003: quantity must be positive")
+	}
+	if req.Direction == investapi.OrderDirection_ORDER_DIRECTION_UNSPECIFIED {
+		e.mu.Unlock()
+		return nil, status.Error(codes.InvalidArgument, "30004: direction is required")
+	}
+
+	var price money.Decimal
+	switch req.OrderType {
+	case investapi.OrderType_ORDER_TYPE_LIMIT:
+		if req.Price == nil {
+			e.mu.Unlock()
+			return nil, status.Error(codes.InvalidArgument, "30008: price is required for limit order")
+		}
+		price = money.FromQuotation(req.Price)
+	case investapi.OrderType_ORDER_TYPE_MARKET:
+		last, ok := e.lastPrices[req.Figi]
+		if !ok {
+			e.mu.Unlock()
+			return nil, status.Error(codes.FailedPrecondition, "30079: instrument is not available for trading")
+		}
+		price = money.FromQuotation(last.Price)
+	default:
+		e.mu.Unlock()
+		return nil, status.Error(codes.InvalidArgument, "30009: order type is required")
+	}
+
+	orderID := uuid.New().String()
+	qty := req.Quantity * int64(share.Lot)
+	order := &investapi.OrderState{
+		OrderId:               orderID,
+		ExecutionReportStatus: investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW,
+		LotsRequested:         req.Quantity,
+		InitialOrderPrice:     moneyValue(share.Currency, price.MulInt(qty)),
+		ExecutedOrderPrice:    moneyValue(share.Currency, money.Zero),
+		TotalOrderAmount:      moneyValue(share.Currency, price.MulInt(qty)),
+		InitialCommission:     moneyValue(share.Currency, money.Zero),
+		ExecutedCommission:    moneyValue(share.Currency, money.Zero),
+		Figi:                  req.Figi,
+		Direction:             req.Direction,
+		InitialSecurityPrice:  moneyValue(share.Currency, price),
+		Currency:              share.Currency,
+		OrderType:             req.OrderType,
+		OrderDate:             timestamppb.New(e.now()),
+	}
+	acc.orders[orderID] = order
+	if req.OrderId != "" {
+		acc.requests[req.OrderId] = orderID
+	}
+	e.accountByOrder[orderID] = req.AccountId
+
+	outcome := Rest
+	if req.OrderType == investapi.OrderType_ORDER_TYPE_MARKET {
+		outcome = Fill
+	}
+	script := e.script
+	e.mu.Unlock()
+
+	if script != nil {
+		outcome = script(req)
+	}
+	switch outcome {
+	case Fill:
+		err = e.fill(orderID, 0)
+	case Reject:
+		err = e.reject(orderID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return postOrderResponse(order), nil
+}
+
+// postOrderResponse should be called with e.mu held.
+func postOrderResponse(order *investapi.OrderState) *investapi.PostOrderResponse {
+	return &investapi.PostOrderResponse{
+		OrderId:               order.OrderId,
+		ExecutionReportStatus: order.ExecutionReportStatus,
+		LotsRequested:         order.LotsRequested,
+		LotsExecuted:          order.LotsExecuted,
+		InitialOrderPrice:     order.InitialOrderPrice,
+		ExecutedOrderPrice:    order.ExecutedOrderPrice,
+		TotalOrderAmount:      order.TotalOrderAmount,
+		InitialCommission:     order.InitialCommission,
+		ExecutedCommission:    order.ExecutedCommission,
+		Figi:                  order.Figi,
+		Direction:             order.Direction,
+		InitialSecurityPrice:  order.InitialSecurityPrice,
+		OrderType:             order.OrderType,
+	}
+}
+
+// order should be called with e.mu held.
+func (e *exchange) order(orderID string) (*account, *investapi.OrderState, error) {
+	accountID, ok := e.accountByOrder[orderID]
+	if !ok {
+		return nil, nil, status.Error(codes.NotFound, "50005: order not found")
+	}
+	acc := e.accounts[accountID]
+	return acc, acc.orders[orderID], nil
+}
+
+func isActive(order *investapi.OrderState) bool {
+	return order.ExecutionReportStatus == investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW ||
+		order.ExecutionReportStatus == investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL
+}
+
+func (e *exchange) fill(orderID string, lots int64) error {
+	return e.execute(orderID, lots, nil)
+}
+
+// execution overrides the price of the order and the commission by the rate for a fill.
+type execution struct {
+	price      money.Decimal
+	commission money.Decimal
+}
+
+func (e *exchange) execute(orderID string, lots int64, at *execution) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, order, err := e.order(orderID)
+	if err != nil {
+		return err
+	}
+	if !isActive(order) {
+		return status.Error(codes.FailedPrecondition, "30059: order is not active")
+	}
+	rest := order.LotsRequested - order.LotsExecuted
+	if lots <= 0 || lots > rest {
+		lots = rest
+	}
+
+	share := e.shares[order.Figi]
+	price := money.FromMoneyValue(order.InitialSecurityPrice).Amount
+	qty := lots * int64(share.Lot)
+	fee := price.MulInt(qty).Mul(e.commission)
+	if at != nil {
+		price = at.price
+		fee = at.commission
+	}
+	// payment is the change of the money position
+	payment := money.NewMoney(price.MulInt(qty), order.Currency)
+	operationType := investapi.OperationType_OPERATION_TYPE_SELL
+	if order.Direction == investapi.OrderDirection_ORDER_DIRECTION_BUY {
+		payment.Amount = payment.Amount.Neg()
+		acc.positions[order.Figi] += qty
+		operationType = investapi.OperationType_OPERATION_TYPE_BUY
+	} else {
+		acc.positions[order.Figi] -= qty
+	}
+	acc.money.Add(payment)
+
+	now := timestamppb.New(e.now())
+	tradeID := uuid.New().String()
+	order.LotsExecuted += lots
+	executed := money.FromMoneyValue(order.ExecutedOrderPrice).Amount.Add(price.MulInt(qty))
+	order.ExecutedOrderPrice = moneyValue(order.Currency, executed)
+	order.AveragePositionPrice = moneyValue(order.Currency, executed.DivInt(order.LotsExecuted*int64(share.Lot)))
+	order.ExecutedCommission = moneyValue(order.Currency, money.FromMoneyValue(order.ExecutedCommission).Amount.Add(fee))
+	order.Stages = append(order.Stages, &investapi.OrderStage{
+		Price:    moneyValue(order.Currency, price),
+		Quantity: lots,
+		TradeId:  tradeID,
+	})
+	order.ExecutionReportStatus = investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_PARTIALLYFILL
+	if order.LotsExecuted == order.LotsRequested {
+		order.ExecutionReportStatus = investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL
+	}
+
+	e.addOperation(acc, order, now, tradeID, qty, price, payment, fee, operationType)
+	if !acc.sandbox {
+		e.onTrade(&investapi.OrderTrades{
+			OrderId:   order.OrderId,
+			CreatedAt: order.OrderDate,
+			Direction: order.Direction,
+			Figi:      order.Figi,
+			AccountId: acc.info.Id,
+			Trades: []*investapi.OrderTrade{{
+				DateTime: now,
+				Price:    price.Quotation(),
+				Quantity: qty,
+			}},
+		})
+	}
+	return nil
+}
+
+// addOperation records the fill in the operation of the order like the API does,
+// the commission is kept in a separate operation referring to it.
+func (e *exchange) addOperation(acc *account, order *investapi.OrderState, now *timestamppb.Timestamp,
+	tradeID string, qty int64, price money.Decimal, payment money.Money, commission money.Decimal, operationType investapi.OperationType) {
+	operation, ok := acc.orderOperations[order.OrderId]
+	if !ok {
+		operation = &investapi.Operation{
+			Id:             uuid.New().String(),
+			Currency:       order.Currency,
+			Payment:        moneyValue(order.Currency, money.Zero),
+			Price:          moneyValue(order.Currency, price),
+			State:          investapi.OperationState_OPERATION_STATE_EXECUTED,
+			Figi:           order.Figi,
+			InstrumentType: "share",
+			Date:           now,
+			OperationType:  operationType,
+		}
+		acc.orderOperations[order.OrderId] = operation
+		acc.operations = append(acc.operations, operation)
+	}
+	operation.Quantity += qty
+	operation.Payment = moneyValue(order.Currency, money.FromMoneyValue(operation.Payment).Amount.Add(payment.Amount))
+	operation.Trades = append(operation.Trades, &investapi.OperationTrade{
+		TradeId:  tradeID,
+		DateTime: now,
+		Quantity: qty,
+		Price:    moneyValue(order.Currency, price),
+	})
+
+	if commission.IsZero() {
+		return
+	}
+	fee := money.NewMoney(commission.Neg(), order.Currency)
+	acc.money.Add(fee)
+	feeOperation, ok := acc.orderFees[order.OrderId]
+	if !ok {
+		feeOperation = &investapi.Operation{
+			Id:                uuid.New().String(),
+			ParentOperationId: operation.Id,
+			Currency:          order.Currency,
+			Payment:           moneyValue(order.Currency, money.Zero),
+			State:             investapi.OperationState_OPERATION_STATE_EXECUTED,
+			Figi:              order.Figi,
+			InstrumentType:    "share",
+			Date:              now,
+			OperationType:     investapi.OperationType_OPERATION_TYPE_BROKER_FEE,
+		}
+		acc.orderFees[order.OrderId] = feeOperation
+		acc.operations = append(acc.operations, feeOperation)
+	}
+	feeOperation.Payment = moneyValue(order.Currency, money.FromMoneyValue(feeOperation.Payment).Amount.Add(fee.Amount))
+}
+
+func (e *exchange) setCommission(rate money.Decimal) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.commission = rate
+}
+
+func (e *exchange) reject(orderID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, order, err := e.order(orderID)
+	if err != nil {
+		return err
+	}
+	if !isActive(order) {
+		return status.Error(codes.FailedPrecondition, "30059: order is not active")
+	}
+	order.ExecutionReportStatus = investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_REJECTED
+	return nil
+}
+
+func (e *exchange) cancelOrder(accountID, orderID string, sandbox bool) (*investapi.CancelOrderResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, err := e.account(accountID, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	order, ok := acc.orders[orderID]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "50005: order not found")
+	}
+	if !isActive(order) {
+		return nil, status.Error(codes.FailedPrecondition, "30059: order is not active")
+	}
+	order.ExecutionReportStatus = investapi.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED
+	return &investapi.CancelOrderResponse{Time: timestamppb.New(e.now())}, nil
+}
+
+func (e *exchange) orderState(accountID, orderID string, sandbox bool) (*investapi.OrderState, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, err := e.account(accountID, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	order, ok := acc.orders[orderID]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "50005: order not found")
+	}
+	return proto.Clone(order).(*investapi.OrderState), nil
+}
+
+func (e *exchange) activeOrders(accountID string, sandbox bool) ([]*investapi.OrderState, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, err := e.account(accountID, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	orders := []*investapi.OrderState{}
+	for _, order := range acc.orders {
+		if isActive(order) {
+			orders = append(orders, proto.Clone(order).(*investapi.OrderState))
+		}
+	}
+	sortOrders(orders)
+	return orders, nil
+}
+
+func (e *exchange) allOrders(accountID string) []*investapi.OrderState {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, ok := e.accounts[accountID]
+	if !ok {
+		return nil
+	}
+	orders := []*investapi.OrderState{}
+	for _, order := range acc.orders {
+		orders = append(orders, proto.Clone(order).(*investapi.OrderState))
+	}
+	sortOrders(orders)
+	return orders
+}
+
+func sortOrders(orders []*investapi.OrderState) {
+	sort.SliceStable(orders, func(i, j int) bool {
+		return orders[i].GetOrderDate().AsTime().Before(orders[j].GetOrderDate().AsTime())
+	})
+}
+
+func (e *exchange) positions(accountID string, sandbox bool) (*investapi.PositionsResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, err := e.account(accountID, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	// active orders block the money of buys and the pieces of sells, balances are what's left free
+	blockedMoney := money.Totals{}
+	blockedPieces := make(map[string]int64)
+	for _, order := range acc.orders {
+		if !isActive(order) {
+			continue
+		}
+		pieces := (order.LotsRequested - order.LotsExecuted) * int64(e.shares[order.Figi].GetLot())
+		if order.Direction == investapi.OrderDirection_ORDER_DIRECTION_SELL {
+			blockedPieces[order.Figi] += pieces
+			continue
+		}
+		price := money.FromMoneyValue(order.InitialSecurityPrice)
+		blockedMoney.Add(money.NewMoney(price.Amount.MulInt(pieces), price.Currency))
+	}
+	resp := &investapi.PositionsResponse{}
+	for currency, amount := range acc.money {
+		blocked := blockedMoney.Get(currency).Amount
+		resp.Money = append(resp.Money, moneyValue(currency, amount.Sub(blocked)))
+		if !blocked.IsZero() {
+			resp.Blocked = append(resp.Blocked, moneyValue(currency, blocked))
+		}
+	}
+	for figi, balance := range acc.positions {
+		if balance == 0 {
+			continue
+		}
+		resp.Securities = append(resp.Securities, &investapi.PositionsSecurities{
+			Figi:           figi,
+			Balance:        balance - blockedPieces[figi],
+			Blocked:        blockedPieces[figi],
+			InstrumentType: "share",
+		})
+	}
+	sort.Slice(resp.Money, func(i, j int) bool { return resp.Money[i].Currency < resp.Money[j].Currency })
+	sort.Slice(resp.Blocked, func(i, j int) bool { return resp.Blocked[i].Currency < resp.Blocked[j].Currency })
+	sort.Slice(resp.Securities, func(i, j int) bool { return resp.Securities[i].Figi < resp.Securities[j].Figi })
+	return resp, nil
+}
+
+func (e *exchange) operations(req *investapi.OperationsRequest, sandbox bool) ([]*investapi.Operation, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, err := e.account(req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	operations := []*investapi.Operation{}
+	for _, operation := range acc.operations {
+		date := operation.Date.AsTime()
+		if req.From != nil && date.Before(req.From.AsTime()) {
+			continue
+		}
+		if req.To != nil && date.After(req.To.AsTime()) {
+			continue
+		}
+		if req.Figi != "" && operation.Figi != req.Figi {
+			continue
+		}
+		if req.State != investapi.OperationState_OPERATION_STATE_UNSPECIFIED && operation.State != req.State {
+			continue
+		}
+		operations = append(operations, proto.Clone(operation).(*investapi.Operation))
+	}
+	return operations, nil
+}
+
+// averagePrice is the average buy price of the open long position, sells don't change it.
+func averagePrice(operations []*investapi.Operation, figi string) (money.Decimal, bool) {
+	qty := int64(0)
+	cost := money.Zero
+	for _, operation := range operations {
+		if operation.Figi != figi || operation.Quantity == 0 {
+			continue
+		}
+		price := money.FromMoneyValue(operation.Price).Amount
+		switch operation.OperationType {
+		case investapi.OperationType_OPERATION_TYPE_BUY:
+			cost = cost.Add(price.MulInt(operation.Quantity))
+			qty += operation.Quantity
+		case investapi.OperationType_OPERATION_TYPE_SELL:
+			if qty > 0 {
+				cost = cost.Sub(cost.DivInt(qty).MulInt(operation.Quantity))
+				qty -= operation.Quantity
+			}
+			if qty <= 0 {
+				qty, cost = 0, money.Zero
+			}
+		}
+	}
+	if qty <= 0 {
+		return money.Zero, false
+	}
+	return cost.DivInt(qty), true
+}
+
+func (e *exchange) portfolio(accountID string, sandbox bool) (*investapi.PortfolioResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, err := e.account(accountID, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	resp := &investapi.PortfolioResponse{}
+	shares := money.Zero
+	for figi, balance := range acc.positions {
+		if balance == 0 {
+			continue
+		}
+		share := e.shares[figi]
+		position := &investapi.PortfolioPosition{
+			Figi:           figi,
+			InstrumentType: "share",
+			Quantity:       &investapi.Quotation{Units: balance},
+		}
+		if share.GetLot() > 0 {
+			position.QuantityLots = &investapi.Quotation{Units: balance / int64(share.Lot)}
+		}
+		if average, ok := averagePrice(acc.operations, figi); ok {
+			position.AveragePositionPrice = moneyValue(share.GetCurrency(), average)
+			position.AveragePositionPriceFifo = position.AveragePositionPrice
+		}
+		if last, ok := e.lastPrices[figi]; ok {
+			position.CurrentPrice = moneyValue(share.GetCurrency(), money.FromQuotation(last.Price))
+			shares = shares.Add(money.FromQuotation(last.Price).MulInt(balance))
+		}
+		resp.Positions = append(resp.Positions, position)
+	}
+	sort.Slice(resp.Positions, func(i, j int) bool { return resp.Positions[i].Figi < resp.Positions[j].Figi })
+	resp.TotalAmountShares = moneyValue(money.RUB, shares)
+	resp.TotalAmountCurrencies = moneyValue(money.RUB, acc.money.Get(money.RUB).Amount)
+	resp.TotalAmountBonds = moneyValue(money.RUB, money.Zero)
+	resp.TotalAmountEtf = moneyValue(money.RUB, money.Zero)
+	resp.TotalAmountFutures = moneyValue(money.RUB, money.Zero)
+	return resp, nil
+}
+
+// brokerReport lists trades of the account made in the period, as the broker report does.
+func (e *exchange) brokerReport(accountID string, from, to time.Time) ([]*investapi.BrokerReport, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, err := e.account(accountID, false)
+	if err != nil {
+		return nil, err
+	}
+	orderByOperation := make(map[string]string, len(acc.orderOperations))
+	for orderID, operation := range acc.orderOperations {
+		orderByOperation[operation.Id] = orderID
+	}
+	fees := make(map[string]money.Decimal)
+	for _, operation := range acc.operations {
+		if operation.OperationType == investapi.OperationType_OPERATION_TYPE_BROKER_FEE {
+			fees[operation.ParentOperationId] = money.FromMoneyValue(operation.Payment).Amount.Neg()
+		}
+	}
+
+	rows := []*investapi.BrokerReport{}
+	for _, operation := range acc.operations {
+		direction := "Продажа"
+		switch operation.OperationType {
+		case investapi.OperationType_OPERATION_TYPE_BUY:
+			direction = "Покупка"
+		case investapi.OperationType_OPERATION_TYPE_SELL:
+		default:
+			continue
+		}
+		share := e.shares[operation.Figi]
+		for _, trade := range operation.Trades {
+			date := trade.DateTime.AsTime()
+			if date.Before(from) || date.After(to) {
+				continue
+			}
+			price := money.FromMoneyValue(trade.Price).Amount
+			amount := price.MulInt(trade.Quantity)
+			// the fee of the order is split between its trades by quantity
+			commission := fees[operation.Id].MulInt(trade.Quantity).DivInt(operation.Quantity)
+			rows = append(rows, &investapi.BrokerReport{
+				TradeId:            trade.TradeId,
+				OrderId:            orderByOperation[operation.Id],
+				Figi:               operation.Figi,
+				ExecuteSign:        "Да",
+				TradeDatetime:      trade.DateTime,
+				Exchange:           share.GetExchange(),
+				ClassCode:          share.GetClassCode(),
+				Direction:          direction,
+				Name:               share.GetName(),
+				Ticker:             share.GetTicker(),
+				Price:              trade.Price,
+				Quantity:           trade.Quantity,
+				OrderAmount:        moneyValue(operation.Currency, amount),
+				AciValue:           &investapi.Quotation{},
+				TotalOrderAmount:   moneyValue(operation.Currency, amount),
+				BrokerCommission:   moneyValue(operation.Currency, commission),
+				ExchangeCommission: moneyValue(operation.Currency, money.Zero),
+				Party:              "Fake exchange",
+				ClearValueDate:     trade.DateTime,
+				SecValueDate:       trade.DateTime,
+			})
+		}
+	}
+	return rows, nil
+}
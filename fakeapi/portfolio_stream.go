@@ -0,0 +1,97 @@
+package fakeapi
+
+import (
+	"sync"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+type portfolioSubscriber struct {
+	accounts map[string]bool
+	changed  chan string
+}
+
+// operationsStreamService sends a portfolio snapshot of every subscribed live account
+// on subscription and after each of its trades.
+type operationsStreamService struct {
+	investapi.UnimplementedOperationsStreamServiceServer
+	exchange *exchange
+
+	mu          sync.Mutex
+	subscribers map[*portfolioSubscriber]struct{}
+}
+
+func newOperationsStreamService() *operationsStreamService {
+	return &operationsStreamService{subscribers: make(map[*portfolioSubscriber]struct{})}
+}
+
+func (o *operationsStreamService) PortfolioStream(req *investapi.PortfolioStreamRequest, stream investapi.OperationsStreamService_PortfolioStreamServer) error {
+	subscriber := &portfolioSubscriber{
+		accounts: make(map[string]bool),
+		changed:  make(chan string, streamBufferSize),
+	}
+	subscription := &investapi.PortfolioSubscriptionResult{}
+	for _, accountID := range req.Accounts {
+		subscriber.accounts[accountID] = true
+		result := &investapi.AccountSubscriptionStatus{
+			AccountId:          accountID,
+			SubscriptionStatus: investapi.PortfolioSubscriptionStatus_PORTFOLIO_SUBSCRIPTION_STATUS_SUCCESS,
+		}
+		if _, err := o.exchange.portfolio(accountID, false); err != nil {
+			result.SubscriptionStatus = investapi.PortfolioSubscriptionStatus_PORTFOLIO_SUBSCRIPTION_STATUS_ACCOUNT_NOT_FOUND
+			delete(subscriber.accounts, accountID)
+		}
+		subscription.Accounts = append(subscription.Accounts, result)
+	}
+	err := stream.Send(&investapi.PortfolioStreamResponse{
+		Payload: &investapi.PortfolioStreamResponse_Subscriptions{Subscriptions: subscription},
+	})
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.subscribers[subscriber] = struct{}{}
+	o.mu.Unlock()
+	defer func() {
+		o.mu.Lock()
+		delete(o.subscribers, subscriber)
+		o.mu.Unlock()
+	}()
+
+	for accountID := range subscriber.accounts {
+		subscriber.changed <- accountID
+	}
+	for {
+		select {
+		case accountID := <-subscriber.changed:
+			portfolio, err := o.exchange.portfolio(accountID, false)
+			if err != nil {
+				return err
+			}
+			err = stream.Send(&investapi.PortfolioStreamResponse{
+				Payload: &investapi.PortfolioStreamResponse_Portfolio{Portfolio: portfolio},
+			})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// publish may be called with the exchange locked, the snapshot is taken by the stream goroutine.
+func (o *operationsStreamService) publish(trades *investapi.OrderTrades) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for subscriber := range o.subscribers {
+		if !subscriber.accounts[trades.AccountId] {
+			continue
+		}
+		select {
+		case subscriber.changed <- trades.AccountId:
+		default:
+		}
+	}
+}
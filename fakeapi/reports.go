@@ -0,0 +1,156 @@
+package fakeapi
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultReportPageSize = 100
+	// defaultReportPolls is how many times a generated report is answered as not ready
+	defaultReportPolls = 1
+)
+
+type reportTask struct {
+	pollsLeft int
+	broker    []*investapi.BrokerReport
+	dividends []*investapi.DividendsForeignIssuerReport
+}
+
+// reports keeps generated report tasks, a report is ready after a few polls.
+type reports struct {
+	mu        sync.Mutex
+	pageSize  int
+	polls     int
+	tasks     map[string]*reportTask
+	dividends map[string][]*investapi.DividendsForeignIssuerReport
+}
+
+func newReports() *reports {
+	return &reports{
+		pageSize:  defaultReportPageSize,
+		polls:     defaultReportPolls,
+		tasks:     make(map[string]*reportTask),
+		dividends: make(map[string][]*investapi.DividendsForeignIssuerReport),
+	}
+}
+
+func (r *reports) setOptions(polls, pageSize int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.polls = polls
+	if pageSize > 0 {
+		r.pageSize = pageSize
+	}
+}
+
+func (r *reports) addDividend(accountID string, dividend *investapi.DividendsForeignIssuerReport) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dividends[accountID] = append(r.dividends[accountID], dividend)
+}
+
+func (r *reports) add(task *reportTask) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	taskID := uuid.New().String()
+	task.pollsLeft = r.polls
+	r.tasks[taskID] = task
+	return taskID
+}
+
+// page returns the task when it's ready, with the bounds of the requested page.
+func (r *reports) page(taskID string, page int32, rows func(*reportTask) int) (task *reportTask, start, end int, pagesCount int32, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, ok := r.tasks[taskID]
+	if !ok {
+		return nil, 0, 0, 0, status.Error(codes.NotFound, "50009: report task not found")
+	}
+	if task.pollsLeft > 0 {
+		task.pollsLeft--
+		return nil, 0, 0, 0, status.Error(codes.FailedPrecondition, "30058: task not completed yet, please try again later")
+	}
+	count := rows(task)
+	pagesCount = int32((count + r.pageSize - 1) / r.pageSize)
+	start = int(page) * r.pageSize
+	if page < 0 || start > count {
+		return nil, 0, 0, 0, status.Error(codes.InvalidArgument, "30059: page is out of range")
+	}
+	end = start + r.pageSize
+	if end > count {
+		end = count
+	}
+	return task, start, end, pagesCount, nil
+}
+
+func (s *operationsService) GetBrokerReport(ctx context.Context, req *investapi.BrokerReportRequest) (*investapi.BrokerReportResponse, error) {
+	if generate := req.GetGenerateBrokerReportRequest(); generate != nil {
+		rows, err := s.exchange.brokerReport(generate.AccountId, generate.From.AsTime(), generate.To.AsTime())
+		if err != nil {
+			return nil, err
+		}
+		taskID := s.reports.add(&reportTask{broker: rows})
+		return &investapi.BrokerReportResponse{Payload: &investapi.BrokerReportResponse_GenerateBrokerReportResponse{
+			GenerateBrokerReportResponse: &investapi.GenerateBrokerReportResponse{TaskId: taskID},
+		}}, nil
+	}
+	get := req.GetGetBrokerReportRequest()
+	if get == nil {
+		return nil, status.Error(codes.InvalidArgument, "30001: payload is required")
+	}
+	task, start, end, pagesCount, err := s.reports.page(get.TaskId, get.Page, func(task *reportTask) int { return len(task.broker) })
+	if err != nil {
+		return nil, err
+	}
+	return &investapi.BrokerReportResponse{Payload: &investapi.BrokerReportResponse_GetBrokerReportResponse{
+		GetBrokerReportResponse: &investapi.GetBrokerReportResponse{
+			BrokerReport: task.broker[start:end],
+			ItemsCount:   int32(len(task.broker)),
+			PagesCount:   pagesCount,
+			Page:         get.Page,
+		},
+	}}, nil
+}
+
+func (s *operationsService) GetDividendsForeignIssuer(ctx context.Context, req *investapi.GetDividendsForeignIssuerRequest) (*investapi.GetDividendsForeignIssuerResponse, error) {
+	if generate := req.GetGenerateDivForeignIssuerReport(); generate != nil {
+		if _, err := s.exchange.positions(generate.AccountId, false); err != nil {
+			return nil, err
+		}
+		rows := []*investapi.DividendsForeignIssuerReport{}
+		s.reports.mu.Lock()
+		for _, dividend := range s.reports.dividends[generate.AccountId] {
+			date := dividend.PaymentDate.AsTime()
+			if !date.Before(generate.From.AsTime()) && !date.After(generate.To.AsTime()) {
+				rows = append(rows, dividend)
+			}
+		}
+		s.reports.mu.Unlock()
+		taskID := s.reports.add(&reportTask{dividends: rows})
+		return &investapi.GetDividendsForeignIssuerResponse{Payload: &investapi.GetDividendsForeignIssuerResponse_GenerateDivForeignIssuerReportResponse{
+			GenerateDivForeignIssuerReportResponse: &investapi.GenerateDividendsForeignIssuerReportResponse{TaskId: taskID},
+		}}, nil
+	}
+	get := req.GetGetDivForeignIssuerReport()
+	if get == nil {
+		return nil, status.Error(codes.InvalidArgument, "30001: payload is required")
+	}
+	task, start, end, pagesCount, err := s.reports.page(get.TaskId, get.Page, func(task *reportTask) int { return len(task.dividends) })
+	if err != nil {
+		return nil, err
+	}
+	return &investapi.GetDividendsForeignIssuerResponse{Payload: &investapi.GetDividendsForeignIssuerResponse_DivForeignIssuerReport{
+		DivForeignIssuerReport: &investapi.GetDividendsForeignIssuerReportResponse{
+			DividendsForeignIssuerReport: task.dividends[start:end],
+			ItemsCount:                   int32(len(task.dividends)),
+			PagesCount:                   pagesCount,
+			Page:                         get.Page,
+		},
+	}}, nil
+}
@@ -0,0 +1,60 @@
+package fakeapi
+
+import (
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/calendar"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (e *exchange) setTradingDay(exchange string, day *investapi.TradingDay) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	days, ok := e.schedules[exchange]
+	if !ok {
+		days = make(map[time.Time]*investapi.TradingDay)
+		e.schedules[exchange] = days
+	}
+	days[moscowDate(day.GetDate().AsTime())] = day
+}
+
+func (e *exchange) tradingSchedule(exchange string, from, to time.Time) *investapi.TradingSchedule {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	schedule := &investapi.TradingSchedule{Exchange: exchange}
+	for date := moscowDate(from); date.Before(to); date = date.AddDate(0, 0, 1) {
+		day, ok := e.schedules[exchange][date]
+		if !ok {
+			day = defaultTradingDay(date)
+		}
+		schedule.Days = append(schedule.Days, day)
+	}
+	return schedule
+}
+
+func moscowDate(t time.Time) time.Time {
+	t = t.In(calendar.Moscow)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, calendar.Moscow)
+}
+
+// defaultTradingDay follows the stock market of Moscow Exchange: weekdays with auctions
+// and the evening session, weekends are closed.
+func defaultTradingDay(date time.Time) *investapi.TradingDay {
+	day := &investapi.TradingDay{Date: timestamppb.New(date)}
+	if date.Weekday() == time.Saturday || date.Weekday() == time.Sunday {
+		return day
+	}
+	at := func(hour, min int) *timestamppb.Timestamp {
+		return timestamppb.New(date.Add(time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute))
+	}
+	day.IsTradingDay = true
+	day.OpeningAuctionStartTime = at(9, 50)
+	day.StartTime = at(10, 0)
+	day.EndTime = at(18, 40)
+	day.ClosingAuctionEndTime = at(18, 50)
+	day.EveningOpeningAuctionStartTime = at(19, 0)
+	day.EveningStartTime = at(19, 5)
+	day.EveningEndTime = at(23, 50)
+	return day
+}
@@ -0,0 +1,294 @@
+package fakeapi
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	bufSize          = 1024 * 1024
+	streamBufferSize = 100
+	trackingIDHeader = "x-tracking-id"
+)
+
+// Server is an in-process replacement of invest-public-api.tinkoff.ru.
+// Instruments, candles and order outcomes are scripted by the caller.
+type Server struct {
+	// Token if set, requests without "Bearer <Token>" are rejected as Unauthenticated
+	Token string
+	// Now returns server time for orders and operations
+	Now func() time.Time
+
+	exchange   *exchange
+	stream     *marketDataStreamService
+	trades     *ordersStreamService
+	portfolios *operationsStreamService
+	reports    *reports
+	users      *usersService
+	faults     *faults
+	grpc       *grpc.Server
+
+	mu       sync.Mutex
+	bufconn  *bufconn.Listener
+	stopOnce sync.Once
+}
+
+func New() *Server {
+	s := &Server{
+		Now: time.Now,
+	}
+	s.trades = newOrdersStreamService()
+	s.portfolios = newOperationsStreamService()
+	s.exchange = newExchange(s.now, func(trades *investapi.OrderTrades) {
+		s.trades.publish(trades)
+		s.portfolios.publish(trades)
+	})
+	s.portfolios.exchange = s.exchange
+	s.stream = newMarketDataStreamService()
+	s.reports = newReports()
+	s.users = &usersService{exchange: s.exchange, tariff: defaultTariff()}
+	s.faults = newFaults()
+	s.grpc = grpc.NewServer(grpc.UnaryInterceptor(s.authUnary), grpc.StreamInterceptor(s.trackStream))
+
+	investapi.RegisterSandboxServiceServer(s.grpc, &sandboxService{exchange: s.exchange})
+	investapi.RegisterOrdersServiceServer(s.grpc, &ordersService{exchange: s.exchange})
+	investapi.RegisterOrdersStreamServiceServer(s.grpc, s.trades)
+	investapi.RegisterUsersServiceServer(s.grpc, s.users)
+	investapi.RegisterOperationsServiceServer(s.grpc, &operationsService{exchange: s.exchange, reports: s.reports})
+	investapi.RegisterOperationsStreamServiceServer(s.grpc, s.portfolios)
+	investapi.RegisterMarketDataServiceServer(s.grpc, &marketDataService{exchange: s.exchange})
+	investapi.RegisterMarketDataStreamServiceServer(s.grpc, s.stream)
+	investapi.RegisterInstrumentsServiceServer(s.grpc, &instrumentsService{exchange: s.exchange})
+	investapi.RegisterStopOrdersServiceServer(s.grpc, &stopOrdersService{exchange: s.exchange})
+	return s
+}
+
+func (s *Server) now() time.Time {
+	return s.Now()
+}
+
+// ServeBufconn starts serving on an in-memory listener, it's started once per server.
+func (s *Server) ServeBufconn() *bufconn.Listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.bufconn == nil {
+		s.bufconn = bufconn.Listen(bufSize)
+		go s.grpc.Serve(s.bufconn)
+	}
+	return s.bufconn
+}
+
+// ServeTCP starts serving plain (non TLS) gRPC on addr, e.g. "127.0.0.1:0".
+func (s *Server) ServeTCP(addr string) (net.Addr, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail listen tcp")
+	}
+	go s.grpc.Serve(lis)
+	return lis.Addr(), nil
+}
+
+// Dial connects an api.Client to the server over bufconn.
+func (s *Server) Dial(sandbox bool, opts ...api.Option) (*api.Client, error) {
+	lis := s.ServeBufconn()
+	opts = append(opts,
+		api.Insecure(),
+		api.DialOptions(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		})),
+	)
+	return api.NewWithOpts(s.Token, "bufnet", sandbox, opts...)
+}
+
+func (s *Server) Stop() {
+	s.stopOnce.Do(s.grpc.Stop)
+}
+
+func (s *Server) authUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	// every response carries a tracking id like the real API does
+	_ = grpc.SetHeader(ctx, metadata.Pairs(trackingIDHeader, uuid.New().String()))
+	if err := s.faults.check(strings.TrimPrefix(info.FullMethod, "/")); err != nil {
+		return nil, err
+	}
+	if s.Token == "" {
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if value == "Bearer "+s.Token {
+			return handler(ctx, req)
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "40003: authentication token is missing or invalid")
+}
+
+func (s *Server) trackStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	_ = stream.SetHeader(metadata.Pairs(trackingIDHeader, uuid.New().String()))
+	return handler(srv, stream)
+}
+
+// SetTariff replaces limits returned by GetUserTariff.
+func (s *Server) SetTariff(tariff *investapi.GetUserTariffResponse) {
+	s.users.mu.Lock()
+	defer s.users.mu.Unlock()
+	s.users.tariff = tariff
+}
+
+// FailCalls makes the next count calls of method fail with code, method is "Service/Method"
+// with the package, e.g. "tinkoff.public.invest.api.contract.v1.OrdersService/PostOrder".
+func (s *Server) FailCalls(method string, count int, code codes.Code) {
+	s.faults.fail(method, count, code)
+}
+
+// Calls returns how many times method was called.
+func (s *Server) Calls(method string) int {
+	return s.faults.count(method)
+}
+
+// TotalCalls returns how many unary calls of any method were made, e.g. to wait until clients are idle.
+func (s *Server) TotalCalls() int {
+	return s.faults.countAll()
+}
+
+// AddShare registers a share, it can then be found by figi, ticker+class_code or uid.
+func (s *Server) AddShare(share *investapi.Share) {
+	s.exchange.addShare(share)
+}
+
+// AddCandles appends candles of figi for interval, the close of the latest candle becomes the last price.
+func (s *Server) AddCandles(figi string, interval investapi.CandleInterval, candles ...*investapi.HistoricCandle) {
+	s.exchange.addCandles(figi, interval, candles)
+}
+
+// SetLastPrice updates the last price, stop orders reached by it are triggered.
+func (s *Server) SetLastPrice(figi string, price *investapi.Quotation) {
+	s.exchange.setLastPrice(figi, price)
+	s.exchange.triggerStops(figi, money.FromQuotation(price))
+}
+
+// SetTradingDay replaces the default schedule of exchange for the day, e.g. to make a holiday.
+func (s *Server) SetTradingDay(exchange string, day *investapi.TradingDay) {
+	s.exchange.setTradingDay(exchange, day)
+}
+
+func (s *Server) SetTradingStatus(figi string, tradingStatus investapi.SecurityTradingStatus) {
+	s.exchange.setTradingStatus(figi, tradingStatus)
+	s.stream.publish(kindInfo, figi, &investapi.MarketDataResponse{
+		Payload: &investapi.MarketDataResponse_TradingStatus{TradingStatus: &investapi.TradingStatus{
+			Figi:          figi,
+			TradingStatus: tradingStatus,
+			Time:          timestamppb.New(s.now()),
+		}},
+	})
+}
+
+// PublishCandle sends the candle to streams subscribed to its figi.
+func (s *Server) PublishCandle(candle *investapi.Candle) {
+	s.stream.publish(kindCandles, candle.Figi, &investapi.MarketDataResponse{
+		Payload: &investapi.MarketDataResponse_Candle{Candle: candle},
+	})
+}
+
+func (s *Server) PublishOrderBook(orderBook *investapi.OrderBook) {
+	s.stream.publish(kindOrderBook, orderBook.Figi, &investapi.MarketDataResponse{
+		Payload: &investapi.MarketDataResponse_Orderbook{Orderbook: orderBook},
+	})
+}
+
+func (s *Server) PublishTrade(trade *investapi.Trade) {
+	s.stream.publish(kindTrades, trade.Figi, &investapi.MarketDataResponse{
+		Payload: &investapi.MarketDataResponse_Trade{Trade: trade},
+	})
+}
+
+// PublishLastPrice updates the last price and sends it to subscribed streams.
+func (s *Server) PublishLastPrice(figi string, price *investapi.Quotation) {
+	s.exchange.setLastPrice(figi, price)
+	s.exchange.triggerStops(figi, money.FromQuotation(price))
+	s.stream.publish(kindLastPrice, figi, &investapi.MarketDataResponse{
+		Payload: &investapi.MarketDataResponse_LastPrice{LastPrice: s.exchange.lastPrice(figi)},
+	})
+}
+
+// DropStreams breaks all open market data streams with Unavailable.
+func (s *Server) DropStreams() {
+	s.stream.drop()
+}
+
+// OnPostOrder sets the script deciding what happens to every posted order.
+// Without a script market orders are filled at the last price and limit orders rest.
+func (s *Server) OnPostOrder(script OrderScript) {
+	s.exchange.setScript(script)
+}
+
+// AddAccount opens a real (non sandbox) account visible through UsersService.
+func (s *Server) AddAccount(accountID string) {
+	s.exchange.openAccount(accountID, false)
+}
+
+// PayIn adds money to any account, sandbox or real.
+func (s *Server) PayIn(accountID string, amount *investapi.MoneyValue) error {
+	_, err := s.exchange.payIn(accountID, amount)
+	return err
+}
+
+// SetCommission sets the broker fee as a rate of the trade amount, e.g. 0.0005, zero disables fees.
+func (s *Server) SetCommission(rate money.Decimal) {
+	s.exchange.setCommission(rate)
+}
+
+// SetReportOptions sets how many polls a generated report is not ready for and the rows per page.
+func (s *Server) SetReportOptions(polls, pageSize int) {
+	s.reports.setOptions(polls, pageSize)
+}
+
+// AddForeignDividend adds a row to the dividends report of a real account.
+func (s *Server) AddForeignDividend(accountID string, dividend *investapi.DividendsForeignIssuerReport) {
+	s.reports.addDividend(accountID, dividend)
+}
+
+// FillOrder executes lots of an active order at its price, zero lots fills the rest.
+func (s *Server) FillOrder(orderID string, lots int64) error {
+	return s.exchange.fill(orderID, lots)
+}
+
+// FillOrderAt executes lots of an active order at price with the commission instead of the rate
+// set by SetCommission, zero lots fills the rest.
+func (s *Server) FillOrderAt(orderID string, lots int64, price, commission money.Decimal) error {
+	return s.exchange.execute(orderID, lots, &execution{price: price, commission: commission})
+}
+
+func (s *Server) RejectOrder(orderID string) error {
+	return s.exchange.reject(orderID)
+}
+
+// StopOrders returns active stop orders of the account.
+func (s *Server) StopOrders(accountID string) ([]*investapi.StopOrder, error) {
+	return s.exchange.stopOrders(accountID)
+}
+
+// TriggerStopOrder turns the stop order into an exchange order as if its price was reached
+// and returns the id of the order.
+func (s *Server) TriggerStopOrder(accountID, stopOrderID string) (string, error) {
+	return s.exchange.triggerStop(accountID, stopOrderID)
+}
+
+// Orders returns all orders ever posted to the account, including finished ones.
+func (s *Server) Orders(accountID string) []*investapi.OrderState {
+	return s.exchange.allOrders(accountID)
+}
@@ -0,0 +1,261 @@
+package fakeapi
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type sandboxService struct {
+	investapi.UnimplementedSandboxServiceServer
+	exchange *exchange
+}
+
+func (s *sandboxService) OpenSandboxAccount(ctx context.Context, req *investapi.OpenSandboxAccountRequest) (*investapi.OpenSandboxAccountResponse, error) {
+	return &investapi.OpenSandboxAccountResponse{AccountId: s.exchange.openAccount("", true)}, nil
+}
+
+func (s *sandboxService) GetSandboxAccounts(ctx context.Context, req *investapi.GetAccountsRequest) (*investapi.GetAccountsResponse, error) {
+	return &investapi.GetAccountsResponse{Accounts: s.exchange.listAccounts(true)}, nil
+}
+
+func (s *sandboxService) CloseSandboxAccount(ctx context.Context, req *investapi.CloseSandboxAccountRequest) (*investapi.CloseSandboxAccountResponse, error) {
+	if err := s.exchange.closeAccount(req.AccountId, true); err != nil {
+		return nil, err
+	}
+	return &investapi.CloseSandboxAccountResponse{}, nil
+}
+
+func (s *sandboxService) PostSandboxOrder(ctx context.Context, req *investapi.PostOrderRequest) (*investapi.PostOrderResponse, error) {
+	return s.exchange.postOrder(req, true)
+}
+
+func (s *sandboxService) GetSandboxOrders(ctx context.Context, req *investapi.GetOrdersRequest) (*investapi.GetOrdersResponse, error) {
+	orders, err := s.exchange.activeOrders(req.AccountId, true)
+	if err != nil {
+		return nil, err
+	}
+	return &investapi.GetOrdersResponse{Orders: orders}, nil
+}
+
+func (s *sandboxService) CancelSandboxOrder(ctx context.Context, req *investapi.CancelOrderRequest) (*investapi.CancelOrderResponse, error) {
+	return s.exchange.cancelOrder(req.AccountId, req.OrderId, true)
+}
+
+func (s *sandboxService) GetSandboxOrderState(ctx context.Context, req *investapi.GetOrderStateRequest) (*investapi.OrderState, error) {
+	return s.exchange.orderState(req.AccountId, req.OrderId, true)
+}
+
+func (s *sandboxService) GetSandboxPositions(ctx context.Context, req *investapi.PositionsRequest) (*investapi.PositionsResponse, error) {
+	return s.exchange.positions(req.AccountId, true)
+}
+
+func (s *sandboxService) GetSandboxOperations(ctx context.Context, req *investapi.OperationsRequest) (*investapi.OperationsResponse, error) {
+	operations, err := s.exchange.operations(req, true)
+	if err != nil {
+		return nil, err
+	}
+	return &investapi.OperationsResponse{Operations: operations}, nil
+}
+
+func (s *sandboxService) GetSandboxPortfolio(ctx context.Context, req *investapi.PortfolioRequest) (*investapi.PortfolioResponse, error) {
+	return s.exchange.portfolio(req.AccountId, true)
+}
+
+func (s *sandboxService) SandboxPayIn(ctx context.Context, req *investapi.SandboxPayInRequest) (*investapi.SandboxPayInResponse, error) {
+	balance, err := s.exchange.payIn(req.AccountId, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return &investapi.SandboxPayInResponse{Balance: balance}, nil
+}
+
+type ordersService struct {
+	investapi.UnimplementedOrdersServiceServer
+	exchange *exchange
+}
+
+func (s *ordersService) PostOrder(ctx context.Context, req *investapi.PostOrderRequest) (*investapi.PostOrderResponse, error) {
+	return s.exchange.postOrder(req, false)
+}
+
+func (s *ordersService) CancelOrder(ctx context.Context, req *investapi.CancelOrderRequest) (*investapi.CancelOrderResponse, error) {
+	return s.exchange.cancelOrder(req.AccountId, req.OrderId, false)
+}
+
+func (s *ordersService) GetOrderState(ctx context.Context, req *investapi.GetOrderStateRequest) (*investapi.OrderState, error) {
+	return s.exchange.orderState(req.AccountId, req.OrderId, false)
+}
+
+func (s *ordersService) GetOrders(ctx context.Context, req *investapi.GetOrdersRequest) (*investapi.GetOrdersResponse, error) {
+	orders, err := s.exchange.activeOrders(req.AccountId, false)
+	if err != nil {
+		return nil, err
+	}
+	return &investapi.GetOrdersResponse{Orders: orders}, nil
+}
+
+type usersService struct {
+	investapi.UnimplementedUsersServiceServer
+	exchange *exchange
+
+	mu     sync.Mutex
+	tariff *investapi.GetUserTariffResponse
+}
+
+func (s *usersService) GetAccounts(ctx context.Context, req *investapi.GetAccountsRequest) (*investapi.GetAccountsResponse, error) {
+	return &investapi.GetAccountsResponse{Accounts: s.exchange.listAccounts(false)}, nil
+}
+
+type operationsService struct {
+	investapi.UnimplementedOperationsServiceServer
+	exchange *exchange
+	reports  *reports
+}
+
+func (s *operationsService) GetOperations(ctx context.Context, req *investapi.OperationsRequest) (*investapi.OperationsResponse, error) {
+	operations, err := s.exchange.operations(req, false)
+	if err != nil {
+		return nil, err
+	}
+	return &investapi.OperationsResponse{Operations: operations}, nil
+}
+
+func (s *operationsService) GetPortfolio(ctx context.Context, req *investapi.PortfolioRequest) (*investapi.PortfolioResponse, error) {
+	return s.exchange.portfolio(req.AccountId, false)
+}
+
+func (s *operationsService) GetPositions(ctx context.Context, req *investapi.PositionsRequest) (*investapi.PositionsResponse, error) {
+	return s.exchange.positions(req.AccountId, false)
+}
+
+type marketDataService struct {
+	investapi.UnimplementedMarketDataServiceServer
+	exchange *exchange
+}
+
+func (s *marketDataService) GetCandles(ctx context.Context, req *investapi.GetCandlesRequest) (*investapi.GetCandlesResponse, error) {
+	if req.Interval == investapi.CandleInterval_CANDLE_INTERVAL_UNSPECIFIED {
+		return nil, status.Error(codes.InvalidArgument, "30014: interval is required")
+	}
+	if req.From == nil || req.To == nil {
+		return nil, status.Error(codes.InvalidArgument, "30007: from and to are required")
+	}
+	candles := s.exchange.getCandles(req.Figi, req.Interval, req.From.AsTime(), req.To.AsTime())
+	return &investapi.GetCandlesResponse{Candles: candles}, nil
+}
+
+func (s *marketDataService) GetLastPrices(ctx context.Context, req *investapi.GetLastPricesRequest) (*investapi.GetLastPricesResponse, error) {
+	resp := &investapi.GetLastPricesResponse{}
+	for _, figi := range req.Figi {
+		if last := s.exchange.lastPrice(figi); last != nil {
+			resp.LastPrices = append(resp.LastPrices, last)
+		}
+	}
+	return resp, nil
+}
+
+func (s *marketDataService) GetTradingStatus(ctx context.Context, req *investapi.GetTradingStatusRequest) (*investapi.GetTradingStatusResponse, error) {
+	tradingStatus := s.exchange.getTradingStatus(req.Figi)
+	available := tradingStatus == investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING
+	return &investapi.GetTradingStatusResponse{
+		Figi:                     req.Figi,
+		TradingStatus:            tradingStatus,
+		LimitOrderAvailableFlag:  available,
+		MarketOrderAvailableFlag: available,
+		ApiTradeAvailableFlag:    true,
+	}, nil
+}
+
+type instrumentsService struct {
+	investapi.UnimplementedInstrumentsServiceServer
+	exchange *exchange
+}
+
+func (s *instrumentsService) ShareBy(ctx context.Context, req *investapi.InstrumentRequest) (*investapi.ShareResponse, error) {
+	share := s.exchange.findShare(req.IdType, req.ClassCode, req.Id)
+	if share == nil {
+		return nil, status.Error(codes.NotFound, "50002: instrument not found")
+	}
+	return &investapi.ShareResponse{Instrument: share}, nil
+}
+
+func (s *instrumentsService) Shares(ctx context.Context, req *investapi.InstrumentsRequest) (*investapi.SharesResponse, error) {
+	return &investapi.SharesResponse{Instruments: s.exchange.allShares()}, nil
+}
+
+// Only shares are listed by the fake, other instrument kinds are always empty.
+func (s *instrumentsService) Bonds(ctx context.Context, req *investapi.InstrumentsRequest) (*investapi.BondsResponse, error) {
+	return &investapi.BondsResponse{}, nil
+}
+
+func (s *instrumentsService) Etfs(ctx context.Context, req *investapi.InstrumentsRequest) (*investapi.EtfsResponse, error) {
+	return &investapi.EtfsResponse{}, nil
+}
+
+func (s *instrumentsService) Currencies(ctx context.Context, req *investapi.InstrumentsRequest) (*investapi.CurrenciesResponse, error) {
+	return &investapi.CurrenciesResponse{}, nil
+}
+
+func (s *instrumentsService) Futures(ctx context.Context, req *investapi.InstrumentsRequest) (*investapi.FuturesResponse, error) {
+	return &investapi.FuturesResponse{}, nil
+}
+
+func (s *instrumentsService) GetInstrumentBy(ctx context.Context, req *investapi.InstrumentRequest) (*investapi.InstrumentResponse, error) {
+	share := s.exchange.findShare(req.IdType, req.ClassCode, req.Id)
+	if share == nil {
+		return nil, status.Error(codes.NotFound, "50002: instrument not found")
+	}
+	return &investapi.InstrumentResponse{Instrument: &investapi.Instrument{
+		Figi:                  share.Figi,
+		Ticker:                share.Ticker,
+		ClassCode:             share.ClassCode,
+		Isin:                  share.Isin,
+		Lot:                   share.Lot,
+		Currency:              share.Currency,
+		ShortEnabledFlag:      share.ShortEnabledFlag,
+		Name:                  share.Name,
+		InstrumentType:        "share",
+		TradingStatus:         share.TradingStatus,
+		BuyAvailableFlag:      share.BuyAvailableFlag,
+		SellAvailableFlag:     share.SellAvailableFlag,
+		MinPriceIncrement:     share.MinPriceIncrement,
+		ApiTradeAvailableFlag: share.ApiTradeAvailableFlag,
+		Uid:                   share.Uid,
+	}}, nil
+}
+
+func (s *instrumentsService) FindInstrument(ctx context.Context, req *investapi.FindInstrumentRequest) (*investapi.FindInstrumentResponse, error) {
+	query := strings.ToUpper(req.Query)
+	resp := &investapi.FindInstrumentResponse{}
+	for _, share := range s.exchange.allShares() {
+		if !strings.Contains(strings.ToUpper(share.Ticker), query) &&
+			!strings.Contains(strings.ToUpper(share.Name), query) &&
+			share.Figi != req.Query && share.Isin != req.Query {
+			continue
+		}
+		resp.Instruments = append(resp.Instruments, &investapi.InstrumentShort{
+			Isin:                  share.Isin,
+			Figi:                  share.Figi,
+			Ticker:                share.Ticker,
+			ClassCode:             share.ClassCode,
+			InstrumentType:        "share",
+			Name:                  share.Name,
+			Uid:                   share.Uid,
+			ApiTradeAvailableFlag: share.ApiTradeAvailableFlag,
+		})
+	}
+	return resp, nil
+}
+
+func (s *instrumentsService) TradingSchedules(ctx context.Context, req *investapi.TradingSchedulesRequest) (*investapi.TradingSchedulesResponse, error) {
+	if req.Exchange == "" {
+		return nil, status.Error(codes.InvalidArgument, "30001: exchange is required by the fake")
+	}
+	schedule := s.exchange.tradingSchedule(req.Exchange, req.GetFrom().AsTime(), req.GetTo().AsTime())
+	return &investapi.TradingSchedulesResponse{Exchanges: []*investapi.TradingSchedule{schedule}}, nil
+}
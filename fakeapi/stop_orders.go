@@ -0,0 +1,196 @@
+package fakeapi
+
+import (
+	"context"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// stopOrdersService works only for live accounts, the real sandbox has no stop orders either.
+type stopOrdersService struct {
+	investapi.UnimplementedStopOrdersServiceServer
+	exchange *exchange
+}
+
+func (s *stopOrdersService) PostStopOrder(ctx context.Context, req *investapi.PostStopOrderRequest) (*investapi.PostStopOrderResponse, error) {
+	stopOrderID, err := s.exchange.postStopOrder(req)
+	if err != nil {
+		return nil, err
+	}
+	return &investapi.PostStopOrderResponse{StopOrderId: stopOrderID}, nil
+}
+
+func (s *stopOrdersService) GetStopOrders(ctx context.Context, req *investapi.GetStopOrdersRequest) (*investapi.GetStopOrdersResponse, error) {
+	stopOrders, err := s.exchange.stopOrders(req.AccountId)
+	if err != nil {
+		return nil, err
+	}
+	return &investapi.GetStopOrdersResponse{StopOrders: stopOrders}, nil
+}
+
+func (s *stopOrdersService) CancelStopOrder(ctx context.Context, req *investapi.CancelStopOrderRequest) (*investapi.CancelStopOrderResponse, error) {
+	err := s.exchange.cancelStopOrder(req.AccountId, req.StopOrderId)
+	if err != nil {
+		return nil, err
+	}
+	return &investapi.CancelStopOrderResponse{Time: timestamppb.New(s.exchange.now())}, nil
+}
+
+func (e *exchange) postStopOrder(req *investapi.PostStopOrderRequest) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, err := e.openedAccount(req.AccountId, false)
+	if err != nil {
+		return "", err
+	}
+	share, ok := e.shares[req.Figi]
+	if !ok {
+		return "", status.Error(codes.NotFound, "50002: instrument not found")
+	}
+	if req.Quantity <= 0 {
+		return "", status.Error(codes.InvalidArgument, "30003: quantity must be positive")
+	}
+	if req.StopPrice == nil {
+		return "", status.Error(codes.InvalidArgument, "30010: stop price is required")
+	}
+	if req.StopOrderType == investapi.StopOrderType_STOP_ORDER_TYPE_STOP_LIMIT && req.Price == nil {
+		return "", status.Error(codes.InvalidArgument, "30008: price is required for stop limit order")
+	}
+
+	stopOrder := &investapi.StopOrder{
+		StopOrderId:   uuid.New().String(),
+		LotsRequested: req.Quantity,
+		Figi:          req.Figi,
+		Direction:     req.Direction,
+		Currency:      share.Currency,
+		OrderType:     req.StopOrderType,
+		CreateDate:    timestamppb.New(e.now()),
+		StopPrice:     moneyValue(share.Currency, money.FromQuotation(req.StopPrice)),
+	}
+	if req.Price != nil {
+		stopOrder.Price = moneyValue(share.Currency, money.FromQuotation(req.Price))
+	}
+	if req.ExpirationType == investapi.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_GOOD_TILL_DATE {
+		stopOrder.ExpirationTime = req.ExpireDate
+	}
+	acc.stopOrders[stopOrder.StopOrderId] = stopOrder
+	return stopOrder.StopOrderId, nil
+}
+
+func (e *exchange) stopOrders(accountID string) ([]*investapi.StopOrder, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, err := e.account(accountID, false)
+	if err != nil {
+		return nil, err
+	}
+	stopOrders := []*investapi.StopOrder{}
+	for _, stopOrder := range acc.stopOrders {
+		stopOrders = append(stopOrders, proto.Clone(stopOrder).(*investapi.StopOrder))
+	}
+	sort.Slice(stopOrders, func(i, j int) bool {
+		return stopOrders[i].CreateDate.AsTime().Before(stopOrders[j].CreateDate.AsTime())
+	})
+	return stopOrders, nil
+}
+
+func (e *exchange) cancelStopOrder(accountID, stopOrderID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	acc, err := e.account(accountID, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := acc.stopOrders[stopOrderID]; !ok {
+		return status.Error(codes.NotFound, "50006: stop order not found")
+	}
+	delete(acc.stopOrders, stopOrderID)
+	return nil
+}
+
+// triggerStops turns stop orders of figi reached by price into exchange orders,
+// stop-limit orders become limit orders and the others market orders.
+func (e *exchange) triggerStops(figi string, price money.Decimal) {
+	e.mu.Lock()
+	triggered := []*investapi.PostOrderRequest{}
+	for accountID, acc := range e.accounts {
+		for id, stopOrder := range acc.stopOrders {
+			if stopOrder.Figi != figi || !stopReached(stopOrder, price) {
+				continue
+			}
+			delete(acc.stopOrders, id)
+			triggered = append(triggered, stopOrderRequest(accountID, stopOrder))
+		}
+	}
+	e.mu.Unlock()
+
+	for _, req := range triggered {
+		// a failed order is just lost, like a stop order rejected by the exchange
+		_, _ = e.postOrder(req, false)
+	}
+}
+
+// triggerStop turns the stop order into an exchange order whatever the price is and returns its id.
+func (e *exchange) triggerStop(accountID, stopOrderID string) (string, error) {
+	e.mu.Lock()
+	acc, err := e.account(accountID, false)
+	if err != nil {
+		e.mu.Unlock()
+		return "", err
+	}
+	stopOrder, ok := acc.stopOrders[stopOrderID]
+	if !ok {
+		e.mu.Unlock()
+		return "", status.Error(codes.NotFound, "50006: stop order not found")
+	}
+	delete(acc.stopOrders, stopOrderID)
+	e.mu.Unlock()
+
+	resp, err := e.postOrder(stopOrderRequest(accountID, stopOrder), false)
+	if err != nil {
+		return "", err
+	}
+	return resp.OrderId, nil
+}
+
+// stopOrderRequest is the exchange order of a triggered stop order, stop-limit orders become limit orders
+// and the others market orders.
+func stopOrderRequest(accountID string, stopOrder *investapi.StopOrder) *investapi.PostOrderRequest {
+	req := &investapi.PostOrderRequest{
+		Figi:      stopOrder.Figi,
+		Quantity:  stopOrder.LotsRequested,
+		AccountId: accountID,
+		OrderType: investapi.OrderType_ORDER_TYPE_MARKET,
+		OrderId:   stopOrder.StopOrderId,
+		Direction: investapi.OrderDirection_ORDER_DIRECTION_BUY,
+	}
+	if stopOrder.Direction == investapi.StopOrderDirection_STOP_ORDER_DIRECTION_SELL {
+		req.Direction = investapi.OrderDirection_ORDER_DIRECTION_SELL
+	}
+	if stopOrder.OrderType == investapi.StopOrderType_STOP_ORDER_TYPE_STOP_LIMIT {
+		req.OrderType = investapi.OrderType_ORDER_TYPE_LIMIT
+		req.Price = money.FromMoneyValue(stopOrder.Price).Amount.Quotation()
+	}
+	return req
+}
+
+func stopReached(stopOrder *investapi.StopOrder, price money.Decimal) bool {
+	stopPrice := money.FromMoneyValue(stopOrder.StopPrice).Amount
+	sell := stopOrder.Direction == investapi.StopOrderDirection_STOP_ORDER_DIRECTION_SELL
+	// take-profit fires when price moves in favor of the position, the stops when against
+	rising := stopOrder.OrderType == investapi.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT
+	if !sell {
+		rising = !rising
+	}
+	if rising {
+		return !price.LessThan(stopPrice)
+	}
+	return !price.GreaterThan(stopPrice)
+}
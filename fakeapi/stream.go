@@ -0,0 +1,179 @@
+package fakeapi
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type subscriptionKind int
+
+const (
+	kindCandles subscriptionKind = iota
+	kindOrderBook
+	kindTrades
+	kindLastPrice
+	kindInfo
+)
+
+type streamSession struct {
+	mu            sync.Mutex
+	subscriptions map[subscriptionKind]map[string]bool
+	out           chan *investapi.MarketDataResponse
+	cancel        context.CancelFunc
+}
+
+func (s *streamSession) set(kind subscriptionKind, figi string, action investapi.SubscriptionAction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscriptions[kind] == nil {
+		s.subscriptions[kind] = make(map[string]bool)
+	}
+	s.subscriptions[kind][figi] = action == investapi.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE
+}
+
+func (s *streamSession) subscribed(kind subscriptionKind, figi string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subscriptions[kind][figi]
+}
+
+type marketDataStreamService struct {
+	investapi.UnimplementedMarketDataStreamServiceServer
+
+	mu       sync.Mutex
+	sessions map[*streamSession]struct{}
+}
+
+func newMarketDataStreamService() *marketDataStreamService {
+	return &marketDataStreamService{sessions: make(map[*streamSession]struct{})}
+}
+
+func (m *marketDataStreamService) MarketDataStream(stream investapi.MarketDataStreamService_MarketDataStreamServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	session := &streamSession{
+		subscriptions: make(map[subscriptionKind]map[string]bool),
+		out:           make(chan *investapi.MarketDataResponse, streamBufferSize),
+		cancel:        cancel,
+	}
+	m.mu.Lock()
+	m.sessions[session] = struct{}{}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.sessions, session)
+		m.mu.Unlock()
+	}()
+
+	go func() {
+		defer cancel()
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			if resp := session.handle(req); resp != nil {
+				select {
+				case session.out <- resp:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case resp := <-session.out:
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			if stream.Context().Err() != nil {
+				return nil
+			}
+			return status.Error(codes.Unavailable, "stream is dropped")
+		}
+	}
+}
+
+// handle applies a subscription request and builds the confirmation.
+func (s *streamSession) handle(req *investapi.MarketDataRequest) *investapi.MarketDataResponse {
+	trackingID := uuid.New().String()
+	success := investapi.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS
+	switch payload := req.GetPayload().(type) {
+	case *investapi.MarketDataRequest_SubscribeCandlesRequest:
+		resp := &investapi.SubscribeCandlesResponse{TrackingId: trackingID}
+		for _, instrument := range payload.SubscribeCandlesRequest.GetInstruments() {
+			s.set(kindCandles, instrument.Figi, payload.SubscribeCandlesRequest.SubscriptionAction)
+			resp.CandlesSubscriptions = append(resp.CandlesSubscriptions, &investapi.CandleSubscription{
+				Figi: instrument.Figi, Interval: instrument.Interval, SubscriptionStatus: success,
+			})
+		}
+		return &investapi.MarketDataResponse{Payload: &investapi.MarketDataResponse_SubscribeCandlesResponse{SubscribeCandlesResponse: resp}}
+	case *investapi.MarketDataRequest_SubscribeOrderBookRequest:
+		resp := &investapi.SubscribeOrderBookResponse{TrackingId: trackingID}
+		for _, instrument := range payload.SubscribeOrderBookRequest.GetInstruments() {
+			s.set(kindOrderBook, instrument.Figi, payload.SubscribeOrderBookRequest.SubscriptionAction)
+			resp.OrderBookSubscriptions = append(resp.OrderBookSubscriptions, &investapi.OrderBookSubscription{
+				Figi: instrument.Figi, Depth: instrument.Depth, SubscriptionStatus: success,
+			})
+		}
+		return &investapi.MarketDataResponse{Payload: &investapi.MarketDataResponse_SubscribeOrderBookResponse{SubscribeOrderBookResponse: resp}}
+	case *investapi.MarketDataRequest_SubscribeTradesRequest:
+		resp := &investapi.SubscribeTradesResponse{TrackingId: trackingID}
+		for _, instrument := range payload.SubscribeTradesRequest.GetInstruments() {
+			s.set(kindTrades, instrument.Figi, payload.SubscribeTradesRequest.SubscriptionAction)
+			resp.TradeSubscriptions = append(resp.TradeSubscriptions, &investapi.TradeSubscription{
+				Figi: instrument.Figi, SubscriptionStatus: success,
+			})
+		}
+		return &investapi.MarketDataResponse{Payload: &investapi.MarketDataResponse_SubscribeTradesResponse{SubscribeTradesResponse: resp}}
+	case *investapi.MarketDataRequest_SubscribeLastPriceRequest:
+		resp := &investapi.SubscribeLastPriceResponse{TrackingId: trackingID}
+		for _, instrument := range payload.SubscribeLastPriceRequest.GetInstruments() {
+			s.set(kindLastPrice, instrument.Figi, payload.SubscribeLastPriceRequest.SubscriptionAction)
+			resp.LastPriceSubscriptions = append(resp.LastPriceSubscriptions, &investapi.LastPriceSubscription{
+				Figi: instrument.Figi, SubscriptionStatus: success,
+			})
+		}
+		return &investapi.MarketDataResponse{Payload: &investapi.MarketDataResponse_SubscribeLastPriceResponse{SubscribeLastPriceResponse: resp}}
+	case *investapi.MarketDataRequest_SubscribeInfoRequest:
+		resp := &investapi.SubscribeInfoResponse{TrackingId: trackingID}
+		for _, instrument := range payload.SubscribeInfoRequest.GetInstruments() {
+			s.set(kindInfo, instrument.Figi, payload.SubscribeInfoRequest.SubscriptionAction)
+			resp.InfoSubscriptions = append(resp.InfoSubscriptions, &investapi.InfoSubscription{
+				Figi: instrument.Figi, SubscriptionStatus: success,
+			})
+		}
+		return &investapi.MarketDataResponse{Payload: &investapi.MarketDataResponse_SubscribeInfoResponse{SubscribeInfoResponse: resp}}
+	}
+	return nil
+}
+
+func (m *marketDataStreamService) publish(kind subscriptionKind, figi string, resp *investapi.MarketDataResponse) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for session := range m.sessions {
+		if !session.subscribed(kind, figi) {
+			continue
+		}
+		select {
+		case session.out <- resp:
+		default:
+		}
+	}
+}
+
+func (m *marketDataStreamService) drop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for session := range m.sessions {
+		session.cancel()
+	}
+}
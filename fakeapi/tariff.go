@@ -0,0 +1,95 @@
+package fakeapi
+
+import (
+	"context"
+	"sync"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultLimits are unary calls per minute of the default tariff by service
+var defaultLimits = []struct {
+	desc  grpc.ServiceDesc
+	limit int32
+}{
+	{investapi.InstrumentsService_ServiceDesc, 200},
+	{investapi.MarketDataService_ServiceDesc, 600},
+	{investapi.OperationsService_ServiceDesc, 200},
+	{investapi.OrdersService_ServiceDesc, 100},
+	{investapi.StopOrdersService_ServiceDesc, 50},
+	{investapi.SandboxService_ServiceDesc, 200},
+	{investapi.UsersService_ServiceDesc, 100},
+}
+
+func defaultTariff() *investapi.GetUserTariffResponse {
+	tariff := &investapi.GetUserTariffResponse{}
+	for _, service := range defaultLimits {
+		limit := &investapi.UnaryLimit{LimitPerMinute: service.limit}
+		for _, method := range service.desc.Methods {
+			limit.Methods = append(limit.Methods, service.desc.ServiceName+"/"+method.MethodName)
+		}
+		tariff.UnaryLimits = append(tariff.UnaryLimits, limit)
+	}
+	return tariff
+}
+
+type failure struct {
+	left int
+	code codes.Code
+}
+
+// faults makes the next calls of a method fail, e.g. to check retries.
+type faults struct {
+	mu       sync.Mutex
+	failures map[string]*failure
+	calls    map[string]int
+	total    int
+}
+
+func newFaults() *faults {
+	return &faults{
+		failures: make(map[string]*failure),
+		calls:    make(map[string]int),
+	}
+}
+
+func (f *faults) fail(method string, count int, code codes.Code) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[method] = &failure{left: count, code: code}
+}
+
+// check counts the call and returns the scripted failure if there is one left.
+func (f *faults) check(method string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[method]++
+	f.total++
+	failure, ok := f.failures[method]
+	if !ok || failure.left == 0 {
+		return nil
+	}
+	failure.left--
+	return status.Error(failure.code, "fake failure")
+}
+
+func (f *faults) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+func (s *usersService) GetUserTariff(ctx context.Context, req *investapi.GetUserTariffRequest) (*investapi.GetUserTariffResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tariff, nil
+}
+
+func (f *faults) countAll() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.total
+}
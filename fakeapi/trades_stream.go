@@ -0,0 +1,69 @@
+package fakeapi
+
+import (
+	"sync"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+type tradesSubscriber struct {
+	accounts map[string]bool
+	out      chan *investapi.OrderTrades
+}
+
+type ordersStreamService struct {
+	investapi.UnimplementedOrdersStreamServiceServer
+
+	mu          sync.Mutex
+	subscribers map[*tradesSubscriber]struct{}
+}
+
+func newOrdersStreamService() *ordersStreamService {
+	return &ordersStreamService{subscribers: make(map[*tradesSubscriber]struct{})}
+}
+
+func (o *ordersStreamService) TradesStream(req *investapi.TradesStreamRequest, stream investapi.OrdersStreamService_TradesStreamServer) error {
+	subscriber := &tradesSubscriber{
+		accounts: make(map[string]bool),
+		out:      make(chan *investapi.OrderTrades, streamBufferSize),
+	}
+	for _, accountID := range req.Accounts {
+		subscriber.accounts[accountID] = true
+	}
+	o.mu.Lock()
+	o.subscribers[subscriber] = struct{}{}
+	o.mu.Unlock()
+	defer func() {
+		o.mu.Lock()
+		delete(o.subscribers, subscriber)
+		o.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case trades := <-subscriber.out:
+			err := stream.Send(&investapi.TradesStreamResponse{
+				Payload: &investapi.TradesStreamResponse_OrderTrades{OrderTrades: trades},
+			})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (o *ordersStreamService) publish(trades *investapi.OrderTrades) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for subscriber := range o.subscribers {
+		if !subscriber.accounts[trades.AccountId] {
+			continue
+		}
+		select {
+		case subscriber.out <- trades:
+		default:
+		}
+	}
+}
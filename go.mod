@@ -12,6 +12,7 @@ require (
 require (
 	github.com/google/uuid v1.1.2
 	google.golang.org/grpc v1.48.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
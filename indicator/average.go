@@ -0,0 +1,110 @@
+package indicator
+
+import "github.com/nax11/tinkoff_bot_public/money"
+
+// SMA is the simple moving average of the last period prices.
+type SMA struct {
+	window *window
+	sum    money.Decimal
+}
+
+// NewSMA returns SMA of period prices, a period below 1 is 1. It's ready after period updates.
+func NewSMA(period int) *SMA {
+	return &SMA{window: newWindow(period)}
+}
+
+func (s *SMA) Update(price money.Decimal) {
+	dropped, full := s.window.push(price)
+	s.sum = s.sum.Add(price)
+	if full {
+		s.sum = s.sum.Sub(dropped)
+	}
+}
+
+func (s *SMA) Value() money.Decimal {
+	if !s.Ready() {
+		return money.Zero
+	}
+	return s.sum.DivInt(int64(s.window.size()))
+}
+
+func (s *SMA) Ready() bool {
+	return s.window.full()
+}
+
+// EMA is the exponential moving average with the weight 2/(period+1) of the last price.
+type EMA struct {
+	period int
+	count  int
+	sum    money.Decimal
+	value  money.Decimal
+}
+
+// NewEMA returns EMA seeded with SMA of the first period prices, a period below 1 is 1.
+// It's ready after period updates.
+func NewEMA(period int) *EMA {
+	return &EMA{period: validPeriod(period)}
+}
+
+func (e *EMA) Update(price money.Decimal) {
+	if e.count < e.period {
+		e.count++
+		e.sum = e.sum.Add(price)
+		if e.count == e.period {
+			e.value = e.sum.DivInt(int64(e.period))
+		}
+		return
+	}
+	e.value = e.value.MulInt(int64(e.period - 1)).Add(price.MulInt(2)).DivInt(int64(e.period + 1))
+}
+
+func (e *EMA) Value() money.Decimal {
+	if !e.Ready() {
+		return money.Zero
+	}
+	return e.value
+}
+
+func (e *EMA) Ready() bool {
+	return e.count == e.period
+}
+
+// WMA is the linearly weighted moving average, the last price has the weight period and the oldest one 1.
+type WMA struct {
+	window *window
+	sum    money.Decimal
+	// weighted is the sum of prices multiplied by their weights
+	weighted money.Decimal
+}
+
+// NewWMA returns WMA of period prices, a period below 1 is 1. It's ready after period updates.
+func NewWMA(period int) *WMA {
+	return &WMA{window: newWindow(period)}
+}
+
+func (w *WMA) Update(price money.Decimal) {
+	n := int64(w.window.size())
+	prevSum := w.sum
+	dropped, full := w.window.push(price)
+	if !full {
+		// weights of the window being filled are 1 to count
+		w.weighted = w.weighted.Add(price.MulInt(int64(w.window.count)))
+		w.sum = w.sum.Add(price)
+		return
+	}
+	// every price loses a unit of weight, the new one gets n
+	w.weighted = w.weighted.Sub(prevSum).Add(price.MulInt(n))
+	w.sum = prevSum.Sub(dropped).Add(price)
+}
+
+func (w *WMA) Value() money.Decimal {
+	if !w.Ready() {
+		return money.Zero
+	}
+	n := int64(w.window.size())
+	return w.weighted.DivInt(n * (n + 1) / 2)
+}
+
+func (w *WMA) Ready() bool {
+	return w.window.full()
+}
@@ -0,0 +1,96 @@
+// Package indicator computes technical indicators incrementally, a candle at a time, so strategies can
+// update them on every new candle without recalculating the history.
+//
+// An indicator needs a number of updates to warm up, usually its period. Until Ready its Value is zero.
+package indicator
+
+import (
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+// Series is an indicator of a price series, usually of candle closes.
+type Series interface {
+	Update(price money.Decimal)
+	Value() money.Decimal
+	Ready() bool
+}
+
+// Candle is the input of the indicators that need more than a price.
+type Candle struct {
+	Time   time.Time
+	Open   money.Decimal
+	High   money.Decimal
+	Low    money.Decimal
+	Close  money.Decimal
+	Volume int64
+}
+
+func FromHistoric(candle *investapi.HistoricCandle) Candle {
+	return Candle{
+		Time:   candle.GetTime().AsTime(),
+		Open:   money.FromQuotation(candle.GetOpen()),
+		High:   money.FromQuotation(candle.GetHigh()),
+		Low:    money.FromQuotation(candle.GetLow()),
+		Close:  money.FromQuotation(candle.GetClose()),
+		Volume: candle.GetVolume(),
+	}
+}
+
+// Typical is the average of high, low and close.
+func (c Candle) Typical() money.Decimal {
+	return c.High.Add(c.Low).Add(c.Close).DivInt(3)
+}
+
+// Band is a price channel, e.g. Bollinger Bands.
+type Band struct {
+	Lower  money.Decimal
+	Middle money.Decimal
+	Upper  money.Decimal
+}
+
+// Width is the distance between the upper and the lower line.
+func (b Band) Width() money.Decimal {
+	return b.Upper.Sub(b.Lower)
+}
+
+// window keeps the last values of a period.
+type window struct {
+	values []money.Decimal
+	next   int
+	count  int
+}
+
+// newWindow returns a window of size values, a size below 1 is 1.
+func newWindow(size int) *window {
+	return &window{values: make([]money.Decimal, validPeriod(size))}
+}
+
+// push adds v, dropped is the oldest value when the window was full.
+func (w *window) push(v money.Decimal) (dropped money.Decimal, full bool) {
+	dropped, full = w.values[w.next], w.full()
+	w.values[w.next] = v
+	w.next = (w.next + 1) % len(w.values)
+	if !full {
+		w.count++
+	}
+	return dropped, full
+}
+
+func (w *window) full() bool {
+	return w.count == len(w.values)
+}
+
+func (w *window) size() int {
+	return len(w.values)
+}
+
+// validPeriod returns period or 1 when it's below 1.
+func validPeriod(period int) int {
+	if period < 1 {
+		return 1
+	}
+	return period
+}
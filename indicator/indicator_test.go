@@ -0,0 +1,212 @@
+package indicator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/money"
+)
+
+// testCloses is the series of Wilder's RSI example, RSI 14 of its first 15 closes is 70.46.
+var testCloses = prices("44.34", "44.09", "44.15", "43.61", "44.33", "44.83", "45.10", "45.42", "45.84", "46.08",
+	"45.89", "46.03", "45.61", "46.28", "46.28", "46.00", "46.03", "46.41", "46.22", "45.64")
+
+// tolerance covers rounding of the divisions, the expected values are computed with exact fractions
+var tolerance = money.MustParse("0.000001")
+
+func prices(values ...string) []money.Decimal {
+	result := make([]money.Decimal, 0, len(values))
+	for _, v := range values {
+		result = append(result, money.MustParse(v))
+	}
+	return result
+}
+
+// testCandles are testCloses with the high 0.25 above the close, 0.35 on every third candle,
+// and the low 0.30 below the close, 0.35 on every fourth candle.
+func testCandles() []Candle {
+	start := time.Date(2024, 3, 12, 7, 0, 0, 0, time.UTC)
+	candles := make([]Candle, 0, len(testCloses))
+	for i, c := range testCloses {
+		high, low := c.Add(money.MustParse("0.25")), c.Sub(money.MustParse("0.30"))
+		if i%3 == 0 {
+			high = high.Add(money.MustParse("0.10"))
+		}
+		if i%4 == 0 {
+			low = low.Sub(money.MustParse("0.05"))
+		}
+		candles = append(candles, Candle{
+			Time:   start.Add(time.Duration(i) * time.Minute),
+			Open:   c,
+			High:   high,
+			Low:    low,
+			Close:  c,
+			Volume: int64(i + 1),
+		})
+	}
+	return candles
+}
+
+func expectNear(t *testing.T, name string, got money.Decimal, want string) {
+	t.Helper()
+	if got.Sub(money.MustParse(want)).Abs().GreaterThan(tolerance) {
+		t.Errorf("%v is %v, want %v", name, got, want)
+	}
+}
+
+// indicator is any indicator of the package fed with the test candles.
+type indicator interface {
+	Ready() bool
+}
+
+func update(ind indicator, candle Candle) {
+	switch ind := ind.(type) {
+	case Series:
+		ind.Update(candle.Close)
+	case *MACD:
+		ind.Update(candle.Close)
+	case *Bollinger:
+		ind.Update(candle.Close)
+	case interface{ Update(Candle) }:
+		ind.Update(candle)
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		indicator indicator
+		updates   int
+	}{
+		{name: "SMA", indicator: NewSMA(5), updates: 5},
+		{name: "EMA", indicator: NewEMA(5), updates: 5},
+		{name: "WMA", indicator: NewWMA(5), updates: 5},
+		{name: "RSI", indicator: NewRSI(14), updates: 15},
+		{name: "MACD", indicator: NewMACD(3, 6, 4), updates: 9},
+		{name: "StdDev", indicator: NewStdDev(5), updates: 5},
+		{name: "Bollinger", indicator: NewBollinger(5, money.FromInt(2)), updates: 5},
+		{name: "ATR", indicator: NewATR(5), updates: 5},
+		{name: "Keltner", indicator: NewKeltner(5, 3, money.FromInt(2)), updates: 5},
+		{name: "Donchian", indicator: NewDonchian(5), updates: 5},
+		{name: "VWAP", indicator: NewVWAP(time.UTC), updates: 1},
+		{name: "period below 1", indicator: NewSMA(0), updates: 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, candle := range testCandles()[:tt.updates] {
+				if tt.indicator.Ready() {
+					t.Fatalf("ready after %v updates, want %v", i, tt.updates)
+				}
+				if series, ok := tt.indicator.(Series); ok && !series.Value().IsZero() {
+					t.Fatalf("value %v before ready", series.Value())
+				}
+				update(tt.indicator, candle)
+			}
+			if !tt.indicator.Ready() {
+				t.Fatalf("not ready after %v updates", tt.updates)
+			}
+		})
+	}
+}
+
+func TestSeriesValues(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		series Series
+		closes []money.Decimal
+		want   string
+	}{
+		{name: "SMA", series: NewSMA(5), closes: testCloses, want: "46.06"},
+		{name: "EMA", series: NewEMA(5), closes: testCloses, want: "45.996053619"},
+		{name: "WMA", series: NewWMA(5), closes: testCloses, want: "46.024666667"},
+		{name: "RSI seed", series: NewRSI(14), closes: testCloses[:15], want: "70.464135021"},
+		{name: "RSI", series: NewRSI(14), closes: testCloses, want: "57.915020670"},
+		{name: "RSI of equal prices", series: NewRSI(3), closes: prices("10", "10", "10", "10"), want: "50"},
+		{name: "StdDev", series: NewStdDev(5), closes: testCloses, want: "0.256515107"},
+		{name: "StdDev of equal prices", series: NewStdDev(3), closes: prices("100.5", "100.5", "100.5"), want: "0"},
+		{name: "StdDev of low prices", series: NewStdDev(3), closes: prices("0.021505", "0.021510", "0.021515"), want: "0.000004082"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, price := range tt.closes {
+				tt.series.Update(price)
+			}
+			expectNear(t, "value", tt.series.Value(), tt.want)
+		})
+	}
+}
+
+func TestMACD(t *testing.T) {
+	macd := NewMACD(3, 6, 4)
+	for _, price := range testCloses {
+		macd.Update(price)
+	}
+	value := macd.Value()
+	expectNear(t, "MACD", value.MACD, "-0.063548521")
+	expectNear(t, "signal", value.Signal, "0.041704278")
+	expectNear(t, "histogram", value.Histogram, "-0.105252799")
+}
+
+func TestBands(t *testing.T) {
+	for _, tt := range []struct {
+		name                 string
+		indicator            indicator
+		lower, middle, upper string
+	}{
+		{name: "Bollinger", indicator: NewBollinger(5, money.FromInt(2)), lower: "45.546969786", middle: "46.06", upper: "46.573030214"},
+		{name: "Keltner", indicator: NewKeltner(5, 3, money.FromInt(2)), lower: "44.550602624", middle: "45.996053619", upper: "47.441504615"},
+		{name: "Donchian", indicator: NewDonchian(5), lower: "45.34", middle: "46", upper: "46.66"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, candle := range testCandles() {
+				update(tt.indicator, candle)
+			}
+			band := tt.indicator.(interface{ Value() Band }).Value()
+			expectNear(t, "lower", band.Lower, tt.lower)
+			expectNear(t, "middle", band.Middle, tt.middle)
+			expectNear(t, "upper", band.Upper, tt.upper)
+		})
+	}
+}
+
+func TestATR(t *testing.T) {
+	atr := NewATR(5)
+	for _, candle := range testCandles() {
+		atr.Update(candle)
+	}
+	expectNear(t, "ATR", atr.Value(), "0.697572513")
+}
+
+func TestVWAPStartsEveryDay(t *testing.T) {
+	candles := testCandles()[:2]
+	vwap := NewVWAP(time.UTC)
+	for _, candle := range candles {
+		vwap.Update(candle)
+	}
+	// typical prices weighted by volumes 1 and 2
+	want := candles[0].Typical().Add(candles[1].Typical().MulInt(2)).DivInt(3)
+	if !vwap.Value().Equal(want) {
+		t.Fatalf("VWAP is %v, want %v", vwap.Value(), want)
+	}
+
+	next := candles[1]
+	next.Time = next.Time.Add(24 * time.Hour)
+	vwap.Update(next)
+	if !vwap.Value().Equal(next.Typical()) {
+		t.Fatalf("VWAP of a new day is %v, want %v", vwap.Value(), next.Typical())
+	}
+}
+
+// Bollinger Bands of a share priced in hundredths of a kopeck, e.g. VTBR, don't collapse to the SMA.
+func TestBollingerOfLowPrices(t *testing.T) {
+	bollinger := NewBollinger(20, money.FromInt(2))
+	for _, price := range prices("0.021505", "0.021510", "0.021515", "0.021520", "0.021500", "0.021495", "0.021505",
+		"0.021530", "0.021540", "0.021525", "0.021515", "0.021510", "0.021520", "0.021535", "0.021545", "0.021550",
+		"0.021540", "0.021530", "0.021525", "0.021535") {
+		bollinger.Update(price)
+	}
+	band := bollinger.Value()
+	expectNear(t, "middle", band.Middle, "0.0215225")
+	// 2 standard deviations of 0.0000152069
+	if want := money.MustParse("0.000030412"); !band.Upper.Sub(band.Middle).Equal(want) {
+		t.Fatalf("band is %v above the middle, want %v", band.Upper.Sub(band.Middle), want)
+	}
+}
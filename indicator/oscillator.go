@@ -0,0 +1,104 @@
+package indicator
+
+import "github.com/nax11/tinkoff_bot_public/money"
+
+var hundred = money.FromInt(100)
+
+// RSI is the relative strength index with Wilder's smoothing, from 0 to 100.
+type RSI struct {
+	period int
+	// changes is the number of price changes seen, one less than updates
+	changes int
+	prev    money.Decimal
+	started bool
+	gain    money.Decimal
+	loss    money.Decimal
+}
+
+// NewRSI returns RSI seeded with the average gain and loss of the first period changes, usually 14.
+// A period below 1 is 1. It's ready after period+1 updates.
+func NewRSI(period int) *RSI {
+	return &RSI{period: validPeriod(period)}
+}
+
+func (r *RSI) Update(price money.Decimal) {
+	if !r.started {
+		r.started = true
+		r.prev = price
+		return
+	}
+	change := price.Sub(r.prev)
+	r.prev = price
+	gain, loss := money.Max(change, money.Zero), money.Max(change.Neg(), money.Zero)
+	if r.changes < r.period {
+		r.changes++
+		// sums until the period is filled, averages after
+		r.gain = r.gain.Add(gain)
+		r.loss = r.loss.Add(loss)
+		if r.changes == r.period {
+			r.gain = r.gain.DivInt(int64(r.period))
+			r.loss = r.loss.DivInt(int64(r.period))
+		}
+		return
+	}
+	r.gain = r.gain.MulInt(int64(r.period - 1)).Add(gain).DivInt(int64(r.period))
+	r.loss = r.loss.MulInt(int64(r.period - 1)).Add(loss).DivInt(int64(r.period))
+}
+
+// Value is 100 * gain / (gain + loss), 50 when the price didn't change.
+func (r *RSI) Value() money.Decimal {
+	if !r.Ready() {
+		return money.Zero
+	}
+	total := r.gain.Add(r.loss)
+	if total.IsZero() {
+		return hundred.DivInt(2)
+	}
+	return r.gain.Mul(hundred).Div(total)
+}
+
+func (r *RSI) Ready() bool {
+	return r.changes == r.period
+}
+
+// MACDValue is the difference of the fast and the slow EMA, its signal EMA and the difference of them.
+type MACDValue struct {
+	MACD      money.Decimal
+	Signal    money.Decimal
+	Histogram money.Decimal
+}
+
+// MACD is the moving average convergence divergence.
+type MACD struct {
+	fast   *EMA
+	slow   *EMA
+	signal *EMA
+}
+
+// NewMACD returns MACD of the fast and slow EMA of prices and the signal EMA of their difference,
+// usually 12, 26 and 9. The signal starts when the slow EMA is ready, so MACD is ready after
+// slow+signal-1 updates.
+func NewMACD(fast, slow, signal int) *MACD {
+	return &MACD{fast: NewEMA(fast), slow: NewEMA(slow), signal: NewEMA(signal)}
+}
+
+func (m *MACD) Update(price money.Decimal) {
+	m.fast.Update(price)
+	m.slow.Update(price)
+	if m.fast.Ready() && m.slow.Ready() {
+		m.signal.Update(m.fast.Value().Sub(m.slow.Value()))
+	}
+}
+
+func (m *MACD) Value() MACDValue {
+	if !m.Ready() {
+		return MACDValue{}
+	}
+	macd := m.fast.Value().Sub(m.slow.Value())
+	signal := m.signal.Value()
+	return MACDValue{MACD: macd, Signal: signal, Histogram: macd.Sub(signal)}
+}
+
+func (m *MACD) Ready() bool {
+	return m.signal.Ready()
+}
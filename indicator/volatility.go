@@ -0,0 +1,230 @@
+package indicator
+
+import (
+	"math/big"
+
+	"github.com/nax11/tinkoff_bot_public/money"
+)
+
+var nanoPerUnit = big.NewInt(1e9)
+
+// StdDev is the population standard deviation of the last period prices.
+type StdDev struct {
+	window *window
+	// sum and squares are the sums of prices and of squared prices in nanos. The sums are exact,
+	// squares of prices rounded to nanos would make the variance of low prices zero.
+	sum     *big.Int
+	squares *big.Int
+}
+
+// NewStdDev returns StdDev of period prices, a period below 1 is 1. It's ready after period updates.
+func NewStdDev(period int) *StdDev {
+	return &StdDev{window: newWindow(period), sum: new(big.Int), squares: new(big.Int)}
+}
+
+func (s *StdDev) Update(price money.Decimal) {
+	dropped, full := s.window.push(price)
+	n := nanos(price)
+	s.sum.Add(s.sum, n)
+	s.squares.Add(s.squares, n.Mul(n, n))
+	if full {
+		d := nanos(dropped)
+		s.sum.Sub(s.sum, d)
+		s.squares.Sub(s.squares, d.Mul(d, d))
+	}
+}
+
+// Value is sqrt(n*squares - sum^2) / n, the variance times n^2 is an exact integer.
+func (s *StdDev) Value() money.Decimal {
+	if !s.Ready() {
+		return money.Zero
+	}
+	n := big.NewInt(int64(s.window.size()))
+	scaled := new(big.Int).Mul(n, s.squares)
+	scaled.Sub(scaled, new(big.Int).Mul(s.sum, s.sum))
+	if scaled.Sign() <= 0 {
+		return money.Zero
+	}
+	scaled.Sqrt(scaled)
+	return fromNanos(scaled.Quo(scaled, n))
+}
+
+func (s *StdDev) Ready() bool {
+	return s.window.full()
+}
+
+// Bollinger is Bollinger Bands: SMA of period prices and lines multiplier standard deviations
+// above and below it.
+type Bollinger struct {
+	sma        *SMA
+	stdDev     *StdDev
+	multiplier money.Decimal
+}
+
+// NewBollinger returns Bollinger Bands of period prices, usually 20 and 2. It's ready after period updates.
+func NewBollinger(period int, multiplier money.Decimal) *Bollinger {
+	return &Bollinger{sma: NewSMA(period), stdDev: NewStdDev(period), multiplier: multiplier}
+}
+
+func (b *Bollinger) Update(price money.Decimal) {
+	b.sma.Update(price)
+	b.stdDev.Update(price)
+}
+
+func (b *Bollinger) Value() Band {
+	if !b.Ready() {
+		return Band{}
+	}
+	middle := b.sma.Value()
+	offset := b.stdDev.Value().Mul(b.multiplier)
+	return Band{Lower: middle.Sub(offset), Middle: middle, Upper: middle.Add(offset)}
+}
+
+func (b *Bollinger) Ready() bool {
+	return b.sma.Ready()
+}
+
+// ATR is the average true range with Wilder's smoothing. The true range of a candle is its range
+// extended to the previous close, so gaps count as volatility.
+type ATR struct {
+	period    int
+	count     int
+	prevClose money.Decimal
+	sum       money.Decimal
+	value     money.Decimal
+}
+
+// NewATR returns ATR seeded with the average true range of the first period candles, usually 14.
+// A period below 1 is 1. It's ready after period updates.
+func NewATR(period int) *ATR {
+	return &ATR{period: validPeriod(period)}
+}
+
+func (a *ATR) Update(candle Candle) {
+	trueRange := candle.High.Sub(candle.Low)
+	if a.count > 0 {
+		trueRange = money.Max(trueRange, money.Max(candle.High.Sub(a.prevClose).Abs(), candle.Low.Sub(a.prevClose).Abs()))
+	}
+	a.prevClose = candle.Close
+	if a.count < a.period {
+		a.count++
+		a.sum = a.sum.Add(trueRange)
+		if a.count == a.period {
+			a.value = a.sum.DivInt(int64(a.period))
+		}
+		return
+	}
+	a.value = a.value.MulInt(int64(a.period - 1)).Add(trueRange).DivInt(int64(a.period))
+}
+
+func (a *ATR) Value() money.Decimal {
+	if !a.Ready() {
+		return money.Zero
+	}
+	return a.value
+}
+
+func (a *ATR) Ready() bool {
+	return a.count == a.period
+}
+
+// Keltner is Keltner channels: EMA of closes and lines multiplier ATRs above and below it.
+type Keltner struct {
+	ema        *EMA
+	atr        *ATR
+	multiplier money.Decimal
+}
+
+// NewKeltner returns Keltner channels, usually of EMA 20, ATR 10 and multiplier 2.
+// It's ready when both EMA and ATR are.
+func NewKeltner(emaPeriod, atrPeriod int, multiplier money.Decimal) *Keltner {
+	return &Keltner{ema: NewEMA(emaPeriod), atr: NewATR(atrPeriod), multiplier: multiplier}
+}
+
+func (k *Keltner) Update(candle Candle) {
+	k.ema.Update(candle.Close)
+	k.atr.Update(candle)
+}
+
+func (k *Keltner) Value() Band {
+	if !k.Ready() {
+		return Band{}
+	}
+	middle := k.ema.Value()
+	offset := k.atr.Value().Mul(k.multiplier)
+	return Band{Lower: middle.Sub(offset), Middle: middle, Upper: middle.Add(offset)}
+}
+
+func (k *Keltner) Ready() bool {
+	return k.ema.Ready() && k.atr.Ready()
+}
+
+// Donchian is Donchian channels: the highest high and the lowest low of the last period candles,
+// the middle is between them.
+type Donchian struct {
+	period int
+	count  int
+	highs  extremes
+	lows   extremes
+}
+
+// NewDonchian returns Donchian channels of period candles, usually 20. A period below 1 is 1.
+// It's ready after period updates.
+func NewDonchian(period int) *Donchian {
+	return &Donchian{period: validPeriod(period)}
+}
+
+func (d *Donchian) Update(candle Candle) {
+	d.count++
+	d.highs.push(d.count, candle.High, d.period, func(a, b money.Decimal) bool { return !a.GreaterThan(b) })
+	d.lows.push(d.count, candle.Low, d.period, func(a, b money.Decimal) bool { return !a.LessThan(b) })
+}
+
+func (d *Donchian) Value() Band {
+	if !d.Ready() {
+		return Band{}
+	}
+	upper, lower := d.highs.first(), d.lows.first()
+	return Band{Lower: lower, Middle: upper.Add(lower).DivInt(2), Upper: upper}
+}
+
+func (d *Donchian) Ready() bool {
+	return d.count >= d.period
+}
+
+// extremes is a monotonic queue, its first value is the extreme of the window.
+type extremes []extreme
+
+type extreme struct {
+	index int
+	value money.Decimal
+}
+
+// push adds the value of index and drops values that can't be the extreme anymore: the ones
+// outside the window of period and the ones the new value replaces.
+func (e *extremes) push(index int, value money.Decimal, period int, replaces func(old, new money.Decimal) bool) {
+	queue := *e
+	for len(queue) > 0 && replaces(queue[len(queue)-1].value, value) {
+		queue = queue[:len(queue)-1]
+	}
+	queue = append(queue, extreme{index: index, value: value})
+	for queue[0].index <= index-period {
+		queue = queue[1:]
+	}
+	*e = queue
+}
+
+func (e extremes) first() money.Decimal {
+	return e[0].value
+}
+
+func nanos(d money.Decimal) *big.Int {
+	n := big.NewInt(d.Units())
+	n.Mul(n, nanoPerUnit)
+	return n.Add(n, big.NewInt(int64(d.Nano())))
+}
+
+func fromNanos(n *big.Int) money.Decimal {
+	units, nano := new(big.Int).QuoRem(n, nanoPerUnit, new(big.Int))
+	return money.New(units.Int64(), int32(nano.Int64()))
+}
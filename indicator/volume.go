@@ -0,0 +1,45 @@
+package indicator
+
+import (
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/money"
+)
+
+// VWAP is the volume weighted average of typical prices of the trading day, it starts over
+// with the first candle of every day.
+type VWAP struct {
+	location *time.Location
+	day      time.Time
+	// weighted is the sum of typical prices multiplied by volumes
+	weighted money.Decimal
+	volume   int64
+}
+
+// NewVWAP returns VWAP of days in location, e.g. calendar.Moscow. It's ready after a candle with volume.
+func NewVWAP(location *time.Location) *VWAP {
+	return &VWAP{location: location}
+}
+
+func (v *VWAP) Update(candle Candle) {
+	local := candle.Time.In(v.location)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, v.location)
+	if !day.Equal(v.day) {
+		v.day = day
+		v.weighted = money.Zero
+		v.volume = 0
+	}
+	v.weighted = v.weighted.Add(candle.Typical().MulInt(candle.Volume))
+	v.volume += candle.Volume
+}
+
+func (v *VWAP) Value() money.Decimal {
+	if !v.Ready() {
+		return money.Zero
+	}
+	return v.weighted.DivInt(v.volume)
+}
+
+func (v *VWAP) Ready() bool {
+	return v.volume > 0
+}
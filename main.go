@@ -2,91 +2,265 @@ package main
 
 import (
 	"context"
-	"time"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
 
 	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/backtest"
+	"github.com/nax11/tinkoff_bot_public/config"
 	"github.com/nax11/tinkoff_bot_public/profile"
 	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/nax11/tinkoff_bot_public/registry"
 	"github.com/nax11/tinkoff_bot_public/strategy"
 	priceband "github.com/nax11/tinkoff_bot_public/strategy/price-band"
 	uirender "github.com/nax11/tinkoff_bot_public/ui-render"
 	"github.com/sirupsen/logrus"
 )
 
-var FigiMap = map[string]string{
-	"SBER":  "BBG004730N88",
-	"SBERP": "BBG0047315Y7",
-	"M":     "BBG000C46HM9",
-	"SAVE":  "BBG000BF6RQ9",
-	"MGNT":  "BBG004RVFCY3",
-	"DSKY":  "BBG000BN56Q9",
-	"MAGN":  "BBG004S68507", // Магнитогорский металлургический комбинат
-	"NLMK":  "BBG004S681B4",
-	"GMKN":  "BBG004731489",
-}
-
 var AvailableStartegy strategy.StartegyMap = strategy.StartegyMap{
 	"band": priceband.NewStrategy,
 }
 
 func main() {
-	client, err := api.NewClient("Put token here")
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		logrus.WithError(err).Error("can't load config")
+		return
+	}
+	isCommand := len(cfg.Args) > 0
+	if isCommand {
+		err = cfg.ValidateAPI()
+	} else {
+		err = cfg.Validate(strategyNames())
+	}
 	if err != nil {
-		panic("")
+		logrus.WithError(err).Error("config is invalid")
+		return
 	}
 
-	ctx := context.Background()
+	client, err := api.NewFromConfig(cfg.API)
+	if err != nil {
+		logrus.WithError(err).Error("can't create client")
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
 	clientProfile := profile.Instance(client)
-	accountID, err := clientProfile.GetOrCreateOpenedAccount(ctx, 3000)
-	if err != nil {
-		logrus.WithError(err).Error("can't get account")
+	if isCommand {
+		err = runCommand(ctx, cfg, client, clientProfile)
+		if err != nil {
+			logrus.WithError(err).Error("command failed")
+		}
 		return
 	}
-
-	strategyProvider, ok := AvailableStartegy["band"]
-	if !ok {
-		logrus.Error("can't find selected startegy")
+	instruments := registry.Instance(client, cfg.Instruments.CachePath, cfg.Instruments.CacheTTL)
+	client.Intents, err = api.OpenIntentStore(cfg.Orders.IntentsPath)
+	if err != nil {
+		logrus.WithError(err).Error("can't open order intents")
 		return
 	}
-
-	figi, ok := FigiMap["SBER"]
-	if !ok {
-		logrus.Error("can't find selected figi from map")
+	states, err := strategy.OpenStateStore(cfg.State.Path)
+	if err != nil {
+		logrus.WithError(err).Error("can't open strategy state")
 		return
 	}
+	defer states.Close()
+	book := strategy.NewBook()
 
-	strategyOperation := strategyProvider(client)
-	params := strategy.TradeParams{
-		AccountID:        accountID,
-		Figi:             figi,
-		OperationLots:    10,
-		MaxDealSum:       2000,
-		DealLimit:        3000,
-		Interval:         investapi.CandleInterval_CANDLE_INTERVAL_5_MIN,
-		DealPeriod:       time.Minute * 30,
-		AnalyzePeriod:    time.Minute * 20,
-		SimulateDayTrade: true,
-		SimulateLotQty:   10,
-		ReportData:       &strategy.ReportParams{},
+	// every strategy is checked before any account is funded or any strategy starts,
+	// a bad one doesn't leave the others running unattended
+	type run struct {
+		operation strategy.Strategy
+		params    strategy.TradeParams
 	}
+	type simulation struct {
+		strategyCfg config.StrategyConfig
+		instrument  *registry.Instrument
+	}
+	runs := []run{}
+	simulations := []simulation{}
+	accountIDs := []string{}
+	for _, strategyCfg := range cfg.Strategies {
+		instrument, err := instruments.Resolve(ctx, strategyCfg.Instrument)
+		if err != nil {
+			logrus.WithError(err).Error("can't resolve instrument")
+			return
+		}
+		if strategyCfg.SimulateDayTrade {
+			// the previous day is replayed by a backtest, nothing is traded
+			simulations = append(simulations, simulation{strategyCfg: strategyCfg, instrument: instrument})
+			continue
+		}
 
-	err = clientProfile.CheckFigiOperations(params.AccountID, params.Figi)
-	if err != nil {
-		logrus.WithError(err).Error("fail check figi operations")
+		accountID, err := selectAccount(ctx, cfg, strategyCfg, clientProfile)
+		if err != nil {
+			logrus.WithError(err).Error("can't get account")
+			return
+		}
+
+		params := strategyCfg.TradeParams(instrument)
+		params.AccountID = accountID
+		params.State = states
+		params.Book = book
+		if !contains(accountIDs, accountID) {
+			accountIDs = append(accountIDs, accountID)
+		}
+		err = clientProfile.CheckFigiOperations(params.AccountID, params.Figi)
+		if err != nil {
+			logrus.WithError(err).Error("fail check figi operations")
+			return
+		}
+		runs = append(runs, run{operation: AvailableStartegy[strategyCfg.Name](client), params: params})
+	}
+	if err = fundAccounts(ctx, cfg, clientProfile, accountIDs); err != nil {
+		logrus.WithError(err).Error("can't fund account")
 		return
 	}
 
-	err = strategyOperation.Run(ctx, params)
-	if err != nil {
-		logrus.WithError(err).Error("strategyOperation complete with error")
+	wg := sync.WaitGroup{}
+	for _, r := range runs {
+		r := r
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := r.operation.Run(ctx, r.params)
+			if err != nil {
+				logrus.WithError(err).Error("strategyOperation complete with error")
+			}
+		}()
+	}
+	type chart struct {
+		candles []*investapi.HistoricCandle
+		fills   []backtest.Fill
+	}
+	charts := make(chan chart, len(simulations))
+	for _, s := range simulations {
+		s := s
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, dayCandles, err := simulateDay(ctx, cfg, client, s.strategyCfg, s.instrument)
+			if err != nil {
+				logrus.WithError(err).Error("simulation failed")
+				return
+			}
+			logrus.WithFields(logrus.Fields{
+				"strategy":   s.strategyCfg.Name,
+				"instrument": s.strategyCfg.Instrument,
+				"fills":      result.Stats.Fills,
+				"profit":     result.Stats.Profit.String(),
+				"return":     result.Stats.Return.String(),
+			}).Info("day simulated")
+			charts <- chart{candles: dayCandles, fills: result.Fills}
+		}()
+	}
+	if cfg.Reconcile.Interval > 0 {
+		reconciler := strategy.NewReconciler(client, book)
+		reconciler.PauseOnDrift = cfg.Reconcile.PauseOnDrift
+		go func() {
+			err := reconciler.Run(ctx, cfg.Reconcile.Interval)
+			if err != nil && ctx.Err() == nil {
+				logrus.WithError(err).Error("reconciliation stopped")
+			}
+		}()
 	}
+	portfolios := clientProfile.Portfolios()
+	go func() {
+		err := portfolios.Run(ctx, uniqueStrings(accountIDs)...)
+		if err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Error("portfolio updates stopped")
+		}
+	}()
+	wg.Wait()
+	close(charts)
+	logPortfolios(context.Background(), portfolios, uniqueStrings(accountIDs))
+	syncJournals(context.Background(), profile.NewHistory(client, cfg.Journal.Dir), uniqueStrings(accountIDs))
 
-	if params.SimulateDayTrade {
+	if c, ok := <-charts; ok {
 		//run UI with market charh on http://localhost:8080/
-		uirender.RunUI(*params.ReportData)
+		uirender.RunUI(c.candles, c.fills)
+	}
+}
+
+// selectAccount binds the strategy to its account, a sandbox account is opened when there is none.
+func selectAccount(ctx context.Context, cfg *config.Config, strategyCfg config.StrategyConfig, clientProfile profile.Provider) (string, error) {
+	accountID := strategyCfg.AccountID
+	if accountID == "" && len(cfg.API.AccountID) == 1 {
+		accountID = cfg.API.AccountID[0]
+	}
+	return clientProfile.SelectAccount(ctx, accountID, cfg.Accounts.Deposit())
+}
+
+// fundAccounts pays sandbox accounts in up to the deposit, live accounts are left as they are.
+func fundAccounts(ctx context.Context, cfg *config.Config, clientProfile profile.Provider, accountIDs []string) error {
+	deposit := cfg.Accounts.Deposit()
+	if !cfg.API.Sandbox || deposit.IsZero() {
+		return nil
+	}
+	for _, accountID := range accountIDs {
+		if _, err := clientProfile.FundAccount(ctx, accountID, deposit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logPortfolios reports P&L of the accounts after strategies are done.
+func logPortfolios(ctx context.Context, portfolios profile.Portfolios, accountIDs []string) {
+	for _, accountID := range accountIDs {
+		portfolio, err := portfolios.Refresh(ctx, accountID)
+		if err != nil {
+			logrus.WithError(err).WithField("account_id", accountID).Warn("fail get portfolio")
+			continue
+		}
+		logrus.WithFields(logrus.Fields{
+			"account_id": accountID,
+			"value":      portfolio.Value.String(),
+			"unrealised": portfolio.Unrealised.String(),
+			"realised":   portfolio.Realised.String(),
+		}).Info("portfolio")
+	}
+}
+
+// syncJournals saves operations made by strategies to the local journal.
+func syncJournals(ctx context.Context, history profile.History, accountIDs []string) {
+	for _, accountID := range accountIDs {
+		if _, err := history.Sync(ctx, accountID); err != nil {
+			logrus.WithError(err).WithField("account_id", accountID).Warn("fail sync operations journal")
+		}
+	}
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	result := []string{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+func strategyNames() []string {
+	names := make([]string, 0, len(AvailableStartegy))
+	for name := range AvailableStartegy {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
+	return false
 }
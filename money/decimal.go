@@ -0,0 +1,323 @@
+package money
+
+import (
+	"math/big"
+	"strconv"
+	"strings"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+)
+
+const (
+	nanoDigits  = 9
+	nanoPerUnit = 1000000000
+)
+
+var bigNanoPerUnit = big.NewInt(nanoPerUnit)
+
+type RoundingMode int
+
+const (
+	// RoundDown rounds towards negative infinity
+	RoundDown RoundingMode = iota
+	// RoundUp rounds towards positive infinity
+	RoundUp
+	// RoundNearest rounds to the nearest value, halves away from zero
+	RoundNearest
+)
+
+// Decimal is an exact number with nine fractional digits, the precision of Quotation and MoneyValue.
+// Units and nano always have the same sign and |nano| < 1e9.
+type Decimal struct {
+	units int64
+	nano  int32
+}
+
+var Zero = Decimal{}
+
+func New(units int64, nano int32) Decimal {
+	units += int64(nano / nanoPerUnit)
+	nano = nano % nanoPerUnit
+	if units > 0 && nano < 0 {
+		units--
+		nano += nanoPerUnit
+	}
+	if units < 0 && nano > 0 {
+		units++
+		nano -= nanoPerUnit
+	}
+	return Decimal{units: units, nano: nano}
+}
+
+func FromInt(units int64) Decimal {
+	return Decimal{units: units}
+}
+
+// FromFloat converts f rounding it to nine fractional digits, NaN, infinities and values
+// out of the range of Decimal are an error.
+func FromFloat(f float64) (Decimal, error) {
+	d, err := Parse(strconv.FormatFloat(f, 'f', nanoDigits, 64))
+	if err != nil {
+		return Zero, errors.Errorf("float %v isn't a decimal", f)
+	}
+	return d, nil
+}
+
+func FromQuotation(q *investapi.Quotation) Decimal {
+	return New(q.GetUnits(), q.GetNano())
+}
+
+// Parse reads a decimal like "-250.05", digits beyond the ninth fractional one are an error.
+func Parse(s string) (Decimal, error) {
+	str := strings.TrimSpace(s)
+	negative := strings.HasPrefix(str, "-")
+	str = strings.TrimPrefix(strings.TrimPrefix(str, "-"), "+")
+	intPart, fracPart := str, ""
+	if idx := strings.IndexByte(str, '.'); idx >= 0 {
+		intPart, fracPart = str[:idx], str[idx+1:]
+	}
+	if intPart == "" && fracPart == "" {
+		return Zero, errors.Errorf("invalid decimal %q", s)
+	}
+	if len(fracPart) > nanoDigits {
+		return Zero, errors.Errorf("decimal %q has more than %v fractional digits", s, nanoDigits)
+	}
+
+	var units int64
+	if intPart != "" {
+		parsed, err := strconv.ParseUint(intPart, 10, 63)
+		if err != nil {
+			return Zero, errors.Wrapf(err, "invalid decimal %q", s)
+		}
+		units = int64(parsed)
+	}
+	var nano int32
+	if fracPart != "" {
+		parsed, err := strconv.ParseUint(fracPart+strings.Repeat("0", nanoDigits-len(fracPart)), 10, 32)
+		if err != nil {
+			return Zero, errors.Wrapf(err, "invalid decimal %q", s)
+		}
+		nano = int32(parsed)
+	}
+	if negative {
+		return Decimal{units: -units, nano: -nano}, nil
+	}
+	return Decimal{units: units, nano: nano}, nil
+}
+
+func MustParse(s string) Decimal {
+	d, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (d Decimal) Units() int64 {
+	return d.units
+}
+
+func (d Decimal) Nano() int32 {
+	return d.nano
+}
+
+func (d Decimal) Quotation() *investapi.Quotation {
+	return &investapi.Quotation{
+		Units: d.units,
+		Nano:  d.nano,
+	}
+}
+
+func (d Decimal) String() string {
+	sign := ""
+	units, nano := d.units, d.nano
+	if units < 0 || nano < 0 {
+		sign = "-"
+		units, nano = -units, -nano
+	}
+	str := sign + strconv.FormatInt(units, 10)
+	if nano == 0 {
+		return str
+	}
+	frac := strconv.FormatInt(int64(nano), 10)
+	frac = strings.Repeat("0", nanoDigits-len(frac)) + frac
+	return str + "." + strings.TrimRight(frac, "0")
+}
+
+// Float64 is meant for reports and charts, calculations should stay in Decimal.
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Decimal) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d Decimal) toBig() *big.Int {
+	n := big.NewInt(d.units)
+	n.Mul(n, bigNanoPerUnit)
+	return n.Add(n, big.NewInt(int64(d.nano)))
+}
+
+func fromBig(n *big.Int) Decimal {
+	units, nano := new(big.Int).QuoRem(n, bigNanoPerUnit, new(big.Int))
+	return Decimal{units: units.Int64(), nano: int32(nano.Int64())}
+}
+
+func (d Decimal) Add(other Decimal) Decimal {
+	return New(d.units+other.units, d.nano+other.nano)
+}
+
+func (d Decimal) Sub(other Decimal) Decimal {
+	return d.Add(other.Neg())
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{units: -d.units, nano: -d.nano}
+}
+
+func (d Decimal) Abs() Decimal {
+	if d.Sign() < 0 {
+		return d.Neg()
+	}
+	return d
+}
+
+func (d Decimal) MulInt(n int64) Decimal {
+	return fromBig(d.toBig().Mul(d.toBig(), big.NewInt(n)))
+}
+
+// Mul multiplies exactly and rounds the product to nine fractional digits.
+func (d Decimal) Mul(other Decimal) Decimal {
+	product := new(big.Int).Mul(d.toBig(), other.toBig())
+	return fromBig(divRound(product, bigNanoPerUnit, RoundNearest))
+}
+
+// Div divides and rounds the quotient to nine fractional digits, division by zero returns zero.
+func (d Decimal) Div(other Decimal) Decimal {
+	return d.DivRound(other, RoundNearest)
+}
+
+func (d Decimal) DivRound(other Decimal, mode RoundingMode) Decimal {
+	if other.IsZero() {
+		return Zero
+	}
+	numerator := new(big.Int).Mul(d.toBig(), bigNanoPerUnit)
+	return fromBig(divRound(numerator, other.toBig(), mode))
+}
+
+func (d Decimal) DivInt(n int64) Decimal {
+	if n == 0 {
+		return Zero
+	}
+	return fromBig(divRound(d.toBig(), big.NewInt(n), RoundNearest))
+}
+
+// RoundTo rounds d to a multiple of increment, e.g. an instrument's min_price_increment.
+// A non positive increment leaves d unchanged.
+func (d Decimal) RoundTo(increment Decimal, mode RoundingMode) Decimal {
+	if increment.Sign() <= 0 {
+		return d
+	}
+	inc := increment.toBig()
+	steps := divRound(d.toBig(), inc, mode)
+	return fromBig(steps.Mul(steps, inc))
+}
+
+// Sqrt returns the square root rounded down to nine fractional digits, a negative d gives zero.
+func (d Decimal) Sqrt() Decimal {
+	if d.Sign() <= 0 {
+		return Zero
+	}
+	n := d.toBig()
+	return fromBig(n.Sqrt(n.Mul(n, bigNanoPerUnit)))
+}
+
+// Truncate drops the fraction, e.g. to count whole lots.
+func (d Decimal) Truncate() int64 {
+	return d.units
+}
+
+func (d Decimal) Cmp(other Decimal) int {
+	switch {
+	case d.units < other.units:
+		return -1
+	case d.units > other.units:
+		return 1
+	case d.nano < other.nano:
+		return -1
+	case d.nano > other.nano:
+		return 1
+	}
+	return 0
+}
+
+func (d Decimal) Equal(other Decimal) bool {
+	return d.Cmp(other) == 0
+}
+
+func (d Decimal) LessThan(other Decimal) bool {
+	return d.Cmp(other) < 0
+}
+
+func (d Decimal) GreaterThan(other Decimal) bool {
+	return d.Cmp(other) > 0
+}
+
+func (d Decimal) IsZero() bool {
+	return d.units == 0 && d.nano == 0
+}
+
+func (d Decimal) Sign() int {
+	return d.Cmp(Zero)
+}
+
+func Min(a, b Decimal) Decimal {
+	if a.LessThan(b) {
+		return a
+	}
+	return b
+}
+
+func Max(a, b Decimal) Decimal {
+	if a.GreaterThan(b) {
+		return a
+	}
+	return b
+}
+
+// divRound returns x/y rounded with mode, y must not be zero.
+func divRound(x, y *big.Int, mode RoundingMode) *big.Int {
+	if y.Sign() < 0 {
+		x = new(big.Int).Neg(x)
+		y = new(big.Int).Neg(y)
+	}
+	switch mode {
+	case RoundUp:
+		q := new(big.Int).Neg(x)
+		q.Div(q, y)
+		return q.Neg(q)
+	case RoundNearest:
+		twice := new(big.Int).Mul(x, big.NewInt(2))
+		if x.Sign() >= 0 {
+			twice.Add(twice, y)
+			return twice.Div(twice, new(big.Int).Mul(y, big.NewInt(2)))
+		}
+		twice.Neg(twice).Add(twice, y)
+		twice.Div(twice, new(big.Int).Mul(y, big.NewInt(2)))
+		return twice.Neg(twice)
+	default:
+		return new(big.Int).Div(x, y)
+	}
+}
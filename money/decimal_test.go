@@ -0,0 +1,203 @@
+package money
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		units int64
+		nano  int32
+		err   bool
+	}{
+		{in: "0", units: 0, nano: 0},
+		{in: "250.5", units: 250, nano: 500000000},
+		{in: "-250.05", units: -250, nano: -50000000},
+		{in: "0.005", units: 0, nano: 5000000},
+		{in: "-0.005", units: 0, nano: -5000000},
+		{in: "+1.000000001", units: 1, nano: 1},
+		{in: " 12 ", units: 12, nano: 0},
+		{in: ".5", units: 0, nano: 500000000},
+		{in: "7.", units: 7, nano: 0},
+		{in: "0.0000000001", err: true},
+		{in: "", err: true},
+		{in: "-", err: true},
+		{in: ".", err: true},
+		{in: "1e3", err: true},
+		{in: "1.-5", err: true},
+		{in: "abc", err: true},
+		{in: "99999999999999999999", err: true},
+	}
+	for _, test := range tests {
+		d, err := Parse(test.in)
+		if test.err {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", test.in, d)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.in, err)
+			continue
+		}
+		if d.Units() != test.units || d.Nano() != test.nano {
+			t.Errorf("Parse(%q) = %v/%v, want %v/%v", test.in, d.Units(), d.Nano(), test.units, test.nano)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		d   Decimal
+		out string
+	}{
+		{d: Zero, out: "0"},
+		{d: New(250, 500000000), out: "250.5"},
+		{d: New(0, 5000000), out: "0.005"},
+		{d: New(0, -5000000), out: "-0.005"},
+		{d: New(-3, -1), out: "-3.000000001"},
+		{d: New(12, 0), out: "12"},
+	}
+	for _, test := range tests {
+		if out := test.d.String(); out != test.out {
+			t.Errorf("String() = %q, want %q", out, test.out)
+		}
+		if parsed := MustParse(test.out); !parsed.Equal(test.d) {
+			t.Errorf("Parse(%q) = %v, want %v", test.out, parsed, test.d)
+		}
+	}
+}
+
+func TestNewNormalizes(t *testing.T) {
+	tests := []struct {
+		units int64
+		nano  int32
+		want  string
+	}{
+		{units: 1, nano: 1500000000, want: "2.5"},
+		{units: 1, nano: -500000000, want: "0.5"},
+		{units: -1, nano: 500000000, want: "-0.5"},
+		{units: 0, nano: -2000000000, want: "-2"},
+		{units: 0, nano: 5000000, want: "0.005"},
+		{units: 2, nano: -2000000000, want: "0"},
+	}
+	for _, test := range tests {
+		d := New(test.units, test.nano)
+		if d.String() != test.want {
+			t.Errorf("New(%v, %v) = %v, want %v", test.units, test.nano, d, test.want)
+		}
+		if d.Units() != 0 && d.Nano() != 0 && (d.Units() < 0) != (d.Nano() < 0) {
+			t.Errorf("New(%v, %v) has units %v and nano %v of different signs", test.units, test.nano, d.Units(), d.Nano())
+		}
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	tests := []struct {
+		f    float64
+		want string
+		err  bool
+	}{
+		{f: 250.5, want: "250.5"},
+		{f: 0.005, want: "0.005"},
+		{f: -0.1, want: "-0.1"},
+		{f: 1e-10, want: "0"},
+		{f: math.NaN(), err: true},
+		{f: math.Inf(1), err: true},
+		{f: 1e30, err: true},
+	}
+	for _, test := range tests {
+		d, err := FromFloat(test.f)
+		if test.err {
+			if err == nil {
+				t.Errorf("FromFloat(%v) = %v, want an error", test.f, d)
+			}
+			continue
+		}
+		if err != nil || d.String() != test.want {
+			t.Errorf("FromFloat(%v) = %v, %v, want %v", test.f, d, err, test.want)
+		}
+	}
+}
+
+func TestDivRound(t *testing.T) {
+	tests := []struct {
+		x, y int64
+		mode RoundingMode
+		want int64
+	}{
+		{x: 7, y: 2, mode: RoundDown, want: 3},
+		{x: 7, y: 2, mode: RoundUp, want: 4},
+		{x: 7, y: 2, mode: RoundNearest, want: 4},
+		{x: -7, y: 2, mode: RoundDown, want: -4},
+		{x: -7, y: 2, mode: RoundUp, want: -3},
+		{x: -7, y: 2, mode: RoundNearest, want: -4},
+		{x: 7, y: -2, mode: RoundDown, want: -4},
+		{x: 7, y: -2, mode: RoundUp, want: -3},
+		{x: 5, y: 3, mode: RoundNearest, want: 2},
+		{x: 4, y: 3, mode: RoundNearest, want: 1},
+		{x: -4, y: 3, mode: RoundNearest, want: -1},
+		{x: 6, y: 3, mode: RoundUp, want: 2},
+		{x: -6, y: 3, mode: RoundDown, want: -2},
+	}
+	for _, test := range tests {
+		got := divRound(big.NewInt(test.x), big.NewInt(test.y), test.mode)
+		if got.Int64() != test.want {
+			t.Errorf("divRound(%v, %v, %v) = %v, want %v", test.x, test.y, test.mode, got, test.want)
+		}
+	}
+}
+
+func TestDivAndMulRounding(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Decimal
+		want string
+	}{
+		{name: "1/3", got: FromInt(1).Div(FromInt(3)), want: "0.333333333"},
+		{name: "2/3", got: FromInt(2).Div(FromInt(3)), want: "0.666666667"},
+		{name: "-2/3", got: FromInt(-2).Div(FromInt(3)), want: "-0.666666667"},
+		{name: "1/3 up", got: FromInt(1).DivRound(FromInt(3), RoundUp), want: "0.333333334"},
+		{name: "-1/3 down", got: FromInt(-1).DivRound(FromInt(3), RoundDown), want: "-0.333333334"},
+		{name: "by zero", got: FromInt(1).Div(Zero), want: "0"},
+		{name: "250.5/2", got: MustParse("250.5").DivInt(2), want: "125.25"},
+		{name: "half nano", got: New(0, 5).Mul(MustParse("0.1")), want: "0.000000001"},
+		{name: "250.5*0.003", got: MustParse("250.5").Mul(MustParse("0.003")), want: "0.7515"},
+	}
+	for _, test := range tests {
+		if !test.got.Equal(MustParse(test.want)) {
+			t.Errorf("%v = %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestRoundTo(t *testing.T) {
+	tick := New(0, 5000000)
+	tests := []struct {
+		d         string
+		increment Decimal
+		mode      RoundingMode
+		want      string
+	}{
+		{d: "250.5", increment: FromInt(1), mode: RoundNearest, want: "251"},
+		{d: "250.5", increment: FromInt(1), mode: RoundDown, want: "250"},
+		{d: "-250.5", increment: FromInt(1), mode: RoundNearest, want: "-251"},
+		{d: "-250.5", increment: FromInt(1), mode: RoundUp, want: "-250"},
+		{d: "100.012", increment: tick, mode: RoundDown, want: "100.01"},
+		{d: "100.012", increment: tick, mode: RoundUp, want: "100.015"},
+		{d: "100.0125", increment: tick, mode: RoundNearest, want: "100.015"},
+		{d: "100.0124", increment: tick, mode: RoundNearest, want: "100.01"},
+		{d: "100.015", increment: tick, mode: RoundUp, want: "100.015"},
+		{d: "0.003", increment: New(0, 10000000), mode: RoundUp, want: "0.01"},
+		{d: "250.5", increment: Zero, mode: RoundNearest, want: "250.5"},
+	}
+	for _, test := range tests {
+		got := MustParse(test.d).RoundTo(test.increment, test.mode)
+		if !got.Equal(MustParse(test.want)) {
+			t.Errorf("%v.RoundTo(%v, %v) = %v, want %v", test.d, test.increment, test.mode, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package money
+
+import (
+	"sort"
+	"strings"
+
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+)
+
+const RUB = "rub"
+
+var ErrCurrencyMismatch = errors.New("currencies of amounts don't match")
+
+// Money is an amount in a currency, currency codes are kept in lower case as the API returns them.
+type Money struct {
+	Amount   Decimal
+	Currency string
+}
+
+func NewMoney(amount Decimal, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToLower(currency)}
+}
+
+func FromMoneyValue(m *investapi.MoneyValue) Money {
+	return NewMoney(New(m.GetUnits(), m.GetNano()), m.GetCurrency())
+}
+
+func (m Money) MoneyValue() *investapi.MoneyValue {
+	return &investapi.MoneyValue{
+		Currency: m.Currency,
+		Units:    m.Amount.units,
+		Nano:     m.Amount.nano,
+	}
+}
+
+func (m Money) String() string {
+	return m.Amount.String() + " " + m.Currency
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount.IsZero()
+}
+
+func (m Money) sameCurrency(other Money) bool {
+	// zero amount without currency is a neutral element, e.g. an unset total
+	if m.Currency == "" && m.Amount.IsZero() || other.Currency == "" && other.Amount.IsZero() {
+		return true
+	}
+	return m.Currency == other.Currency
+}
+
+func (m Money) currency(other Money) string {
+	if m.Currency != "" {
+		return m.Currency
+	}
+	return other.Currency
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if !m.sameCurrency(other) {
+		return Money{}, errors.Wrapf(ErrCurrencyMismatch, "%v + %v", m, other)
+	}
+	return Money{Amount: m.Amount.Add(other.Amount), Currency: m.currency(other)}, nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if !m.sameCurrency(other) {
+		return Money{}, errors.Wrapf(ErrCurrencyMismatch, "%v - %v", m, other)
+	}
+	return Money{Amount: m.Amount.Sub(other.Amount), Currency: m.currency(other)}, nil
+}
+
+func (m Money) MulInt(n int64) Money {
+	return Money{Amount: m.Amount.MulInt(n), Currency: m.Currency}
+}
+
+func (m Money) Mul(d Decimal) Money {
+	return Money{Amount: m.Amount.Mul(d), Currency: m.Currency}
+}
+
+func (m Money) Cmp(other Money) (int, error) {
+	if !m.sameCurrency(other) {
+		return 0, errors.Wrapf(ErrCurrencyMismatch, "%v <> %v", m, other)
+	}
+	return m.Amount.Cmp(other.Amount), nil
+}
+
+// Totals keeps sums split by currency.
+type Totals map[string]Decimal
+
+func (t Totals) Add(m Money) {
+	t[m.Currency] = t[m.Currency].Add(m.Amount)
+}
+
+func (t Totals) Get(currency string) Money {
+	currency = strings.ToLower(currency)
+	return Money{Amount: t[currency], Currency: currency}
+}
+
+// String lists amounts sorted by currency, e.g. "10 rub, 2 usd".
+func (t Totals) String() string {
+	currencies := make([]string, 0, len(t))
+	for currency := range t {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	values := make([]string, 0, len(currencies))
+	for _, currency := range currencies {
+		values = append(values, t.Get(currency).String())
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,382 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/atomicfile"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+// journalOverlap is requested again on every sync, operations in progress change their state.
+const journalOverlap = 24 * time.Hour
+
+// History reads operations of accounts from the API and keeps them in a local journal.
+type History interface {
+	// Operations requests operations matching filter from the API
+	Operations(ctx context.Context, accountID string, filter api.OperationsFilter) ([]*investapi.Operation, error)
+	// Sync saves operations since the previous sync to the journal, the first sync starts
+	// from the opening of the account. It returns the number of new operations.
+	Sync(ctx context.Context, accountID string) (int, error)
+	// Journal returns saved operations matching filter sorted by date
+	Journal(accountID string, filter api.OperationsFilter) ([]*investapi.Operation, error)
+	// Deals groups saved trades per order, commissions are kept apart from the traded amount
+	Deals(accountID string, filter api.OperationsFilter) ([]Deal, error)
+	// RecordOrder saves which trades belong to the order. Operations of the API have no order id,
+	// trades of orders that weren't recorded are grouped by their operation.
+	RecordOrder(accountID string, order *investapi.OrderState) error
+}
+
+type Trade struct {
+	ID       string
+	Time     time.Time
+	Quantity int64
+	Price    money.Decimal
+}
+
+// Deal is an executed order with all of its trades.
+type Deal struct {
+	// OrderID is empty when the order wasn't recorded
+	OrderID      string
+	OperationIDs []string
+	AccountID    string
+	Figi         string
+	Direction    investapi.OrderDirection
+	Currency     string
+	// Quantity in pieces
+	Quantity     int64
+	AveragePrice money.Decimal
+	// Amount is paid for bought or received for sold instruments, it's negative for buys
+	Amount money.Decimal
+	// Commission is the sum of broker fees of the deal, it's negative
+	Commission money.Decimal
+	Trades     []Trade
+	// Time of the first trade
+	Time time.Time
+}
+
+type journalFile struct {
+	SyncedTo   time.Time         `json:"synced_to"`
+	Operations []json.RawMessage `json:"operations"`
+	// Orders maps trade id to order id
+	Orders map[string]string `json:"orders"`
+}
+
+type journal struct {
+	syncedTo   time.Time
+	operations map[string]*investapi.Operation
+	orders     map[string]string
+}
+
+type history struct {
+	client *api.Client
+	dir    string
+
+	mu       sync.Mutex
+	journals map[string]*journal
+}
+
+// NewHistory keeps the journal of every account in dir, empty dir keeps journals in memory only.
+func NewHistory(client *api.Client, dir string) History {
+	return &history{
+		client:   client,
+		dir:      dir,
+		journals: make(map[string]*journal),
+	}
+}
+
+func (h *history) Operations(ctx context.Context, accountID string, filter api.OperationsFilter) ([]*investapi.Operation, error) {
+	if filter.From.IsZero() {
+		from, err := accountOpenedAt(ctx, h.client, accountID)
+		if err != nil {
+			return nil, err
+		}
+		filter.From = from
+	}
+	return h.client.GetOperationsRange(ctx, accountID, filter)
+}
+
+func (h *history) Sync(ctx context.Context, accountID string) (int, error) {
+	h.mu.Lock()
+	j, err := h.load(accountID)
+	h.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	from := j.syncedTo.Add(-journalOverlap)
+	if j.syncedTo.IsZero() {
+		from, err = accountOpenedAt(ctx, h.client, accountID)
+		if err != nil {
+			return 0, err
+		}
+	}
+	to := time.Now()
+	operations, err := h.client.GetOperationsRange(ctx, accountID, api.OperationsFilter{From: from, To: to})
+	if err != nil {
+		return 0, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	added := 0
+	for _, operation := range operations {
+		if _, ok := j.operations[operation.GetId()]; !ok {
+			added++
+		}
+		j.operations[operation.GetId()] = operation
+	}
+	j.syncedTo = to
+	if err = h.save(accountID, j); err != nil {
+		return added, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"account_id": accountID,
+		"added":      added,
+		"synced_to":  to,
+	}).Info("operations journal synced")
+	return added, nil
+}
+
+func (h *history) Journal(accountID string, filter api.OperationsFilter) ([]*investapi.Operation, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	j, err := h.load(accountID)
+	if err != nil {
+		return nil, err
+	}
+	operations := []*investapi.Operation{}
+	for _, operation := range j.operations {
+		if filter.Match(operation) {
+			operations = append(operations, operation)
+		}
+	}
+	sortOperations(operations)
+	return operations, nil
+}
+
+func (h *history) Deals(accountID string, filter api.OperationsFilter) ([]Deal, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	j, err := h.load(accountID)
+	if err != nil {
+		return nil, err
+	}
+	operations := make([]*investapi.Operation, 0, len(j.operations))
+	for _, operation := range j.operations {
+		operations = append(operations, operation)
+	}
+	sortOperations(operations)
+
+	// trade filters apply to deals, fees are found by their parent operation
+	filter.Types = nil
+	deals := []*Deal{}
+	byKey := make(map[string]*Deal)
+	byOperation := make(map[string]*Deal)
+	for _, operation := range operations {
+		direction, ok := TradeDirection(operation.GetOperationType())
+		if !ok || operation.GetState() == investapi.OperationState_OPERATION_STATE_CANCELED || !filter.Match(operation) {
+			continue
+		}
+		key := "operation:" + operation.GetId()
+		orderID := ""
+		for _, trade := range operation.GetTrades() {
+			if id, ok := j.orders[trade.GetTradeId()]; ok {
+				key, orderID = "order:"+id, id
+				break
+			}
+		}
+		deal, ok := byKey[key]
+		if !ok {
+			deal = &Deal{
+				OrderID:   orderID,
+				AccountID: accountID,
+				Figi:      operation.GetFigi(),
+				Direction: direction,
+				Currency:  operation.GetCurrency(),
+				Time:      operation.GetDate().AsTime(),
+			}
+			byKey[key] = deal
+			deals = append(deals, deal)
+		}
+		deal.add(operation)
+		byOperation[operation.GetId()] = deal
+	}
+
+	for _, operation := range operations {
+		if operation.GetOperationType() != investapi.OperationType_OPERATION_TYPE_BROKER_FEE {
+			continue
+		}
+		if deal, ok := byOperation[operation.GetParentOperationId()]; ok {
+			deal.Commission = deal.Commission.Add(money.FromMoneyValue(operation.GetPayment()).Amount)
+		}
+	}
+
+	result := make([]Deal, 0, len(deals))
+	for _, deal := range deals {
+		if deal.Quantity > 0 {
+			deal.AveragePrice = deal.Amount.Abs().DivInt(deal.Quantity)
+		}
+		result = append(result, *deal)
+	}
+	sort.SliceStable(result, func(a, b int) bool { return result[a].Time.Before(result[b].Time) })
+	return result, nil
+}
+
+// add counts trades of the operation, an operation without trades is a single trade.
+func (d *Deal) add(operation *investapi.Operation) {
+	d.OperationIDs = append(d.OperationIDs, operation.GetId())
+	d.Amount = d.Amount.Add(money.FromMoneyValue(operation.GetPayment()).Amount)
+	trades := operation.GetTrades()
+	if len(trades) == 0 {
+		trades = []*investapi.OperationTrade{{
+			TradeId:  operation.GetId(),
+			DateTime: operation.GetDate(),
+			Quantity: operation.GetQuantity(),
+			Price:    operation.GetPrice(),
+		}}
+	}
+	for _, trade := range trades {
+		d.Quantity += trade.GetQuantity()
+		d.Trades = append(d.Trades, Trade{
+			ID:       trade.GetTradeId(),
+			Time:     trade.GetDateTime().AsTime(),
+			Quantity: trade.GetQuantity(),
+			Price:    money.FromMoneyValue(trade.GetPrice()).Amount,
+		})
+		if trade.GetDateTime().AsTime().Before(d.Time) {
+			d.Time = trade.GetDateTime().AsTime()
+		}
+	}
+}
+
+func (h *history) RecordOrder(accountID string, order *investapi.OrderState) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	j, err := h.load(accountID)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, stage := range order.GetStages() {
+		if stage.GetTradeId() == "" || j.orders[stage.GetTradeId()] == order.GetOrderId() {
+			continue
+		}
+		j.orders[stage.GetTradeId()] = order.GetOrderId()
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return h.save(accountID, j)
+}
+
+// TradeDirection tells whether the operation is a buy or a sell of an instrument.
+func TradeDirection(operationType investapi.OperationType) (investapi.OrderDirection, bool) {
+	switch operationType {
+	case investapi.OperationType_OPERATION_TYPE_BUY,
+		investapi.OperationType_OPERATION_TYPE_BUY_CARD,
+		investapi.OperationType_OPERATION_TYPE_BUY_MARGIN,
+		investapi.OperationType_OPERATION_TYPE_DELIVERY_BUY:
+		return investapi.OrderDirection_ORDER_DIRECTION_BUY, true
+	case investapi.OperationType_OPERATION_TYPE_SELL,
+		investapi.OperationType_OPERATION_TYPE_SELL_CARD,
+		investapi.OperationType_OPERATION_TYPE_SELL_MARGIN,
+		investapi.OperationType_OPERATION_TYPE_DELIVERY_SELL:
+		return investapi.OrderDirection_ORDER_DIRECTION_SELL, true
+	}
+	return investapi.OrderDirection_ORDER_DIRECTION_UNSPECIFIED, false
+}
+
+func sortOperations(operations []*investapi.Operation) {
+	sort.SliceStable(operations, func(i, j int) bool {
+		a, b := operations[i].GetDate().AsTime(), operations[j].GetDate().AsTime()
+		if a.Equal(b) {
+			return operations[i].GetId() < operations[j].GetId()
+		}
+		return a.Before(b)
+	})
+}
+
+func (h *history) path(accountID string) string {
+	return filepath.Join(h.dir, accountID+".json")
+}
+
+// load returns the journal of the account, it's read from disk once. h.mu must be held.
+func (h *history) load(accountID string) (*journal, error) {
+	if j, ok := h.journals[accountID]; ok {
+		return j, nil
+	}
+	j := &journal{
+		operations: make(map[string]*investapi.Operation),
+		orders:     make(map[string]string),
+	}
+	if h.dir != "" {
+		data, err := os.ReadFile(h.path(accountID))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "fail read operations journal")
+		}
+		if err == nil {
+			if err = j.unmarshal(data); err != nil {
+				return nil, errors.Wrapf(err, "fail parse operations journal %v", h.path(accountID))
+			}
+		}
+	}
+	h.journals[accountID] = j
+	return j, nil
+}
+
+// save rewrites the journal file of the account. h.mu must be held.
+func (h *history) save(accountID string, j *journal) error {
+	if h.dir == "" {
+		return nil
+	}
+	file := journalFile{SyncedTo: j.syncedTo, Orders: j.orders}
+	operations := make([]*investapi.Operation, 0, len(j.operations))
+	for _, operation := range j.operations {
+		operations = append(operations, operation)
+	}
+	sortOperations(operations)
+	for _, operation := range operations {
+		data, err := protojson.Marshal(operation)
+		if err != nil {
+			return errors.Wrap(err, "fail marshal operation")
+		}
+		file.Operations = append(file.Operations, data)
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return errors.Wrap(err, "fail marshal operations journal")
+	}
+	return atomicfile.WriteFile(h.path(accountID), data)
+}
+
+func (j *journal) unmarshal(data []byte) error {
+	file := journalFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	j.syncedTo = file.SyncedTo
+	for tradeID, orderID := range file.Orders {
+		j.orders[tradeID] = orderID
+	}
+	for _, raw := range file.Operations {
+		operation := &investapi.Operation{}
+		if err := protojson.Unmarshal(raw, operation); err != nil {
+			return err
+		}
+		j.operations[operation.GetId()] = operation
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+package profile
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+const (
+	// sandbox has no portfolio stream, portfolios are polled
+	portfolioPollInterval = 10 * time.Second
+	portfolioUpdatesSize  = 100
+	streamMinBackoff      = time.Second
+	streamMaxBackoff      = time.Minute
+)
+
+// Portfolios values positions of accounts.
+type Portfolios interface {
+	// Get returns the portfolio kept up to date by Run, other accounts are requested
+	Get(ctx context.Context, accountID string) (*Portfolio, error)
+	// Refresh requests the portfolio even when it's kept by Run
+	Refresh(ctx context.Context, accountID string) (*Portfolio, error)
+	// Run keeps portfolios of accounts up to date from PortfolioStream until ctx is done, sandbox is polled
+	Run(ctx context.Context, accountIDs ...string) error
+	// Updates publishes every portfolio received by Run, updates are dropped when nobody reads them
+	Updates() <-chan *Portfolio
+}
+
+// Position is an instrument of the portfolio, closed positions are kept for their realised P&L.
+type Position struct {
+	Figi           string
+	InstrumentType string
+	Currency       string
+	// Quantity in pieces, negative for shorts
+	Quantity     money.Decimal
+	AveragePrice money.Decimal
+	CurrentPrice money.Decimal
+	Value        money.Decimal
+	Unrealised   money.Decimal
+	// Realised is P&L of closed trades with fees, dividends and coupons since the account was opened
+	Realised money.Decimal
+}
+
+func (p Position) IsOpen() bool {
+	return !p.Quantity.IsZero()
+}
+
+type Portfolio struct {
+	AccountID  string
+	UpdatedAt  time.Time
+	Positions  []Position
+	Value      money.Totals
+	Unrealised money.Totals
+	Realised   money.Totals
+}
+
+// Position returns the position of figi, ok is false when there was no trade with it.
+func (p *Portfolio) Position(figi string) (position Position, ok bool) {
+	for _, position := range p.Positions {
+		if position.Figi == figi {
+			return position, true
+		}
+	}
+	return Position{}, false
+}
+
+func (p *Portfolio) copy() *Portfolio {
+	result := *p
+	result.Positions = append([]Position(nil), p.Positions...)
+	result.Value = copyTotals(p.Value)
+	result.Unrealised = copyTotals(p.Unrealised)
+	result.Realised = copyTotals(p.Realised)
+	return &result
+}
+
+func copyTotals(totals money.Totals) money.Totals {
+	result := make(money.Totals, len(totals))
+	for currency, amount := range totals {
+		result[currency] = amount
+	}
+	return result
+}
+
+// realised is P&L of closed trades by figi, it's recalculated when quantities change
+type realised struct {
+	quantities string
+	byFigi     map[string]money.Money
+	// fees and payments without figi
+	account money.Totals
+}
+
+type portfolios struct {
+	client *api.Client
+
+	mu       sync.Mutex
+	kept     map[string]*Portfolio
+	realised map[string]*realised
+	openedAt map[string]time.Time
+	updates  chan *Portfolio
+}
+
+func newPortfolios(client *api.Client) *portfolios {
+	return &portfolios{
+		client:   client,
+		kept:     make(map[string]*Portfolio),
+		realised: make(map[string]*realised),
+		openedAt: make(map[string]time.Time),
+		updates:  make(chan *Portfolio, portfolioUpdatesSize),
+	}
+}
+
+func (p *portfolios) Updates() <-chan *Portfolio {
+	return p.updates
+}
+
+func (p *portfolios) Get(ctx context.Context, accountID string) (*Portfolio, error) {
+	p.mu.Lock()
+	portfolio, ok := p.kept[accountID]
+	p.mu.Unlock()
+	if ok {
+		return portfolio.copy(), nil
+	}
+	return p.Refresh(ctx, accountID)
+}
+
+func (p *portfolios) Refresh(ctx context.Context, accountID string) (*Portfolio, error) {
+	resp, err := p.client.Broker.GetPortfolio(ctx, &investapi.PortfolioRequest{AccountId: accountID})
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail get portfolio of %v", accountID)
+	}
+	return p.value(ctx, accountID, resp)
+}
+
+func (p *portfolios) Run(ctx context.Context, accountIDs ...string) error {
+	if len(accountIDs) == 0 {
+		return errors.New("no accounts to keep portfolios of")
+	}
+	if p.client.IsSandbox() || p.client.OperationsStreamServiceClient == nil {
+		return p.poll(ctx, accountIDs)
+	}
+
+	wg := sync.WaitGroup{}
+	for _, accountID := range accountIDs {
+		accountID := accountID
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.runStream(ctx, accountID)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (p *portfolios) poll(ctx context.Context, accountIDs []string) error {
+	ticker := time.NewTicker(portfolioPollInterval)
+	defer ticker.Stop()
+	for {
+		for _, accountID := range accountIDs {
+			portfolio, err := p.Refresh(ctx, accountID)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				logrus.WithError(err).WithField("account_id", accountID).Warn("fail refresh portfolio")
+				continue
+			}
+			p.keep(portfolio)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// runStream keeps one stream per account, portfolio messages don't say which account they are of.
+func (p *portfolios) runStream(ctx context.Context, accountID string) {
+	backoff := streamMinBackoff
+	for {
+		err := p.streamOnce(ctx, accountID)
+		if ctx.Err() != nil {
+			return
+		}
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"account_id": accountID,
+			"backoff":    backoff,
+		}).Warn("portfolio stream dropped, reconnecting")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+func (p *portfolios) streamOnce(ctx context.Context, accountID string) error {
+	stream, err := p.client.OperationsStreamServiceClient.PortfolioStream(ctx, &investapi.PortfolioStreamRequest{
+		Accounts: []string{accountID},
+	})
+	if err != nil {
+		return errors.Wrap(err, "fail open portfolio stream")
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("portfolio stream closed by server")
+			}
+			return errors.Wrap(err, "fail receive portfolio")
+		}
+		if subscriptions := resp.GetSubscriptions(); subscriptions != nil {
+			for _, account := range subscriptions.GetAccounts() {
+				if account.SubscriptionStatus != investapi.PortfolioSubscriptionStatus_PORTFOLIO_SUBSCRIPTION_STATUS_SUCCESS {
+					return errors.Errorf("portfolio subscription of %v: %v", account.AccountId, account.SubscriptionStatus)
+				}
+			}
+		}
+		if resp.GetPortfolio() == nil {
+			continue
+		}
+		portfolio, err := p.value(ctx, accountID, resp.GetPortfolio())
+		if err != nil {
+			return err
+		}
+		p.keep(portfolio)
+	}
+}
+
+func (p *portfolios) keep(portfolio *Portfolio) {
+	p.mu.Lock()
+	p.kept[portfolio.AccountID] = portfolio
+	p.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"account_id": portfolio.AccountID,
+		"value":      portfolio.Value.String(),
+		"unrealised": portfolio.Unrealised.String(),
+		"realised":   portfolio.Realised.String(),
+	}).Debug("portfolio updated")
+
+	select {
+	case p.updates <- portfolio.copy():
+	default:
+	}
+}
+
+// value adds P&L to the positions of resp.
+func (p *portfolios) value(ctx context.Context, accountID string, resp *investapi.PortfolioResponse) (*Portfolio, error) {
+	portfolio := &Portfolio{
+		AccountID:  accountID,
+		UpdatedAt:  time.Now(),
+		Value:      make(money.Totals),
+		Unrealised: make(money.Totals),
+		Realised:   make(money.Totals),
+	}
+	quantities := []string{}
+	for _, pos := range resp.GetPositions() {
+		position := Position{
+			Figi:           pos.GetFigi(),
+			InstrumentType: pos.GetInstrumentType(),
+			Currency:       strings.ToLower(pos.GetCurrentPrice().GetCurrency()),
+			Quantity:       money.FromQuotation(pos.GetQuantity()),
+			AveragePrice:   money.FromMoneyValue(pos.GetAveragePositionPrice()).Amount,
+			CurrentPrice:   money.FromMoneyValue(pos.GetCurrentPrice()).Amount,
+		}
+		if position.Currency == "" {
+			position.Currency = strings.ToLower(pos.GetAveragePositionPrice().GetCurrency())
+		}
+		position.Value = position.CurrentPrice.Mul(position.Quantity)
+		if !position.CurrentPrice.IsZero() {
+			position.Unrealised = position.CurrentPrice.Sub(position.AveragePrice).Mul(position.Quantity)
+		}
+		portfolio.Positions = append(portfolio.Positions, position)
+		quantities = append(quantities, position.Figi+":"+position.Quantity.String())
+	}
+	sort.Strings(quantities)
+
+	realised, err := p.realisedOf(ctx, accountID, strings.Join(quantities, ","))
+	if err != nil {
+		return nil, err
+	}
+	for i := range portfolio.Positions {
+		position := &portfolio.Positions[i]
+		if pnl, ok := realised.byFigi[position.Figi]; ok {
+			position.Realised = pnl.Amount
+		}
+	}
+	for figi, pnl := range realised.byFigi {
+		if _, ok := portfolio.Position(figi); !ok {
+			portfolio.Positions = append(portfolio.Positions, Position{
+				Figi:     figi,
+				Currency: pnl.Currency,
+				Realised: pnl.Amount,
+			})
+		}
+	}
+	sort.Slice(portfolio.Positions, func(i, j int) bool {
+		return portfolio.Positions[i].Figi < portfolio.Positions[j].Figi
+	})
+
+	for _, position := range portfolio.Positions {
+		portfolio.Value.Add(money.NewMoney(position.Value, position.Currency))
+		portfolio.Unrealised.Add(money.NewMoney(position.Unrealised, position.Currency))
+		portfolio.Realised.Add(money.NewMoney(position.Realised, position.Currency))
+	}
+	for currency, amount := range realised.account {
+		portfolio.Realised.Add(money.NewMoney(amount, currency))
+	}
+	return portfolio, nil
+}
+
+func (p *portfolios) realisedOf(ctx context.Context, accountID, quantities string) (*realised, error) {
+	p.mu.Lock()
+	cached, ok := p.realised[accountID]
+	p.mu.Unlock()
+	if ok && cached.quantities == quantities {
+		return cached, nil
+	}
+
+	from, err := p.accountOpenedAt(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	operations, err := p.client.GetOperationsRange(ctx, accountID, api.OperationsFilter{
+		From:  from,
+		State: investapi.OperationState_OPERATION_STATE_EXECUTED,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail get operations of %v", accountID)
+	}
+	result := realisedPnL(operations)
+	result.quantities = quantities
+
+	p.mu.Lock()
+	p.realised[accountID] = result
+	p.mu.Unlock()
+	return result, nil
+}
+
+func (p *portfolios) accountOpenedAt(ctx context.Context, accountID string) (time.Time, error) {
+	p.mu.Lock()
+	openedAt, ok := p.openedAt[accountID]
+	p.mu.Unlock()
+	if ok {
+		return openedAt, nil
+	}
+
+	openedAt, err := accountOpenedAt(ctx, p.client, accountID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	p.mu.Lock()
+	p.openedAt[accountID] = openedAt
+	p.mu.Unlock()
+	return openedAt, nil
+}
+
+func accountOpenedAt(ctx context.Context, client *api.Client, accountID string) (time.Time, error) {
+	accounts, err := client.GetAccounts(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, account := range accounts {
+		if account.GetId() == accountID {
+			return account.GetOpenedDate().AsTime(), nil
+		}
+	}
+	return time.Time{}, errors.Errorf("account %v is not found", accountID)
+}
+
+// holding is a position replayed from operations with the average cost method
+type holding struct {
+	quantity money.Decimal
+	average  money.Decimal
+}
+
+// realisedPnL replays executed operations, a trade towards zero realises the difference
+// between its price and the average price. Fees, dividends and coupons are realised as paid.
+func realisedPnL(operations []*investapi.Operation) *realised {
+	sort.SliceStable(operations, func(i, j int) bool {
+		return operations[i].GetDate().AsTime().Before(operations[j].GetDate().AsTime())
+	})
+
+	result := &realised{
+		byFigi:  make(map[string]money.Money),
+		account: make(money.Totals),
+	}
+	add := func(figi string, amount money.Money) {
+		if figi == "" {
+			result.account.Add(amount)
+			return
+		}
+		pnl := result.byFigi[figi]
+		pnl.Currency = amount.Currency
+		pnl.Amount = pnl.Amount.Add(amount.Amount)
+		result.byFigi[figi] = pnl
+	}
+
+	holdings := make(map[string]*holding)
+	for _, operation := range operations {
+		figi := operation.GetFigi()
+		if direction, ok := TradeDirection(operation.GetOperationType()); ok {
+			if operation.GetQuantity() == 0 {
+				continue
+			}
+			h, ok := holdings[figi]
+			if !ok {
+				h = &holding{}
+				holdings[figi] = h
+			}
+			price := money.FromMoneyValue(operation.GetPrice())
+			quantity := money.FromInt(operation.GetQuantity())
+			if direction == investapi.OrderDirection_ORDER_DIRECTION_SELL {
+				quantity = quantity.Neg()
+			}
+			add(figi, money.NewMoney(h.trade(quantity, price.Amount), price.Currency))
+			continue
+		}
+		switch operation.GetOperationType() {
+		case investapi.OperationType_OPERATION_TYPE_BROKER_FEE,
+			investapi.OperationType_OPERATION_TYPE_SERVICE_FEE,
+			investapi.OperationType_OPERATION_TYPE_MARGIN_FEE,
+			investapi.OperationType_OPERATION_TYPE_SUCCESS_FEE,
+			investapi.OperationType_OPERATION_TYPE_DIVIDEND,
+			investapi.OperationType_OPERATION_TYPE_DIVIDEND_TAX,
+			investapi.OperationType_OPERATION_TYPE_COUPON,
+			investapi.OperationType_OPERATION_TYPE_BOND_TAX,
+			investapi.OperationType_OPERATION_TYPE_ACCRUING_VARMARGIN,
+			investapi.OperationType_OPERATION_TYPE_WRITING_OFF_VARMARGIN:
+			add(figi, money.FromMoneyValue(operation.GetPayment()))
+		}
+	}
+	return result
+}
+
+// trade applies a signed quantity at price and returns the realised P&L.
+func (h *holding) trade(quantity, price money.Decimal) money.Decimal {
+	pnl := money.Zero
+	if h.quantity.Sign()*quantity.Sign() < 0 {
+		closed := money.Min(h.quantity.Abs(), quantity.Abs())
+		// longs gain when sold above the average, shorts when bought back below it
+		pnl = price.Sub(h.average).Mul(closed)
+		if h.quantity.Sign() < 0 {
+			pnl = pnl.Neg()
+		}
+		if closed.LessThan(h.quantity.Abs()) {
+			h.quantity = h.quantity.Add(quantity)
+			return pnl
+		}
+		// the position is closed, the rest of quantity opens the opposite one
+		if quantity.Sign() > 0 {
+			quantity = quantity.Sub(closed)
+		} else {
+			quantity = quantity.Add(closed)
+		}
+		h.quantity, h.average = money.Zero, money.Zero
+		if quantity.IsZero() {
+			return pnl
+		}
+	}
+	cost := h.average.Mul(h.quantity.Abs()).Add(price.Mul(quantity.Abs()))
+	h.quantity = h.quantity.Add(quantity)
+	h.average = cost.Div(h.quantity.Abs())
+	return pnl
+}
@@ -2,48 +2,176 @@ package profile
 
 import (
 	"context"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/money"
 	investapi "github.com/nax11/tinkoff_bot_public/proto"
 )
 
 type Provider interface {
-	GetOrCreateOpenedAccount(ctx context.Context, amount int) (accountID string, err error)
+	// ListAccounts returns accounts of the current mode with their balances
+	ListAccounts(ctx context.Context) ([]Account, error)
+	// OpenAccount opens a sandbox account funded with deposit
+	OpenAccount(ctx context.Context, deposit money.Money) (accountID string, err error)
+	// CloseAccount closes a sandbox account
+	CloseAccount(ctx context.Context, accountID string) error
+	// FundAccount pays in the sandbox account up to target and returns the new balance
+	FundAccount(ctx context.Context, accountID string, target money.Money) (money.Money, error)
+	// SelectAccount checks that accountID is open, empty accountID is allowed when there is
+	// exactly one open account, in sandbox an account funded with deposit is opened when there is none
+	SelectAccount(ctx context.Context, accountID string, deposit money.Money) (string, error)
+	// FindAccount is SelectAccount that never opens an account, it fails when there is no open one
+	FindAccount(ctx context.Context, accountID string) (string, error)
 	CheckFigiOperations(accountID, figi string) error
+	// Portfolios values positions of accounts, it's shared by everyone using the provider
+	Portfolios() Portfolios
+}
+
+type Account struct {
+	ID      string
+	Name    string
+	Type    investapi.AccountType
+	Status  investapi.AccountStatus
+	Sandbox bool
+	Balance money.Totals
+}
+
+func (a Account) IsOpen() bool {
+	return a.Status == investapi.AccountStatus_ACCOUNT_STATUS_OPEN
 }
 
 type impl struct {
-	client *api.Client
+	client     *api.Client
+	portfolios *portfolios
 }
 
 func Instance(client *api.Client) Provider {
-	return &impl{client: client}
+	return &impl{client: client, portfolios: newPortfolios(client)}
+}
+
+func (i *impl) Portfolios() Portfolios {
+	return i.portfolios
 }
 
-func (i *impl) GetOrCreateOpenedAccount(ctx context.Context, amount int) (accountID string, err error) {
-	accounts, err := i.client.SandboxGetAccounts(ctx)
+func (i *impl) ListAccounts(ctx context.Context) ([]Account, error) {
+	accounts, err := i.client.GetAccounts(ctx)
 	if err != nil {
-		return "", errors.Wrap(err, "fail get sandbox accounts")
+		return nil, err
 	}
 
+	result := make([]Account, 0, len(accounts))
 	for _, acc := range accounts {
-		if acc.GetStatus() == investapi.AccountStatus_ACCOUNT_STATUS_OPEN {
-			if acc.Id != "" {
-				return acc.Id, nil
+		account := Account{
+			ID:      acc.GetId(),
+			Name:    acc.GetName(),
+			Type:    acc.GetType(),
+			Status:  acc.GetStatus(),
+			Sandbox: i.client.IsSandbox(),
+			Balance: make(money.Totals),
+		}
+		if account.IsOpen() {
+			account.Balance, err = i.client.GetBalances(ctx, account.ID)
+			if err != nil {
+				return nil, errors.Wrapf(err, "fail get balance of %v", account.ID)
 			}
 		}
+		result = append(result, account)
 	}
+	return result, nil
+}
 
-	accountID, err = i.client.SandboxOpenAccount(ctx, 3000)
+func (i *impl) OpenAccount(ctx context.Context, deposit money.Money) (string, error) {
+	if !i.client.IsSandbox() {
+		return "", errors.New("live accounts can't be opened by the bot")
+	}
+	accountID, err := i.client.SandboxOpenAccount(ctx, deposit)
 	if err != nil {
-		return "", errors.Wrap(err, "fail create sandbox account")
+		return accountID, errors.Wrap(err, "fail create sandbox account")
 	}
+	logrus.WithFields(logrus.Fields{
+		"account_id": accountID,
+		"deposit":    deposit,
+	}).Info("sandbox account opened")
 	return accountID, nil
 }
 
+func (i *impl) CloseAccount(ctx context.Context, accountID string) error {
+	if !i.client.IsSandbox() {
+		return errors.New("live accounts can't be closed by the bot")
+	}
+	return i.client.SandboxCloseAccount(ctx, accountID)
+}
+
+func (i *impl) FundAccount(ctx context.Context, accountID string, target money.Money) (money.Money, error) {
+	balances, err := i.client.GetBalances(ctx, accountID)
+	if err != nil {
+		return money.Money{}, err
+	}
+	balance := balances.Get(target.Currency)
+	if !balance.Amount.LessThan(target.Amount) {
+		return balance, nil
+	}
+	if !i.client.IsSandbox() {
+		return balance, errors.Errorf("account %v has %v, less than %v", accountID, balance, target)
+	}
+
+	payIn := money.NewMoney(target.Amount.Sub(balance.Amount), target.Currency)
+	logrus.WithFields(logrus.Fields{
+		"account_id": accountID,
+		"pay_in":     payIn,
+	}).Info("fund sandbox account")
+	return i.client.SandboxPayInAccount(ctx, accountID, payIn)
+}
+
+var errNoOpenAccount = errors.New("there is no opened account")
+
+func (i *impl) SelectAccount(ctx context.Context, accountID string, deposit money.Money) (string, error) {
+	accountID, err := i.FindAccount(ctx, accountID)
+	if err != errNoOpenAccount {
+		return accountID, err
+	}
+	if !i.client.IsSandbox() {
+		return "", errors.New("there is no opened account, live accounts can't be opened by the bot")
+	}
+	return i.OpenAccount(ctx, deposit)
+}
+
+func (i *impl) FindAccount(ctx context.Context, accountID string) (string, error) {
+	accounts, err := i.client.GetAccounts(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	opened := []string{}
+	for _, acc := range accounts {
+		if acc.GetStatus() != investapi.AccountStatus_ACCOUNT_STATUS_OPEN {
+			if acc.Id == accountID {
+				return "", errors.Errorf("account %v is %v", accountID, acc.GetStatus())
+			}
+			continue
+		}
+		if acc.Id == accountID {
+			return accountID, nil
+		}
+		opened = append(opened, acc.Id)
+	}
+
+	if accountID != "" {
+		return "", errors.Errorf("account %v is not found", accountID)
+	}
+	switch len(opened) {
+	case 0:
+		return "", errNoOpenAccount
+	case 1:
+		return opened[0], nil
+	}
+	return "", errors.Errorf("there are %v opened accounts, choose one of: %v", len(opened), strings.Join(opened, ", "))
+}
+
 func (i *impl) CheckFigiOperations(accountID, figi string) error {
 	pos, err := i.client.GetOpenPosition(context.TODO(), accountID, figi)
 	if err != nil {
@@ -51,7 +179,7 @@ func (i *impl) CheckFigiOperations(accountID, figi string) error {
 	}
 	logrus.WithField("open_position", pos).Info("GetOpenPosition sent")
 
-	ops, err := i.client.SandboxGetOperations(context.TODO(), accountID, figi, investapi.OperationState_OPERATION_STATE_EXECUTED)
+	ops, err := i.client.GetOperations(context.TODO(), accountID, figi, investapi.OperationState_OPERATION_STATE_EXECUTED)
 	if err != nil {
 		return errors.Wrap(err, "fail GetOperations")
 	}
@@ -0,0 +1,167 @@
+package profile_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/fakeapi"
+	"github.com/nax11/tinkoff_bot_public/money"
+	"github.com/nax11/tinkoff_bot_public/profile"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/sirupsen/logrus"
+)
+
+const testFigi = "BBG000TEST01"
+
+func rub(amount int64) money.Money {
+	return money.NewMoney(money.FromInt(amount), "rub")
+}
+
+func newProfile(t *testing.T, sandbox bool) (*fakeapi.Server, *api.Client, profile.Provider) {
+	logrus.SetLevel(logrus.WarnLevel)
+	server := fakeapi.New()
+	t.Cleanup(server.Stop)
+	server.SetTariff(&investapi.GetUserTariffResponse{})
+	server.AddShare(&investapi.Share{
+		Figi:              testFigi,
+		Ticker:            "TEST",
+		ClassCode:         "TQBR",
+		Lot:               1,
+		Currency:          "rub",
+		MinPriceIncrement: money.New(0, 10000000).Quotation(),
+	})
+	client, err := server.Dial(sandbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server, client, profile.Instance(client)
+}
+
+func TestSelectAccount(t *testing.T) {
+	ctx := context.Background()
+	_, _, p := newProfile(t, true)
+
+	opened, err := p.SelectAccount(ctx, "", rub(1000))
+	if err != nil || opened == "" {
+		t.Fatalf("account %q, error %v, want a new sandbox account", opened, err)
+	}
+	selected, err := p.SelectAccount(ctx, "", rub(1000))
+	if err != nil || selected != opened {
+		t.Fatalf("account %q, error %v, want the opened %v", selected, err, opened)
+	}
+
+	other, err := p.OpenAccount(ctx, rub(500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.FindAccount(ctx, ""); err == nil || !strings.Contains(err.Error(), "2 opened accounts") {
+		t.Fatalf("error %v, want a choice of 2 accounts", err)
+	}
+	if found, err := p.FindAccount(ctx, other); err != nil || found != other {
+		t.Fatalf("account %q, error %v, want %v", found, err, other)
+	}
+
+	if err = p.CloseAccount(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.FindAccount(ctx, other); err == nil {
+		t.Fatal("closed account is found")
+	}
+	if found, err := p.FindAccount(ctx, ""); err != nil || found != opened {
+		t.Fatalf("account %q, error %v, want the only open %v", found, err, opened)
+	}
+}
+
+func TestLiveAccountIsNeverOpened(t *testing.T) {
+	ctx := context.Background()
+	server, _, p := newProfile(t, false)
+
+	if _, err := p.SelectAccount(ctx, "", rub(1000)); err == nil {
+		t.Fatal("live account is opened")
+	}
+	server.AddAccount("live")
+	if selected, err := p.SelectAccount(ctx, "", rub(1000)); err != nil || selected != "live" {
+		t.Fatalf("account %q, error %v, want live", selected, err)
+	}
+	if _, err := p.FundAccount(ctx, "live", rub(1000)); err == nil {
+		t.Fatal("live account without money is funded")
+	}
+}
+
+func TestFundAccount(t *testing.T) {
+	ctx := context.Background()
+	_, _, p := newProfile(t, true)
+	accountID, err := p.OpenAccount(ctx, rub(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		target  int64
+		balance int64
+	}{
+		{target: 5000, balance: 5000},
+		{target: 3000, balance: 5000},
+	} {
+		balance, err := p.FundAccount(ctx, accountID, rub(test.target))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !balance.Amount.Equal(money.FromInt(test.balance)) {
+			t.Fatalf("balance %v after funding to %v, want %v", balance, test.target, test.balance)
+		}
+	}
+
+	accounts, err := p.ListAccounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || !accounts[0].Balance.Get("rub").Amount.Equal(money.FromInt(5000)) {
+		t.Fatalf("accounts %+v, want one with 5000 rub", accounts)
+	}
+}
+
+func TestPortfolioOfTrades(t *testing.T) {
+	ctx := context.Background()
+	server, client, p := newProfile(t, true)
+	accountID, err := p.OpenAccount(ctx, rub(10000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trade := func(order *api.OrderBuilder, price int64) {
+		t.Helper()
+		server.SetLastPrice(testFigi, money.FromInt(price).Quotation())
+		if _, err := client.PostOrder(ctx, order.Market()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trade(api.NewOrder(accountID, testFigi).Buy().Lots(10), 100)
+	trade(api.NewOrder(accountID, testFigi).Sell().Lots(5), 110)
+
+	portfolio, err := p.Portfolios().Refresh(ctx, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	position, ok := portfolio.Position(testFigi)
+	if !ok {
+		t.Fatal("no position of the traded share")
+	}
+	for _, check := range []struct {
+		name      string
+		got, want money.Decimal
+	}{
+		{name: "quantity", got: position.Quantity, want: money.FromInt(5)},
+		{name: "average price", got: position.AveragePrice, want: money.FromInt(100)},
+		{name: "value", got: position.Value, want: money.FromInt(550)},
+		{name: "unrealised", got: position.Unrealised, want: money.FromInt(50)},
+		{name: "realised", got: position.Realised, want: money.FromInt(50)},
+	} {
+		if !check.got.Equal(check.want) {
+			t.Errorf("%v is %v, want %v", check.name, check.got, check.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package registry
+
+import (
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+)
+
+const (
+	TypeShare    = "share"
+	TypeBond     = "bond"
+	TypeEtf      = "etf"
+	TypeCurrency = "currency"
+	TypeFuture   = "futures"
+)
+
+// Instrument keeps what the bot needs to trade an instrument of any type.
+type Instrument struct {
+	Figi              string                          `json:"figi"`
+	Ticker            string                          `json:"ticker"`
+	ClassCode         string                          `json:"class_code"`
+	Uid               string                          `json:"uid"`
+	Isin              string                          `json:"isin,omitempty"`
+	Name              string                          `json:"name"`
+	Type              string                          `json:"type"`
+	Lot               int32                           `json:"lot"`
+	MinPriceIncrement money.Decimal                   `json:"min_price_increment"`
+	Currency          string                          `json:"currency"`
+	Exchange          string                          `json:"exchange"`
+	TradingStatus     investapi.SecurityTradingStatus `json:"trading_status"`
+	BuyAvailable      bool                            `json:"buy_available"`
+	SellAvailable     bool                            `json:"sell_available"`
+	ApiTradeAvailable bool                            `json:"api_trade_available"`
+	ShortEnabled      bool                            `json:"short_enabled"`
+}
+
+// Tradable tells whether the bot can send orders for the instrument at all,
+// the current trading session is checked separately.
+func (i Instrument) Tradable() bool {
+	return i.ApiTradeAvailable && i.BuyAvailable && i.SellAvailable
+}
+
+// Share converts the instrument back to a share with the fields the registry keeps, e.g. for a backtest
+// that runs from the cache.
+func (i Instrument) Share() *investapi.Share {
+	return &investapi.Share{
+		Figi:                  i.Figi,
+		Ticker:                i.Ticker,
+		ClassCode:             i.ClassCode,
+		Uid:                   i.Uid,
+		Isin:                  i.Isin,
+		Name:                  i.Name,
+		Lot:                   i.Lot,
+		MinPriceIncrement:     i.MinPriceIncrement.Quotation(),
+		Currency:              i.Currency,
+		Exchange:              i.Exchange,
+		TradingStatus:         i.TradingStatus,
+		BuyAvailableFlag:      i.BuyAvailable,
+		SellAvailableFlag:     i.SellAvailable,
+		ApiTradeAvailableFlag: i.ApiTradeAvailable,
+		ShortEnabledFlag:      i.ShortEnabled,
+	}
+}
+
+// FromShare describes the share as an instrument, e.g. of a backtest source.
+func FromShare(s *investapi.Share) *Instrument {
+	return &Instrument{
+		Figi:              s.Figi,
+		Ticker:            s.Ticker,
+		ClassCode:         s.ClassCode,
+		Uid:               s.Uid,
+		Isin:              s.Isin,
+		Name:              s.Name,
+		Type:              TypeShare,
+		Lot:               s.Lot,
+		MinPriceIncrement: money.FromQuotation(s.MinPriceIncrement),
+		Currency:          s.Currency,
+		Exchange:          s.Exchange,
+		TradingStatus:     s.TradingStatus,
+		BuyAvailable:      s.BuyAvailableFlag,
+		SellAvailable:     s.SellAvailableFlag,
+		ApiTradeAvailable: s.ApiTradeAvailableFlag,
+		ShortEnabled:      s.ShortEnabledFlag,
+	}
+}
+
+func fromBond(b *investapi.Bond) *Instrument {
+	return &Instrument{
+		Figi:              b.Figi,
+		Ticker:            b.Ticker,
+		ClassCode:         b.ClassCode,
+		Uid:               b.Uid,
+		Isin:              b.Isin,
+		Name:              b.Name,
+		Type:              TypeBond,
+		Lot:               b.Lot,
+		MinPriceIncrement: money.FromQuotation(b.MinPriceIncrement),
+		Currency:          b.Currency,
+		Exchange:          b.Exchange,
+		TradingStatus:     b.TradingStatus,
+		BuyAvailable:      b.BuyAvailableFlag,
+		SellAvailable:     b.SellAvailableFlag,
+		ApiTradeAvailable: b.ApiTradeAvailableFlag,
+		ShortEnabled:      b.ShortEnabledFlag,
+	}
+}
+
+func fromEtf(e *investapi.Etf) *Instrument {
+	return &Instrument{
+		Figi:              e.Figi,
+		Ticker:            e.Ticker,
+		ClassCode:         e.ClassCode,
+		Uid:               e.Uid,
+		Isin:              e.Isin,
+		Name:              e.Name,
+		Type:              TypeEtf,
+		Lot:               e.Lot,
+		MinPriceIncrement: money.FromQuotation(e.MinPriceIncrement),
+		Currency:          e.Currency,
+		Exchange:          e.Exchange,
+		TradingStatus:     e.TradingStatus,
+		BuyAvailable:      e.BuyAvailableFlag,
+		SellAvailable:     e.SellAvailableFlag,
+		ApiTradeAvailable: e.ApiTradeAvailableFlag,
+		ShortEnabled:      e.ShortEnabledFlag,
+	}
+}
+
+func fromCurrency(c *investapi.Currency) *Instrument {
+	return &Instrument{
+		Figi:              c.Figi,
+		Ticker:            c.Ticker,
+		ClassCode:         c.ClassCode,
+		Uid:               c.Uid,
+		Isin:              c.Isin,
+		Name:              c.Name,
+		Type:              TypeCurrency,
+		Lot:               c.Lot,
+		MinPriceIncrement: money.FromQuotation(c.MinPriceIncrement),
+		Currency:          c.Currency,
+		Exchange:          c.Exchange,
+		TradingStatus:     c.TradingStatus,
+		BuyAvailable:      c.BuyAvailableFlag,
+		SellAvailable:     c.SellAvailableFlag,
+		ApiTradeAvailable: c.ApiTradeAvailableFlag,
+		ShortEnabled:      c.ShortEnabledFlag,
+	}
+}
+
+func fromFuture(f *investapi.Future) *Instrument {
+	return &Instrument{
+		Figi:              f.Figi,
+		Ticker:            f.Ticker,
+		ClassCode:         f.ClassCode,
+		Uid:               f.Uid,
+		Name:              f.Name,
+		Type:              TypeFuture,
+		Lot:               f.Lot,
+		MinPriceIncrement: money.FromQuotation(f.MinPriceIncrement),
+		Currency:          f.Currency,
+		Exchange:          f.Exchange,
+		TradingStatus:     f.TradingStatus,
+		BuyAvailable:      f.BuyAvailableFlag,
+		SellAvailable:     f.SellAvailableFlag,
+		ApiTradeAvailable: f.ApiTradeAvailableFlag,
+		ShortEnabled:      f.ShortEnabledFlag,
+	}
+}
+
+func fromInstrument(i *investapi.Instrument) *Instrument {
+	return &Instrument{
+		Figi:              i.Figi,
+		Ticker:            i.Ticker,
+		ClassCode:         i.ClassCode,
+		Uid:               i.Uid,
+		Isin:              i.Isin,
+		Name:              i.Name,
+		Type:              i.InstrumentType,
+		Lot:               i.Lot,
+		MinPriceIncrement: money.FromQuotation(i.MinPriceIncrement),
+		Currency:          i.Currency,
+		Exchange:          i.Exchange,
+		TradingStatus:     i.TradingStatus,
+		BuyAvailable:      i.BuyAvailableFlag,
+		SellAvailable:     i.SellAvailableFlag,
+		ApiTradeAvailable: i.ApiTradeAvailableFlag,
+		ShortEnabled:      i.ShortEnabledFlag,
+	}
+}
@@ -0,0 +1,354 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/atomicfile"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultTTL = 24 * time.Hour
+
+var ErrNotFound = errors.New("instrument not found")
+
+type Provider interface {
+	// Resolve accepts FIGI, instrument uid, TICKER or TICKER@CLASS_CODE
+	Resolve(ctx context.Context, query string) (*Instrument, error)
+	ByTicker(ctx context.Context, ticker, classCode string) (*Instrument, error)
+	ByFigi(ctx context.Context, figi string) (*Instrument, error)
+	ByUID(ctx context.Context, uid string) (*Instrument, error)
+	// Refresh reloads all instruments from the API and rewrites the cache file
+	Refresh(ctx context.Context) error
+}
+
+type cacheFile struct {
+	UpdatedAt   time.Time     `json:"updated_at"`
+	Instruments []*Instrument `json:"instruments"`
+}
+
+type impl struct {
+	client    *api.Client
+	cachePath string
+	ttl       time.Duration
+
+	mu        sync.Mutex
+	loaded    bool
+	updatedAt time.Time
+	byFigi    map[string]*Instrument
+	byUID     map[string]*Instrument
+	byTicker  map[string][]*Instrument
+}
+
+// Instance creates the registry, empty cachePath keeps instruments in memory only.
+// The cache is used while it's younger than ttl, a stale cache is still used when the API fails.
+func Instance(client *api.Client, cachePath string, ttl time.Duration) Provider {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &impl{
+		client:    client,
+		cachePath: cachePath,
+		ttl:       ttl,
+	}
+}
+
+func (i *impl) Resolve(ctx context.Context, query string) (*Instrument, error) {
+	if ticker, classCode, ok := splitTicker(query); ok {
+		return i.ByTicker(ctx, ticker, classCode)
+	}
+	if err := i.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	instrument, ok := i.byFigi[query]
+	if !ok {
+		instrument, ok = i.byUID[query]
+	}
+	i.mu.Unlock()
+	if ok {
+		return instrument, nil
+	}
+	return i.ByTicker(ctx, query, "")
+}
+
+func splitTicker(query string) (ticker, classCode string, ok bool) {
+	idx := strings.LastIndexByte(query, '@')
+	if idx <= 0 || idx == len(query)-1 {
+		return "", "", false
+	}
+	return query[:idx], query[idx+1:], true
+}
+
+func (i *impl) ByTicker(ctx context.Context, ticker, classCode string) (*Instrument, error) {
+	if err := i.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+	instrument, err := i.findTicker(ticker, classCode)
+	if errors.Cause(err) != ErrNotFound {
+		return instrument, err
+	}
+
+	if err = i.search(ctx, ticker); err != nil {
+		return nil, err
+	}
+	return i.findTicker(ticker, classCode)
+}
+
+func (i *impl) findTicker(ticker, classCode string) (*Instrument, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	candidates := []*Instrument{}
+	for _, instrument := range i.byTicker[strings.ToUpper(ticker)] {
+		if classCode == "" || instrument.ClassCode == classCode {
+			candidates = append(candidates, instrument)
+		}
+	}
+	if len(candidates) > 1 {
+		// prefer instruments the bot is able to trade
+		tradable := []*Instrument{}
+		for _, instrument := range candidates {
+			if instrument.ApiTradeAvailable {
+				tradable = append(tradable, instrument)
+			}
+		}
+		if len(tradable) > 0 {
+			candidates = tradable
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		if classCode != "" {
+			return nil, errors.Wrapf(ErrNotFound, "ticker %v@%v", ticker, classCode)
+		}
+		return nil, errors.Wrapf(ErrNotFound, "ticker %v", ticker)
+	case 1:
+		return candidates[0], nil
+	}
+	classCodes := []string{}
+	for _, instrument := range candidates {
+		classCodes = append(classCodes, instrument.ClassCode)
+	}
+	return nil, errors.Errorf("ticker %v is ambiguous, use %v@CLASS_CODE with one of: %v",
+		ticker, ticker, strings.Join(classCodes, ", "))
+}
+
+func (i *impl) ByFigi(ctx context.Context, figi string) (*Instrument, error) {
+	return i.byID(ctx, figi, investapi.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI, func() *Instrument { return i.byFigi[figi] })
+}
+
+func (i *impl) ByUID(ctx context.Context, uid string) (*Instrument, error) {
+	return i.byID(ctx, uid, investapi.InstrumentIdType_INSTRUMENT_ID_TYPE_UID, func() *Instrument { return i.byUID[uid] })
+}
+
+func (i *impl) byID(ctx context.Context, id string, idType investapi.InstrumentIdType, lookup func() *Instrument) (*Instrument, error) {
+	if err := i.ensureLoaded(ctx); err != nil {
+		return nil, err
+	}
+	i.mu.Lock()
+	instrument := lookup()
+	i.mu.Unlock()
+	if instrument != nil {
+		return instrument, nil
+	}
+
+	resp, err := i.client.InstrumentsServiceClient.GetInstrumentBy(ctx, &investapi.InstrumentRequest{
+		IdType: idType,
+		Id:     id,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail get instrument %v", id)
+	}
+	if resp.GetInstrument() == nil {
+		return nil, errors.Wrapf(ErrNotFound, "id %v", id)
+	}
+	instrument = fromInstrument(resp.GetInstrument())
+	i.add(instrument)
+	return instrument, nil
+}
+
+// search looks the ticker up with FindInstrument and adds full descriptions of the matches.
+func (i *impl) search(ctx context.Context, ticker string) error {
+	resp, err := i.client.InstrumentsServiceClient.FindInstrument(ctx, &investapi.FindInstrumentRequest{Query: ticker})
+	if err != nil {
+		return errors.Wrapf(err, "fail find instrument %v", ticker)
+	}
+	found := false
+	for _, short := range resp.GetInstruments() {
+		if !strings.EqualFold(short.Ticker, ticker) {
+			continue
+		}
+		full, err := i.client.InstrumentsServiceClient.GetInstrumentBy(ctx, &investapi.InstrumentRequest{
+			IdType: investapi.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI,
+			Id:     short.Figi,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "fail get instrument %v", short.Figi)
+		}
+		i.add(fromInstrument(full.GetInstrument()))
+		found = true
+	}
+	if found {
+		i.save()
+	}
+	return nil
+}
+
+func (i *impl) ensureLoaded(ctx context.Context) error {
+	i.mu.Lock()
+	loaded := i.loaded
+	i.mu.Unlock()
+	if loaded {
+		return nil
+	}
+
+	cache, cacheErr := i.readCache()
+	if cacheErr == nil && time.Since(cache.UpdatedAt) < i.ttl {
+		i.replace(cache.Instruments, cache.UpdatedAt)
+		return nil
+	}
+
+	err := i.Refresh(ctx)
+	if err == nil {
+		return nil
+	}
+	if cacheErr != nil {
+		return err
+	}
+	logrus.WithError(err).WithField("updated_at", cache.UpdatedAt).Warn("fail refresh instruments, stale cache is used")
+	i.replace(cache.Instruments, cache.UpdatedAt)
+	return nil
+}
+
+func (i *impl) Refresh(ctx context.Context) error {
+	req := &investapi.InstrumentsRequest{InstrumentStatus: investapi.InstrumentStatus_INSTRUMENT_STATUS_BASE}
+	instruments := []*Instrument{}
+
+	shares, err := i.client.InstrumentsServiceClient.Shares(ctx, req)
+	if err != nil {
+		return errors.Wrap(err, "fail get shares")
+	}
+	for _, item := range shares.GetInstruments() {
+		instruments = append(instruments, FromShare(item))
+	}
+	bonds, err := i.client.InstrumentsServiceClient.Bonds(ctx, req)
+	if err != nil {
+		return errors.Wrap(err, "fail get bonds")
+	}
+	for _, item := range bonds.GetInstruments() {
+		instruments = append(instruments, fromBond(item))
+	}
+	etfs, err := i.client.InstrumentsServiceClient.Etfs(ctx, req)
+	if err != nil {
+		return errors.Wrap(err, "fail get etfs")
+	}
+	for _, item := range etfs.GetInstruments() {
+		instruments = append(instruments, fromEtf(item))
+	}
+	currencies, err := i.client.InstrumentsServiceClient.Currencies(ctx, req)
+	if err != nil {
+		return errors.Wrap(err, "fail get currencies")
+	}
+	for _, item := range currencies.GetInstruments() {
+		instruments = append(instruments, fromCurrency(item))
+	}
+	futures, err := i.client.InstrumentsServiceClient.Futures(ctx, req)
+	if err != nil {
+		return errors.Wrap(err, "fail get futures")
+	}
+	for _, item := range futures.GetInstruments() {
+		instruments = append(instruments, fromFuture(item))
+	}
+
+	i.replace(instruments, time.Now())
+	i.save()
+	return nil
+}
+
+func (i *impl) replace(instruments []*Instrument, updatedAt time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.loaded = true
+	i.updatedAt = updatedAt
+	i.byFigi = make(map[string]*Instrument, len(instruments))
+	i.byUID = make(map[string]*Instrument, len(instruments))
+	i.byTicker = make(map[string][]*Instrument, len(instruments))
+	for _, instrument := range instruments {
+		i.addLocked(instrument)
+	}
+}
+
+func (i *impl) add(instrument *Instrument) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.addLocked(instrument)
+}
+
+func (i *impl) addLocked(instrument *Instrument) {
+	if old, ok := i.byFigi[instrument.Figi]; ok {
+		tickers := i.byTicker[strings.ToUpper(old.Ticker)]
+		for idx, item := range tickers {
+			if item == old {
+				i.byTicker[strings.ToUpper(old.Ticker)] = append(tickers[:idx:idx], tickers[idx+1:]...)
+				break
+			}
+		}
+	}
+	i.byFigi[instrument.Figi] = instrument
+	if instrument.Uid != "" {
+		i.byUID[instrument.Uid] = instrument
+	}
+	ticker := strings.ToUpper(instrument.Ticker)
+	i.byTicker[ticker] = append(i.byTicker[ticker], instrument)
+}
+
+func (i *impl) readCache() (*cacheFile, error) {
+	if i.cachePath == "" {
+		return nil, errors.New("cache is disabled")
+	}
+	data, err := os.ReadFile(i.cachePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail read instruments cache")
+	}
+	cache := &cacheFile{}
+	if err = json.Unmarshal(data, cache); err != nil {
+		return nil, errors.Wrap(err, "fail parse instruments cache")
+	}
+	return cache, nil
+}
+
+// save writes the cache through a temporary file, failures only cost a slower next start.
+func (i *impl) save() {
+	if i.cachePath == "" {
+		return
+	}
+	i.mu.Lock()
+	cache := cacheFile{UpdatedAt: i.updatedAt}
+	for _, instrument := range i.byFigi {
+		cache.Instruments = append(cache.Instruments, instrument)
+	}
+	i.mu.Unlock()
+	sort.Slice(cache.Instruments, func(a, b int) bool { return cache.Instruments[a].Figi < cache.Instruments[b].Figi })
+
+	err := writeJSON(i.cachePath, cache)
+	if err != nil {
+		logrus.WithError(err).WithField("path", i.cachePath).Warn("fail save instruments cache")
+	}
+}
+
+func writeJSON(path string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data)
+}
@@ -0,0 +1,178 @@
+package registry_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/fakeapi"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/nax11/tinkoff_bot_public/registry"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+)
+
+const sharesMethod = "tinkoff.public.invest.api.contract.v1.InstrumentsService/Shares"
+
+func share(figi, ticker, classCode string, apiTrade bool) *investapi.Share {
+	return &investapi.Share{
+		Figi:                  figi,
+		Ticker:                ticker,
+		ClassCode:             classCode,
+		Uid:                   "uid-" + figi,
+		Lot:                   10,
+		Currency:              "rub",
+		Exchange:              "MOEX",
+		MinPriceIncrement:     money.New(0, 10000000).Quotation(),
+		ApiTradeAvailableFlag: apiTrade,
+	}
+}
+
+// newRegistry returns the registry over a new fake server with the cache file at cachePath.
+func newRegistry(t *testing.T, cachePath string, shares ...*investapi.Share) (*fakeapi.Server, registry.Provider) {
+	logrus.SetLevel(logrus.ErrorLevel)
+	server := fakeapi.New()
+	t.Cleanup(server.Stop)
+	server.SetTariff(&investapi.GetUserTariffResponse{})
+	for _, s := range shares {
+		server.AddShare(s)
+	}
+	client, err := server.Dial(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server, registry.Instance(client, cachePath, time.Hour)
+}
+
+func cachePath(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "instruments.json")
+}
+
+// writeCache saves a cache updated age ago with the instruments.
+func writeCache(t *testing.T, path string, age time.Duration, instruments ...*registry.Instrument) {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{
+		"updated_at":  time.Now().Add(-age),
+		"instruments": instruments,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	cached := registry.FromShare(share("BBG000CACHED", "TEST", "TQBR", true))
+	for _, tt := range []struct {
+		name string
+		age  time.Duration
+		// fails makes the API fail the refresh
+		fails    bool
+		wantFigi string
+		refresh  bool
+	}{
+		{name: "fresh cache", age: 30 * time.Minute, wantFigi: "BBG000CACHED"},
+		{name: "stale cache", age: 2 * time.Hour, wantFigi: "BBG000SERVER", refresh: true},
+		{name: "stale cache when API fails", age: 2 * time.Hour, fails: true, wantFigi: "BBG000CACHED", refresh: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := cachePath(t)
+			server, reg := newRegistry(t, path, share("BBG000SERVER", "TEST", "TQBR", true))
+			writeCache(t, path, tt.age, cached)
+			if tt.fails {
+				server.FailCalls(sharesMethod, 1, codes.Internal)
+			}
+
+			instrument, err := reg.Resolve(context.Background(), "TEST")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if instrument.Figi != tt.wantFigi {
+				t.Fatalf("resolved %v, want %v", instrument.Figi, tt.wantFigi)
+			}
+			if refreshed := server.Calls(sharesMethod) > 0; refreshed != tt.refresh {
+				t.Fatalf("refreshed %v, want %v", refreshed, tt.refresh)
+			}
+		})
+	}
+}
+
+func TestRefreshRewritesCache(t *testing.T) {
+	path := cachePath(t)
+	_, reg := newRegistry(t, path, share("BBG000SERVER", "TEST", "TQBR", true))
+	writeCache(t, path, 2*time.Hour, registry.FromShare(share("BBG000CACHED", "TEST", "TQBR", true)))
+	if _, err := reg.Resolve(context.Background(), "TEST"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new process reads the refreshed cache without the API
+	server, next := newRegistry(t, path)
+	instrument, err := next.Resolve(context.Background(), "TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if instrument.Figi != "BBG000SERVER" || server.TotalCalls() != 0 {
+		t.Fatalf("resolved %v from the cache, want BBG000SERVER", instrument.Figi)
+	}
+}
+
+func TestNoCacheWhenAPIFails(t *testing.T) {
+	server, reg := newRegistry(t, cachePath(t), share("BBG000SERVER", "TEST", "TQBR", true))
+	server.FailCalls(sharesMethod, 1, codes.Internal)
+	if _, err := reg.Resolve(context.Background(), "TEST"); err == nil {
+		t.Fatal("resolved without the cache and the API")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	_, reg := newRegistry(t, cachePath(t),
+		share("BBG000TQBR01", "TEST", "TQBR", true),
+		share("BBG000SPEQ01", "TEST", "SPBXM", false),
+		share("BBG000TWIN01", "TWIN", "TQBR", true),
+		share("BBG000TWIN02", "TWIN", "SPBXM", true),
+	)
+	for _, tt := range []struct {
+		query    string
+		wantFigi string
+		notFound bool
+	}{
+		{query: "BBG000TQBR01", wantFigi: "BBG000TQBR01"},
+		{query: "uid-BBG000SPEQ01", wantFigi: "BBG000SPEQ01"},
+		// the instrument the bot can trade is preferred
+		{query: "test", wantFigi: "BBG000TQBR01"},
+		{query: "TEST@SPBXM", wantFigi: "BBG000SPEQ01"},
+		{query: "TWIN@SPBXM", wantFigi: "BBG000TWIN02"},
+		{query: "TWIN"},
+		{query: "NONE", notFound: true},
+	} {
+		t.Run(tt.query, func(t *testing.T) {
+			instrument, err := reg.Resolve(context.Background(), tt.query)
+			if tt.notFound {
+				if errors.Cause(err) != registry.ErrNotFound {
+					t.Fatalf("error %v, want not found", err)
+				}
+				return
+			}
+			if tt.wantFigi == "" {
+				if err == nil {
+					t.Fatalf("ambiguous ticker resolved to %v", instrument.Figi)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if instrument.Figi != tt.wantFigi {
+				t.Fatalf("resolved %v, want %v", instrument.Figi, tt.wantFigi)
+			}
+		})
+	}
+}
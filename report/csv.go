@@ -0,0 +1,106 @@
+package report
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var brokerHeader = []string{
+	"trade_id", "order_id", "figi", "ticker", "class_code", "exchange", "name", "direction", "execute_sign",
+	"trade_datetime", "currency", "price", "quantity", "order_amount", "aci_value", "total_order_amount",
+	"broker_commission", "exchange_commission", "exchange_clearing_commission", "repo_rate", "party",
+	"clear_value_date", "sec_value_date", "broker_status", "delivery_type",
+	"separate_agreement_type", "separate_agreement_number", "separate_agreement_date",
+}
+
+var dividendsHeader = []string{
+	"record_date", "payment_date", "security_name", "isin", "issuer_country", "quantity", "dividend",
+	"external_commission", "dividend_gross", "tax", "dividend_amount", "currency",
+}
+
+// WriteBrokerCSV writes rows of the broker report with a header, amounts are in the currency of the price.
+func WriteBrokerCSV(w io.Writer, rows []*investapi.BrokerReport) error {
+	records := make([][]string, 0, len(rows)+1)
+	records = append(records, brokerHeader)
+	for _, row := range rows {
+		records = append(records, []string{
+			row.GetTradeId(),
+			row.GetOrderId(),
+			row.GetFigi(),
+			row.GetTicker(),
+			row.GetClassCode(),
+			row.GetExchange(),
+			row.GetName(),
+			row.GetDirection(),
+			row.GetExecuteSign(),
+			timestamp(row.GetTradeDatetime()),
+			row.GetPrice().GetCurrency(),
+			amount(row.GetPrice()),
+			strconv.FormatInt(row.GetQuantity(), 10),
+			amount(row.GetOrderAmount()),
+			money.FromQuotation(row.GetAciValue()).String(),
+			amount(row.GetTotalOrderAmount()),
+			amount(row.GetBrokerCommission()),
+			amount(row.GetExchangeCommission()),
+			amount(row.GetExchangeClearingCommission()),
+			money.FromQuotation(row.GetRepoRate()).String(),
+			row.GetParty(),
+			timestamp(row.GetClearValueDate()),
+			timestamp(row.GetSecValueDate()),
+			row.GetBrokerStatus(),
+			row.GetDeliveryType(),
+			row.GetSeparateAgreementType(),
+			row.GetSeparateAgreementNumber(),
+			row.GetSeparateAgreementDate(),
+		})
+	}
+	return writeAll(w, records)
+}
+
+// WriteDividendsCSV writes rows of the foreign issuers dividends report with a header.
+func WriteDividendsCSV(w io.Writer, rows []*investapi.DividendsForeignIssuerReport) error {
+	records := make([][]string, 0, len(rows)+1)
+	records = append(records, dividendsHeader)
+	for _, row := range rows {
+		records = append(records, []string{
+			timestamp(row.GetRecordDate()),
+			timestamp(row.GetPaymentDate()),
+			row.GetSecurityName(),
+			row.GetIsin(),
+			row.GetIssuerCountry(),
+			strconv.FormatInt(row.GetQuantity(), 10),
+			money.FromQuotation(row.GetDividend()).String(),
+			money.FromQuotation(row.GetExternalCommission()).String(),
+			money.FromQuotation(row.GetDividendGross()).String(),
+			money.FromQuotation(row.GetTax()).String(),
+			money.FromQuotation(row.GetDividendAmount()).String(),
+			row.GetCurrency(),
+		})
+	}
+	return writeAll(w, records)
+}
+
+func writeAll(w io.Writer, records [][]string) error {
+	if err := csv.NewWriter(w).WriteAll(records); err != nil {
+		return errors.Wrap(err, "fail write csv")
+	}
+	return nil
+}
+
+func amount(value *investapi.MoneyValue) string {
+	return money.FromMoneyValue(value).Amount.String()
+}
+
+func timestamp(value *timestamppb.Timestamp) string {
+	if value == nil {
+		return ""
+	}
+	return value.AsTime().Format(time.RFC3339)
+}
@@ -0,0 +1,125 @@
+package strategy
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pausePollInterval is how often a paused strategy checks whether it's resumed
+const pausePollInterval = time.Second
+
+// Holding is what a strategy believes it has on an instrument of an account.
+type Holding struct {
+	AccountID string
+	Figi      string
+	// Lot converts lots of orders to pieces
+	Lot int32
+	// Quantity is the position in pieces without fills of the active orders
+	Quantity int64
+	// OrderIDs are the active orders of the strategy
+	OrderIDs []string
+}
+
+// Book collects holdings of running strategies by their keys. A strategy publishes its holding
+// after every change and waits while its key is paused.
+type Book struct {
+	mu       sync.Mutex
+	holdings map[string]Holding
+	paused   map[string]string // key -> reason
+	// versions count changes of holdings of an account
+	versions map[string]int64
+}
+
+func NewBook() *Book {
+	return &Book{
+		holdings: make(map[string]Holding),
+		paused:   make(map[string]string),
+		versions: make(map[string]int64),
+	}
+}
+
+// Set publishes the holding of key, a nil book ignores it.
+func (b *Book) Set(key string, holding Holding) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.holdings[key] = holding
+	b.versions[holding.AccountID]++
+}
+
+// Holdings returns a copy of the published holdings.
+func (b *Book) Holdings() map[string]Holding {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	holdings := make(map[string]Holding, len(b.holdings))
+	for key, holding := range b.holdings {
+		holding.OrderIDs = append([]string(nil), holding.OrderIDs...)
+		holdings[key] = holding
+	}
+	return holdings
+}
+
+// Accounts returns accounts of the holdings, sorted.
+func (b *Book) Accounts() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	accounts := []string{}
+	for accountID := range b.versions {
+		accounts = append(accounts, accountID)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// version tells whether holdings of the account changed between two calls.
+func (b *Book) version(accountID string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.versions[accountID]
+}
+
+func (b *Book) Pause(key, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.paused[key] = reason
+}
+
+func (b *Book) Resume(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.paused, key)
+}
+
+// Paused returns the reason the strategy of key is paused for, a nil book is never paused.
+func (b *Book) Paused(key string) (reason string, paused bool) {
+	if b == nil {
+		return "", false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	reason, paused = b.paused[key]
+	return reason, paused
+}
+
+// WaitResumed blocks while the strategy of key is paused.
+func (b *Book) WaitResumed(ctx context.Context, key string) error {
+	if _, paused := b.Paused(key); !paused {
+		return nil
+	}
+	ticker := time.NewTicker(pausePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if _, paused := b.Paused(key); !paused {
+			return nil
+		}
+	}
+}
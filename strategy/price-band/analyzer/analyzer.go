@@ -2,10 +2,10 @@ package analyzer
 
 import (
 	"context"
-	"math"
 	"time"
 
 	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/money"
 	investapi "github.com/nax11/tinkoff_bot_public/proto"
 	"github.com/nax11/tinkoff_bot_public/strategy/price-band/models"
 	"github.com/pkg/errors"
@@ -13,8 +13,9 @@ import (
 )
 
 type Provider interface {
-	Analyze(ctx context.Context, figi string, from, to time.Time) (buyPrice, sellPrice float64, err error)
-	AnalyzeFromSlice(ctx context.Context, candles []*investapi.HistoricCandle) (buyPrice, sellPrice float64, err error)
+	// Analyze returns prices rounded to increment, the instrument's min_price_increment
+	Analyze(ctx context.Context, figi string, from, to time.Time, increment money.Decimal) (buyPrice, sellPrice money.Decimal, err error)
+	AnalyzeFromSlice(ctx context.Context, candles []*investapi.HistoricCandle, increment money.Decimal) (buyPrice, sellPrice money.Decimal, err error)
 }
 
 func NewAnalyzer(client *api.Client) *impl {
@@ -27,7 +28,7 @@ type impl struct {
 	client *api.Client
 }
 
-func (i impl) Analyze(ctx context.Context, figi string, from, to time.Time) (buyPrice, sellPrice float64, err error) {
+func (i impl) Analyze(ctx context.Context, figi string, from, to time.Time, increment money.Decimal) (buyPrice, sellPrice money.Decimal, err error) {
 	req := investapi.GetCandlesRequest{
 		Figi:     figi,
 		From:     timestamppb.New(from),
@@ -36,25 +37,25 @@ func (i impl) Analyze(ctx context.Context, figi string, from, to time.Time) (buy
 	}
 	resp, err := i.client.MarketDataServiceClient.GetCandles(ctx, &req)
 	if err != nil {
-		return 0, 0, errors.Wrap(err, "fail get candles")
+		return money.Zero, money.Zero, errors.Wrap(err, "fail get candles")
 	}
 	candles := resp.GetCandles()
 	if candles == nil {
-		return 0, 0, errors.New("the candles are empty")
+		return money.Zero, money.Zero, errors.New("the candles are empty")
 	}
 
-	return i.AnalyzeFromSlice(ctx, candles)
+	return i.AnalyzeFromSlice(ctx, candles, increment)
 }
 
-func (i impl) AnalyzeFromSlice(ctx context.Context, candles []*investapi.HistoricCandle) (buyPrice, sellPrice float64, err error) {
-	buyPrice, sellPrice, err = pricesByHistory(candles)
+func (i impl) AnalyzeFromSlice(ctx context.Context, candles []*investapi.HistoricCandle, increment money.Decimal) (buyPrice, sellPrice money.Decimal, err error) {
+	buyPrice, sellPrice, err = pricesByHistory(candles, increment)
 	if err != nil {
-		return 0, 0, errors.Wrap(err, "fail get prices by history")
+		return money.Zero, money.Zero, errors.Wrap(err, "fail get prices by history")
 	}
 	return buyPrice, sellPrice, nil
 }
 
-func pricesByHistory(candles []*investapi.HistoricCandle) (buyPrice, sellPrice float64, err error) {
+func pricesByHistory(candles []*investapi.HistoricCandle, increment money.Decimal) (buyPrice, sellPrice money.Decimal, err error) {
 	maxPrices := models.AverageSlice{}
 	minPrices := models.AverageSlice{}
 
@@ -62,16 +63,8 @@ func pricesByHistory(candles []*investapi.HistoricCandle) (buyPrice, sellPrice f
 		if !candle.IsComplete {
 			continue
 		}
-		maxPrice, err := api.GetPrice(candle.High)
-		if err != nil {
-			return 0, 0, errors.Wrap(err, "fail convert High price of candle")
-		}
-		minPrice, err := api.GetPrice(candle.Low)
-		if err != nil {
-			return 0, 0, errors.Wrap(err, "fail convert Low price of candle")
-		}
-		maxPrices = append(maxPrices, maxPrice)
-		minPrices = append(minPrices, minPrice)
+		maxPrices = append(maxPrices, money.FromQuotation(candle.High))
+		minPrices = append(minPrices, money.FromQuotation(candle.Low))
 		if i < 3 {
 			continue
 		}
@@ -79,17 +72,21 @@ func pricesByHistory(candles []*investapi.HistoricCandle) (buyPrice, sellPrice f
 		minPrices = minPrices[1:]
 	}
 
-	buyPrice, sellPrice = getBuySellPrice(minPrices, maxPrices)
+	if len(maxPrices) == 0 {
+		return money.Zero, money.Zero, errors.New("there are no complete candles")
+	}
+
+	buyPrice, sellPrice = getBuySellPrice(minPrices, maxPrices, increment)
 	return buyPrice, sellPrice, nil
 }
 
-func getBuySellPrice(minPrices, maxPrices models.AverageSlice) (buyPrice, sellPrice float64) {
+func getBuySellPrice(minPrices, maxPrices models.AverageSlice, increment money.Decimal) (buyPrice, sellPrice money.Decimal) {
 	minBy := minPrices.AveragePrice()
 	maxBy := maxPrices.AveragePrice()
-	delta := maxBy - minBy
-	deltaP := math.Round(delta*0.01*100) / 100
+	delta := maxBy.Sub(minBy)
+	deltaP := delta.DivInt(100).RoundTo(increment, money.RoundNearest)
 
-	buyPrice = math.Ceil((minBy+deltaP)*100) / 100
-	sellPrice = math.Floor((maxBy-deltaP)*100) / 100
+	buyPrice = minBy.Add(deltaP).RoundTo(increment, money.RoundUp)
+	sellPrice = maxBy.Sub(deltaP).RoundTo(increment, money.RoundDown)
 	return buyPrice, sellPrice
 }
@@ -0,0 +1,225 @@
+package priceband
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/nax11/tinkoff_bot_public/strategy"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type phase string
+
+const (
+	phaseBuying phase = "buying"
+	// phaseSelling waits for the limit sell at SellPrice
+	phaseSelling phase = "selling"
+	// phaseProtecting waits for the stop-loss and take-profit bracket
+	phaseProtecting phase = "protecting"
+	// phaseFlattening closes the position by market after the risk limit is hit
+	phaseFlattening phase = "flattening"
+)
+
+// deal is the progress of one buy and sell cycle. It's saved after every step,
+// so a restarted strategy continues the deal instead of starting a new one.
+type deal struct {
+	Phase     phase         `json:"phase"`
+	BuyPrice  money.Decimal `json:"buy_price"`
+	SellPrice money.Decimal `json:"sell_price"`
+	Lots      int64         `json:"lots"`
+	// OrderID is the order of the phase, empty until it's posted
+	OrderID string `json:"order_id,omitempty"`
+	// Quantity is the bought pieces and CostBasis is their average price, while buying they are
+	// the pieces bought by orders replaced on reprice
+	Quantity  int64         `json:"quantity,omitempty"`
+	CostBasis money.Decimal `json:"cost_basis"`
+	// Bracket is the stop orders of phaseProtecting
+	Bracket   *api.Bracket `json:"bracket,omitempty"`
+	StartedAt time.Time    `json:"started_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// addFill counts pieces bought or, when negative, sold at price into the deal.
+func (d *deal) addFill(pieces int64, price money.Decimal) {
+	if pieces > 0 && d.Quantity+pieces > 0 {
+		cost := d.CostBasis.MulInt(d.Quantity).Add(price.MulInt(pieces))
+		d.CostBasis = cost.DivInt(d.Quantity + pieces)
+	}
+	d.Quantity += pieces
+}
+
+// entryPrice is the price the loss of the position is counted from.
+func (d *deal) entryPrice() money.Decimal {
+	if d.CostBasis.Sign() > 0 {
+		return d.CostBasis
+	}
+	return d.BuyPrice
+}
+
+func (p priceBandImpl) dealKey(params strategy.TradeParams, share *investapi.Share) string {
+	return fmt.Sprintf("%v/%v/%v", p.Name(), params.AccountID, share.Figi)
+}
+
+// loadDeal returns the deal left by a previous run, nil when there is none.
+func (p priceBandImpl) loadDeal(params strategy.TradeParams, share *investapi.Share) (*deal, error) {
+	if params.State == nil {
+		return nil, nil
+	}
+	d := &deal{}
+	ok, err := params.State.Load(p.dealKey(params, share), d)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (p priceBandImpl) saveDeal(params strategy.TradeParams, share *investapi.Share, d *deal) error {
+	d.UpdatedAt = p.client.Clock.Now()
+	p.publish(params, share, d)
+	if params.State == nil {
+		return nil
+	}
+	return errors.Wrap(params.State.Save(p.dealKey(params, share), d), "fail save deal")
+}
+
+func (p priceBandImpl) finishDeal(params strategy.TradeParams, share *investapi.Share) error {
+	p.publish(params, share, nil)
+	if params.State == nil {
+		return nil
+	}
+	return errors.Wrap(params.State.Delete(p.dealKey(params, share)), "fail save deal")
+}
+
+// publish tells the book what the deal holds, nil is no deal.
+func (p priceBandImpl) publish(params strategy.TradeParams, share *investapi.Share, d *deal) {
+	holding := strategy.Holding{
+		AccountID: params.AccountID,
+		Figi:      share.Figi,
+		Lot:       share.Lot,
+	}
+	if d != nil {
+		holding.Quantity = d.Quantity
+		if d.OrderID != "" {
+			holding.OrderIDs = []string{d.OrderID}
+		}
+	}
+	params.Book.Set(p.dealKey(params, share), holding)
+}
+
+// waitResumed blocks while reconciliation keeps the strategy paused.
+func (p priceBandImpl) waitResumed(ctx context.Context, params strategy.TradeParams, share *investapi.Share) error {
+	key := p.dealKey(params, share)
+	if reason, paused := params.Book.Paused(key); paused {
+		logrus.WithFields(logrus.Fields{
+			"strategy": p.Name(),
+			"figi":     share.Figi,
+			"reason":   reason,
+		}).Warn("strategy is paused")
+	}
+	return params.Book.WaitResumed(ctx, key)
+}
+
+// newDeal analyzes the band and saves the deal before anything is bought.
+func (p priceBandImpl) newDeal(ctx context.Context, params strategy.TradeParams, share *investapi.Share) (*deal, error) {
+	to := p.client.Clock.Now()
+	from := to.Add(-params.AnalyzePeriod)
+	buyPrice, sellPrice, err := p.analyzer.Analyze(ctx, share.Figi, from, to, money.FromQuotation(share.MinPriceIncrement))
+	if err != nil {
+		return nil, err
+	}
+
+	qty := api.CalcLotCount(params.MaxDealSum, buyPrice, share.Lot, params.OperationLots)
+	if qty < 1 {
+		return nil, errors.New("available lot count is less than 1")
+	}
+	d := &deal{
+		Phase:     phaseBuying,
+		BuyPrice:  buyPrice,
+		SellPrice: sellPrice,
+		Lots:      qty,
+		StartedAt: to,
+	}
+	return d, p.saveDeal(params, share, d)
+}
+
+// startClosing moves the deal to selling or protecting once the position is bought.
+func (p priceBandImpl) startClosing(params strategy.TradeParams, share *investapi.Share, d *deal) error {
+	if !params.TakeProfit.IsZero() {
+		d.SellPrice = d.BuyPrice.Add(params.TakeProfit).RoundTo(money.FromQuotation(share.MinPriceIncrement), money.RoundUp)
+	}
+	d.Phase = phaseSelling
+	if !params.StopLoss.IsZero() {
+		d.Phase = phaseProtecting
+	}
+	d.OrderID = ""
+	return p.saveDeal(params, share, d)
+}
+
+// activeOrder returns the order of the deal when it's still active. A filled order is returned too,
+// waiting for it completes the phase at once.
+func (p priceBandImpl) activeOrder(ctx context.Context, params strategy.TradeParams, d *deal) (string, error) {
+	if d.OrderID == "" {
+		return "", nil
+	}
+	state, err := p.client.Broker.GetOrderState(ctx, &investapi.GetOrderStateRequest{
+		AccountId: params.AccountID,
+		OrderId:   d.OrderID,
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "fail get state of order %v", d.OrderID)
+	}
+	orderStatus := api.OrderStatusOf(state.ExecutionReportStatus)
+	if orderStatus.IsFinal() && orderStatus != api.OrderFilled {
+		logrus.WithFields(logrus.Fields{
+			"strategy": p.Name(),
+			"order_id": d.OrderID,
+			"status":   orderStatus,
+		}).Info("order of deal is finished, the phase is checked again")
+		return "", nil
+	}
+	return d.OrderID, nil
+}
+
+// replaced saves the order that replaced the re-quoted one together with the lots the old one executed,
+// the event of the last order knows only its own lots.
+func (p priceBandImpl) replaced(params strategy.TradeParams, share *investapi.Share, d *deal) func(orderID string, old *investapi.OrderState) {
+	return func(orderID string, old *investapi.OrderState) {
+		pieces := old.GetLotsExecuted() * int64(share.Lot)
+		if old.GetDirection() == investapi.OrderDirection_ORDER_DIRECTION_SELL {
+			pieces = -pieces
+		}
+		d.addFill(pieces, p.fillPrice(old, d.BuyPrice))
+		d.OrderID = orderID
+		if err := p.saveDeal(params, share, d); err != nil {
+			logrus.WithError(err).WithField("order_id", orderID).Warn("fail save replaced order of deal")
+		}
+	}
+}
+
+// fillPrice is the average price of the executed lots of the order, fallback when the broker doesn't tell it.
+func (p priceBandImpl) fillPrice(state *investapi.OrderState, fallback money.Decimal) money.Decimal {
+	price := money.FromMoneyValue(state.GetAveragePositionPrice()).Amount
+	if price.Sign() <= 0 {
+		return fallback
+	}
+	return price
+}
+
+// positionCost returns the average price of the position from the portfolio, zero when it's unknown.
+func (p priceBandImpl) positionCost(ctx context.Context, params strategy.TradeParams, share *investapi.Share) (money.Decimal, error) {
+	portfolio, err := p.client.Broker.GetPortfolio(ctx, &investapi.PortfolioRequest{AccountId: params.AccountID})
+	if err != nil {
+		return money.Zero, errors.Wrap(err, "fail get portfolio")
+	}
+	for _, position := range portfolio.GetPositions() {
+		if position.Figi == share.Figi {
+			return money.FromMoneyValue(position.AveragePositionPrice).Amount, nil
+		}
+	}
+	return money.Zero, nil
+}
@@ -1,22 +1,13 @@
 package models
 
-type AverageSlice []float64
+import "github.com/nax11/tinkoff_bot_public/money"
 
-func (a AverageSlice) AveragePrice() float64 {
-	total := 0.0
+type AverageSlice []money.Decimal
+
+func (a AverageSlice) AveragePrice() money.Decimal {
+	total := money.Zero
 	for _, item := range a {
-		total += item
+		total = total.Add(item)
 	}
-	return total / float64(len(a))
-}
-
-type SimulateOrder struct {
-	BuyPrice    float64
-	BuySum      float64
-	Qty         int32
-	SellPrice   float64
-	SellSum     float64
-	Profit      float64
-	IsPurchased bool
-	IsSold      bool
+	return total.DivInt(int64(len(a)))
 }
@@ -2,30 +2,47 @@ package priceband
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/calendar"
+	"github.com/nax11/tinkoff_bot_public/clock"
+	"github.com/nax11/tinkoff_bot_public/money"
 	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/nax11/tinkoff_bot_public/registry"
 	"github.com/nax11/tinkoff_bot_public/strategy"
 	"github.com/nax11/tinkoff_bot_public/strategy/price-band/analyzer"
-	"github.com/nax11/tinkoff_bot_public/strategy/price-band/models"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func NewStrategy(client *api.Client) strategy.Strategy {
+	tracker := client.NewOrderTracker()
 	return &priceBandImpl{
-		client:        client,
-		analyzer:      analyzer.NewAnalyzer(client),
-		simulateSlice: make(map[int64]*investapi.HistoricCandle),
+		client:   client,
+		analyzer: analyzer.NewAnalyzer(client),
+		tracker:  tracker,
+		orders:   client.NewOrderManager(tracker),
+		calendar: calendar.Instance(client),
 	}
 }
 
+// stopPollInterval is how often the triggered stop orders are checked, there is no stream for them.
+const stopPollInterval = 10 * time.Second
+
+// requoteInterval is how often active orders are compared with the current band.
+const requoteInterval = time.Minute
+
+// haltPollInterval is how often the trading status is checked while trading is suspended in an open session.
+const haltPollInterval = time.Minute
+
 type priceBandImpl struct {
-	client        *api.Client
-	analyzer      analyzer.Provider
-	simulateSlice map[int64]*investapi.HistoricCandle
+	client   *api.Client
+	analyzer analyzer.Provider
+	tracker  *api.OrderTracker
+	orders   *api.OrderManager
+	calendar calendar.Provider
 }
 
 func (p priceBandImpl) Name() string {
@@ -38,10 +55,7 @@ func (p priceBandImpl) Run(ctx context.Context, params strategy.TradeParams) err
 		return err
 	}
 
-	share, err := p.client.GetShare(ctx, params.Figi)
-	if err != nil {
-		return err
-	}
+	share := params.Instrument.Share()
 
 	log := logrus.WithFields(logrus.Fields{
 		"strategy":   p.Name(),
@@ -50,287 +64,465 @@ func (p priceBandImpl) Run(ctx context.Context, params strategy.TradeParams) err
 
 	log.Info("Run strategy")
 
-	if params.SimulateDayTrade {
-		err = p.simulateStrategy(ctx, params, share)
+	trackerCtx, stopTracker := context.WithCancel(ctx)
+	defer stopTracker()
+	clock.Go(p.client.Clock, func() { p.tracker.Run(trackerCtx) })
+
+	// orders of intents sent by the previous run are looked up before they are cancelled,
+	// so the intents are finished by the state of their orders
+	if err = p.client.ReconcileIntents(ctx, params.AccountID); err != nil {
+		return errors.Wrap(err, "fail reconcile order intents of previous run")
+	}
+	// the order of a deal left by the previous run is kept, the deal continues with it
+	keep := []string{}
+	d, err := p.loadDeal(params, share)
+	if err != nil {
+		return err
+	}
+	p.publish(params, share, d)
+	if d != nil {
+		log.WithFields(logrus.Fields{
+			"phase":    d.Phase,
+			"order_id": d.OrderID,
+		}).Info("deal of previous run is resumed")
+		if d.OrderID != "" {
+			keep = append(keep, d.OrderID)
+		}
+	}
+	cancelled, err := p.orders.CancelAll(ctx, params.AccountID, share.Figi, keep...)
+	if err != nil {
+		return errors.Wrap(err, "fail cancel orders left from previous run")
+	}
+	if cancelled > 0 {
+		log.WithField("cancelled", cancelled).Info("orders left from previous run are cancelled")
+	}
+
+	for ctx.Err() == nil {
+		err = p.waitSession(ctx, params, share)
 		if err != nil {
 			return err
 		}
-		return nil
-	} else {
+		if ctx.Err() != nil {
+			break
+		}
 		err = p.performStrategy(ctx, params, share)
 		if err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	return p.Run(ctx, params)
+// waitSession blocks until the instrument is tradable and the session lasts at least DealPeriod,
+// so a deal isn't started right before the close.
+func (p priceBandImpl) waitSession(ctx context.Context, params strategy.TradeParams, share *investapi.Share) error {
+	for ctx.Err() == nil {
+		tradable, err := p.calendar.IsTradable(ctx, share.Exchange, share.Figi)
+		if err != nil {
+			return err
+		}
+		now := p.client.Clock.Now()
+		left, err := p.calendar.UntilClose(ctx, share.Exchange, now)
+		if err != nil {
+			return err
+		}
+		if tradable && left >= params.DealPeriod {
+			return nil
+		}
+
+		var wait time.Duration
+		if left > 0 && left >= params.DealPeriod {
+			// the session is open, but trading is suspended, a halt usually ends within the session
+			wait = haltPollInterval
+			logrus.WithFields(logrus.Fields{
+				"strategy":    p.Name(),
+				"figi":        share.Figi,
+				"until_close": left,
+			}).Info("trading is suspended, waiting")
+		} else {
+			next, err := p.calendar.NextOpen(ctx, share.Exchange, now.Add(left))
+			if err != nil {
+				return err
+			}
+			wait = p.client.Clock.Until(next)
+			if wait < haltPollInterval {
+				// the schedule says open, but the session is already over
+				wait = haltPollInterval
+			}
+			logrus.WithFields(logrus.Fields{
+				"strategy":   p.Name(),
+				"figi":       share.Figi,
+				"next_open":  next.In(calendar.Moscow),
+				"until_open": wait,
+			}).Info("market is closed, waiting")
+		}
+
+		timer := p.client.Clock.NewTimer(wait)
+		clock.Park(p.client.Clock)
+		select {
+		case <-ctx.Done():
+			clock.Wake(p.client.Clock)
+			timer.Stop()
+		case <-timer.C():
+		}
+	}
+	return nil
 }
 
+// performStrategy makes one deal, a deal left by a previous run is continued from its phase.
 func (p priceBandImpl) performStrategy(ctx context.Context, params strategy.TradeParams, share *investapi.Share) error {
-
-	from := time.Now().Add(params.AnalyzePeriod * (-1))
-	to := time.Now()
-	buyPrice, sellPrice, err := p.analyzer.Analyze(ctx, share.Figi, from, to)
+	d, err := p.loadDeal(params, share)
 	if err != nil {
 		return err
 	}
-
-	qty := api.CalcLotCount(params.MaxDealSum, buyPrice, share.Lot, params.OperationLots)
-	if qty < 1 {
-		return errors.New("available lot count is less than 1")
+	if err = p.waitResumed(ctx, params, share); err != nil {
+		return nil
+	}
+	if d == nil {
+		d, err = p.newDeal(ctx, params, share)
+		if err != nil {
+			return err
+		}
 	}
 
-	if ok, err := p.buy(ctx, params.AccountID, share, buyPrice, qty); err != nil || !ok {
-		if err == nil {
-			return errors.New("buy operation terminated")
+	if d.Phase == phaseBuying {
+		if ok, err := p.buy(ctx, params, share, d); err != nil || !ok {
+			if err == nil {
+				return errors.New("buy operation terminated")
+			}
+			return err
 		}
-		return err
 	}
 
-	if ok, err := p.sell(ctx, params.AccountID, share, sellPrice); err != nil || !ok {
+	if err = p.waitResumed(ctx, params, share); err != nil {
+		return nil
+	}
+	var ok bool
+	switch d.Phase {
+	case phaseSelling:
+		ok, err = p.sell(ctx, params, share, d)
+	case phaseProtecting:
+		ok, err = p.protect(ctx, params, share, d)
+	case phaseFlattening:
+		ok, err = p.flatten(ctx, params, share, d)
+	default:
+		return errors.Errorf("deal has unknown phase %q", d.Phase)
+	}
+	if err != nil || !ok {
 		if err == nil {
 			return errors.New("sell operation terminated")
 		}
 		return err
 	}
-	return nil
+	return p.finishDeal(params, share)
 }
 
-func (p priceBandImpl) simulateStrategy(ctx context.Context, params strategy.TradeParams, share *investapi.Share) error {
-	nowTime := time.Now()
-	from := time.Date(nowTime.Year(), nowTime.Month(), nowTime.Day()-1, 0, 0, 0, 0, nowTime.Location())
-	to := time.Date(nowTime.Year(), nowTime.Month(), nowTime.Day(), 0, 0, 0, 0, nowTime.Location())
-	req := investapi.GetCandlesRequest{
-		Figi:     share.Figi,
-		From:     timestamppb.New(from),
-		To:       timestamppb.New(to),
-		Interval: investapi.CandleInterval_CANDLE_INTERVAL_5_MIN,
-	}
-	resp, err := p.client.MarketDataServiceClient.GetCandles(ctx, &req)
+func (p priceBandImpl) buy(ctx context.Context, params strategy.TradeParams, share *investapi.Share, d *deal) (ok bool, err error) {
+	accountID := params.AccountID
+	orderID, err := p.activeOrder(ctx, params, d)
 	if err != nil {
-		return errors.Wrap(err, "fail get candles")
-	}
-	qty := share.Lot * int32(params.SimulateLotQty)
-
-	orders := []models.SimulateOrder{}
-	buySuccess := 0
-	sellSuccess := 0
-
-	queueQty := int(params.AnalyzePeriod / (time.Minute * 5))
-	candles := resp.GetCandles()
-	lastPrice := 0.0
-
-	if candles != nil {
-		params.ReportData.AnalyzedData = []strategy.TikCandle{}
-		queue := []*investapi.HistoricCandle{}
-		for _, candle := range candles {
-			max, _ := api.GetPrice(candle.GetHigh())
-			min, _ := api.GetPrice(candle.GetLow())
-
-			for i, order := range orders {
-				if !order.IsPurchased {
-					if order.BuyPrice >= min &&
-						order.BuyPrice <= max {
-						orders[i].IsPurchased = true
-						buySuccess++
-					}
-					continue
-				}
-				if !order.IsSold {
-					if order.SellPrice >= min &&
-						order.SellPrice <= max {
-						orders[i].IsSold = true
-						sellSuccess++
-					}
-					continue
-				}
-			}
-			repData := strategy.TikCandle{
-				MarketHighPrice: max,
-				MarketLowPrice:  min,
+		return false, err
+	}
+	if orderID == "" {
+		order, err := p.client.GetActiveOrder(ctx, accountID, share.Figi)
+		if err != nil {
+			return false, err
+		}
+		if order != nil {
+			orderID = order.OrderId
+		} else {
+			position, err := p.client.GetOpenPosition(ctx, accountID, share.Figi)
+			if err != nil {
+				return false, err
 			}
-			if len(queue) > queueQty {
-				buy, sell, err := p.analyzer.AnalyzeFromSlice(ctx, queue)
-				if err != nil {
-					return errors.Wrap(err, "AnalyzeFromSlice with error")
-				}
-				repData.CalculatedBuyPrice = buy
-				repData.CalculatedSellPrice = sell
-
-				order := models.SimulateOrder{
-					BuyPrice:  buy,
-					BuySum:    buy * float64(qty),
-					Qty:       qty,
-					SellPrice: sell,
-					SellSum:   sell * float64(qty),
-					Profit:    sell*float64(qty) - buy*float64(qty),
-				}
-				if order.Profit < 10 {
-					logrus.WithFields(
-						logrus.Fields{
-							"buy":    buy,
-							"sell":   sell,
-							"qty":    qty,
-							"profit": order.Profit,
-						},
-					).Info("Period skipped")
-					continue
+			if position != nil && api.Held(position) > 0 {
+				logrus.WithFields(logrus.Fields{
+					"account_id": accountID,
+					"position":   position,
+					"figi":       share.Figi,
+				}).Warn("found open position")
+				d.Quantity = api.Held(position)
+				if d.CostBasis, err = p.positionCost(ctx, params, share); err != nil {
+					return false, err
 				}
-				orders = append(orders, order)
+				return true, p.startClosing(params, share, d)
+			}
 
-				logrus.WithFields(
-					logrus.Fields{
-						"buy":    buy,
-						"sell":   sell,
-						"qty":    qty,
-						"profit": order.Profit,
-					},
-				).Info("Recomended prices")
+			order := api.NewOrder(accountID, share.Figi).Buy().Lots(d.Lots).Limit(d.BuyPrice)
+			orderID, err = p.client.PostOrderIntent(ctx, p.scope(params, share, "buy"), order)
+			if err != nil {
+				return false, err
+			}
+		}
+		d.OrderID = orderID
+		if err = p.saveDeal(params, share, d); err != nil {
+			return false, err
+		}
+	}
 
-				logrus.Info("====================================")
+	event, ok, err := p.waitOrder(ctx, params, share, p.scope(params, share, "buy"), orderID, p.quote(params, share, true), nil, p.replaced(params, share, d))
+	if err != nil || !ok {
+		return ok, err
+	}
+	d.addFill(event.LotsExecuted*int64(share.Lot), p.fillPrice(event.State, d.BuyPrice))
+	return true, p.startClosing(params, share, d)
+}
 
-				queue = queue[1:]
+func (p priceBandImpl) sell(ctx context.Context, params strategy.TradeParams, share *investapi.Share, d *deal) (ok bool, err error) {
+	accountID := params.AccountID
+	orderID, err := p.activeOrder(ctx, params, d)
+	if err != nil {
+		return false, err
+	}
+	if orderID == "" {
+		order, err := p.client.GetActiveOrder(ctx, accountID, share.Figi)
+		if err != nil {
+			return false, err
+		}
+		if order != nil {
+			orderID = order.OrderId
+		} else {
+			position, err := p.client.GetOpenPosition(ctx, accountID, share.Figi)
+			if err != nil {
+				return false, err
 			}
-			if !candle.IsComplete {
-				continue
+			if position != nil && position.GetBalance() > 0 {
+				order := api.NewOrder(accountID, share.Figi).Sell().
+					Instrument(share.Lot, money.FromQuotation(share.MinPriceIncrement)).
+					Pieces(position.GetBalance()).
+					Limit(d.SellPrice)
+				orderID, err = p.client.PostOrderIntent(ctx, p.scope(params, share, "sell"), order)
+				if err != nil {
+					return false, err
+				}
 			}
-
-			logrus.
-				WithField("volume", candle.GetVolume()).
-				WithField("max_prices", max).
-				WithField("min_prices", min).
-				Info("Added candle")
-
-			queue = append(queue, candle)
-			params.ReportData.AnalyzedData = append(params.ReportData.AnalyzedData, repData)
-			lastPrice = max
+		}
+		if orderID == "" {
+			return true, nil
+		}
+		d.OrderID = orderID
+		if err = p.saveDeal(params, share, d); err != nil {
+			return false, err
 		}
 	}
 
-	logrus.Info("====================================")
-
-	logrus.Infof("buy result: success %v/%v", buySuccess, len(orders))
-	logrus.Infof("sell result: success %v/%v", sellSuccess, len(orders))
+	var quote api.Quote
+	if params.TakeProfit.IsZero() {
+		quote = p.quote(params, share, false)
+	}
+	_, ok, err = p.waitOrder(ctx, params, share, p.scope(params, share, "sell"), orderID, quote, p.riskExit(params, share, d.entryPrice()), p.replaced(params, share, d))
+	if errors.Cause(err) == api.ErrOrderExited {
+		d.Phase, d.OrderID = phaseFlattening, ""
+		if err = p.saveDeal(params, share, d); err != nil {
+			return false, err
+		}
+		return p.flatten(ctx, params, share, d)
+	}
+	return ok, err
+}
 
-	profit := 0.0
-	onMarket := 0.0
-	notTaken := 0.0
-	lastSale := 0.0
-	onMarketQty := 0.0
-	for _, order := range orders {
-		if order.IsSold {
-			profit = profit + order.Profit
-			logrus.Infof("profitable: %v", order.Profit)
-			continue
+// riskExit tells to drop the sell order when the open position loses more than MaxLoss.
+func (p priceBandImpl) riskExit(params strategy.TradeParams, share *investapi.Share, buyPrice money.Decimal) func(ctx context.Context) (bool, error) {
+	if params.MaxLoss.IsZero() {
+		return nil
+	}
+	return func(ctx context.Context) (bool, error) {
+		position, err := p.client.GetOpenPosition(ctx, params.AccountID, share.Figi)
+		if err != nil || position == nil {
+			return false, err
 		}
-		if order.IsPurchased {
-			onMarket = onMarket + order.BuySum
-			onMarketQty = onMarketQty + float64(order.Qty)
-			logrus.Infof("unprofitable: %v", order.Profit)
-			continue
+		lastPrice, err := p.client.GetLastPrice(ctx, share.Figi)
+		if err != nil {
+			return false, err
+		}
+		loss := buyPrice.Sub(lastPrice).MulInt(api.Held(position))
+		if loss.GreaterThan(params.MaxLoss) {
+			logrus.WithFields(logrus.Fields{
+				"strategy":   p.Name(),
+				"figi":       share.Figi,
+				"buy_price":  buyPrice,
+				"last_price": lastPrice,
+				"loss":       loss,
+			}).Warn("risk limit hit")
+			return true, nil
 		}
-		notTaken = notTaken + order.BuySum
+		return false, nil
 	}
-
-	lastSale = onMarketQty * lastPrice
-
-	logrus.Infof("qty per operation: %v", qty)
-	logrus.Infof("profit: %v", profit)
-	logrus.Infof("still on market: %v", onMarket)
-	logrus.Infof("not taken: %v", notTaken)
-	logrus.Infof("sale at last price: %v", lastSale)
-	logrus.Infof("profit on sale at last price: %v", lastSale-onMarket)
-	return nil
 }
 
-func (p priceBandImpl) buy(ctx context.Context, accountID string, share *investapi.Share, buyPrice float64, qty int64) (ok bool, err error) {
-	order, err := p.client.GetActiveOrder(ctx, accountID, share.Figi)
+// flatten closes the whole position by a market order.
+func (p priceBandImpl) flatten(ctx context.Context, params strategy.TradeParams, share *investapi.Share, d *deal) (ok bool, err error) {
+	orderID, err := p.activeOrder(ctx, params, d)
 	if err != nil {
 		return false, err
 	}
-
-	orderID := ""
-	if order != nil {
-		orderID = order.OrderId
-	} else {
-		position, err := p.client.GetOpenPosition(ctx, accountID, share.Figi)
+	if orderID == "" {
+		if _, err = p.orders.CancelAll(ctx, params.AccountID, share.Figi); err != nil {
+			return false, err
+		}
+		position, err := p.client.GetOpenPosition(ctx, params.AccountID, share.Figi)
 		if err != nil {
 			return false, err
 		}
-		if position != nil && position.GetBalance() > 0 {
-			logrus.WithFields(logrus.Fields{
-				"account_id": accountID,
-				"position":   position,
-				"figi":       share.Figi,
-			}).Warn("found open position")
+		if position == nil || position.GetBalance() <= 0 {
 			return true, nil
 		}
-
-		orderID, err = p.client.SandboxBuyOrder(ctx, accountID, share.Figi, buyPrice, qty)
+		order := api.NewOrder(params.AccountID, share.Figi).Sell().
+			Instrument(share.Lot, money.Zero).
+			Pieces(position.GetBalance()).
+			Market()
+		orderID, err = p.client.PostOrderIntent(ctx, p.scope(params, share, "sell"), order)
 		if err != nil {
 			return false, err
 		}
+		d.OrderID = orderID
+		if err = p.saveDeal(params, share, d); err != nil {
+			return false, err
+		}
+	}
+	// a market order is never re-quoted, so it's waited without the order manager
+	event, err := p.tracker.Track(params.AccountID, orderID, 0).Wait(ctx)
+	if ctx.Err() != nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if event.Status != api.OrderFilled {
+		return false, errors.Errorf("flatten order finished with status %v", event.Status)
 	}
+	return true, nil
+}
 
-	return p.waitOrder(ctx, accountID, orderID)
+// scope names the sequence of orders of the instrument in one direction, an order intent
+// left unfinished by a previous run is reconciled before the next order of its scope.
+func (p priceBandImpl) scope(params strategy.TradeParams, share *investapi.Share, direction string) string {
+	return fmt.Sprintf("%v/%v/%v/%v", p.Name(), params.AccountID, share.Figi, direction)
 }
 
-func (p priceBandImpl) sell(ctx context.Context, accountID string, share *investapi.Share, sellPrice float64) (ok bool, err error) {
-	order, err := p.client.GetActiveOrder(ctx, accountID, share.Figi)
-	if err != nil {
-		return false, err
+// quote re-analyzes the band for repricing of the buy or the sell order.
+func (p priceBandImpl) quote(params strategy.TradeParams, share *investapi.Share, buy bool) api.Quote {
+	return func(ctx context.Context) (money.Decimal, error) {
+		to := p.client.Clock.Now()
+		from := to.Add(-params.AnalyzePeriod)
+		buyPrice, sellPrice, err := p.analyzer.Analyze(ctx, share.Figi, from, to, money.FromQuotation(share.MinPriceIncrement))
+		if buy {
+			return buyPrice, err
+		}
+		return sellPrice, err
 	}
-	orderID := ""
-	if order != nil {
-		orderID = order.OrderId
-	} else {
-		position, err := p.client.GetOpenPosition(ctx, accountID, share.Figi)
+}
+
+// protect closes the bought position with a bracket of stop-loss and take-profit stop orders
+// instead of a limit sell, a limit sell would block the lots the stop has to sell.
+func (p priceBandImpl) protect(ctx context.Context, params strategy.TradeParams, share *investapi.Share, d *deal) (ok bool, err error) {
+	increment := money.FromQuotation(share.MinPriceIncrement)
+	stopPrice := d.BuyPrice.Sub(params.StopLoss).RoundTo(increment, money.RoundDown)
+	log := logrus.WithFields(logrus.Fields{
+		"strategy":    p.Name(),
+		"account_id":  params.AccountID,
+		"figi":        share.Figi,
+		"stop":        stopPrice,
+		"take_profit": d.SellPrice,
+	})
+
+	if d.Bracket == nil {
+		position, err := p.client.GetOpenPosition(ctx, params.AccountID, share.Figi)
 		if err != nil {
 			return false, err
 		}
-		if position != nil && position.GetBalance() > 0 {
-			orderID, err = p.client.SandboxSellOrder(ctx, accountID, share.Figi, sellPrice, position.GetBalance())
-			if err != nil {
-				return false, err
+		lots := int64(0)
+		if position != nil && share.Lot > 0 {
+			lots = position.GetBalance() / int64(share.Lot)
+		}
+		if lots <= 0 {
+			return true, nil
+		}
+
+		stopLimitPrice := money.Zero
+		if !params.StopLimitOffset.IsZero() {
+			stopLimitPrice = stopPrice.Sub(params.StopLimitOffset).RoundTo(increment, money.RoundDown)
+		}
+		bracket, err := p.client.PostBracket(ctx, params.AccountID, share.Figi, lots, stopPrice, stopLimitPrice, d.SellPrice)
+		if err != nil {
+			if bracket != nil {
+				log.WithField("stop_order_id", bracket.StopID).Warn("take-profit failed, position is left with the stop only")
 			}
+			return false, err
 		}
+		d.Bracket = bracket
+		if err = p.saveDeal(params, share, d); err != nil {
+			return false, err
+		}
+	}
+
+	log.Info("position protected, waiting stop orders")
+	kind, err := p.client.WaitBracket(ctx, d.Bracket, stopPollInterval)
+	if ctx.Err() != nil {
+		log.Info("Strategy canceled, stop orders are left active")
+		return false, nil
 	}
+	if err != nil {
+		return false, err
+	}
+	log.WithField("triggered", kind).Info("position closed by stop order")
+	return true, nil
+}
 
-	return p.waitOrder(ctx, accountID, orderID)
+// paused tells the order manager to stop quoting while reconciliation keeps the strategy paused.
+func (p priceBandImpl) paused(params strategy.TradeParams, share *investapi.Share) func() bool {
+	key := p.dealKey(params, share)
+	return func() bool {
+		_, paused := params.Book.Paused(key)
+		return paused
+	}
 }
 
-func (p priceBandImpl) waitOrder(ctx context.Context, accountID, orderID string) (ok bool, err error) {
+func (p priceBandImpl) waitOrder(ctx context.Context, params strategy.TradeParams, share *investapi.Share, scope, orderID string, quote api.Quote, exit func(ctx context.Context) (bool, error), replaced func(orderID string, old *investapi.OrderState)) (event api.OrderEvent, ok bool, err error) {
 	log := logrus.WithFields(logrus.Fields{
 		"strategy":   p.Name(),
-		"account_id": accountID,
+		"account_id": params.AccountID,
 		"order_id":   orderID,
 	})
 	log.Info("begin waiting operation")
-	orderDone := false
-	select {
-	case <-time.After(10 * time.Second):
-		orderDone, err = p.client.CheckOrderStatus(ctx, accountID, orderID)
-		if err != nil {
-			return false, err
-		}
-	case <-ctx.Done():
+	event, err = p.orders.Manage(ctx, params.AccountID, orderID, api.ManageParams{
+		MaxAge:        params.MaxOrderAge,
+		CheckInterval: requoteInterval,
+		Quote:         quote,
+		Exit:          exit,
+		Paused:        p.paused(params, share),
+		Scope:         scope,
+		Replaced:      replaced,
+	})
+	if ctx.Err() != nil {
 		log.Info("Strategy canceled")
-		return false, nil
+		return event, false, nil
 	}
-	if orderDone {
-		log.Info("operation done")
-		return true, nil
+	if err != nil {
+		return event, false, err
+	}
+	if event.Status != api.OrderFilled {
+		return event, false, errors.Errorf("order finished with status %v", event.Status)
 	}
-	return p.waitOrder(ctx, accountID, orderID)
+	log.Info("operation done")
+	return event, true, nil
 }
 
 func (p priceBandImpl) validate(params strategy.TradeParams) error {
-	if params.MaxDealSum > params.DealLimit {
+	if params.Instrument == nil || params.Instrument.Figi != params.Figi {
+		return errors.Errorf("instrument %v is not resolved", params.Figi)
+	}
+	// deal sums are price by quantity, a futures price is in points
+	if params.Instrument.Type == registry.TypeFuture {
+		return errors.Errorf("%v doesn't trade futures", p.Name())
+	}
+
+	if params.MaxDealSum.GreaterThan(params.DealLimit) {
 		return errors.New("DealLimit should be bigger when MaxDealSum")
 	}
 
-	if params.SimulateDayTrade && params.SimulateLotQty <= 0 {
-		return errors.New("SimulateLotQty param should be bigger when zero")
+	if !params.StopLoss.IsZero() && p.client.IsSandbox() {
+		return errors.New("StopLoss is not available in sandbox")
 	}
 
 	return nil
@@ -0,0 +1,351 @@
+package priceband
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/calendar"
+	"github.com/nax11/tinkoff_bot_public/clock"
+	"github.com/nax11/tinkoff_bot_public/fakeapi"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/nax11/tinkoff_bot_public/registry"
+	"github.com/nax11/tinkoff_bot_public/strategy"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	testAccount = "test-account"
+	testFigi    = "BBG000TEST01"
+	// pollInterval is how often the tracker of the strategy polls orders on the simulated clock
+	pollInterval = 5 * time.Second
+)
+
+// the band of testCandles is 99-101, the strategy buys at 99.02 and sells at 100.98
+var (
+	buyPrice  = money.MustParse("99.02")
+	sellPrice = money.MustParse("100.98")
+)
+
+// harness runs the strategy against the fake server in lockstep with a simulated clock.
+type harness struct {
+	t      *testing.T
+	clock  *clock.Simulated
+	server *fakeapi.Server
+	client *api.Client
+	params strategy.TradeParams
+	// statePath is the journal of params.State
+	statePath string
+}
+
+func testShare() *investapi.Share {
+	return &investapi.Share{
+		Figi:                  testFigi,
+		Ticker:                "TEST",
+		ClassCode:             "TQBR",
+		Lot:                   1,
+		Currency:              "rub",
+		Exchange:              "MOEX",
+		MinPriceIncrement:     money.New(0, 10000000).Quotation(),
+		TradingStatus:         investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING,
+		BuyAvailableFlag:      true,
+		SellAvailableFlag:     true,
+		ApiTradeAvailableFlag: true,
+	}
+}
+
+// testCandles are 5 minute candles of the whole day between 99 and 101.
+func testCandles(day time.Time) []*investapi.HistoricCandle {
+	candles := []*investapi.HistoricCandle{}
+	for t := day.Add(7 * time.Hour); t.Before(day.Add(19 * time.Hour)); t = t.Add(5 * time.Minute) {
+		candles = append(candles, &investapi.HistoricCandle{
+			Time:       timestamppb.New(t),
+			Open:       money.FromInt(100).Quotation(),
+			High:       money.FromInt(101).Quotation(),
+			Low:        money.FromInt(99).Quotation(),
+			Close:      money.FromInt(100).Quotation(),
+			Volume:     1000,
+			IsComplete: true,
+		})
+	}
+	return candles
+}
+
+func newHarness(t *testing.T) *harness {
+	logrus.SetLevel(logrus.WarnLevel)
+	day := time.Date(2024, 3, 12, 0, 0, 0, 0, calendar.Moscow)
+	h := &harness{
+		t:      t,
+		clock:  clock.NewSimulated(day.Add(11 * time.Hour)),
+		server: fakeapi.New(),
+	}
+	t.Cleanup(h.server.Stop)
+	h.server.Now = h.clock.Now
+	h.server.SetTariff(&investapi.GetUserTariffResponse{})
+	h.server.OnPostOrder(func(req *investapi.PostOrderRequest) fakeapi.Outcome { return fakeapi.Rest })
+	share := testShare()
+	h.server.AddShare(share)
+	h.server.AddCandles(testFigi, investapi.CandleInterval_CANDLE_INTERVAL_5_MIN, testCandles(day)...)
+	h.server.AddAccount(testAccount)
+	if err := h.server.PayIn(testAccount, money.NewMoney(money.FromInt(100000), "rub").MoneyValue()); err != nil {
+		t.Fatal(err)
+	}
+	client, err := h.server.Dial(false, api.UseClock(h.clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	h.client = client
+
+	h.params = strategy.TradeParams{
+		AccountID:     testAccount,
+		Figi:          testFigi,
+		Instrument:    registry.FromShare(share),
+		OperationLots: 10,
+		MaxDealSum:    money.FromInt(2000),
+		DealLimit:     money.FromInt(5000),
+		AnalyzePeriod: time.Hour,
+		DealPeriod:    time.Hour,
+		MaxOrderAge:   10 * time.Minute,
+		Book:          strategy.NewBook(),
+	}
+	h.openState()
+	return h
+}
+
+// openState opens the state journal again as a restarted process does.
+func (h *harness) openState() {
+	if h.params.State != nil {
+		if err := h.params.State.Close(); err != nil {
+			h.t.Fatal(err)
+		}
+	} else {
+		h.statePath = filepath.Join(h.t.TempDir(), "state.jsonl")
+	}
+	state, err := strategy.OpenStateStore(h.statePath)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.t.Cleanup(func() { state.Close() })
+	h.params.State = state
+}
+
+// start runs a new strategy and returns a function stopping it. The stop returns the error of Run
+// when it has returned by itself, errors of a cancelled Run are ignored as the backtest does.
+func (h *harness) start() (stop func() error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	run := NewStrategy(h.client)
+	clock.Go(h.clock, func() { done <- run.Run(ctx, h.params) })
+	h.settle()
+	return func() error {
+		select {
+		case err := <-done:
+			cancel()
+			return err
+		default:
+		}
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-time.After(5 * time.Second):
+			h.t.Fatal("strategy doesn't stop")
+			return nil
+		}
+	}
+}
+
+// settle waits until the strategy is parked on the clock.
+func (h *harness) settle() {
+	h.t.Helper()
+	select {
+	case <-h.clock.Idle():
+	case <-time.After(5 * time.Second):
+		h.t.Fatal("strategy isn't parked on the clock")
+	}
+}
+
+// advance moves the clock by d in poll intervals, letting the strategy handle every move.
+func (h *harness) advance(d time.Duration) {
+	h.t.Helper()
+	for end := h.clock.Now().Add(d); h.clock.Now().Before(end); {
+		h.clock.Set(h.clock.Now().Add(pollInterval))
+		h.settle()
+	}
+}
+
+func (h *harness) orders() []*investapi.OrderState {
+	return h.server.Orders(testAccount)
+}
+
+// active returns the only active order, the test fails when there are several or none.
+func (h *harness) active() *investapi.OrderState {
+	h.t.Helper()
+	var active *investapi.OrderState
+	for _, order := range h.orders() {
+		if api.OrderStatusOf(order.ExecutionReportStatus).IsFinal() {
+			continue
+		}
+		if active != nil {
+			h.t.Fatalf("orders %v and %v are both active", active.OrderId, order.OrderId)
+		}
+		active = order
+	}
+	if active == nil {
+		h.t.Fatal("no active order")
+	}
+	return active
+}
+
+func (h *harness) fill(order *investapi.OrderState, lots int64) {
+	h.t.Helper()
+	if err := h.server.FillOrder(order.OrderId, lots); err != nil {
+		h.t.Fatal(err)
+	}
+	h.advance(pollInterval)
+}
+
+func (h *harness) deal() *deal {
+	h.t.Helper()
+	d := &deal{}
+	ok, err := h.params.State.Load(dealKey(h.params, testShare()), d)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if !ok {
+		return nil
+	}
+	return d
+}
+
+func dealKey(params strategy.TradeParams, share *investapi.Share) string {
+	return priceBandImpl{}.dealKey(params, share)
+}
+
+func expectOrder(t *testing.T, order *investapi.OrderState, direction investapi.OrderDirection, lots int64, price money.Decimal) {
+	t.Helper()
+	orderPrice := money.FromMoneyValue(order.InitialSecurityPrice).Amount
+	if order.Direction != direction || order.LotsRequested != lots || !orderPrice.Equal(price) {
+		t.Fatalf("order %v is %v of %v lots at %v, want %v of %v lots at %v",
+			order.OrderId, order.Direction, order.LotsRequested, orderPrice, direction, lots, price)
+	}
+}
+
+func TestDealIsBoughtAndSold(t *testing.T) {
+	h := newHarness(t)
+	stop := h.start()
+
+	buy := h.active()
+	expectOrder(t, buy, investapi.OrderDirection_ORDER_DIRECTION_BUY, 10, buyPrice)
+	h.fill(buy, 0)
+
+	sell := h.active()
+	expectOrder(t, sell, investapi.OrderDirection_ORDER_DIRECTION_SELL, 10, sellPrice)
+	if d := h.deal(); d == nil || d.Phase != phaseSelling || d.Quantity != 10 || !d.CostBasis.Equal(buyPrice) {
+		t.Fatalf("deal %+v, want selling of 10 pieces at %v", d, buyPrice)
+	}
+	h.fill(sell, 0)
+
+	// the next deal starts with a new buy
+	next := h.active()
+	expectOrder(t, next, investapi.OrderDirection_ORDER_DIRECTION_BUY, 10, buyPrice)
+	if len(h.orders()) != 3 {
+		t.Fatalf("%v orders, want 3", len(h.orders()))
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPartialFillIsRepricedAndSoldWhole(t *testing.T) {
+	h := newHarness(t)
+	stop := h.start()
+
+	buy := h.active()
+	h.fill(buy, 4)
+	if h.active().OrderId != buy.OrderId {
+		t.Fatal("partially filled order is replaced before MaxOrderAge")
+	}
+
+	h.advance(h.params.MaxOrderAge)
+	rest := h.active()
+	if rest.OrderId == buy.OrderId {
+		t.Fatal("order older than MaxOrderAge isn't replaced")
+	}
+	expectOrder(t, rest, investapi.OrderDirection_ORDER_DIRECTION_BUY, 6, buyPrice)
+	if d := h.deal(); d == nil || d.OrderID != rest.OrderId || d.Quantity != 4 {
+		t.Fatalf("deal %+v, want the replacement order and 4 bought pieces", d)
+	}
+	h.fill(rest, 0)
+
+	sell := h.active()
+	expectOrder(t, sell, investapi.OrderDirection_ORDER_DIRECTION_SELL, 10, sellPrice)
+	if d := h.deal(); d == nil || d.Quantity != 10 || !d.CostBasis.Equal(buyPrice) {
+		t.Fatalf("deal %+v, want 10 pieces at %v", d, buyPrice)
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRejectedBuyFailsTheStrategy(t *testing.T) {
+	h := newHarness(t)
+	stop := h.start()
+
+	if err := h.server.RejectOrder(h.active().OrderId); err != nil {
+		t.Fatal(err)
+	}
+	h.advance(pollInterval)
+	err := stop()
+	if err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Fatalf("error %v, want the rejected order", err)
+	}
+}
+
+func TestRestartResumesTheDeal(t *testing.T) {
+	h := newHarness(t)
+	stop := h.start()
+	h.fill(h.active(), 0)
+	sell := h.active()
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	h.openState()
+	stop = h.start()
+	if active := h.active(); active.OrderId != sell.OrderId {
+		t.Fatalf("active order %v, want the sell %v of the previous run", active.OrderId, sell.OrderId)
+	}
+	if len(h.orders()) != 2 {
+		t.Fatalf("%v orders after restart, want 2", len(h.orders()))
+	}
+	h.fill(sell, 0)
+
+	expectOrder(t, h.active(), investapi.OrderDirection_ORDER_DIRECTION_BUY, 10, buyPrice)
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHaltDelaysTheDealWithinTheSession(t *testing.T) {
+	h := newHarness(t)
+	h.server.SetTradingStatus(testFigi, investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_BREAK_IN_TRADING)
+	stop := h.start()
+	h.advance(2 * time.Minute)
+	if len(h.orders()) != 0 {
+		t.Fatalf("%v orders while trading is suspended", len(h.orders()))
+	}
+
+	h.server.SetTradingStatus(testFigi, investapi.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING)
+	h.advance(haltPollInterval)
+	expectOrder(t, h.active(), investapi.OrderDirection_ORDER_DIRECTION_BUY, 10, buyPrice)
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,320 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+type DriftKind string
+
+const (
+	// DriftPosition is a position at the broker that differs from the one strategies believe in
+	DriftPosition DriftKind = "position"
+	// DriftMissingOrder is an order of a strategy that isn't active at the broker
+	DriftMissingOrder DriftKind = "missing_order"
+	// DriftUnknownOrder is an active order of a traded instrument no strategy knows about
+	DriftUnknownOrder DriftKind = "unknown_order"
+	// DriftCash is a change of cash while strategies of the account didn't trade, e.g. a manual trade or a payout
+	DriftCash DriftKind = "cash"
+)
+
+// Drift is a difference between holdings of strategies and the broker.
+type Drift struct {
+	Kind DriftKind
+	// Keys are strategies holding the instrument, cash drift has none
+	Keys      []string
+	AccountID string
+	Figi      string
+	OrderID   string
+	Currency  string
+	Expected  money.Decimal
+	Actual    money.Decimal
+}
+
+func (d Drift) id() string {
+	return strings.Join([]string{string(d.Kind), d.AccountID, d.Figi, d.OrderID, d.Currency}, "/")
+}
+
+func (d Drift) String() string {
+	switch d.Kind {
+	case DriftPosition:
+		return fmt.Sprintf("position of %v is %v, expected %v", d.Figi, d.Actual, d.Expected)
+	case DriftMissingOrder:
+		return fmt.Sprintf("order %v of %v is not active", d.OrderID, d.Figi)
+	case DriftUnknownOrder:
+		return fmt.Sprintf("order %v of %v is unknown", d.OrderID, d.Figi)
+	case DriftCash:
+		return fmt.Sprintf("cash changed from %v to %v %v", d.Expected, d.Actual, d.Currency)
+	}
+	return string(d.Kind)
+}
+
+// cashSnapshot is the cash of an account with the version of its holdings at that time.
+type cashSnapshot struct {
+	version int64
+	totals  money.Totals
+}
+
+// Reconciler compares holdings published to the book with positions and orders at the broker.
+type Reconciler struct {
+	client *api.Client
+	book   *Book
+	// PauseOnDrift pauses strategies with position or order drift, they are resumed once the drift is gone
+	PauseOnDrift bool
+
+	mu sync.Mutex
+	// suspected are drifts seen by the previous check
+	suspected map[string]bool
+	// cash keeps the two last snapshots of every account
+	cash   map[string][]cashSnapshot
+	paused map[string]bool
+}
+
+func NewReconciler(client *api.Client, book *Book) *Reconciler {
+	return &Reconciler{
+		client:    client,
+		book:      book,
+		suspected: make(map[string]bool),
+		cash:      make(map[string][]cashSnapshot),
+		paused:    make(map[string]bool),
+	}
+}
+
+// Run checks the book every interval and logs drift until ctx is done.
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		drifts, err := r.Check(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logrus.WithError(err).Warn("fail reconcile positions")
+			continue
+		}
+		for _, drift := range drifts {
+			logrus.WithFields(logrus.Fields{
+				"kind":       drift.Kind,
+				"strategies": drift.Keys,
+				"account_id": drift.AccountID,
+				"figi":       drift.Figi,
+				"order_id":   drift.OrderID,
+			}).Warn("drift: " + drift.String())
+		}
+	}
+}
+
+// Check compares the book with the broker once. Position and order drift is returned when two checks
+// in a row see it, a fill between reading the book and the broker isn't a drift. A change of cash is
+// returned one check later, when the holdings of the account didn't change around it.
+func (r *Reconciler) Check(ctx context.Context) ([]Drift, error) {
+	holdings := r.book.Holdings()
+	found := []Drift{}
+	cashDrifts := []Drift{}
+	for _, accountID := range r.book.Accounts() {
+		version := r.book.version(accountID)
+		drifts, totals, err := r.checkAccount(ctx, accountID, holdings)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, drifts...)
+		cashDrifts = append(cashDrifts, r.checkCash(accountID, cashSnapshot{version: version, totals: totals})...)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	confirmed := []Drift{}
+	suspected := make(map[string]bool, len(found))
+	drifting := make(map[string]bool)
+	for _, drift := range found {
+		suspected[drift.id()] = true
+		if r.suspected[drift.id()] {
+			confirmed = append(confirmed, drift)
+			for _, key := range drift.Keys {
+				drifting[key] = true
+			}
+		}
+	}
+	r.suspected = suspected
+	if r.PauseOnDrift {
+		r.pauseDrifting(drifting, confirmed)
+	}
+	return append(confirmed, cashDrifts...), nil
+}
+
+// pauseDrifting pauses strategies with confirmed drift and resumes the ones paused before
+// whose drift is gone. r.mu must be held.
+func (r *Reconciler) pauseDrifting(drifting map[string]bool, confirmed []Drift) {
+	for _, drift := range confirmed {
+		for _, key := range drift.Keys {
+			if _, paused := r.book.Paused(key); !paused {
+				logrus.WithField("strategy", key).Warn("strategy is paused on drift: " + drift.String())
+			}
+			r.book.Pause(key, drift.String())
+			r.paused[key] = true
+		}
+	}
+	for key := range r.paused {
+		if !drifting[key] {
+			r.book.Resume(key)
+			delete(r.paused, key)
+			logrus.WithField("strategy", key).Info("drift is gone, strategy is resumed")
+		}
+	}
+}
+
+// believed sums holdings of strategies on an instrument.
+type believed struct {
+	keys     []string
+	lot      int32
+	quantity int64
+	orders   map[string]bool
+}
+
+func (r *Reconciler) checkAccount(ctx context.Context, accountID string, holdings map[string]Holding) ([]Drift, money.Totals, error) {
+	positions, err := r.client.Broker.GetPositions(ctx, &investapi.PositionsRequest{AccountId: accountID})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "fail get positions of %v", accountID)
+	}
+	resp, err := r.client.Broker.GetOrders(ctx, &investapi.GetOrdersRequest{AccountId: accountID})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "fail get orders of %v", accountID)
+	}
+
+	instruments := make(map[string]*believed)
+	for key, holding := range holdings {
+		if holding.AccountID != accountID {
+			continue
+		}
+		b, ok := instruments[holding.Figi]
+		if !ok {
+			b = &believed{orders: make(map[string]bool)}
+			instruments[holding.Figi] = b
+		}
+		b.keys = append(b.keys, key)
+		b.quantity += holding.Quantity
+		if holding.Lot > 0 {
+			b.lot = holding.Lot
+		}
+		for _, orderID := range holding.OrderIDs {
+			b.orders[orderID] = true
+		}
+	}
+	held := make(map[string]int64)
+	for _, security := range positions.GetSecurities() {
+		held[security.Figi] += api.Held(security)
+	}
+	active := make(map[string]bool)
+	for _, order := range resp.GetOrders() {
+		active[order.OrderId] = true
+	}
+
+	figis := make([]string, 0, len(instruments))
+	for figi := range instruments {
+		figis = append(figis, figi)
+	}
+	sort.Strings(figis)
+	drifts := []Drift{}
+	for _, figi := range figis {
+		b := instruments[figi]
+		sort.Strings(b.keys)
+		expected := b.quantity
+		for _, order := range resp.GetOrders() {
+			if order.Figi != figi {
+				continue
+			}
+			if !b.orders[order.OrderId] {
+				drifts = append(drifts, Drift{Kind: DriftUnknownOrder, Keys: b.keys, AccountID: accountID, Figi: figi, OrderID: order.OrderId})
+				continue
+			}
+			// fills of active orders aren't in holdings yet
+			executed := order.LotsExecuted * int64(b.lot)
+			if order.Direction == investapi.OrderDirection_ORDER_DIRECTION_SELL {
+				executed = -executed
+			}
+			expected += executed
+		}
+		if held[figi] != expected {
+			drifts = append(drifts, Drift{
+				Kind:      DriftPosition,
+				Keys:      b.keys,
+				AccountID: accountID,
+				Figi:      figi,
+				Expected:  money.FromInt(expected),
+				Actual:    money.FromInt(held[figi]),
+			})
+		}
+		orderIDs := make([]string, 0, len(b.orders))
+		for orderID := range b.orders {
+			orderIDs = append(orderIDs, orderID)
+		}
+		sort.Strings(orderIDs)
+		for _, orderID := range orderIDs {
+			if !active[orderID] {
+				drifts = append(drifts, Drift{Kind: DriftMissingOrder, Keys: b.keys, AccountID: accountID, Figi: figi, OrderID: orderID})
+			}
+		}
+	}
+
+	totals := money.Totals{}
+	for _, value := range positions.GetMoney() {
+		totals.Add(money.FromMoneyValue(value))
+	}
+	for _, value := range positions.GetBlocked() {
+		totals.Add(money.FromMoneyValue(value))
+	}
+	return drifts, totals, nil
+}
+
+// checkCash compares the cash of the two previous checks, the change between them is drift
+// when the holdings of the account didn't change from the first of them until now.
+func (r *Reconciler) checkCash(accountID string, current cashSnapshot) []Drift {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snapshots := append(r.cash[accountID], current)
+	if len(snapshots) > 3 {
+		snapshots = snapshots[len(snapshots)-3:]
+	}
+	r.cash[accountID] = snapshots
+	if len(snapshots) < 3 {
+		return nil
+	}
+	before, after := snapshots[0], snapshots[1]
+	if before.version != current.version {
+		return nil
+	}
+	currencies := []string{}
+	for currency := range before.totals {
+		currencies = append(currencies, currency)
+	}
+	for currency := range after.totals {
+		if _, ok := before.totals[currency]; !ok {
+			currencies = append(currencies, currency)
+		}
+	}
+	sort.Strings(currencies)
+	drifts := []Drift{}
+	for _, currency := range currencies {
+		was, now := before.totals[currency], after.totals[currency]
+		if !was.Equal(now) {
+			drifts = append(drifts, Drift{Kind: DriftCash, AccountID: accountID, Currency: currency, Expected: was, Actual: now})
+		}
+	}
+	return drifts
+}
@@ -0,0 +1,168 @@
+package strategy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nax11/tinkoff_bot_public/atomicfile"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// StateStore keeps states of running strategies in an append-only journal of JSON lines,
+// every Save is a line synced to disk before it returns. The last line of a key wins,
+// the journal is compacted to one line per key when it's opened.
+type StateStore struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	states map[string]json.RawMessage
+}
+
+type stateRecord struct {
+	Key  string    `json:"key"`
+	Time time.Time `json:"time"`
+	// State is null for a deleted key
+	State json.RawMessage `json:"state"`
+}
+
+// OpenStateStore replays the journal at path, empty path keeps states in memory only.
+func OpenStateStore(path string) (*StateStore, error) {
+	s := &StateStore{path: path, states: make(map[string]json.RawMessage)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "fail read strategy state")
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	// a crash while appending leaves the last line cut, the lines before it are whole,
+	// so a broken line followed by another one is corruption compact would lose
+	var broken error
+	for line := 1; scanner.Scan(); line++ {
+		if broken != nil {
+			return nil, broken
+		}
+		record := stateRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			broken = errors.Wrapf(err, "broken strategy state record at %v:%v", path, line)
+			continue
+		}
+		if len(record.State) == 0 || string(record.State) == "null" {
+			delete(s.states, record.Key)
+			continue
+		}
+		s.states[record.Key] = record.State
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "fail read strategy state")
+	}
+	if broken != nil {
+		logrus.WithError(broken).Warn("cut last strategy state record is skipped")
+	}
+	if err = s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load reads the state of key into state, false tells there is none.
+func (s *StateStore) Load(key string, state interface{}) (bool, error) {
+	s.mu.Lock()
+	data, ok := s.states[key]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return false, errors.Wrapf(err, "fail parse state of %v", key)
+	}
+	return true, nil
+}
+
+// Save appends the state of key to the journal.
+func (s *StateStore) Save(key string, state interface{}) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrapf(err, "fail marshal state of %v", key)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err = s.append(stateRecord{Key: key, Time: time.Now(), State: data}); err != nil {
+		return err
+	}
+	s.states[key] = data
+	return nil
+}
+
+// Delete forgets the state of key, e.g. when a deal is done.
+func (s *StateStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.states[key]; !ok {
+		return nil
+	}
+	if err := s.append(stateRecord{Key: key, Time: time.Now()}); err != nil {
+		return err
+	}
+	delete(s.states, key)
+	return nil
+}
+
+func (s *StateStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// append writes the record and syncs the file. s.mu must be held.
+func (s *StateStore) append(record stateRecord) error {
+	if s.path == "" {
+		return nil
+	}
+	if s.file == nil {
+		return errors.New("strategy state store is closed")
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "fail marshal strategy state")
+	}
+	if _, err = s.file.Write(append(data, '\n')); err != nil {
+		return errors.Wrap(err, "fail write strategy state")
+	}
+	return errors.Wrap(s.file.Sync(), "fail sync strategy state")
+}
+
+// compact rewrites the journal with the last state of every key and opens it for appending.
+func (s *StateStore) compact() error {
+	buf := bytes.Buffer{}
+	now := time.Now()
+	for key, state := range s.states {
+		data, err := json.Marshal(stateRecord{Key: key, Time: now, State: state})
+		if err != nil {
+			return errors.Wrap(err, "fail marshal strategy state")
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	if err := atomicfile.WriteFile(s.path, buf.Bytes()); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return errors.Wrap(err, "fail open strategy state")
+	}
+	s.file = file
+	return nil
+}
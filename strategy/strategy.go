@@ -5,7 +5,9 @@ import (
 	"time"
 
 	"github.com/nax11/tinkoff_bot_public/api"
+	"github.com/nax11/tinkoff_bot_public/money"
 	investapi "github.com/nax11/tinkoff_bot_public/proto"
+	"github.com/nax11/tinkoff_bot_public/registry"
 )
 
 type StartegyMap map[string]func(client *api.Client) Strategy
@@ -16,26 +18,20 @@ type Strategy interface {
 }
 
 type TradeParams struct {
-	AccountID        string
-	Figi             string
-	OperationLots    int64
-	MaxDealSum       float64                  //maximum amount per deal
-	DealLimit        float64                  //max limit of deals
-	Interval         investapi.CandleInterval //?
-	AnalyzePeriod    time.Duration
-	DealPeriod       time.Duration
-	SimulateDayTrade bool
-	SimulateLotQty   int64
-	ReportData       *ReportParams
-}
-
-type ReportParams struct {
-	AnalyzedData []TikCandle
-}
-
-type TikCandle struct {
-	MarketHighPrice     float64
-	MarketLowPrice      float64
-	CalculatedSellPrice float64
-	CalculatedBuyPrice  float64
+	AccountID       string
+	Figi            string
+	Instrument      *registry.Instrument //description of Figi, the strategy doesn't look it up again
+	OperationLots   int64
+	MaxDealSum      money.Decimal            //maximum amount per deal
+	DealLimit       money.Decimal            //max limit of deals
+	StopLoss        money.Decimal            //distance below the buy price for a protective stop, zero disables it
+	StopLimitOffset money.Decimal            //makes the stop a stop-limit order with limit price that far below the stop
+	TakeProfit      money.Decimal            //distance above the buy price to take profit, zero keeps the analyzed sell price
+	MaxLoss         money.Decimal            //loss of the open position that closes it by market, zero disables it
+	Interval        investapi.CandleInterval //?
+	AnalyzePeriod   time.Duration
+	MaxOrderAge     time.Duration //limit orders older than that are re-quoted, zero disables it
+	DealPeriod      time.Duration
+	State           *StateStore //saves progress of deals to resume them after a restart, nil keeps it in memory
+	Book            *Book       //holdings are published to it for reconciliation, a paused strategy doesn't trade
 }
@@ -3,16 +3,11 @@ package models
 type HtmlData struct {
 	MarketHigh []Item
 	MarketLow  []Item
-	CalcPrice  []CalcItem
+	Buy        []Item
+	Sell       []Item
 }
 
 type Item struct {
 	D1 int
 	V1 float64
 }
-
-type CalcItem struct {
-	D1   int
-	Buy  float64
-	Sell float64
-}
@@ -4,41 +4,45 @@ import (
 	"html/template"
 	"net/http"
 
-	"github.com/nax11/tinkoff_bot_public/strategy"
+	"github.com/nax11/tinkoff_bot_public/backtest"
+	"github.com/nax11/tinkoff_bot_public/money"
+	investapi "github.com/nax11/tinkoff_bot_public/proto"
 	"github.com/nax11/tinkoff_bot_public/ui-render/models"
 )
 
-func RunUI(reportParams strategy.ReportParams) {
-	htmlData := prepareHtml(reportParams)
+// RunUI serves the chart of the candles with the fills of a backtest on them.
+func RunUI(candles []*investapi.HistoricCandle, fills []backtest.Fill) {
+	htmlData := prepareHtml(candles, fills)
 	buildHtml(htmlData)
 }
 
-func prepareHtml(reportParams strategy.ReportParams) models.HtmlData {
+func prepareHtml(candles []*investapi.HistoricCandle, fills []backtest.Fill) models.HtmlData {
 	result := models.HtmlData{
 		MarketHigh: []models.Item{},
 		MarketLow:  []models.Item{},
-		CalcPrice:  []models.CalcItem{},
+		Buy:        []models.Item{},
+		Sell:       []models.Item{},
 	}
-	for i, item := range reportParams.AnalyzedData {
-		marketHighItem := models.Item{
+	for i, candle := range candles {
+		result.MarketHigh = append(result.MarketHigh, models.Item{
 			D1: i,
-			V1: item.MarketHighPrice,
-		}
-		result.MarketHigh = append(result.MarketHigh, marketHighItem)
-
-		marketLowItem := models.Item{
+			V1: money.FromQuotation(candle.GetHigh()).Float64(),
+		})
+		result.MarketLow = append(result.MarketLow, models.Item{
 			D1: i,
-			V1: item.MarketLowPrice,
-		}
-		result.MarketLow = append(result.MarketLow, marketLowItem)
+			V1: money.FromQuotation(candle.GetLow()).Float64(),
+		})
 
-		if item.CalculatedBuyPrice != 0 {
-			calcItem := models.CalcItem{
-				D1:   i,
-				Buy:  item.CalculatedBuyPrice,
-				Sell: item.CalculatedSellPrice,
+		for _, fill := range fills {
+			if !fill.Time.Equal(candle.GetTime().AsTime()) {
+				continue
+			}
+			item := models.Item{D1: i, V1: fill.Price.Float64()}
+			if fill.Direction == investapi.OrderDirection_ORDER_DIRECTION_BUY {
+				result.Buy = append(result.Buy, item)
+			} else {
+				result.Sell = append(result.Sell, item)
 			}
-			result.CalcPrice = append(result.CalcPrice, calcItem)
 		}
 	}
 	return result